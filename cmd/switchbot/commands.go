@@ -0,0 +1,315 @@
+package main
+
+import (
+	"fmt"
+	"github.com/nasa9084/go-switchbot/v3/switchbot"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// command is a command which can be sent by the command command.
+type command struct {
+	name string
+	// params is the usage of the parameters, e.g. "<r> <g> <b>".
+	params string
+	help   string
+	// build returns the command of the parameters, whose number is checked
+	// against params beforehand.
+	build func(p params) (switchbot.Command, error)
+}
+
+// simple returns a command without parameters.
+func simple(name, help string, fn func() switchbot.Command) command {
+	return command{
+		name:  name,
+		help:  help,
+		build: func(params) (switchbot.Command, error) { return fn(), nil },
+	}
+}
+
+// commands are the commands, one for each command builder of the switchbot
+// package.
+var commands = []command{
+	simple("turn-on", "Turn on the device", switchbot.TurnOnCommand),
+	simple("turn-off", "Turn off the device", switchbot.TurnOffCommand),
+	simple("toggle", "Toggle the state of the color bulb, strip light or plug mini", switchbot.ToggleCommand),
+	simple("press", "Trigger the press command of the bot", switchbot.PressCommand),
+	{
+		name:   "set-position",
+		params: "<index> <default|performance|silent> <position>",
+		help:   "Set the position of the curtain, 0 for opened and 100 for closed",
+		build: func(p params) (switchbot.Command, error) {
+			c := switchbot.SetPosition(p.int(0), setPositionModes[p.enum(1, setPositionModes)], p.int(2))
+			return c, p.err
+		},
+	},
+	simple("lock", "Lock the lock", switchbot.LockCommand),
+	simple("unlock", "Unlock the lock", switchbot.UnlockCommand),
+	{
+		name:   "set-mode",
+		params: "<auto|low|mid|high|0-100>",
+		help:   "Set the mode of the humidifier",
+		build: func(p params) (switchbot.Command, error) {
+			if mode, ok := humidifierModes[strings.ToLower(p.args[0])]; ok {
+				return switchbot.SetModeCommand(mode), nil
+			}
+			c := switchbot.SetModeCommand(switchbot.HumidifierMode(p.int(0)))
+			return c, p.err
+		},
+	},
+	{
+		name:   "set-all-status",
+		params: "<on|off> <standard|natural> <speed> <shake-range>",
+		help:   "Set all the status of the smart fan",
+		build: func(p params) (switchbot.Command, error) {
+			power := powerStates[p.enum(0, powerStates)]
+			mode := smartFanModes[p.enum(1, smartFanModes)]
+			c := switchbot.SetAllStatusCommand(power, mode, p.int(2), p.int(3))
+			return c, p.err
+		},
+	},
+	{
+		name:   "set-brightness",
+		params: "<1-100>",
+		help:   "Set the brightness of the light",
+		build: func(p params) (switchbot.Command, error) {
+			c := switchbot.SetBrightnessCommand(p.int(0))
+			return c, p.err
+		},
+	},
+	{
+		name:   "set-color",
+		params: "<r> <g> <b>",
+		help:   "Set the RGB color of the color bulb or strip light",
+		build: func(p params) (switchbot.Command, error) {
+			c := switchbot.SetColorCommand(p.int(0), p.int(1), p.int(2))
+			return c, p.err
+		},
+	},
+	{
+		name:   "set-color-temperature",
+		params: "<2700-6500>",
+		help:   "Set the color temperature of the color bulb or ceiling light",
+		build: func(p params) (switchbot.Command, error) {
+			c := switchbot.SetColorTemperatureCommand(p.int(0))
+			return c, p.err
+		},
+	},
+	simple("brightness-up", "Brighten the infrared light", switchbot.LightBrightnessUpCommand),
+	simple("brightness-down", "Dim the infrared light", switchbot.LightBrightnessDownCommand),
+	simple("start", "Start vacuuming", switchbot.StartCommand),
+	simple("stop", "Stop vacuuming", switchbot.StopCommand),
+	simple("dock", "Return the robot vacuum cleaner to the charging dock", switchbot.DockCommand),
+	{
+		name:   "pow-level",
+		params: "<quiet|standard|strong|max>",
+		help:   "Set the suction power level of the robot vacuum cleaner",
+		build: func(p params) (switchbot.Command, error) {
+			c := switchbot.PowLevelCommand(vacuumPowerLevels[p.enum(0, vacuumPowerLevels)])
+			return c, p.err
+		},
+	},
+	{
+		name:   "create-key",
+		params: "<name> <permanent|timeLimit|disposable|urgent> <password> [<start> <end>]",
+		help:   "Create a passcode of the keypad, start and end in RFC 3339 are required for timeLimit and disposable",
+		build: func(p params) (switchbot.Command, error) {
+			typ := passcodeTypes[p.enum(1, passcodeTypes)]
+			var start, end time.Time
+			if len(p.args) == 4 {
+				return nil, fmt.Errorf("both of start and end are required")
+			}
+			if len(p.args) == 5 {
+				start, end = p.time(3), p.time(4)
+			}
+			if p.err != nil {
+				return nil, p.err
+			}
+			return switchbot.CreateKeyCommand(p.args[0], typ, p.args[2], start, end)
+		},
+	},
+	{
+		name:   "delete-key",
+		params: "<id>",
+		help:   "Delete a passcode of the keypad",
+		build: func(p params) (switchbot.Command, error) {
+			c := switchbot.DeleteKeyCommand(p.int(0))
+			return c, p.err
+		},
+	},
+	{
+		name:   "button-push",
+		params: "<button>",
+		help:   "Push a customized button of the infrared remote",
+		build: func(p params) (switchbot.Command, error) {
+			return switchbot.ButtonPushCommand(p.args[0]), nil
+		},
+	},
+	{
+		name:   "blind-tilt-set-position",
+		params: "<up|down> <0-100>",
+		help:   "Set the position of the blind tilt",
+		build: func(p params) (switchbot.Command, error) {
+			c := switchbot.BlindTiltSetPositionCommand(blindTiltDirections[p.enum(0, blindTiltDirections)], p.int(1))
+			return c, p.err
+		},
+	},
+	simple("fully-open", "Open the blind tilt fully", switchbot.FullyOpenCommand),
+	simple("close-up", "Close the blind tilt up", switchbot.CloseUpCommand),
+	simple("close-down", "Close the blind tilt down", switchbot.CloseDownCommand),
+	{
+		name:   "ac-set-all",
+		params: "<temperature> <auto|cool|dry|fan|heat> <auto|low|medium|high> <on|off>",
+		help:   "Set all the status of the infrared air conditioner",
+		build: func(p params) (switchbot.Command, error) {
+			mode := acModes[p.enum(1, acModes)]
+			speed := acFanSpeeds[p.enum(2, acFanSpeeds)]
+			power := powerStates[p.enum(3, powerStates)]
+			c := switchbot.ACSetAllCommand(p.int(0), mode, speed, power)
+			return c, p.err
+		},
+	},
+	{
+		name:   "set-channel",
+		params: "<channel>",
+		help:   "Set the channel of the infrared TV",
+		build: func(p params) (switchbot.Command, error) {
+			c := switchbot.SetChannelCommand(p.int(0))
+			return c, p.err
+		},
+	},
+	simple("volume-add", "Turn the volume of the infrared TV up", switchbot.VolumeAddCommand),
+	simple("volume-sub", "Turn the volume of the infrared TV down", switchbot.VolumeSubCommand),
+	simple("channel-add", "Go to the next channel of the infrared TV", switchbot.ChannelAddCommand),
+	simple("channel-sub", "Go to the previous channel of the infrared TV", switchbot.ChannelSubCommand),
+	simple("set-mute", "Mute or unmute the infrared TV", switchbot.SetMuteCommand),
+	simple("fast-forward", "Fast forward the infrared player", switchbot.FastForwardCommand),
+	simple("rewind", "Rewind the infrared player", switchbot.RewindCommand),
+	simple("next", "Skip to the next track of the infrared player", switchbot.NextCommand),
+	simple("previous", "Skip to the previous track of the infrared player", switchbot.PreviousCommand),
+	simple("pause", "Pause the infrared player", switchbot.PauseCommand),
+	simple("play", "Play the infrared player", switchbot.PlayCommand),
+	simple("stop-player", "Stop the infrared player", switchbot.StopPlayerCommand),
+	simple("fan-swing", "Swing the infrared fan", switchbot.FanSwingCommand),
+	simple("fan-timer", "Set the timer of the infrared fan", switchbot.FanTimerCommand),
+	simple("fan-low-speed", "Set the infrared fan to the low speed", switchbot.FanLowSpeedCommand),
+	simple("fan-middle-speed", "Set the infrared fan to the middle speed", switchbot.FanMiddleSpeedCommand),
+	simple("fan-high-speed", "Set the infrared fan to the high speed", switchbot.FanHighSpeedCommand),
+}
+
+var (
+	setPositionModes = map[string]switchbot.SetPositionMode{
+		"default":     switchbot.DefaultMode,
+		"performance": switchbot.PerformanceMode,
+		"silent":      switchbot.SilentMode,
+	}
+	humidifierModes = map[string]switchbot.HumidifierMode{
+		"auto": switchbot.AutoMode,
+		"low":  switchbot.LowMode,
+		"mid":  switchbot.MidMode,
+		"high": switchbot.HighMode,
+	}
+	powerStates = map[string]switchbot.PowerState{
+		"on":  switchbot.PowerOn,
+		"off": switchbot.PowerOff,
+	}
+	smartFanModes = map[string]switchbot.SmartFanMode{
+		"standard": switchbot.StandardFanMode,
+		"natural":  switchbot.NaturalFanMode,
+	}
+	vacuumPowerLevels = map[string]switchbot.VacuumPowerLevel{
+		"quiet":    switchbot.QuietVacuumPowerLevel,
+		"standard": switchbot.StandardVacuumPowerLevel,
+		"strong":   switchbot.StrongVacuumPowerLevel,
+		"max":      switchbot.MaxVacuumPowerLevel,
+	}
+	passcodeTypes = map[string]switchbot.PasscodeType{
+		"permanent":  switchbot.PermanentPasscode,
+		"timelimit":  switchbot.TimeLimitPasscode,
+		"disposable": switchbot.DisposablePasscode,
+		"urgent":     switchbot.UrgentPasscode,
+	}
+	blindTiltDirections = map[string]switchbot.BlindTiltSetPositionDirection{
+		"up":   switchbot.UpDirection,
+		"down": switchbot.DownDirection,
+	}
+	acModes = map[string]switchbot.ACMode{
+		"auto": switchbot.ACAuto,
+		"cool": switchbot.ACCool,
+		"dry":  switchbot.ACDry,
+		"fan":  switchbot.ACFan,
+		"heat": switchbot.ACHeat,
+	}
+	acFanSpeeds = map[string]switchbot.ACFanSpeed{
+		"auto":   switchbot.ACAutoSpeed,
+		"low":    switchbot.ACLow,
+		"medium": switchbot.ACMedium,
+		"high":   switchbot.ACHigh,
+	}
+)
+
+// buildCommand returns the named command of the parameters.
+func buildCommand(name string, args []string) (switchbot.Command, error) {
+	for _, c := range commands {
+		if !strings.EqualFold(c.name, name) {
+			continue
+		}
+
+		// the parameters in brackets are optional
+		required := strings.Count(strings.SplitN(c.params, "[", 2)[0], "<")
+		if limit := strings.Count(c.params, "<"); len(args) < required || len(args) > limit {
+			return nil, fmt.Errorf("usage: %s %s", c.name, c.params)
+		}
+
+		return c.build(params{args: args})
+	}
+
+	return nil, fmt.Errorf("unknown command: %s", name)
+}
+
+// params parses the parameters of a command, keeping the first error.
+type params struct {
+	args []string
+	err  error
+}
+
+func (p *params) int(i int) int {
+	v, err := strconv.Atoi(p.args[i])
+	if err != nil && p.err == nil {
+		p.err = fmt.Errorf("invalid parameter %q: must be an integer", p.args[i])
+	}
+
+	return v
+}
+
+func (p *params) time(i int) time.Time {
+	v, err := time.Parse(time.RFC3339, p.args[i])
+	if err != nil && p.err == nil {
+		p.err = fmt.Errorf("invalid parameter %q: must be a time in RFC 3339", p.args[i])
+	}
+
+	return v
+}
+
+// enum returns the lower-cased parameter if it is a key of values, which is a
+// map keyed by the names of the values.
+func (p *params) enum(i int, values interface{}) string {
+	v := strings.ToLower(p.args[i])
+
+	var names []string
+	for _, key := range reflect.ValueOf(values).MapKeys() {
+		if key.String() == v {
+			return v
+		}
+		names = append(names, key.String())
+	}
+	sort.Strings(names)
+	if p.err == nil {
+		p.err = fmt.Errorf("invalid parameter %q: must be one of %s", p.args[i], strings.Join(names, ", "))
+	}
+
+	return v
+}
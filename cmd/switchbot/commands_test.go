@@ -0,0 +1,150 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/nasa9084/go-switchbot/v3/switchbot"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestBuildCommand(t *testing.T) {
+	start := time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC)
+	end := time.Date(2024, 1, 2, 9, 0, 0, 0, time.UTC)
+	mustCreateKey := func(name string, typ switchbot.PasscodeType, password string, start, end time.Time) switchbot.Command {
+		c, err := switchbot.CreateKeyCommand(name, typ, password, start, end)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+
+	tests := []struct {
+		name string
+		args []string
+		want switchbot.Command
+	}{
+		{"turn-on", nil, switchbot.TurnOnCommand()},
+		{"turn-off", nil, switchbot.TurnOffCommand()},
+		{"toggle", nil, switchbot.ToggleCommand()},
+		{"press", nil, switchbot.PressCommand()},
+		{"set-position", []string{"0", "silent", "40"}, switchbot.SetPosition(0, switchbot.SilentMode, 40)},
+		{"lock", nil, switchbot.LockCommand()},
+		{"unlock", nil, switchbot.UnlockCommand()},
+		{"set-mode", []string{"auto"}, switchbot.SetModeCommand(switchbot.AutoMode)},
+		{"set-mode", []string{"45"}, switchbot.SetModeCommand(switchbot.HumidifierMode(45))},
+		{"set-all-status", []string{"on", "natural", "3", "60"}, switchbot.SetAllStatusCommand(switchbot.PowerOn, switchbot.NaturalFanMode, 3, 60)},
+		{"set-brightness", []string{"80"}, switchbot.SetBrightnessCommand(80)},
+		{"set-color", []string{"255", "128", "0"}, switchbot.SetColorCommand(255, 128, 0)},
+		{"set-color-temperature", []string{"4000"}, switchbot.SetColorTemperatureCommand(4000)},
+		{"brightness-up", nil, switchbot.LightBrightnessUpCommand()},
+		{"brightness-down", nil, switchbot.LightBrightnessDownCommand()},
+		{"start", nil, switchbot.StartCommand()},
+		{"stop", nil, switchbot.StopCommand()},
+		{"dock", nil, switchbot.DockCommand()},
+		{"pow-level", []string{"strong"}, switchbot.PowLevelCommand(switchbot.StrongVacuumPowerLevel)},
+		{"create-key", []string{"guest", "permanent", "12345678"}, mustCreateKey("guest", switchbot.PermanentPasscode, "12345678", time.Time{}, time.Time{})},
+		{"create-key", []string{"guest", "timeLimit", "12345678", "2024-01-01T09:00:00Z", "2024-01-02T09:00:00Z"}, mustCreateKey("guest", switchbot.TimeLimitPasscode, "12345678", start, end)},
+		{"delete-key", []string{"11"}, switchbot.DeleteKeyCommand(11)},
+		{"button-push", []string{"My Button"}, switchbot.ButtonPushCommand("My Button")},
+		{"blind-tilt-set-position", []string{"up", "60"}, switchbot.BlindTiltSetPositionCommand(switchbot.UpDirection, 60)},
+		{"fully-open", nil, switchbot.FullyOpenCommand()},
+		{"close-up", nil, switchbot.CloseUpCommand()},
+		{"close-down", nil, switchbot.CloseDownCommand()},
+		{"ac-set-all", []string{"26", "cool", "medium", "on"}, switchbot.ACSetAllCommand(26, switchbot.ACCool, switchbot.ACMedium, switchbot.PowerOn)},
+		{"set-channel", []string{"8"}, switchbot.SetChannelCommand(8)},
+		{"volume-add", nil, switchbot.VolumeAddCommand()},
+		{"volume-sub", nil, switchbot.VolumeSubCommand()},
+		{"channel-add", nil, switchbot.ChannelAddCommand()},
+		{"channel-sub", nil, switchbot.ChannelSubCommand()},
+		{"set-mute", nil, switchbot.SetMuteCommand()},
+		{"fast-forward", nil, switchbot.FastForwardCommand()},
+		{"rewind", nil, switchbot.RewindCommand()},
+		{"next", nil, switchbot.NextCommand()},
+		{"previous", nil, switchbot.PreviousCommand()},
+		{"pause", nil, switchbot.PauseCommand()},
+		{"play", nil, switchbot.PlayCommand()},
+		{"stop-player", nil, switchbot.StopPlayerCommand()},
+		{"fan-swing", nil, switchbot.FanSwingCommand()},
+		{"fan-timer", nil, switchbot.FanTimerCommand()},
+		{"fan-low-speed", nil, switchbot.FanLowSpeedCommand()},
+		{"fan-middle-speed", nil, switchbot.FanMiddleSpeedCommand()},
+		{"fan-high-speed", nil, switchbot.FanHighSpeedCommand()},
+	}
+
+	tested := map[string]bool{}
+	for _, tt := range tests {
+		tested[tt.name] = true
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := buildCommand(tt.name, tt.args)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if diff := cmp.Diff(tt.want.Request(), got.Request()); diff != "" {
+				t.Errorf("command mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+
+	for _, c := range commands {
+		if !tested[c.name] {
+			t.Errorf("command %s is not tested", c.name)
+		}
+	}
+}
+
+func TestBuildCommandError(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{"unknown", nil, "unknown command: unknown"},
+		{"set-brightness", []string{"80", "90"}, "usage: set-brightness <1-100>"},
+		{"set-color", []string{"255", "128"}, "usage: set-color <r> <g> <b>"},
+		{"set-brightness", []string{"bright"}, `invalid parameter "bright": must be an integer`},
+		{"pow-level", []string{"turbo"}, `invalid parameter "turbo": must be one of max, quiet, standard, strong`},
+		{"create-key", []string{"guest", "timeLimit", "12345678", "2024-01-01T09:00:00Z"}, "both of start and end are required"},
+		{"create-key", []string{"guest", "timeLimit", "12345678", "tomorrow", "2024-01-02T09:00:00Z"}, `invalid parameter "tomorrow": must be a time in RFC 3339`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := buildCommand(tt.name, tt.args)
+			if err == nil {
+				t.Fatal("error is expected")
+			}
+
+			if got := err.Error(); got != tt.want {
+				t.Errorf("error = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExitCode(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"status error", &switchbot.StatusError{StatusCode: 152, Message: "device not found"}, 152},
+		{"wrapped status error", fmt.Errorf("send command: %w", &switchbot.StatusError{StatusCode: 160}), 160},
+		{"device offline", fmt.Errorf("send command: %w", switchbot.ErrDeviceOffline), 161},
+		{"hub offline", switchbot.ErrHubOffline, 171},
+		{"device internal", fmt.Errorf("%w due to the request limit", switchbot.ErrDeviceInternal), 190},
+		{"out of range", &switchbot.StatusError{StatusCode: 400}, 1},
+		{"other", errors.New("connection refused"), 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := exitCode(tt.err); got != tt.want {
+				t.Errorf("exitCode() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
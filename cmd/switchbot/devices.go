@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/nasa9084/go-switchbot/v3/switchbot"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type listCmd struct{}
+
+// deviceList is the output of the list command.
+type deviceList struct {
+	Devices  []switchbot.Device         `json:"deviceList"`
+	Infrared []switchbot.InfraredDevice `json:"infraredRemoteList"`
+}
+
+func (cmd *listCmd) Run(ctx context.Context, g *globals) error {
+	devices, infrared, err := g.client().Device().List(ctx)
+	if err != nil {
+		return err
+	}
+
+	list := deviceList{Devices: devices, Infrared: infrared}
+	if list.Devices == nil {
+		list.Devices = []switchbot.Device{}
+	}
+	if list.Infrared == nil {
+		list.Infrared = []switchbot.InfraredDevice{}
+	}
+
+	return write(os.Stdout, g.Output, list, func(t *table) {
+		t.row("ID", "NAME", "TYPE", "HUB", "CLOUD")
+		for _, d := range devices {
+			t.row(d.ID, d.Name, string(d.Type), d.Hub, strconv.FormatBool(d.IsEnableCloudService))
+		}
+		for _, d := range infrared {
+			t.row(d.ID, d.Name, string(d.Type)+" (IR)", d.Hub, "")
+		}
+	})
+}
+
+type statusCmd struct {
+	Device string `arg:"" help:"ID or name of the device"`
+}
+
+func (cmd *statusCmd) Run(ctx context.Context, g *globals) error {
+	svc := g.client().Device()
+	id, err := resolve(ctx, svc, cmd.Device, false)
+	if err != nil {
+		return err
+	}
+
+	status, err := svc.Status(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	return write(os.Stdout, g.Output, status, func(t *table) {
+		fields := statusFields(status)
+		keys := make([]string, 0, len(fields))
+		for k := range fields {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		t.row("FIELD", "VALUE")
+		t.row("deviceId", status.ID)
+		t.row("deviceType", string(status.Type))
+		for _, k := range keys {
+			t.row(k, fmt.Sprint(fields[k]))
+		}
+	})
+}
+
+type commandCmd struct {
+	Device  string   `arg:"" help:"ID or name of the device or the infrared remote"`
+	Command string   `arg:"" help:"Command to send, see the commands command"`
+	Params  []string `arg:"" optional:"" help:"Parameters of the command"`
+}
+
+// commandResult is the output of the command command.
+type commandResult struct {
+	DeviceID    string `json:"deviceId"`
+	Command     string `json:"command"`
+	Parameter   string `json:"parameter"`
+	CommandType string `json:"commandType"`
+	StatusCode  int    `json:"statusCode"`
+}
+
+func (cmd *commandCmd) Run(ctx context.Context, g *globals) error {
+	command, err := buildCommand(cmd.Command, cmd.Params)
+	if err != nil {
+		return err
+	}
+
+	svc := g.client().Device()
+	id, err := resolve(ctx, svc, cmd.Device, true)
+	if err != nil {
+		return err
+	}
+
+	if err := svc.Command(ctx, id, command); err != nil {
+		return err
+	}
+
+	req := command.Request()
+	result := commandResult{
+		DeviceID:    id,
+		Command:     req.Command,
+		Parameter:   req.Parameter,
+		CommandType: req.CommandType,
+		StatusCode:  100,
+	}
+
+	return write(os.Stdout, g.Output, result, func(t *table) {
+		t.row("DEVICE", "COMMAND", "PARAMETER", "RESULT")
+		t.row(id, req.Command, req.Parameter, "ok")
+	})
+}
+
+type commandsCmd struct{}
+
+func (cmd *commandsCmd) Run(g *globals) error {
+	type entry struct {
+		Name  string `json:"name"`
+		Usage string `json:"usage"`
+		Help  string `json:"help"`
+	}
+	entries := make([]entry, 0, len(commands))
+	for _, c := range commands {
+		entries = append(entries, entry{Name: c.name, Usage: strings.TrimSpace(c.name + " " + c.params), Help: c.help})
+	}
+
+	return write(os.Stdout, g.Output, entries, func(t *table) {
+		t.row("COMMAND", "HELP")
+		for _, e := range entries {
+			t.row(e.Usage, e.Help)
+		}
+	})
+}
+
+// deviceIDRegexp matches the IDs of physical devices, e.g. C271111EC0AB, and
+// of infrared remotes, e.g. 02-202008110034-13.
+var deviceIDRegexp = regexp.MustCompile(`^([0-9A-F]{12}|[0-9]{2}-[0-9]+-[0-9]+)$`)
+
+// resolve returns the ID of the device given by its ID or name. The device
+// list is fetched, which takes an API call, unless an ID is given.
+// Infrared remotes are looked up as well if infrared is true.
+func resolve(ctx context.Context, svc *switchbot.DeviceService, device string, infrared bool) (string, error) {
+	if deviceIDRegexp.MatchString(device) {
+		return device, nil
+	}
+
+	devices, remotes, err := svc.List(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	type candidate struct{ id, name string }
+	var candidates []candidate
+	for _, d := range devices {
+		candidates = append(candidates, candidate{d.ID, d.Name})
+	}
+	if infrared {
+		for _, d := range remotes {
+			candidates = append(candidates, candidate{d.ID, d.Name})
+		}
+	}
+
+	for _, c := range candidates {
+		if c.id == device {
+			return c.id, nil
+		}
+	}
+
+	// names are matched exactly first, and then case-insensitively
+	for _, match := range []func(string) bool{
+		func(name string) bool { return name == device },
+		func(name string) bool { return strings.EqualFold(name, device) },
+	} {
+		var ids []string
+		for _, c := range candidates {
+			if match(c.name) {
+				ids = append(ids, c.id)
+			}
+		}
+		switch len(ids) {
+		case 0:
+			continue
+		case 1:
+			return ids[0], nil
+		default:
+			return "", fmt.Errorf("%d devices are named %q, use one of the IDs: %s", len(ids), device, strings.Join(ids, ", "))
+		}
+	}
+
+	// the same status code as the API is used for scripts
+	return "", &switchbot.StatusError{StatusCode: 152, Message: "device not found: " + device}
+}
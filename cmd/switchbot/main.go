@@ -0,0 +1,72 @@
+// Command switchbot lists, inspects and controls the devices of a SwitchBot
+// account from the command line.
+//
+//	switchbot devices list
+//	switchbot devices status "Living Meter" -o json
+//	switchbot devices command "Front Door" unlock
+//
+// The exit code is the status code of the SwitchBot API on its errors, e.g.
+// 161 if the device is offline, and 1 on other errors.
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/alecthomas/kong"
+	"github.com/nasa9084/go-switchbot/v3/switchbot"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+type globals struct {
+	Token    string `env:"SWITCHBOT_TOKEN" help:"${env} - Switchbot Developer Token" required:""`
+	Key      string `env:"SWITCHBOT_KEY" help:"${env} - Switchbot Developer Key" required:""`
+	Endpoint string `env:"SWITCHBOT_ENDPOINT" help:"${env} - Switchbot API Endpoint" default:"https://api.switch-bot.com"`
+	Output   string `short:"o" enum:"table,json,yaml" help:"Output format, one of table, json and yaml" default:"table"`
+}
+
+var cli struct {
+	globals
+
+	Devices devicesCmd `cmd:"" help:"Manage devices"`
+}
+
+type devicesCmd struct {
+	List     listCmd     `cmd:"" help:"List the devices and the infrared remotes"`
+	Status   statusCmd   `cmd:"" help:"Show the status of a device"`
+	Command  commandCmd  `cmd:"" help:"Send a command to a device or an infrared remote"`
+	Commands commandsCmd `cmd:"" help:"List the commands which can be sent"`
+}
+
+func main() {
+	ctx := kong.Parse(&cli,
+		kong.Name("switchbot"),
+		kong.Description("A command-line tool for the SwitchBot API"),
+		kong.UsageOnError(),
+	)
+
+	sigCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	ctx.BindTo(sigCtx, (*context.Context)(nil))
+
+	if err := ctx.Run(&cli.globals); err != nil {
+		fmt.Fprintf(os.Stderr, "switchbot: error: %v\n", err)
+		stop()
+		os.Exit(exitCode(err))
+	}
+}
+
+// exitCode returns the status code of the SwitchBot API which caused the
+// error, or 1 if there is none or it does not fit in an exit code.
+func exitCode(err error) int {
+	if code := switchbot.StatusCode(err); 0 < code && code < 256 {
+		return code
+	}
+
+	return 1
+}
+
+func (g *globals) client() *switchbot.Client {
+	return switchbot.New(g.Token, g.Key, switchbot.WithEndpoint(g.Endpoint))
+}
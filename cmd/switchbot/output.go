@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/nasa9084/go-switchbot/v3/switchbot"
+	"gopkg.in/yaml.v3"
+	"io"
+	"strings"
+	"text/tabwriter"
+)
+
+// table is the table output.
+type table struct {
+	w *tabwriter.Writer
+}
+
+func (t *table) row(columns ...string) {
+	fmt.Fprintln(t.w, strings.Join(columns, "\t"))
+}
+
+// write writes v in the format, or the table made by fn if the format is table.
+func write(w io.Writer, format string, v interface{}, fn func(*table)) error {
+	switch format {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	case "yaml":
+		// v is converted through JSON so the keys are the same as the ones in JSON
+		b, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		var generic interface{}
+		dec := json.NewDecoder(bytes.NewReader(b))
+		dec.UseNumber()
+		if err := dec.Decode(&generic); err != nil {
+			return err
+		}
+
+		enc := yaml.NewEncoder(w)
+		enc.SetIndent(2)
+		if err := enc.Encode(yamlValue(generic)); err != nil {
+			return err
+		}
+		return enc.Close()
+	default:
+		t := &table{w: tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)}
+		fn(t)
+		return t.w.Flush()
+	}
+}
+
+// yamlValue replaces the JSON numbers in v with ints or floats, which are
+// encoded as YAML numbers rather than strings.
+func yamlValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		f, _ := v.Float64()
+		return f
+	case map[string]interface{}:
+		for k, e := range v {
+			v[k] = yamlValue(e)
+		}
+	case []interface{}:
+		for i, e := range v {
+			v[i] = yamlValue(e)
+		}
+	}
+
+	return v
+}
+
+// statusFields returns the non-zero fields of the status keyed by their JSON
+// names, except the ones of the device itself.
+func statusFields(status switchbot.DeviceStatus) map[string]interface{} {
+	fields := map[string]interface{}{}
+	if b, err := json.Marshal(status); err == nil {
+		_ = json.Unmarshal(b, &fields)
+	}
+
+	delete(fields, "deviceId")
+	delete(fields, "deviceType")
+	delete(fields, "hubDeviceId")
+	for k, v := range fields {
+		switch v {
+		case nil, "", float64(0), false:
+			delete(fields, k)
+		}
+	}
+
+	return fields
+}
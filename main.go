@@ -1,50 +1,196 @@
 package main
 
 import (
+	"context"
 	"github.com/alecthomas/kong"
 	"github.com/nasa9084/go-switchbot/v3/prom"
+	"github.com/nasa9084/go-switchbot/v3/switchbot"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 )
 
 var cli struct {
-	MetricsPath     string `env:"EXPORTER_METRICS_PATH" help:"${env} - Path under which to expose metrics" default:"/metrics"`
-	DefaultEndpoint string `env:"DEFAULT_ENDPOINT" help:"${env} - Switchbot API Endpoint" default:"https://api.switch-bot.com"`
-	ListenAddress   string `env:"EXPORTER_LISTEN_ADDRESS"  help:"${env} - Address to listen on for web interface and telemetry" default:":9617"`
-	Token           string `env:"SWITCHBOT_TOKEN" help:"${env} - Switchbot Developer Token" required:""`
-	Key             string `env:"SWITCHBOT_KEY" help:"${env} - Switchbot Developer Key" required:""`
+	Exporter exporterCmd `cmd:"" default:"withargs" help:"Run the Prometheus exporter (default)"`
+	Relay    relayCmd    `cmd:"" help:"Relay SwitchBot webhook events to downstream receivers"`
+	Simulate simulateCmd `cmd:"" help:"Send simulated SwitchBot webhook events for testing"`
+}
+
+type exporterCmd struct {
+	MetricsPath     string        `env:"EXPORTER_METRICS_PATH" help:"${env} - Path under which to expose metrics" default:"/metrics"`
+	DefaultEndpoint string        `env:"DEFAULT_ENDPOINT" help:"${env} - Switchbot API Endpoint" default:"https://api.switch-bot.com"`
+	ListenAddress   string        `env:"EXPORTER_LISTEN_ADDRESS"  help:"${env} - Address to listen on for web interface and telemetry" default:":9617"`
+	Token           string        `env:"SWITCHBOT_TOKEN" help:"${env} - Switchbot Developer Token" required:""`
+	Key             string        `env:"SWITCHBOT_KEY" help:"${env} - Switchbot Developer Key" required:""`
+	PollInterval    time.Duration `env:"SWITCHBOT_POLL_INTERVAL" help:"${env} - Interval between polls of the devices whose type has no poll policy" default:"5m"`
+	ListInterval    time.Duration `env:"SWITCHBOT_LIST_INTERVAL" help:"${env} - Interval between fetches of the Switchbot device list" default:"1h"`
+	DailyBudget     int           `env:"SWITCHBOT_DAILY_BUDGET" help:"${env} - Number of Switchbot API calls per day the poll plan fits in" default:"9000"`
+	MaxBackoff      time.Duration `env:"SWITCHBOT_MAX_BACKOFF" help:"${env} - Maximum interval between polls while the Switchbot API errors" default:"1h"`
+	StaleThreshold  time.Duration `env:"SWITCHBOT_STALE_THRESHOLD" help:"${env} - Age after which device values are reported as stale, three poll intervals if zero"`
+	Account         string        `env:"SWITCHBOT_ACCOUNT" help:"${env} - Value of the account label added to all metrics, no label if empty"`
+	DailyQuota      int           `env:"SWITCHBOT_DAILY_QUOTA" help:"${env} - Number of Switchbot API calls allowed per day" default:"10000"`
+	Config          string        `env:"EXPORTER_CONFIG" help:"${env} - YAML file of device filters and labels, reloaded on SIGHUP" type:"existingfile"`
+	ProbePath       string        `env:"EXPORTER_PROBE_PATH" help:"${env} - Path under which to probe the accounts in the config on demand" default:"/probe"`
+	ProbeCacheTTL   time.Duration `env:"EXPORTER_PROBE_CACHE_TTL" help:"${env} - Age of the snapshot of an account after which a probe refreshes it" default:"1m"`
+
+	WebhookPath        string        `env:"EXPORTER_WEBHOOK_PATH" help:"${env} - Path under which to receive Switchbot webhook events, disabled if empty"`
+	WebhookToken       string        `env:"SWITCHBOT_WEBHOOK_TOKEN" help:"${env} - Token required in the token query parameter of webhook requests"`
+	PushedPollInterval time.Duration `env:"SWITCHBOT_PUSHED_POLL_INTERVAL" help:"${env} - Interval between polls of the devices which send webhook events" default:"1h"`
+
+	serverFlags `embed:""`
 }
 
 func main() {
 	//region Initialization
 	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
-	kong.Parse(&cli)
+	ctx := kong.Parse(&cli)
+	ctx.FatalIfErrorf(ctx.Run())
+}
+
+func (cmd *exporterCmd) Run() error {
+	if err := cmd.validate(); err != nil {
+		return err
+	}
 
 	// Set up Switchbot, and refresh device data
-	prom.New(cli.Token, cli.Key)
-
-	prometheus.MustRegister(prom.NewExporter())
-	http.Handle(cli.MetricsPath, promhttp.Handler())
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		_, err := w.Write([]byte(`<html>
-					<head><title>B2 Exporter</title></head>
-		            <body>
-		            <h1>B2 Exporter</h1>
-		            <p><a href='` + cli.MetricsPath + `'>Metrics</a></p>
-		            </body>
-		            </html>`))
-		if err != nil {
-			return
+	staleThreshold := cmd.StaleThreshold
+	if staleThreshold <= 0 {
+		staleThreshold = 3 * cmd.PollInterval
+	}
+	apiMetrics := prom.NewAPIMetrics(prom.WithAPIAccount(cmd.Account), prom.WithDailyQuota(cmd.DailyQuota))
+	client := switchbot.New(cmd.Token, cmd.Key,
+		switchbot.WithEndpoint(cmd.DefaultEndpoint),
+		switchbot.WithHTTPClient(&http.Client{Transport: apiMetrics.Transport(nil)}),
+	)
+	var config *prom.Config
+	if cmd.Config != "" {
+		var err error
+		if config, err = prom.LoadConfig(cmd.Config); err != nil {
+			return err
+		}
+	}
+
+	exporter := prom.NewExporter(
+		client.Device(),
+		prom.WithConfig(config),
+		prom.WithStaleThreshold(staleThreshold),
+		prom.WithAccount(cmd.Account),
+		prom.WithPushedPollInterval(cmd.PushedPollInterval),
+		prom.WithDefaultPollInterval(cmd.PollInterval),
+		prom.WithListInterval(cmd.ListInterval),
+		prom.WithDailyBudget(cmd.DailyBudget),
+	)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go exporter.Poll(ctx, cmd.MaxBackoff)
+
+	prober := prom.NewProber(config,
+		prom.WithProbeCacheTTL(cmd.ProbeCacheTTL),
+		prom.WithProbeDailyBudget(cmd.DailyBudget),
+		prom.WithProbeClient(func(a prom.AccountConfig, httpClient *http.Client) prom.DeviceClient {
+			return switchbot.New(a.Token, a.Key,
+				switchbot.WithEndpoint(cmd.DefaultEndpoint),
+				switchbot.WithHTTPClient(httpClient),
+			).Device()
+		}),
+		prom.WithProbeAPIMetricsOptions(prom.WithDailyQuota(cmd.DailyQuota)),
+		// the account of the exporter may be probed too
+		prom.WithProbeSharedAPIMetrics(apiMetrics),
+		prom.WithProbeExporterOptions(
+			prom.WithStaleThreshold(staleThreshold),
+			prom.WithDefaultPollInterval(cmd.PollInterval),
+		),
+	)
+	if cmd.Config != "" {
+		go cmd.reloadOnSIGHUP(ctx, exporter.SetConfig, prober.SetConfig)
+	}
+
+	prometheus.MustRegister(exporter, apiMetrics, prober)
+	mux := http.NewServeMux()
+	mux.Handle(cmd.MetricsPath, cmd.protect(promhttp.Handler()))
+	mux.Handle("/debug/plan", cmd.protect(exporter.PlanHandler()))
+	mux.Handle(cmd.ProbePath, cmd.protect(prober))
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok\n"))
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case !apiMetrics.Authenticated():
+			http.Error(w, "credentials are rejected by the Switchbot API", http.StatusServiceUnavailable)
+		case !exporter.Ready():
+			http.Error(w, "devices have not been polled yet", http.StatusServiceUnavailable)
+		default:
+			_, _ = w.Write([]byte("ok\n"))
 		}
 	})
 
-	log.Info().Msgf("⚡ Starting HTTP server http://127.0.0.1%s%s on listen address %s and metric path %s", cli.ListenAddress, cli.MetricsPath, cli.ListenAddress, cli.MetricsPath)
+	if cmd.WebhookPath != "" {
+		webhook := switchbot.NewWebhookHandler(
+			switchbot.WithErrorHandler(func(err error) {
+				log.Warn().Err(err).Msg("⚠️ failed to handle webhook event")
+			}),
+			// redelivered events are dropped, and late events are still
+			// counted but do not overwrite the status, see ApplyEvent
+			switchbot.WithDeduplicator(switchbot.NewDeduplicator(switchbot.WithLatePolicy(switchbot.PassLateEvents))),
+		)
+		defer webhook.Close()
+		webhook.OnEvent(exporter.ApplyEvent)
+
+		var h http.Handler = webhook
+		if cmd.WebhookToken != "" {
+			auth, err := switchbot.NewWebhookAuth(cmd.WebhookToken)
+			if err != nil {
+				return err
+			}
+			h = auth.Middleware(h)
+		}
+		mux.Handle(cmd.WebhookPath, h)
+		log.Info().Msgf("🪝 Receiving webhook events on path %s", cmd.WebhookPath)
+	}
+	// the status page on / also serves the JSON API under /api/devices
+	mux.Handle("/", cmd.protect(prom.NewStatusHandler(exporter,
+		prom.WithStatusAPIMetrics(apiMetrics),
+		prom.WithStatusMetricsPath(cmd.MetricsPath),
+	)))
+
+	scheme := "http"
+	if cmd.TLSCertFile != "" {
+		scheme = "https"
+	}
+	log.Info().Msgf("⚡ Starting HTTP server %s://127.0.0.1%s%s on listen address %s and metric path %s", scheme, cmd.ListenAddress, cmd.MetricsPath, cmd.ListenAddress, cmd.MetricsPath)
+
+	return cmd.serve(ctx, cmd.ListenAddress, mux)
+}
+
+// reloadOnSIGHUP reloads the config file on every SIGHUP, and passes it to
+// each of apply. The current config is kept if the file is invalid.
+func (cmd *exporterCmd) reloadOnSIGHUP(ctx context.Context, apply ...func(*prom.Config)) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+		}
 
-	if err := http.ListenAndServe(cli.ListenAddress, nil); err != nil {
-		log.Fatal().Err(err).Msgf("⛔️ %v", err)
+		config, err := prom.LoadConfig(cmd.Config)
+		if err != nil {
+			log.Error().Err(err).Msgf("⛔️ failed to reload config %s", cmd.Config)
+			continue
+		}
+		for _, fn := range apply {
+			fn(config)
+		}
+		log.Info().Msgf("🔄 Reloaded config %s", cmd.Config)
 	}
 }
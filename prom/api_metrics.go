@@ -0,0 +1,246 @@
+package prom
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/prometheus/client_golang/prometheus"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultDailyQuota is the number of SwitchBot API calls allowed per day.
+const DefaultDailyQuota = 10000
+
+// APIMetrics instruments the requests to the SwitchBot API made through
+// its Transport, and exports them as Prometheus metrics.
+type APIMetrics struct {
+	account    string
+	dailyQuota int
+	now        func() time.Time
+
+	requests *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+	quota    *prometheus.Desc
+	authOK   *prometheus.Desc
+
+	mu sync.Mutex
+	// day is the UTC day the requests in dayRequests are made on.
+	day         time.Time
+	dayRequests int
+	// auth is 1 if the last response was authenticated, 0 if not, and -1
+	// before the first response.
+	auth float64
+}
+
+// APIMetricsOption configures the APIMetrics.
+type APIMetricsOption func(*APIMetrics)
+
+// WithAPIAccount sets the value of the account label added to all API metrics.
+func WithAPIAccount(account string) APIMetricsOption {
+	return func(m *APIMetrics) {
+		m.account = account
+	}
+}
+
+// WithDailyQuota sets the number of API calls allowed per day, used to
+// estimate the remaining quota.
+func WithDailyQuota(quota int) APIMetricsOption {
+	return func(m *APIMetrics) {
+		m.dailyQuota = quota
+	}
+}
+
+// WithAPIClock sets the clock used to count the requests of the day.
+func WithAPIClock(now func() time.Time) APIMetricsOption {
+	return func(m *APIMetrics) {
+		m.now = now
+	}
+}
+
+// NewAPIMetrics returns a new APIMetrics.
+func NewAPIMetrics(opts ...APIMetricsOption) *APIMetrics {
+	m := &APIMetrics{
+		dailyQuota: DefaultDailyQuota,
+		now:        time.Now,
+		auth:       -1,
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+
+	var constLabels prometheus.Labels
+	if m.account != "" {
+		constLabels = prometheus.Labels{"account": m.account}
+	}
+
+	m.requests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace:   namespace,
+		Subsystem:   "api",
+		Name:        "requests_total",
+		Help:        "The number of requests to the SwitchBot API",
+		ConstLabels: constLabels,
+	}, []string{"endpoint", "http_status", "switchbot_status"})
+	m.duration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace:   namespace,
+		Subsystem:   "api",
+		Name:        "request_duration_seconds",
+		Help:        "The latency of the requests to the SwitchBot API",
+		ConstLabels: constLabels,
+		Buckets:     prometheus.DefBuckets,
+	}, []string{"endpoint"})
+	m.quota = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "api", "quota_remaining"),
+		"The estimated number of the API calls left today, counted from the requests made by this process since 00:00 UTC",
+		nil,
+		constLabels,
+	)
+	m.authOK = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "api", "auth_ok"),
+		"determines if the last response of the SwitchBot API was authenticated or not",
+		nil,
+		constLabels,
+	)
+
+	return m
+}
+
+// Transport returns an http.RoundTripper which instruments the requests made
+// through next, http.DefaultTransport if nil.
+// Use it as the transport of the http.Client given to switchbot.WithHTTPClient.
+func (m *APIMetrics) Transport(next http.RoundTripper) http.RoundTripper {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+
+	return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		endpoint := apiEndpoint(req.URL.Path)
+
+		m.count()
+		started := time.Now()
+		resp, err := next.RoundTrip(req)
+		m.duration.WithLabelValues(endpoint).Observe(time.Since(started).Seconds())
+
+		if err != nil {
+			m.requests.WithLabelValues(endpoint, "", "").Inc()
+			return nil, err
+		}
+
+		var statusCode string
+		resp.Body, statusCode = peekStatusCode(resp.Body)
+		m.requests.WithLabelValues(endpoint, strconv.Itoa(resp.StatusCode), statusCode).Inc()
+
+		m.mu.Lock()
+		m.auth = Bool2f64(resp.StatusCode != http.StatusUnauthorized && resp.StatusCode != http.StatusForbidden)
+		m.mu.Unlock()
+
+		return resp, nil
+	})
+}
+
+// Usage returns the number of requests made since 00:00 UTC and the daily quota.
+func (m *APIMetrics) Usage() (used, quota int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.day.Equal(m.now().UTC().Truncate(24 * time.Hour)) {
+		used = m.dayRequests
+	}
+
+	return used, m.dailyQuota
+}
+
+// Authenticated reports whether the last response of the API was
+// authenticated. It is true before the first response.
+func (m *APIMetrics) Authenticated() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.auth != 0
+}
+
+func (m *APIMetrics) count() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	day := m.now().UTC().Truncate(24 * time.Hour)
+	if !day.Equal(m.day) {
+		m.day = day
+		m.dayRequests = 0
+	}
+	m.dayRequests++
+}
+
+// Describe Prometheus Describer
+func (m *APIMetrics) Describe(descs chan<- *prometheus.Desc) {
+	m.requests.Describe(descs)
+	m.duration.Describe(descs)
+	descs <- m.quota
+	descs <- m.authOK
+}
+
+// Collect Prometheus Collector
+func (m *APIMetrics) Collect(metrics chan<- prometheus.Metric) {
+	m.requests.Collect(metrics)
+	m.duration.Collect(metrics)
+
+	used, quota := m.Usage()
+	remaining := quota - used
+	if remaining < 0 {
+		remaining = 0
+	}
+	m.mu.Lock()
+	auth := m.auth
+	m.mu.Unlock()
+
+	metrics <- prometheus.MustNewConstMetric(m.quota, prometheus.GaugeValue, float64(remaining))
+	if auth >= 0 {
+		metrics <- prometheus.MustNewConstMetric(m.authOK, prometheus.GaugeValue, auth)
+	}
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// apiEndpoint returns the path with the device and scene IDs replaced with
+// placeholders, to keep the cardinality of the endpoint label low.
+func apiEndpoint(path string) string {
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	// e.g. v1.1/devices/{id}/status
+	if len(segments) >= 3 && (segments[1] == "devices" || segments[1] == "scenes") {
+		segments[2] = "{id}"
+	}
+
+	return "/" + strings.Join(segments, "/")
+}
+
+// maxPeekSize is the maximum size of the response body read to find the status code.
+const maxPeekSize = 1 << 20
+
+// peekStatusCode returns the SwitchBot status code in the JSON response body,
+// and a body which reads the same content as the given one.
+func peekStatusCode(body io.ReadCloser) (io.ReadCloser, string) {
+	b, err := io.ReadAll(io.LimitReader(body, maxPeekSize))
+	rest := struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(b), body), body}
+	if err != nil {
+		return rest, ""
+	}
+
+	var v struct {
+		StatusCode *int `json:"statusCode"`
+	}
+	if json.Unmarshal(b, &v) != nil || v.StatusCode == nil {
+		return rest, ""
+	}
+
+	return rest, strconv.Itoa(*v.StatusCode)
+}
@@ -0,0 +1,88 @@
+package prom_test
+
+import (
+	"context"
+	"github.com/google/go-cmp/cmp"
+	"github.com/nasa9084/go-switchbot/v3/prom"
+	"github.com/nasa9084/go-switchbot/v3/switchbot"
+	"github.com/prometheus/client_golang/prometheus"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestAPIMetrics(t *testing.T) {
+	authorized := true
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !authorized {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		switch r.URL.Path {
+		case "/v1.1/devices":
+			w.Write([]byte(`{"statusCode":100,"body":{"deviceList":[{"deviceId":"meter","deviceName":"Living","deviceType":"Meter"},{"deviceId":"lock","deviceName":"Door","deviceType":"Smart Lock"}],"infraredRemoteList":[]},"message":"success"}`))
+		case "/v1.1/devices/meter/status":
+			w.Write([]byte(`{"statusCode":100,"body":{"deviceId":"meter","deviceType":"Meter","temperature":21.5},"message":"success"}`))
+		case "/v1.1/devices/lock/status":
+			w.Write([]byte(`{"statusCode":171,"body":{},"message":"hub device is offline"}`))
+		default:
+			t.Errorf("unexpected request path: %s", r.URL.Path)
+		}
+	}))
+	defer srv.Close()
+
+	now := time.Date(2024, 1, 1, 23, 0, 0, 0, time.UTC)
+	api := prom.NewAPIMetrics(prom.WithDailyQuota(100), prom.WithAPIClock(func() time.Time { return now }))
+	client := switchbot.New("", "", switchbot.WithEndpoint(srv.URL), switchbot.WithHTTPClient(&http.Client{Transport: api.Transport(nil)}))
+	e := prom.NewExporter(client.Device())
+
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(e, api)
+
+	if err := e.Refresh(context.Background()); err == nil {
+		t.Fatal("error is expected for the lock status")
+	}
+
+	want := map[string]float64{
+		"endpoint=/v1.1/devices,http_status=200,switchbot_status=100":             1,
+		"endpoint=/v1.1/devices/{id}/status,http_status=200,switchbot_status=100": 1,
+		"endpoint=/v1.1/devices/{id}/status,http_status=200,switchbot_status=171": 1,
+	}
+	if diff := cmp.Diff(want, gather(t, reg, "switchbot_api_requests_total")); diff != "" {
+		t.Errorf("requests mismatch (-want +got):\n%s", diff)
+	}
+
+	want = map[string]float64{"id=lock,kind=hub_offline,name=Door": 1}
+	if diff := cmp.Diff(want, gather(t, reg, "switchbot_device_status_errors_total")); diff != "" {
+		t.Errorf("status errors mismatch (-want +got):\n%s", diff)
+	}
+
+	if diff := cmp.Diff(map[string]float64{"": 97}, gather(t, reg, "switchbot_api_quota_remaining")); diff != "" {
+		t.Errorf("quota mismatch (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff(map[string]float64{"": 1}, gather(t, reg, "switchbot_api_auth_ok")); diff != "" {
+		t.Errorf("auth mismatch (-want +got):\n%s", diff)
+	}
+	if !api.Authenticated() {
+		t.Error("authenticated is expected")
+	}
+
+	// the quota is reset on the next day
+	now = now.Add(2 * time.Hour)
+	authorized = false
+	if err := e.Refresh(context.Background()); err == nil {
+		t.Fatal("error is expected for the unauthorized request")
+	}
+
+	if diff := cmp.Diff(map[string]float64{"": 99}, gather(t, reg, "switchbot_api_quota_remaining")); diff != "" {
+		t.Errorf("quota mismatch (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff(map[string]float64{"": 0}, gather(t, reg, "switchbot_api_auth_ok")); diff != "" {
+		t.Errorf("auth mismatch (-want +got):\n%s", diff)
+	}
+	if api.Authenticated() {
+		t.Error("unauthenticated is expected")
+	}
+}
@@ -0,0 +1,333 @@
+package prom
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/nasa9084/go-switchbot/v3/switchbot"
+	"gopkg.in/yaml.v3"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Config is the exporter configuration loaded from a YAML file.
+//
+//	include:
+//	  - types: [Meter, Meter Plus (JP)]
+//	exclude:
+//	  - name: "^Test "
+//	groupLabel: room
+//	labels:
+//	  C271111EC0AB: {room: living, floor: "1"}
+//	  Front Door: {room: entrance, owner: alice}
+//	names:
+//	  C271111EC0AB: living_meter
+//	sanitizeNames: true
+//	polling:
+//	  dailyBudget: 9000
+//	  types:
+//	    Meter: {interval: 10m, priority: low}
+//	  devices:
+//	    Front Door: {interval: 15s, priority: high}
+//	    Test Plug: {never: true}
+//	accounts:
+//	  office: {token: blahblahblah, key: blahblahblah}
+type Config struct {
+	// Include is the list of rules of the exported devices. All devices are
+	// exported if empty.
+	Include []DeviceRule `yaml:"include"`
+	// Exclude is the list of rules of the devices which are not exported
+	// even if they match Include. Excluded devices are not polled either.
+	Exclude []DeviceRule `yaml:"exclude"`
+	// GroupLabel is the name of the label whose value is the group name of
+	// the device, e.g. room. No label is added if empty.
+	GroupLabel string `yaml:"groupLabel"`
+	// Labels are the static labels added to the devices, keyed by the
+	// device ID or name.
+	Labels map[string]map[string]string `yaml:"labels"`
+	// Names are the values of the name label, keyed by the device ID or name.
+	Names map[string]string `yaml:"names"`
+	// SanitizeNames converts the values of the name label which are not in
+	// Names into lower snake case, e.g. "Living Room Meter" becomes
+	// living_room_meter.
+	SanitizeNames bool `yaml:"sanitizeNames"`
+	// Polling overrides the poll plan.
+	Polling PollingConfig `yaml:"polling"`
+	// Accounts are the credentials of the SwitchBot accounts keyed by the
+	// names given in the target parameter of probes, see Prober.
+	Accounts map[string]AccountConfig `yaml:"accounts"`
+
+	// labelNames is the sorted list of the names of the labels added to the devices.
+	labelNames []string
+}
+
+// PollingConfig overrides the poll plan of the exporter.
+type PollingConfig struct {
+	// DailyBudget is the number of API calls per day the plan fits in.
+	DailyBudget int `yaml:"dailyBudget"`
+	// Types are the poll policies keyed by the device type.
+	Types map[string]PollPolicy `yaml:"types"`
+	// Devices are the poll policies keyed by the device ID or name, which
+	// take precedence over Types.
+	Devices map[string]PollPolicy `yaml:"devices"`
+}
+
+// AccountConfig is the credentials of a SwitchBot account.
+type AccountConfig struct {
+	// Token is the open token of the account.
+	Token string `yaml:"token"`
+	// Key is the secret key of the account.
+	Key string `yaml:"key"`
+}
+
+// DeviceRule matches the devices which match all the non-empty fields.
+type DeviceRule struct {
+	// Types is the list of the device types, one of which the device has.
+	Types []string `yaml:"types"`
+	// IDs is the list of the device IDs, one of which the device has.
+	IDs []string `yaml:"ids"`
+	// HubIDs is the list of the hub device IDs, one of which the device is connected to.
+	HubIDs []string `yaml:"hubIds"`
+	// Name is the regular expression the device name matches.
+	Name string `yaml:"name"`
+
+	name *regexp.Regexp
+}
+
+var (
+	labelNameRegexp = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+	// reservedLabelNames are the label names used by the exporter.
+	reservedLabelNames = map[string]bool{
+		"id": true, "name": true, "state": true, "account": true,
+		"type": true, "group": true, "hub_id": true, "version": true,
+	}
+	unsafeNameRegexp = regexp.MustCompile(`[^a-z0-9]+`)
+)
+
+// LoadConfig reads the exporter configuration from the YAML file.
+func LoadConfig(path string) (*Config, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseConfig(b)
+}
+
+// ParseConfig parses the exporter configuration in YAML.
+func ParseConfig(b []byte) (*Config, error) {
+	var c Config
+	dec := yaml.NewDecoder(bytes.NewReader(b))
+	dec.KnownFields(true)
+	if err := dec.Decode(&c); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("parsing config: %w", err)
+	}
+
+	if err := c.init(); err != nil {
+		return nil, err
+	}
+
+	return &c, nil
+}
+
+func (c *Config) init() error {
+	for _, rules := range [][]DeviceRule{c.Include, c.Exclude} {
+		for i := range rules {
+			if rules[i].Name == "" {
+				continue
+			}
+			re, err := regexp.Compile(rules[i].Name)
+			if err != nil {
+				return fmt.Errorf("invalid name pattern %q: %w", rules[i].Name, err)
+			}
+			rules[i].name = re
+		}
+	}
+
+	for name, account := range c.Accounts {
+		if account.Token == "" || account.Key == "" {
+			return fmt.Errorf("token and key are required for account %s", name)
+		}
+	}
+
+	names := map[string]bool{}
+	if c.GroupLabel != "" {
+		names[c.GroupLabel] = true
+	}
+	for _, labels := range c.Labels {
+		for name := range labels {
+			names[name] = true
+		}
+	}
+
+	c.labelNames = make([]string, 0, len(names))
+	for name := range names {
+		if !labelNameRegexp.MatchString(name) {
+			return fmt.Errorf("invalid label name: %s", name)
+		}
+		if reservedLabelNames[name] {
+			return fmt.Errorf("label name is reserved: %s", name)
+		}
+		c.labelNames = append(c.labelNames, name)
+	}
+	sort.Strings(c.labelNames)
+
+	return nil
+}
+
+// Exported reports whether the device is exported.
+func (c *Config) Exported(d switchbot.Device) bool {
+	if c == nil {
+		return true
+	}
+
+	if len(c.Include) > 0 && !matchAny(c.Include, d) {
+		return false
+	}
+
+	return !matchAny(c.Exclude, d)
+}
+
+// LabelNames returns the names of the labels added to the devices.
+func (c *Config) LabelNames() []string {
+	if c == nil {
+		return nil
+	}
+
+	return c.labelNames
+}
+
+// LabelValues returns the values of the labels added to the device, in the
+// order of LabelNames.
+func (c *Config) LabelValues(d switchbot.Device) []string {
+	if c == nil {
+		return nil
+	}
+
+	labels := map[string]string{}
+	if c.GroupLabel != "" {
+		labels[c.GroupLabel] = d.GroupName
+	}
+	for _, key := range []string{d.Name, d.ID} {
+		// labels keyed by ID take precedence over ones keyed by name
+		for k, v := range c.Labels[key] {
+			labels[k] = v
+		}
+	}
+
+	values := make([]string, len(c.labelNames))
+	for i, name := range c.labelNames {
+		values[i] = labels[name]
+	}
+
+	return values
+}
+
+// DeviceName returns the value of the name label of the device.
+func (c *Config) DeviceName(d switchbot.Device) string {
+	if c == nil {
+		return d.Name
+	}
+
+	if name, ok := c.Names[d.ID]; ok {
+		return name
+	}
+	if name, ok := c.Names[d.Name]; ok {
+		return name
+	}
+	if c.SanitizeNames {
+		return strings.Trim(unsafeNameRegexp.ReplaceAllString(strings.ToLower(d.Name), "_"), "_")
+	}
+
+	return d.Name
+}
+
+// account returns the credentials of the named account.
+func (c *Config) account(name string) (AccountConfig, bool) {
+	if c == nil {
+		return AccountConfig{}, false
+	}
+
+	account, ok := c.Accounts[name]
+	return account, ok
+}
+
+// sameFilters reports whether the config exports the same devices as o.
+func (c *Config) sameFilters(o *Config) bool {
+	var include, exclude, otherInclude, otherExclude []DeviceRule
+	if c != nil {
+		include, exclude = c.Include, c.Exclude
+	}
+	if o != nil {
+		otherInclude, otherExclude = o.Include, o.Exclude
+	}
+
+	return equalRules(include, otherInclude) && equalRules(exclude, otherExclude)
+}
+
+func equalRules(a, b []DeviceRule) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Name != b[i].Name || !equalStrings(a[i].Types, b[i].Types) ||
+			!equalStrings(a[i].IDs, b[i].IDs) || !equalStrings(a[i].HubIDs, b[i].HubIDs) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+func matchAny(rules []DeviceRule, d switchbot.Device) bool {
+	for _, rule := range rules {
+		if rule.match(d) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (r DeviceRule) match(d switchbot.Device) bool {
+	if len(r.Types) > 0 && !contains(r.Types, string(d.Type)) {
+		return false
+	}
+	if len(r.IDs) > 0 && !contains(r.IDs, d.ID) {
+		return false
+	}
+	if len(r.HubIDs) > 0 && !contains(r.HubIDs, d.Hub) {
+		return false
+	}
+	if r.name != nil && !r.name.MatchString(d.Name) {
+		return false
+	}
+
+	return true
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+
+	return false
+}
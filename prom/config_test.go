@@ -0,0 +1,99 @@
+package prom_test
+
+import (
+	"context"
+	"github.com/google/go-cmp/cmp"
+	"github.com/nasa9084/go-switchbot/v3/prom"
+	"github.com/nasa9084/go-switchbot/v3/switchbot"
+	"github.com/prometheus/client_golang/prometheus"
+	"testing"
+)
+
+func TestConfig(t *testing.T) {
+	config, err := prom.ParseConfig([]byte(`
+include:
+  - types: [Meter, Curtain]
+exclude:
+  - name: "^Test "
+  - hubIds: [HUB2]
+groupLabel: room
+labels:
+  METER1: {floor: "1"}
+  Bedroom Curtain: {floor: "2", owner: alice}
+names:
+  METER1: living
+sanitizeNames: true
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := &fakeClient{
+		devices: []switchbot.Device{
+			{ID: "METER1", Name: "Living Meter", Type: switchbot.Meter, Hub: "HUB1"},
+			{ID: "METER2", Name: "Test Meter", Type: switchbot.Meter, Hub: "HUB1"},
+			{ID: "METER3", Name: "Garage Meter", Type: switchbot.Meter, Hub: "HUB2"},
+			{ID: "CURTAIN1", Name: "Bedroom Curtain", Type: switchbot.Curtain, Hub: "HUB1", GroupName: "bedroom"},
+			{ID: "PLUG1", Name: "Plug", Type: switchbot.Plug, Hub: "HUB1"},
+		},
+		status: map[string]switchbot.DeviceStatus{
+			"METER1":   {Battery: 90},
+			"METER2":   {Battery: 80},
+			"METER3":   {Battery: 70},
+			"CURTAIN1": {Battery: 60},
+		},
+	}
+	e := prom.NewExporter(client, prom.WithConfig(config))
+
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(e)
+
+	if err := e.Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// excluded devices are not polled
+	if diff := cmp.Diff([]string{"METER1", "CURTAIN1"}, client.polled); diff != "" {
+		t.Errorf("polled devices mismatch (-want +got):\n%s", diff)
+	}
+
+	want := map[string]float64{
+		"floor=1,id=METER1,name=living,owner=,room=":                        90,
+		"floor=2,id=CURTAIN1,name=bedroom_curtain,owner=alice,room=bedroom": 60,
+	}
+	if diff := cmp.Diff(want, gather(t, reg, "switchbot_device_battery")); diff != "" {
+		t.Errorf("battery mismatch (-want +got):\n%s", diff)
+	}
+
+	t.Run("reload", func(t *testing.T) {
+		config, err := prom.ParseConfig([]byte(`exclude: [{types: [Curtain]}]`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		e.SetConfig(config)
+
+		want := map[string]float64{"id=METER1,name=Living Meter": 90}
+		if diff := cmp.Diff(want, gather(t, reg, "switchbot_device_battery")); diff != "" {
+			t.Errorf("battery mismatch (-want +got):\n%s", diff)
+		}
+	})
+}
+
+func TestParseConfigError(t *testing.T) {
+	tests := map[string]string{
+		"unknown field":       `exlude: []`,
+		"invalid pattern":     `include: [{name: "("}]`,
+		"invalid label name":  `labels: {METER1: {"floor-number": "1"}}`,
+		"reserved label":      `groupLabel: name`,
+		"unknown priority":    `polling: {types: {Meter: {priority: urgent}}}`,
+		"account without key": `accounts: {office: {token: blahblahblah}}`,
+	}
+
+	for name, config := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := prom.ParseConfig([]byte(config)); err == nil {
+				t.Errorf("error is expected for %q", config)
+			}
+		})
+	}
+}
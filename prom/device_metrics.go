@@ -0,0 +1,346 @@
+package prom
+
+import (
+	"github.com/nasa9084/go-switchbot/v3/switchbot"
+	"strings"
+)
+
+// deviceMetric is a metric taken from the status of the devices of deviceTypes.
+// Adding a device type to deviceTypes is all that is needed to export the
+// metric for a new device.
+type deviceMetric struct {
+	name        string
+	help        string
+	deviceTypes []switchbot.PhysicalDeviceType
+	// value returns the value of the metric, and false if the device does
+	// not report the value. It is not used for state set metrics.
+	value func(status switchbot.DeviceStatus) (float64, bool)
+	// state returns the current state of a state set metric, which has a
+	// series for each of states with 1 for the current state and 0 for the
+	// others. The metric is not exported if the state is empty.
+	state  func(status switchbot.DeviceStatus) string
+	states []string
+}
+
+var (
+	meterDevices = []switchbot.PhysicalDeviceType{
+		switchbot.Meter, switchbot.MeterPlus, switchbot.MeterPlusJP, switchbot.MeterPlusUS,
+		switchbot.WoIOSensor, switchbot.MeterPro, switchbot.MeterProCO2,
+	}
+	curtainDevices = []switchbot.PhysicalDeviceType{
+		switchbot.Curtain, switchbot.Curtain3,
+	}
+	lockDevices = []switchbot.PhysicalDeviceType{
+		switchbot.Lock, switchbot.SmartLockPro,
+	}
+	plugMiniDevices = []switchbot.PhysicalDeviceType{
+		switchbot.PlugMiniUS, switchbot.PlugMiniJP,
+	}
+	lightDevices = []switchbot.PhysicalDeviceType{
+		switchbot.ColorBulb, switchbot.StripLight, switchbot.CeilingLight, switchbot.CeilingLightPro,
+	}
+	cleanerDevices = []switchbot.PhysicalDeviceType{
+		switchbot.RobotVacuumCleanerS1, switchbot.RobotVacuumCleanerS1Plus,
+		switchbot.WoSweeperMini, switchbot.WoSweeperMiniPro,
+	}
+	sensorDevices = []switchbot.PhysicalDeviceType{
+		switchbot.MotionSensor, switchbot.ContactSensor,
+	}
+	fanDevices = []switchbot.PhysicalDeviceType{
+		switchbot.SmartFan,
+	}
+	humidifierDevices = []switchbot.PhysicalDeviceType{
+		switchbot.Humidifier,
+	}
+	batteryDevices = concatDeviceTypes(
+		meterDevices, curtainDevices, lockDevices, cleanerDevices, sensorDevices,
+		[]switchbot.PhysicalDeviceType{
+			switchbot.Bot, switchbot.BlindTilt, switchbot.KeyPad, switchbot.KeyPadTouch,
+			switchbot.BatteryCirculatorFan,
+		},
+	)
+	powerDevices = concatDeviceTypes(
+		plugMiniDevices, lightDevices, fanDevices, humidifierDevices,
+		[]switchbot.PhysicalDeviceType{
+			switchbot.Bot, switchbot.Plug, switchbot.BatteryCirculatorFan,
+		},
+	)
+)
+
+func concatDeviceTypes(lists ...[]switchbot.PhysicalDeviceType) []switchbot.PhysicalDeviceType {
+	var ret []switchbot.PhysicalDeviceType
+	for _, l := range lists {
+		ret = append(ret, l...)
+	}
+
+	return ret
+}
+
+func intValue(f func(switchbot.DeviceStatus) int) func(switchbot.DeviceStatus) (float64, bool) {
+	return func(status switchbot.DeviceStatus) (float64, bool) {
+		return float64(f(status)), true
+	}
+}
+
+func floatValue(f func(switchbot.DeviceStatus) float64) func(switchbot.DeviceStatus) (float64, bool) {
+	return func(status switchbot.DeviceStatus) (float64, bool) {
+		return f(status), true
+	}
+}
+
+func boolValue(f func(switchbot.DeviceStatus) bool) func(switchbot.DeviceStatus) (float64, bool) {
+	return func(status switchbot.DeviceStatus) (float64, bool) {
+		return Bool2f64(f(status)), true
+	}
+}
+
+// deviceMetrics is the list of the metrics taken from the device status.
+var deviceMetrics = []deviceMetric{
+	{
+		name:        "battery",
+		help:        "The current battery level",
+		deviceTypes: batteryDevices,
+		value:       intValue(func(s switchbot.DeviceStatus) int { return s.Battery }),
+	},
+	{
+		name:        "lock_state",
+		help:        "The lock state of the device, 1 for the current state",
+		deviceTypes: lockDevices,
+		state:       func(s switchbot.DeviceStatus) string { return s.LockState },
+		states:      []string{"locked", "unlocked", "jammed"},
+	},
+	{
+		name:        "door_state",
+		help:        "The door state of the device, 1 for the current state",
+		deviceTypes: lockDevices,
+		state:       func(s switchbot.DeviceStatus) string { return s.DoorState },
+		states:      []string{"opened", "closed"},
+	},
+	{
+		name:        "temperature_celsius",
+		help:        "The current temperature reading in celsius",
+		deviceTypes: concatDeviceTypes(meterDevices, humidifierDevices, []switchbot.PhysicalDeviceType{switchbot.Hub2}),
+		value:       floatValue(func(s switchbot.DeviceStatus) float64 { return s.Temperature }),
+	},
+	{
+		name:        "humidity_percent",
+		help:        "The current humidity reading in percentage",
+		deviceTypes: concatDeviceTypes(meterDevices, humidifierDevices, []switchbot.PhysicalDeviceType{switchbot.Hub2}),
+		value:       intValue(func(s switchbot.DeviceStatus) int { return s.Humidity }),
+	},
+	{
+		name:        "co2_ppm",
+		help:        "The current CO2 concentration in ppm",
+		deviceTypes: []switchbot.PhysicalDeviceType{switchbot.MeterProCO2},
+		value:       intValue(func(s switchbot.DeviceStatus) int { return s.CO2 }),
+	},
+	{
+		name:        "light_level",
+		help:        "The level of illuminance of the ambience light, 1-20",
+		deviceTypes: []switchbot.PhysicalDeviceType{switchbot.Hub2},
+		value:       intValue(func(s switchbot.DeviceStatus) int { return s.LightLevel }),
+	},
+	{
+		name:        "power_state",
+		help:        "The power state of the device, 1 for the current state",
+		deviceTypes: powerDevices,
+		state:       func(s switchbot.DeviceStatus) string { return string(s.Power) },
+		states:      []string{"on", "off"},
+	},
+	{
+		name:        "voltage_volts",
+		help:        "The current voltage of the device",
+		deviceTypes: plugMiniDevices,
+		value:       floatValue(func(s switchbot.DeviceStatus) float64 { return s.Voltage }),
+	},
+	{
+		name:        "power_watts",
+		help:        "The current power of the device at the moment",
+		deviceTypes: plugMiniDevices,
+		value:       floatValue(func(s switchbot.DeviceStatus) float64 { return s.Weight }),
+	},
+	{
+		// the API reports the current in amperes
+		name:        "electric_current_amperes",
+		help:        "The current of the device at the moment",
+		deviceTypes: plugMiniDevices,
+		value:       floatValue(func(s switchbot.DeviceStatus) float64 { return s.ElectricCurrent }),
+	},
+	{
+		name:        "usage_minutes_today",
+		help:        "How long the device has been used for today, in minutes",
+		deviceTypes: plugMiniDevices,
+		value:       intValue(func(s switchbot.DeviceStatus) int { return s.ElectricityOfDay }),
+	},
+	{
+		name:        "slide_position",
+		help:        "The percentage of the distance between the calibrated open position and closed position",
+		deviceTypes: concatDeviceTypes(curtainDevices, []switchbot.PhysicalDeviceType{switchbot.BlindTilt}),
+		value:       intValue(func(s switchbot.DeviceStatus) int { return s.SlidePosition }),
+	},
+	{
+		name:        "moving",
+		help:        "determines if the device is moving or not",
+		deviceTypes: curtainDevices,
+		value:       boolValue(func(s switchbot.DeviceStatus) bool { return s.IsMoving }),
+	},
+	{
+		name:        "grouped",
+		help:        "determines if the device is grouped with another device or not",
+		deviceTypes: concatDeviceTypes(curtainDevices, []switchbot.PhysicalDeviceType{switchbot.BlindTilt}),
+		value:       boolValue(func(s switchbot.DeviceStatus) bool { return s.IsGrouped }),
+	},
+	{
+		name:        "brightness",
+		help:        "The brightness of the light, 1-100",
+		deviceTypes: lightDevices,
+		value: func(s switchbot.DeviceStatus) (float64, bool) {
+			v, err := s.Brightness.Int()
+			return float64(v), err == nil
+		},
+	},
+	{
+		name:        "color_temperature",
+		help:        "The color temperature of the light",
+		deviceTypes: []switchbot.PhysicalDeviceType{switchbot.ColorBulb, switchbot.CeilingLight, switchbot.CeilingLightPro},
+		value:       intValue(func(s switchbot.DeviceStatus) int { return s.ColorTemperature }),
+	},
+	{
+		name:        "motion_state",
+		help:        "The motion detection state of the device, 1 for the current state",
+		deviceTypes: sensorDevices,
+		state: func(s switchbot.DeviceStatus) string {
+			if s.IsMoveDetected {
+				return "detected"
+			}
+			return "not_detected"
+		},
+		states: []string{"detected", "not_detected"},
+	},
+	{
+		name:        "open_state",
+		help:        "The open state of the contact sensor, 1 for the current state",
+		deviceTypes: []switchbot.PhysicalDeviceType{switchbot.ContactSensor},
+		state:       func(s switchbot.DeviceStatus) string { return string(s.OpenState) },
+		states: []string{
+			string(switchbot.ContactOpen), string(switchbot.ContactClose), string(switchbot.ContactTimeoutNotClose),
+		},
+	},
+	{
+		name:        "ambient_bright",
+		help:        "determines if the ambient is bright or not",
+		deviceTypes: sensorDevices,
+		value: func(s switchbot.DeviceStatus) (float64, bool) {
+			v, err := s.Brightness.AmbientBrightness()
+			return Bool2f64(v == switchbot.AmbientBrightnessBright), err == nil
+		},
+	},
+	{
+		name:        "nebulization_efficiency",
+		help:        "The atomization efficiency in percentage",
+		deviceTypes: humidifierDevices,
+		value:       intValue(func(s switchbot.DeviceStatus) int { return s.NebulizationEfficiency }),
+	},
+	{
+		name:        "auto",
+		help:        "determines if the device is in auto mode or not",
+		deviceTypes: humidifierDevices,
+		value:       boolValue(func(s switchbot.DeviceStatus) bool { return s.IsAuto }),
+	},
+	{
+		name:        "child_lock",
+		help:        "determines if the child lock is on or not",
+		deviceTypes: humidifierDevices,
+		value:       boolValue(func(s switchbot.DeviceStatus) bool { return s.IsChildLock }),
+	},
+	{
+		name:        "sound",
+		help:        "determines if the device is muted or not",
+		deviceTypes: humidifierDevices,
+		value:       boolValue(func(s switchbot.DeviceStatus) bool { return s.IsSound }),
+	},
+	{
+		name:        "lack_water",
+		help:        "determines if the water tank is empty or not",
+		deviceTypes: humidifierDevices,
+		value:       boolValue(func(s switchbot.DeviceStatus) bool { return s.IsLackWater }),
+	},
+	{
+		name:        "fan_mode",
+		help:        "The fan mode, 1 for standard and 2 for natural",
+		deviceTypes: fanDevices,
+		value:       intValue(func(s switchbot.DeviceStatus) int { return s.FanMode }),
+	},
+	{
+		name:        "fan_speed",
+		help:        "The fan speed, 1-4",
+		deviceTypes: fanDevices,
+		value:       intValue(func(s switchbot.DeviceStatus) int { return s.FanSpeed }),
+	},
+	{
+		name:        "shaking",
+		help:        "determines if the fan is swinging or not",
+		deviceTypes: fanDevices,
+		value:       boolValue(func(s switchbot.DeviceStatus) bool { return s.IsShaking }),
+	},
+	{
+		name:        "shake_center",
+		help:        "The fan's swing direction",
+		deviceTypes: fanDevices,
+		value:       intValue(func(s switchbot.DeviceStatus) int { return s.ShakeCenter }),
+	},
+	{
+		name:        "shake_range",
+		help:        "The fan's swing range, 0-120",
+		deviceTypes: fanDevices,
+		value:       intValue(func(s switchbot.DeviceStatus) int { return s.ShakeRange }),
+	},
+	{
+		name:        "working_status",
+		help:        "The working status of the cleaner, 1 for the current state",
+		deviceTypes: cleanerDevices,
+		state:       func(s switchbot.DeviceStatus) string { return string(s.WorkingStatus) },
+		states: []string{
+			string(switchbot.CleanerStandBy), string(switchbot.CleanerClearing), string(switchbot.CleanerPaused),
+			string(switchbot.CleanerGotoChargeBase), string(switchbot.CleanerCharging), string(switchbot.CleanerChargeDone),
+			string(switchbot.CleanerDormant), string(switchbot.CleanerInTrouble), string(switchbot.CleanerInRemoteControl),
+			string(switchbot.CleanerInDustCollecting),
+		},
+	},
+	{
+		name:        "online_status",
+		help:        "The online status of the cleaner, 1 for the current state",
+		deviceTypes: cleanerDevices,
+		state:       func(s switchbot.DeviceStatus) string { return string(s.OnlineStatus) },
+		states:      []string{string(switchbot.CleanerOnline), string(switchbot.CleanerOffline)},
+	},
+}
+
+// stateSet returns the values of the series of the state set metric keyed by
+// the states. The current state is matched case-insensitively, as webhook
+// events report some states in upper case, and is added to the states if
+// unknown.
+func (m deviceMetric) stateSet(current string) map[string]float64 {
+	set := make(map[string]float64, len(m.states)+1)
+	known := false
+	for _, state := range m.states {
+		match := strings.EqualFold(state, current)
+		set[state] = Bool2f64(match)
+		known = known || match
+	}
+	if !known {
+		set[current] = 1
+	}
+
+	return set
+}
+
+func (m deviceMetric) supports(typ switchbot.PhysicalDeviceType) bool {
+	for _, t := range m.deviceTypes {
+		if t == typ {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,118 @@
+package prom
+
+import (
+	"encoding/json"
+	"github.com/google/go-cmp/cmp"
+	"github.com/nasa9084/go-switchbot/v3/switchbot"
+	"testing"
+)
+
+// statusMetrics returns the values of the metrics of the device status keyed
+// by the names of the metrics, with the state label for the state sets.
+func statusMetrics(typ switchbot.PhysicalDeviceType, status switchbot.DeviceStatus) map[string]float64 {
+	got := map[string]float64{}
+	for _, m := range deviceMetrics {
+		if !m.supports(typ) {
+			continue
+		}
+
+		if m.states != nil {
+			if current := m.state(status); current != "" {
+				for state, v := range m.stateSet(current) {
+					got[m.name+"{state="+state+"}"] = v
+				}
+			}
+			continue
+		}
+
+		v, ok := m.value(status)
+		if !ok {
+			continue
+		}
+
+		got[m.name] = v
+	}
+
+	return got
+}
+
+func TestDeviceMetrics(t *testing.T) {
+	tests := []struct {
+		name       string
+		deviceType switchbot.PhysicalDeviceType
+		status     string
+		want       map[string]float64
+	}{
+		{
+			name:       "meter",
+			deviceType: switchbot.Meter,
+			status:     `{"deviceId":"dev","deviceType":"Meter","hubDeviceId":"hub","temperature":21.5,"humidity":40,"battery":90}`,
+			want: map[string]float64{
+				"battery":             90,
+				"temperature_celsius": 21.5,
+				"humidity_percent":    40,
+			},
+		},
+		{
+			name:       "plug mini",
+			deviceType: switchbot.PlugMiniJP,
+			status:     `{"deviceId":"dev","deviceType":"Plug Mini (JP)","hubDeviceId":"hub","power":"on","voltage":100.5,"weight":12.3,"electricityOfDay":30,"electricCurrent":0.12}`,
+			want: map[string]float64{
+				"power_state{state=on}":    1,
+				"power_state{state=off}":   0,
+				"voltage_volts":            100.5,
+				"power_watts":              12.3,
+				"electric_current_amperes": 0.12,
+				"usage_minutes_today":      30,
+			},
+		},
+		{
+			name:       "curtain",
+			deviceType: switchbot.Curtain,
+			status:     `{"deviceId":"dev","deviceType":"Curtain","hubDeviceId":"hub","calibrate":true,"group":false,"moving":true,"battery":80,"slidePosition":40}`,
+			want: map[string]float64{
+				"battery":        80,
+				"slide_position": 40,
+				"moving":         1,
+				"grouped":        0,
+			},
+		},
+		{
+			name:       "color bulb",
+			deviceType: switchbot.ColorBulb,
+			status:     `{"deviceId":"dev","deviceType":"Color Bulb","hubDeviceId":"hub","power":"off","brightness":80,"color":"255:0:0","colorTemperature":4000}`,
+			want: map[string]float64{
+				"power_state{state=on}":  0,
+				"power_state{state=off}": 1,
+				"brightness":             80,
+				"color_temperature":      4000,
+			},
+		},
+		{
+			name:       "lock",
+			deviceType: switchbot.Lock,
+			status:     `{"deviceId":"dev","deviceType":"Smart Lock","hubDeviceId":"hub","lockState":"jammed","doorState":"closed","calibrate":true,"battery":60}`,
+			want: map[string]float64{
+				"battery":                    60,
+				"lock_state{state=locked}":   0,
+				"lock_state{state=unlocked}": 0,
+				"lock_state{state=jammed}":   1,
+				"door_state{state=opened}":   0,
+				"door_state{state=closed}":   1,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var status switchbot.DeviceStatus
+			if err := json.Unmarshal([]byte(tt.status), &status); err != nil {
+				t.Fatal(err)
+			}
+
+			if diff := cmp.Diff(tt.want, statusMetrics(tt.deviceType, status)); diff != "" {
+				t.Errorf("metrics mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
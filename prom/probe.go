@@ -0,0 +1,307 @@
+package prom
+
+import (
+	"context"
+	"github.com/nasa9084/go-switchbot/v3/switchbot"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// DefaultProbeCacheTTL is the default age of the snapshot of an account after
+// which a probe refreshes it.
+const DefaultProbeCacheTTL = time.Minute
+
+// Prober serves the metrics of the accounts in the config on demand, in the
+// manner of the blackbox exporter:
+//
+//	/probe?target=<account>&device=<id|all>
+//
+// A probe refreshes the snapshot of the account if it is older than the cache
+// TTL, and serves the metrics of the device, or of all devices, from a fresh
+// registry along with switchbot_probe_success and
+// switchbot_probe_duration_seconds. The credentials of the accounts are looked
+// up in Config.Accounts.
+//
+// The requests to the API of each account are instrumented with its own
+// APIMetrics labeled with the account, which the Prober collects as a
+// prometheus.Collector, except the account of the APIMetrics set with
+// WithProbeSharedAPIMetrics.
+type Prober struct {
+	cacheTTL     time.Duration
+	dailyBudget  int
+	newClient    func(AccountConfig, *http.Client) DeviceClient
+	exporterOpts []ExporterOption
+	apiOpts      []APIMetricsOption
+	// sharedAPIMetrics are the API metrics of an account collected by others.
+	sharedAPIMetrics *APIMetrics
+
+	mu      sync.Mutex
+	config  *Config
+	targets map[string]*probeTarget
+	// apiMetrics are kept across the changes of the credentials of the
+	// accounts so that the counters are not reset.
+	apiMetrics map[string]*APIMetrics
+}
+
+// probeTarget is the exporter of an account, refreshed by probes.
+type probeTarget struct {
+	account  AccountConfig
+	exporter *Exporter
+
+	// mu serializes refreshes, so that concurrent probes of the account
+	// share one refresh.
+	mu        sync.Mutex
+	refreshed time.Time
+	err       error
+}
+
+// ProberOption configures the Prober.
+type ProberOption func(*Prober)
+
+// WithProbeCacheTTL sets the age of the snapshot of an account after which a
+// probe refreshes it.
+func WithProbeCacheTTL(d time.Duration) ProberOption {
+	return func(p *Prober) {
+		p.cacheTTL = d
+	}
+}
+
+// WithProbeDailyBudget sets the number of API calls per day each account may
+// use for probes. The cache TTL of an account is stretched so that refreshing
+// the snapshot at that rate fits in the budget.
+func WithProbeDailyBudget(n int) ProberOption {
+	return func(p *Prober) {
+		p.dailyBudget = n
+	}
+}
+
+// WithProbeClient sets the function which makes the client of an account.
+// The given HTTP client instruments the requests with the API metrics of the
+// account, and should be used to make the requests to the API.
+// By default, a client of the SwitchBot API is made with the credentials.
+func WithProbeClient(fn func(AccountConfig, *http.Client) DeviceClient) ProberOption {
+	return func(p *Prober) {
+		p.newClient = fn
+	}
+}
+
+// WithProbeExporterOptions sets the options of the exporters of the accounts.
+// The account and the config are always set by the Prober.
+func WithProbeExporterOptions(opts ...ExporterOption) ProberOption {
+	return func(p *Prober) {
+		p.exporterOpts = opts
+	}
+}
+
+// WithProbeAPIMetricsOptions sets the options of the API metrics of the
+// accounts. The account is always set by the Prober.
+func WithProbeAPIMetricsOptions(opts ...APIMetricsOption) ProberOption {
+	return func(p *Prober) {
+		p.apiOpts = opts
+	}
+}
+
+// WithProbeSharedAPIMetrics makes the probes of the account of m, see
+// WithAPIAccount, instrument their requests with m, which the Prober does not
+// collect. It is used to share the API metrics of the exporter of the same
+// account registered on the same registry, whose metrics would otherwise clash.
+func WithProbeSharedAPIMetrics(m *APIMetrics) ProberOption {
+	return func(p *Prober) {
+		p.sharedAPIMetrics = m
+	}
+}
+
+// NewProber returns a new Prober of the accounts in the config.
+func NewProber(c *Config, opts ...ProberOption) *Prober {
+	p := &Prober{
+		cacheTTL:    DefaultProbeCacheTTL,
+		dailyBudget: DefaultDailyBudget,
+		newClient: func(a AccountConfig, httpClient *http.Client) DeviceClient {
+			return switchbot.New(a.Token, a.Key, switchbot.WithHTTPClient(httpClient)).Device()
+		},
+		config:     c,
+		targets:    map[string]*probeTarget{},
+		apiMetrics: map[string]*APIMetrics{},
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+
+	return p
+}
+
+// SetConfig replaces the config. The cached snapshots of the accounts whose
+// credentials are changed or removed are dropped, and so are the API metrics
+// of the removed accounts.
+func (p *Prober) SetConfig(c *Config) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.config = c
+	for name := range p.apiMetrics {
+		if _, ok := c.account(name); !ok {
+			delete(p.apiMetrics, name)
+		}
+	}
+	for name, t := range p.targets {
+		if account, ok := c.account(name); !ok || account != t.account {
+			delete(p.targets, name)
+			continue
+		}
+		t.exporter.SetConfig(c)
+	}
+}
+
+// target returns the exporter of the named account, and false if the account
+// is not in the config.
+func (p *Prober) target(name string) (*probeTarget, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if t, ok := p.targets[name]; ok {
+		return t, true
+	}
+
+	account, ok := p.config.account(name)
+	if !ok {
+		return nil, false
+	}
+
+	m, ok := p.apiMetrics[name]
+	switch {
+	case ok:
+	case p.sharedAPIMetrics != nil && p.sharedAPIMetrics.account == name:
+		m = p.sharedAPIMetrics
+	default:
+		m = NewAPIMetrics(append(append([]APIMetricsOption(nil), p.apiOpts...), WithAPIAccount(name))...)
+		p.apiMetrics[name] = m
+	}
+	client := p.newClient(account, &http.Client{Transport: m.Transport(nil)})
+
+	opts := append(append([]ExporterOption(nil), p.exporterOpts...), WithAccount(name), WithConfig(p.config))
+	t := &probeTarget{
+		account:  account,
+		exporter: NewExporter(client, opts...),
+	}
+	p.targets[name] = t
+
+	return t, true
+}
+
+// refresh refreshes the snapshot of the account unless it is younger than
+// the cache TTL, and returns the error of the last refresh.
+func (p *Prober) refresh(ctx context.Context, t *probeTarget) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if !t.refreshed.IsZero() && time.Since(t.refreshed) < p.ttl(t) {
+		return t.err
+	}
+
+	err := t.exporter.Refresh(ctx)
+	if ctx.Err() != nil {
+		// the probe is canceled, the next one refreshes the snapshot again
+		return err
+	}
+	t.refreshed, t.err = time.Now(), err
+
+	return err
+}
+
+// ttl returns the cache TTL of the account, stretched so that a refresh,
+// which calls the API once for the device list and once for each device,
+// every TTL fits in the daily budget.
+func (p *Prober) ttl(t *probeTarget) time.Duration {
+	ttl := p.cacheTTL
+	if p.dailyBudget <= 0 {
+		return ttl
+	}
+
+	calls := 1 + len(t.exporter.getSnapshot().devices)
+	if d := 24 * time.Hour * time.Duration(calls) / time.Duration(p.dailyBudget); d > ttl {
+		ttl = d
+	}
+
+	return ttl
+}
+
+// ServeHTTP serves a probe.
+func (p *Prober) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	name := query.Get("target")
+	if name == "" {
+		http.Error(w, "target parameter is missing", http.StatusBadRequest)
+		return
+	}
+	t, ok := p.target(name)
+	if !ok {
+		http.Error(w, "unknown target: "+name, http.StatusNotFound)
+		return
+	}
+	device := query.Get("device")
+	if device == "all" {
+		device = ""
+	}
+
+	started := time.Now()
+	err := p.refresh(r.Context(), t)
+
+	constLabels := prometheus.Labels{"account": name}
+	success := prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace:   namespace,
+		Subsystem:   "probe",
+		Name:        "success",
+		Help:        "determines if the last refresh of the probed account succeeded or not",
+		ConstLabels: constLabels,
+	})
+	success.Set(Bool2f64(err == nil))
+	duration := prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace:   namespace,
+		Subsystem:   "probe",
+		Name:        "duration_seconds",
+		Help:        "The time taken to serve the probe, including the refresh of the account",
+		ConstLabels: constLabels,
+	})
+	duration.Set(time.Since(started).Seconds())
+
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(success, duration, deviceCollector{exporter: t.exporter, id: device})
+	promhttp.HandlerFor(reg, promhttp.HandlerOpts{}).ServeHTTP(w, r)
+}
+
+// Describe implements prometheus.Collector. The Prober is an unchecked
+// collector since the accounts are only known once they are probed.
+func (p *Prober) Describe(chan<- *prometheus.Desc) {}
+
+// Collect implements prometheus.Collector, collecting the API metrics of the
+// probed accounts.
+func (p *Prober) Collect(metrics chan<- prometheus.Metric) {
+	p.mu.Lock()
+	apiMetrics := make([]*APIMetrics, 0, len(p.apiMetrics))
+	for _, m := range p.apiMetrics {
+		apiMetrics = append(apiMetrics, m)
+	}
+	p.mu.Unlock()
+
+	for _, m := range apiMetrics {
+		m.Collect(metrics)
+	}
+}
+
+// deviceCollector collects the metrics of a device, or of all devices if id
+// is empty, from the exporter.
+type deviceCollector struct {
+	exporter *Exporter
+	id       string
+}
+
+func (c deviceCollector) Describe(descs chan<- *prometheus.Desc) {
+	c.exporter.Describe(descs)
+}
+
+func (c deviceCollector) Collect(metrics chan<- prometheus.Metric) {
+	c.exporter.collect(metrics, c.id)
+}
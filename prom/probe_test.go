@@ -0,0 +1,213 @@
+package prom_test
+
+import (
+	"github.com/nasa9084/go-switchbot/v3/prom"
+	"github.com/nasa9084/go-switchbot/v3/switchbot"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newProber(t *testing.T, clients map[string]*fakeClient, opts ...prom.ProberOption) *prom.Prober {
+	t.Helper()
+
+	config, err := prom.ParseConfig([]byte(`
+accounts:
+  office: {token: office-token, key: office-key}
+  warehouse: {token: warehouse-token, key: warehouse-key}
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts = append(opts, prom.WithProbeClient(func(a prom.AccountConfig, _ *http.Client) prom.DeviceClient {
+		return clients[a.Token]
+	}))
+
+	return prom.NewProber(config, opts...)
+}
+
+func probe(t *testing.T, h http.Handler, query string) (int, string) {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/probe?"+query, nil))
+	b, err := io.ReadAll(rec.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return rec.Code, string(b)
+}
+
+func TestProber(t *testing.T) {
+	office := newMeterClient(21.5)
+	office.devices = append(office.devices, switchbot.Device{ID: "plug", Name: "Plug", Type: switchbot.Plug})
+	warehouse := newMeterClient(10)
+	p := newProber(t, map[string]*fakeClient{"office-token": office, "warehouse-token": warehouse})
+
+	code, body := probe(t, p, "target=office&device=meter")
+	if code != http.StatusOK {
+		t.Fatalf("status code = %d, want 200: %s", code, body)
+	}
+	for _, want := range []string{
+		`switchbot_device_temperature_celsius{account="office",id="meter",name="Living"} 21.5`,
+		`switchbot_probe_success{account="office"} 1`,
+		`switchbot_up{account="office"} 1`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("%q is not in the probe:\n%s", want, body)
+		}
+	}
+	if strings.Contains(body, `id="plug"`) {
+		t.Errorf("other devices are in the probe:\n%s", body)
+	}
+
+	_, body = probe(t, p, "target=office&device=all")
+	if !strings.Contains(body, `switchbot_device_stale{account="office",id="plug",name="Plug"} 0`) {
+		t.Errorf("all devices are not in the probe:\n%s", body)
+	}
+
+	_, body = probe(t, p, "target=warehouse")
+	if want := `switchbot_device_temperature_celsius{account="warehouse",id="meter",name="Living"} 10`; !strings.Contains(body, want) {
+		t.Errorf("%q is not in the probe:\n%s", want, body)
+	}
+
+	// the second and the third probes of the office are served from the cache
+	office.mu.Lock()
+	defer office.mu.Unlock()
+	if len(office.polled) != 2 {
+		t.Errorf("office is polled %d times, want 2: %v", len(office.polled), office.polled)
+	}
+}
+
+func TestProberQuota(t *testing.T) {
+	client := newMeterClient(21.5)
+	// a refresh makes 2 calls, which fit in the budget of 2 calls a day only
+	// once a day
+	p := newProber(t, map[string]*fakeClient{"office-token": client}, prom.WithProbeCacheTTL(time.Nanosecond), prom.WithProbeDailyBudget(2))
+
+	for i := 0; i < 3; i++ {
+		time.Sleep(time.Millisecond)
+		if code, body := probe(t, p, "target=office"); code != http.StatusOK {
+			t.Fatalf("status code = %d, want 200: %s", code, body)
+		}
+	}
+
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	if len(client.polled) != 1 {
+		t.Errorf("polled %d times, want 1", len(client.polled))
+	}
+}
+
+func TestProberError(t *testing.T) {
+	p := newProber(t, map[string]*fakeClient{})
+
+	tests := map[string]int{
+		"":               http.StatusBadRequest,
+		"target=unknown": http.StatusNotFound,
+	}
+	for query, want := range tests {
+		if code, _ := probe(t, p, query); code != want {
+			t.Errorf("status code of %q = %d, want %d", query, code, want)
+		}
+	}
+
+	// accounts removed from the config are no longer probed
+	p.SetConfig(nil)
+	if code, _ := probe(t, p, "target=office"); code != http.StatusNotFound {
+		t.Errorf("status code = %d, want 404", code)
+	}
+}
+
+func TestProberAPIMetrics(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1.1/devices":
+			w.Write([]byte(`{"statusCode":100,"body":{"deviceList":[{"deviceId":"meter","deviceName":"Living","deviceType":"Meter"}],"infraredRemoteList":[]},"message":"success"}`))
+		case "/v1.1/devices/meter/status":
+			w.Write([]byte(`{"statusCode":100,"body":{"deviceId":"meter","deviceType":"Meter","temperature":21.5},"message":"success"}`))
+		default:
+			t.Errorf("unexpected request path: %s", r.URL.Path)
+		}
+	}))
+	defer srv.Close()
+
+	config, err := prom.ParseConfig([]byte(`
+accounts:
+  office: {token: office-token, key: office-key}
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := prom.NewProber(config,
+		prom.WithProbeClient(func(a prom.AccountConfig, httpClient *http.Client) prom.DeviceClient {
+			return switchbot.New(a.Token, a.Key, switchbot.WithEndpoint(srv.URL), switchbot.WithHTTPClient(httpClient)).Device()
+		}),
+		prom.WithProbeAPIMetricsOptions(prom.WithDailyQuota(100)),
+	)
+
+	if code, body := probe(t, p, "target=office"); code != http.StatusOK {
+		t.Fatalf("status code = %d, want 200: %s", code, body)
+	}
+
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(p)
+	for _, want := range []string{
+		`switchbot_api_requests_total{account="office",endpoint="/v1.1/devices",http_status="200",switchbot_status="100"} 1`,
+		`switchbot_api_requests_total{account="office",endpoint="/v1.1/devices/{id}/status",http_status="200",switchbot_status="100"} 1`,
+		`switchbot_api_quota_remaining{account="office"} 98`,
+	} {
+		if body := scrape(t, reg); !strings.Contains(body, want) {
+			t.Errorf("%q is not in the metrics:\n%s", want, body)
+		}
+	}
+
+	// the metrics of the accounts removed from the config are dropped
+	p.SetConfig(nil)
+	if body := scrape(t, reg); strings.Contains(body, `account="office"`) {
+		t.Errorf("the metrics of the removed account are collected:\n%s", body)
+	}
+
+	t.Run("shared API metrics", func(t *testing.T) {
+		apiMetrics := prom.NewAPIMetrics(prom.WithAPIAccount("office"), prom.WithDailyQuota(100))
+		p := prom.NewProber(config,
+			prom.WithProbeClient(func(a prom.AccountConfig, httpClient *http.Client) prom.DeviceClient {
+				return switchbot.New(a.Token, a.Key, switchbot.WithEndpoint(srv.URL), switchbot.WithHTTPClient(httpClient)).Device()
+			}),
+			prom.WithProbeSharedAPIMetrics(apiMetrics),
+		)
+
+		reg := prometheus.NewRegistry()
+		reg.MustRegister(apiMetrics, p)
+
+		if code, body := probe(t, p, "target=office"); code != http.StatusOK {
+			t.Fatalf("status code = %d, want 200: %s", code, body)
+		}
+
+		rec := httptest.NewRecorder()
+		promhttp.HandlerFor(reg, promhttp.HandlerOpts{}).ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status code = %d, want 200: %s", rec.Code, rec.Body)
+		}
+		want := `switchbot_api_quota_remaining{account="office"} 98`
+		if body := rec.Body.String(); !strings.Contains(body, want) {
+			t.Errorf("%q is not in the metrics:\n%s", want, body)
+		}
+	})
+}
+
+func scrape(t *testing.T, reg *prometheus.Registry) string {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	promhttp.HandlerFor(reg, promhttp.HandlerOpts{}).ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+
+	return rec.Body.String()
+}
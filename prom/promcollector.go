@@ -1,136 +1,438 @@
 package prom
 
 import (
-	"fmt"
-	"github.com/nasa9084/go-switchbot/v3/switchbot"
 	"github.com/prometheus/client_golang/prometheus"
+	"sync"
+	"time"
 )
 
 const (
 	namespace = "switchbot"
+
+	// DefaultStaleThreshold is the default age of the last successful status
+	// fetch after which a device is reported as stale.
+	DefaultStaleThreshold = 3 * DefaultPollInterval
 )
 
+// Exporter exports the metrics of the devices of one SwitchBot account.
+// Several exporters can be registered on one registry if each one has a
+// distinct account label, see WithAccount.
 type Exporter struct {
-	DeviceInfo         *prometheus.Desc
-	DeviceCalibrated   *prometheus.Desc
-	DeviceCloudEnabled *prometheus.Desc
-	DeviceBattery      *prometheus.Desc
-	LockLockState      *prometheus.Desc
-	LockDoorState      *prometheus.Desc
+	client         DeviceClient
+	account        string
+	staleThreshold time.Duration
+	// pushedPollInterval is the poll interval of the devices which push webhook events.
+	pushedPollInterval  time.Duration
+	listInterval        time.Duration
+	defaultPollInterval time.Duration
+	dailyBudget         int
+
+	constLabels prometheus.Labels
+
+	// refreshMu serializes refreshes, and mu guards the snapshot of the last
+	// poll, the config and the device descriptions which depend on the config.
+	refreshMu sync.Mutex
+	mu        sync.RWMutex
+	snapshot  snapshot
+	config    *Config
+	devices   deviceDescs
+	plan      Plan
+	// relist makes Poll fetch the device list immediately.
+	relist chan struct{}
+
+	Up              *prometheus.Desc
+	LastPoll        *prometheus.Desc
+	LastPollSuccess *prometheus.Desc
+	PlanDailyCalls  *prometheus.Desc
+	DailyBudget     *prometheus.Desc
+
+	pollDuration prometheus.Histogram
+	statusErrors *prometheus.CounterVec
+	eventMetrics eventMetrics
+}
+
+// ExporterOption configures the Exporter.
+type ExporterOption func(*Exporter)
+
+// WithStaleThreshold sets the age of the last successful status fetch after
+// which a device is reported as stale.
+func WithStaleThreshold(d time.Duration) ExporterOption {
+	return func(e *Exporter) {
+		e.staleThreshold = d
+	}
+}
+
+// WithPushedPollInterval sets the interval at which the status of the devices
+// which have sent webhook events within the interval is polled, see ApplyEvent.
+// Such devices are polled on every poll if zero.
+func WithPushedPollInterval(d time.Duration) ExporterOption {
+	return func(e *Exporter) {
+		e.pushedPollInterval = d
+	}
+}
+
+// WithListInterval sets the interval between fetches of the device list by Poll.
+func WithListInterval(d time.Duration) ExporterOption {
+	return func(e *Exporter) {
+		e.listInterval = d
+	}
+}
+
+// WithDefaultPollInterval sets the poll interval of the devices whose type
+// has no poll policy.
+func WithDefaultPollInterval(d time.Duration) ExporterOption {
+	return func(e *Exporter) {
+		e.defaultPollInterval = d
+	}
+}
+
+// WithDailyBudget sets the number of API calls per day the poll plan fits in.
+// The budget in the config takes precedence.
+func WithDailyBudget(n int) ExporterOption {
+	return func(e *Exporter) {
+		e.dailyBudget = n
+	}
+}
+
+// WithAccount sets the value of the account label added to all metrics of the exporter.
+func WithAccount(account string) ExporterOption {
+	return func(e *Exporter) {
+		e.account = account
+	}
 }
 
+// WithConfig sets the device filters and labels, see SetConfig.
+func WithConfig(c *Config) ExporterOption {
+	return func(e *Exporter) {
+		e.config = c
+	}
+}
+
+// SetConfig replaces the device filters and labels. If the config changes the
+// filters, Poll fetches the device list immediately to export the devices newly
+// included and drop the ones newly excluded, otherwise they are applied by the
+// next fetch of the device list, e.g. by Refresh.
+// If the config changes the set of device labels, the metrics are no longer
+// consistent with their descriptions registered at first, which only a
+// registry with pedantic checks enabled rejects.
+func (e *Exporter) SetConfig(c *Config) {
+	devices := newDeviceDescs(e.constLabels, c.LabelNames())
+
+	e.mu.Lock()
+	relist := !e.config.sameFilters(c)
+	e.config = c
+	e.devices = devices
+	e.mu.Unlock()
+
+	if relist {
+		select {
+		case e.relist <- struct{}{}:
+		default:
+		}
+	}
+
+	// the config may override the poll policies
+	e.replan(e.getSnapshot().devices)
+}
+
+// deviceDescs are the descriptions of the device metrics.
+type deviceDescs struct {
+	info         *prometheus.Desc
+	calibrated   *prometheus.Desc
+	cloudEnabled *prometheus.Desc
+	lastSuccess  *prometheus.Desc
+	stale        *prometheus.Desc
+	pollInterval *prometheus.Desc
+	// metrics holds the descriptions of deviceMetrics in the same order.
+	metrics []*prometheus.Desc
+}
+
+func newDeviceDescs(constLabels prometheus.Labels, labelNames []string) deviceDescs {
+	deviceLabels := func(labels ...string) []string {
+		return append(labels, labelNames...)
+	}
+
+	d := deviceDescs{
+		info: prometheus.NewDesc(
+			prometheus.BuildFQName(
+				namespace,
+				"info",
+				"description",
+			),
+			"Information of the gathered devices",
+			deviceLabels("id", "name", "type", "group", "hub_id", "version"),
+			constLabels,
+		),
+		calibrated:   prometheusDevice("calibrated", "determines if the open position and the close position of a device have been properly calibrated or not", deviceLabels("id", "name"), constLabels),
+		cloudEnabled: prometheusDevice("cloud_service_enabled", "determines if Cloud Service is enabled or not for the current device", deviceLabels("id", "name"), constLabels),
+		lastSuccess:  prometheusDevice("last_success_timestamp_seconds", "The time the status of the device was last fetched successfully", deviceLabels("id", "name"), constLabels),
+		stale:        prometheusDevice("stale", "determines if the status of the device is older than the staleness threshold or not", deviceLabels("id", "name"), constLabels),
+		pollInterval: prometheusDevice("poll_interval_seconds", "The planned interval between polls of the device status, zero if the device is not polled", deviceLabels("id", "name"), constLabels),
+	}
+
+	for _, m := range deviceMetrics {
+		labels := deviceLabels("id", "name")
+		if m.states != nil {
+			labels = deviceLabels("id", "name", "state")
+		}
+
+		d.metrics = append(d.metrics, prometheusDevice(m.name, m.help, labels, constLabels))
+	}
+
+	return d
+}
+
+func (d deviceDescs) describe(descs chan<- *prometheus.Desc) {
+	descs <- d.info
+	descs <- d.calibrated
+	descs <- d.cloudEnabled
+	descs <- d.lastSuccess
+	descs <- d.stale
+	descs <- d.pollInterval
+	for _, desc := range d.metrics {
+		descs <- desc
+	}
+}
+
+// Collect serves the metrics from the snapshot of the last poll, see Poll.
 func (e *Exporter) Collect(metrics chan<- prometheus.Metric) {
-	Refresh() // Refreshes Data
+	e.collect(metrics, "")
+}
+
+// collect serves the metrics of the device with the given ID, or of all
+// devices if the ID is empty, along with the metrics of the account.
+func (e *Exporter) collect(metrics chan<- prometheus.Metric, id string) {
+	e.mu.RLock()
+	snap, config, descs, plan := e.snapshot, e.config, e.devices, e.plan
+	e.mu.RUnlock()
+	now := time.Now()
+
+	metrics <- prometheus.MustNewConstMetric(e.PlanDailyCalls, prometheus.GaugeValue, plan.DailyCalls)
+	metrics <- prometheus.MustNewConstMetric(e.DailyBudget, prometheus.GaugeValue, float64(plan.DailyBudget))
+	intervals := make(map[string]Duration, len(plan.Devices))
+	for _, d := range plan.Devices {
+		intervals[d.ID] = d.Interval
+	}
+
+	metrics <- prometheus.MustNewConstMetric(e.Up, prometheus.GaugeValue, Bool2f64(snap.up))
+	if !snap.lastPoll.IsZero() {
+		metrics <- prometheus.MustNewConstMetric(e.LastPoll, prometheus.GaugeValue, float64(snap.lastPoll.UnixNano())/1e9)
+	}
+	if !snap.lastPollSuccess.IsZero() {
+		metrics <- prometheus.MustNewConstMetric(e.LastPollSuccess, prometheus.GaugeValue, float64(snap.lastPollSuccess.UnixNano())/1e9)
+	}
+	e.pollDuration.Collect(metrics)
+	e.statusErrors.Collect(metrics)
+	for _, c := range e.eventMetrics.collectors() {
+		c.Collect(metrics)
+	}
 
 	// Loop through all the devices
-	for _, device := range switchbotDevices {
+	for _, device := range snap.devices {
+		if !config.Exported(device) || (id != "" && device.ID != id) {
+			continue
+		}
+
+		name := config.DeviceName(device)
+		extraLabels := config.LabelValues(device)
+		labels := func(values ...string) []string {
+			return append(values, extraLabels...)
+		}
+
 		// Device Description
 		metrics <- prometheus.MustNewConstMetric(
-			e.DeviceInfo,
+			descs.info,
 			prometheus.GaugeValue,
 			1,
-			device.ID, device.Name, string(device.Type), device.GroupName, device.Hub, string(switchbotDeviceStatus[device.ID].Version), // {"id", "name", "type", "group", "hub_id", "version"}
+			labels(device.ID, name, string(device.Type), device.GroupName, device.Hub, string(snap.status[device.ID].Version))..., // {"id", "name", "type", "group", "hub_id", "version"}
 		)
 		metrics <- prometheus.MustNewConstMetric(
-			e.DeviceCloudEnabled,
+			descs.cloudEnabled,
 			prometheus.GaugeValue,
 			Bool2f64(device.IsEnableCloudService),
-			device.ID, device.Name,
+			labels(device.ID, name)...,
+		)
+
+		if interval, ok := intervals[device.ID]; ok {
+			metrics <- prometheus.MustNewConstMetric(
+				descs.pollInterval,
+				prometheus.GaugeValue,
+				time.Duration(interval).Seconds(),
+				labels(device.ID, name)...,
+			)
+		}
+
+		lastSuccess, ok := snap.lastSuccess[device.ID]
+		metrics <- prometheus.MustNewConstMetric(
+			descs.stale,
+			prometheus.GaugeValue,
+			Bool2f64(!ok || now.Sub(lastSuccess) > e.staleThreshold),
+			labels(device.ID, name)...,
+		)
+		if !ok {
+			// the status has never been fetched
+			continue
+		}
+		metrics <- prometheus.MustNewConstMetric(
+			descs.lastSuccess,
+			prometheus.GaugeValue,
+			float64(lastSuccess.UnixNano())/1e9,
+			labels(device.ID, name)...,
 		)
+
+		status := snap.status[device.ID]
 		metrics <- prometheus.MustNewConstMetric(
-			e.DeviceCalibrated,
+			descs.calibrated,
 			prometheus.GaugeValue,
-			Bool2f64(switchbotDeviceStatus[device.ID].IsCalibrated),
-			device.ID, device.Name,
+			Bool2f64(status.IsCalibrated),
+			labels(device.ID, name)...,
 		)
 
 		// Device-Specific Metrics
-		switch device.Type {
-		//#region SmartLockPro
-		case switchbot.SmartLockPro:
-			metrics <- prometheus.MustNewConstMetric(
-				e.DeviceBattery,
-				prometheus.GaugeValue,
-				float64(switchbotDeviceStatus[device.ID].Battery),
-				device.ID, device.Name,
-			)
-			metrics <- prometheus.MustNewConstMetric(
-				e.LockLockState,
-				prometheus.GaugeValue,
-				StateOK(switchbotDeviceStatus[device.ID].LockState),
-				device.ID, device.Name, switchbotDeviceStatus[device.ID].LockState,
-			)
+		for i, m := range deviceMetrics {
+			if !m.supports(device.Type) {
+				continue
+			}
+
+			if m.states != nil {
+				state := m.state(status)
+				if state == "" {
+					continue
+				}
+				for state, v := range m.stateSet(state) {
+					metrics <- prometheus.MustNewConstMetric(
+						descs.metrics[i],
+						prometheus.GaugeValue,
+						v,
+						labels(device.ID, name, state)...,
+					)
+				}
+				continue
+			}
+
+			v, ok := m.value(status)
+			if !ok {
+				continue
+			}
+
 			metrics <- prometheus.MustNewConstMetric(
-				e.LockDoorState,
+				descs.metrics[i],
 				prometheus.GaugeValue,
-				StateOK(switchbotDeviceStatus[device.ID].DoorState),
-				device.ID, device.Name, switchbotDeviceStatus[device.ID].DoorState,
+				v,
+				labels(device.ID, name)...,
 			)
-			//#endregion
 		}
 	}
 }
 
 // Describe Prometheus Describer
 func (e *Exporter) Describe(descs chan<- *prometheus.Desc) {
-	descs <- e.DeviceInfo
-	descs <- e.DeviceCalibrated
-	descs <- e.DeviceCloudEnabled
-	descs <- e.DeviceBattery
-	descs <- e.LockLockState
-	descs <- e.LockDoorState
+	descs <- e.Up
+	descs <- e.LastPoll
+	descs <- e.LastPollSuccess
+	descs <- e.PlanDailyCalls
+	descs <- e.DailyBudget
+	e.pollDuration.Describe(descs)
+	e.statusErrors.Describe(descs)
+	for _, c := range e.eventMetrics.collectors() {
+		c.Describe(descs)
+	}
+
+	e.mu.RLock()
+	devices := e.devices
+	e.mu.RUnlock()
+	devices.describe(descs)
 }
 
 // NewExporter New Prometheus Exporter
-func NewExporter() *Exporter {
-	return &Exporter{
-		DeviceInfo: prometheus.NewDesc(
-			prometheus.BuildFQName(
-				namespace,
-				"info",
-				"description",
-			),
-			"Information of the gathered devices",
-			[]string{"id", "name", "type", "group", "hub_id", "version"},
-			nil,
-		),
-		DeviceCalibrated:   prometheusDevice("calibrated", "determines if the open position and the close position of a device have been properly calibrated or not"),
-		DeviceCloudEnabled: prometheusDevice("cloud_service_enabled", "determines if Cloud Service is enabled or not for the current device"),
-		DeviceBattery:      prometheusDevice("battery", "The current battery level"),
-		LockLockState: prometheus.NewDesc(
-			prometheus.BuildFQName(
-				namespace,
-				"device",
-				"lock_state",
-			),
-			"The lock state of the device",
-			[]string{"id", "name", "state"},
-			nil,
-		),
-		LockDoorState: prometheus.NewDesc(
-			prometheus.BuildFQName(
-				namespace,
-				"device",
-				"door_state",
-			),
-			"The door state of the device",
-			[]string{"id", "name", "state"},
-			nil,
-		),
+// The exporter serves the data fetched from client by Refresh or Poll,
+// which is usually the DeviceService of a *switchbot.Client.
+func NewExporter(client DeviceClient, opts ...ExporterOption) *Exporter {
+	e := &Exporter{
+		client:              client,
+		staleThreshold:      DefaultStaleThreshold,
+		listInterval:        DefaultListInterval,
+		defaultPollInterval: DefaultPollInterval,
+		dailyBudget:         DefaultDailyBudget,
+		relist:              make(chan struct{}, 1),
+	}
+	for _, opt := range opts {
+		opt(e)
+	}
+	if e.listInterval <= 0 {
+		e.listInterval = DefaultListInterval
+	}
+	if e.defaultPollInterval <= 0 {
+		e.defaultPollInterval = DefaultPollInterval
 	}
+
+	var constLabels prometheus.Labels
+	if e.account != "" {
+		constLabels = prometheus.Labels{"account": e.account}
+	}
+
+	e.Up = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "up"),
+		"determines if the last poll of the SwitchBot API succeeded or not",
+		nil,
+		constLabels,
+	)
+	e.LastPoll = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "last_poll_timestamp_seconds"),
+		"The time of the last poll of the SwitchBot API",
+		nil,
+		constLabels,
+	)
+	e.LastPollSuccess = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "last_poll_success_timestamp_seconds"),
+		"The time of the last poll of the SwitchBot API without errors",
+		nil,
+		constLabels,
+	)
+	e.PlanDailyCalls = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "poll", "planned_daily_calls"),
+		"The number of API calls per day the poll plan makes",
+		nil,
+		constLabels,
+	)
+	e.DailyBudget = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "poll", "daily_budget"),
+		"The number of API calls per day the poll plan fits in",
+		nil,
+		constLabels,
+	)
+	e.pollDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace:   namespace,
+		Name:        "poll_duration_seconds",
+		Help:        "The time taken to poll the device list and the status of all devices",
+		ConstLabels: constLabels,
+		Buckets:     []float64{0.5, 1, 2.5, 5, 10, 30, 60, 120},
+	})
+	e.statusErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace:   namespace,
+		Subsystem:   "device",
+		Name:        "status_errors_total",
+		Help:        "The number of failed status fetches of the device by the kind of the error: offline, hub_offline, 190 or other",
+		ConstLabels: constLabels,
+	}, []string{"id", "name", "kind"})
+	e.eventMetrics = newEventMetrics(constLabels)
+	e.constLabels = constLabels
+	e.devices = newDeviceDescs(constLabels, e.config.LabelNames())
+
+	return e
 }
 
 // prometheusDevice Device-Specific Describer
-func prometheusDevice(metric string, help string) *prometheus.Desc {
+func prometheusDevice(metric string, help string, labels []string, constLabels prometheus.Labels) *prometheus.Desc {
 	return prometheus.NewDesc(
 		prometheus.BuildFQName(
 			namespace,
 			"device",
-			fmt.Sprintf("%s", metric),
+			metric,
 		),
 		help,
-		[]string{"id", "name"},
-		nil,
+		labels,
+		constLabels,
 	)
 }
 
@@ -146,6 +448,9 @@ func Bool2f64(b bool) float64 {
 }
 
 // StateOK If the device state is in an acceptable state return 1, otherwise return 0
+//
+// Deprecated: the lock and door states are exported as state sets, which
+// have a series for each state.
 func StateOK(state string) float64 {
 	switch state {
 	case "locked":
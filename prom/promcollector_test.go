@@ -0,0 +1,351 @@
+package prom_test
+
+import (
+	"context"
+	"errors"
+	"github.com/google/go-cmp/cmp"
+	"github.com/nasa9084/go-switchbot/v3/prom"
+	"github.com/nasa9084/go-switchbot/v3/switchbot"
+	"github.com/prometheus/client_golang/prometheus"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeClient struct {
+	mu        sync.Mutex
+	devices   []switchbot.Device
+	infrared  []switchbot.InfraredDevice
+	status    map[string]switchbot.DeviceStatus
+	listErr   error
+	statusErr error
+	// deviceErrs are the errors of the status of the devices keyed by their IDs.
+	deviceErrs map[string]error
+	polled     []string
+	listed     int
+}
+
+func (c *fakeClient) List(context.Context) ([]switchbot.Device, []switchbot.InfraredDevice, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.listed++
+	return c.devices, c.infrared, c.listErr
+}
+
+func (c *fakeClient) Status(_ context.Context, id string) (switchbot.DeviceStatus, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.polled = append(c.polled, id)
+	if c.statusErr != nil {
+		return switchbot.DeviceStatus{}, c.statusErr
+	}
+	if err := c.deviceErrs[id]; err != nil {
+		return switchbot.DeviceStatus{}, err
+	}
+	return c.status[id], nil
+}
+
+func newMeterClient(temperature float64) *fakeClient {
+	return &fakeClient{
+		devices: []switchbot.Device{{ID: "meter", Name: "Living", Type: switchbot.Meter}},
+		status: map[string]switchbot.DeviceStatus{
+			"meter": {ID: "meter", Type: switchbot.Meter, Temperature: temperature, Humidity: 40, Battery: 90},
+		},
+	}
+}
+
+// gather returns the values of the metric name keyed by the label pairs.
+func gather(t *testing.T, reg *prometheus.Registry, name string) map[string]float64 {
+	t.Helper()
+
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := map[string]float64{}
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+		for _, m := range family.GetMetric() {
+			var labels []string
+			for _, l := range m.GetLabel() {
+				labels = append(labels, l.GetName()+"="+l.GetValue())
+			}
+			sort.Strings(labels)
+			if m.GetCounter() != nil {
+				got[strings.Join(labels, ",")] = m.GetCounter().GetValue()
+			} else {
+				got[strings.Join(labels, ",")] = m.GetGauge().GetValue()
+			}
+		}
+	}
+
+	return got
+}
+
+func TestExporter(t *testing.T) {
+	home := prom.NewExporter(newMeterClient(21.5), prom.WithAccount("home"))
+	office := prom.NewExporter(newMeterClient(25), prom.WithAccount("office"))
+
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(home, office)
+
+	for _, e := range []*prom.Exporter{home, office} {
+		if err := e.Refresh(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := map[string]float64{
+		"account=home,id=meter,name=Living":   21.5,
+		"account=office,id=meter,name=Living": 25,
+	}
+	if diff := cmp.Diff(want, gather(t, reg, "switchbot_device_temperature_celsius")); diff != "" {
+		t.Errorf("temperature mismatch (-want +got):\n%s", diff)
+	}
+
+	want = map[string]float64{
+		"account=home,id=meter,name=Living":   90,
+		"account=office,id=meter,name=Living": 90,
+	}
+	if diff := cmp.Diff(want, gather(t, reg, "switchbot_device_battery")); diff != "" {
+		t.Errorf("battery mismatch (-want +got):\n%s", diff)
+	}
+
+	// metrics of other device types are not exported
+	if got := gather(t, reg, "switchbot_device_slide_position"); len(got) != 0 {
+		t.Errorf("unexpected slide position metrics: %v", got)
+	}
+}
+
+func TestExporterKeepsValuesOnError(t *testing.T) {
+	client := newMeterClient(21.5)
+	e := prom.NewExporter(client)
+
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(e)
+
+	if e.Ready() {
+		t.Error("exporter is ready before the first refresh")
+	}
+	if err := e.Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	client.mu.Lock()
+	client.status["meter"] = switchbot.DeviceStatus{Temperature: 30}
+	client.statusErr = errors.New("too many requests")
+	client.mu.Unlock()
+
+	if err := e.Refresh(context.Background()); err == nil {
+		t.Fatal("error is expected")
+	}
+
+	if diff := cmp.Diff(map[string]float64{"id=meter,name=Living": 21.5}, gather(t, reg, "switchbot_device_temperature_celsius")); diff != "" {
+		t.Errorf("temperature mismatch (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff(map[string]float64{"": 0}, gather(t, reg, "switchbot_up")); diff != "" {
+		t.Errorf("up mismatch (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff(map[string]float64{"id=meter,name=Living": 0}, gather(t, reg, "switchbot_device_stale")); diff != "" {
+		t.Errorf("stale mismatch (-want +got):\n%s", diff)
+	}
+	// the exporter stays ready once a poll has completed
+	if !e.Ready() {
+		t.Error("exporter is not ready after the first poll")
+	}
+}
+
+func TestExporterReady(t *testing.T) {
+	client := newMeterClient(21.5)
+	client.statusErr = errors.New("too many requests")
+	e := prom.NewExporter(client)
+
+	// the device list is fetched but the status is not
+	if err := e.Refresh(context.Background()); err == nil {
+		t.Fatal("error is expected")
+	}
+	if e.Ready() {
+		t.Error("exporter is ready before a poll completes")
+	}
+
+	client.mu.Lock()
+	client.statusErr = nil
+	client.mu.Unlock()
+
+	if err := e.Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if !e.Ready() {
+		t.Error("exporter is not ready after the first poll")
+	}
+
+	t.Run("no device", func(t *testing.T) {
+		e := prom.NewExporter(&fakeClient{})
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go e.Poll(ctx, time.Hour)
+
+		deadline := time.Now().Add(5 * time.Second)
+		for !e.Ready() {
+			if time.Now().After(deadline) {
+				t.Fatal("exporter of an account without devices is not ready")
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	})
+}
+
+func TestExporterServesSnapshot(t *testing.T) {
+	client := newMeterClient(21.5)
+	e := prom.NewExporter(client)
+
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(e)
+
+	// scrapes before the first poll do not call the API either
+	if got := gather(t, reg, "switchbot_device_temperature_celsius"); len(got) != 0 {
+		t.Errorf("unexpected temperature before the first poll: %v", got)
+	}
+	if err := e.Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	client.mu.Lock()
+	listed, polled := client.listed, len(client.polled)
+	client.status["meter"] = switchbot.DeviceStatus{Temperature: 30}
+	client.mu.Unlock()
+
+	for i := 0; i < 3; i++ {
+		if diff := cmp.Diff(map[string]float64{"id=meter,name=Living": 21.5}, gather(t, reg, "switchbot_device_temperature_celsius")); diff != "" {
+			t.Errorf("temperature mismatch (-want +got):\n%s", diff)
+		}
+	}
+
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	if client.listed != listed || len(client.polled) != polled {
+		t.Errorf("scrapes are expected not to call the API but %d list and %d status calls", client.listed-listed, len(client.polled)-polled)
+	}
+}
+
+func TestExporterKeepsSnapshotOnListError(t *testing.T) {
+	client := newMeterClient(21.5)
+	e := prom.NewExporter(client)
+
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(e)
+
+	if err := e.Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	client.mu.Lock()
+	client.devices = nil
+	client.listErr = errors.New("internal server error")
+	client.mu.Unlock()
+
+	if err := e.Refresh(context.Background()); err == nil {
+		t.Fatal("error is expected")
+	}
+
+	if diff := cmp.Diff(map[string]float64{"id=meter,name=Living": 21.5}, gather(t, reg, "switchbot_device_temperature_celsius")); diff != "" {
+		t.Errorf("temperature mismatch (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff(map[string]float64{"id=meter,name=Living": 90}, gather(t, reg, "switchbot_device_battery")); diff != "" {
+		t.Errorf("battery mismatch (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff(map[string]float64{"": 0}, gather(t, reg, "switchbot_up")); diff != "" {
+		t.Errorf("up mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestExporterConcurrentScrapes(t *testing.T) {
+	e := prom.NewExporter(newMeterClient(21.5))
+
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(e)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			if err := e.Refresh(context.Background()); err != nil {
+				t.Error(err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			if _, err := reg.Gather(); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestExporterStateSets(t *testing.T) {
+	client := &fakeClient{
+		devices: []switchbot.Device{
+			{ID: "lock", Name: "Front Door", Type: switchbot.Lock},
+			{ID: "contact", Name: "Window", Type: switchbot.ContactSensor},
+			{ID: "plug", Name: "Fan", Type: switchbot.PlugMiniJP},
+		},
+		status: map[string]switchbot.DeviceStatus{
+			"lock":    {LockState: "jammed", DoorState: "ajar"},
+			"contact": {OpenState: switchbot.ContactTimeoutNotClose, IsMoveDetected: true},
+			"plug":    {Power: switchbot.PowerOn},
+		},
+	}
+	e := prom.NewExporter(client)
+
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(e)
+
+	if err := e.Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]map[string]float64{
+		"switchbot_device_lock_state": {
+			"id=lock,name=Front Door,state=locked":   0,
+			"id=lock,name=Front Door,state=unlocked": 0,
+			"id=lock,name=Front Door,state=jammed":   1,
+		},
+		// unknown states have their own series
+		"switchbot_device_door_state": {
+			"id=lock,name=Front Door,state=opened": 0,
+			"id=lock,name=Front Door,state=closed": 0,
+			"id=lock,name=Front Door,state=ajar":   1,
+		},
+		"switchbot_device_open_state": {
+			"id=contact,name=Window,state=open":            0,
+			"id=contact,name=Window,state=close":           0,
+			"id=contact,name=Window,state=timeOutNotClose": 1,
+		},
+		"switchbot_device_motion_state": {
+			"id=contact,name=Window,state=detected":     1,
+			"id=contact,name=Window,state=not_detected": 0,
+		},
+		// states are matched case-insensitively
+		"switchbot_device_power_state": {
+			"id=plug,name=Fan,state=on":  1,
+			"id=plug,name=Fan,state=off": 0,
+		},
+		// devices which report no state have no series
+		"switchbot_device_online_status": {},
+	}
+	for name, want := range tests {
+		if diff := cmp.Diff(want, gather(t, reg, name)); diff != "" {
+			t.Errorf("%s mismatch (-want +got):\n%s", name, diff)
+		}
+	}
+}
@@ -0,0 +1,462 @@
+package prom
+
+import (
+	"context"
+	"fmt"
+	"github.com/nasa9084/go-switchbot/v3/switchbot"
+	"github.com/rs/zerolog/log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultListInterval is the default interval between fetches of the device list.
+	DefaultListInterval = time.Hour
+	// DefaultDailyBudget is the default number of API calls per day the
+	// exporter plans to use, leaving a margin of the daily quota for other
+	// clients of the account.
+	DefaultDailyBudget = DefaultDailyQuota * 9 / 10
+	// MaxPollInterval is the upper bound of the poll intervals stretched to
+	// fit the daily budget.
+	MaxPollInterval = 24 * time.Hour
+)
+
+// Priority is the priority of the devices in the poll plan. The intervals of
+// the devices of lower priority are stretched first when the plan exceeds the
+// daily budget.
+type Priority int
+
+const (
+	PriorityLow Priority = iota - 1
+	PriorityNormal
+	PriorityHigh
+)
+
+var priorityNames = map[Priority]string{
+	PriorityLow:    "low",
+	PriorityNormal: "normal",
+	PriorityHigh:   "high",
+}
+
+func (p Priority) String() string {
+	if name, ok := priorityNames[p]; ok {
+		return name
+	}
+
+	return fmt.Sprintf("Priority(%d)", int(p))
+}
+
+// MarshalText encodes the priority as its name.
+func (p Priority) MarshalText() ([]byte, error) {
+	return []byte(p.String()), nil
+}
+
+// UnmarshalText decodes the name of the priority.
+func (p *Priority) UnmarshalText(b []byte) error {
+	for priority, name := range priorityNames {
+		if strings.EqualFold(string(b), name) {
+			*p = priority
+			return nil
+		}
+	}
+
+	return fmt.Errorf("unknown priority: %s", b)
+}
+
+// PollPolicy is how often the status of a device is polled.
+type PollPolicy struct {
+	// Interval is the interval between polls of the device status.
+	Interval time.Duration `yaml:"interval"`
+	// Priority is the priority of the device when the intervals are stretched.
+	Priority *Priority `yaml:"priority"`
+	// Never disables polling of the device status.
+	Never bool `yaml:"never"`
+}
+
+func policy(interval time.Duration, priority Priority) PollPolicy {
+	return PollPolicy{Interval: interval, Priority: &priority}
+}
+
+// defaultPollPolicies are the poll policies of the device types. The devices
+// of the other types are polled at the default poll interval with the normal
+// priority.
+var defaultPollPolicies = map[switchbot.PhysicalDeviceType]PollPolicy{
+	switchbot.Lock:         policy(30*time.Second, PriorityHigh),
+	switchbot.SmartLockPro: policy(30*time.Second, PriorityHigh),
+	switchbot.KeyPad:       policy(30*time.Minute, PriorityLow),
+	switchbot.KeyPadTouch:  policy(30*time.Minute, PriorityLow),
+
+	switchbot.ContactSensor: policy(time.Minute, PriorityNormal),
+	switchbot.MotionSensor:  policy(time.Minute, PriorityNormal),
+
+	switchbot.Meter:       policy(5*time.Minute, PriorityLow),
+	switchbot.MeterPlus:   policy(5*time.Minute, PriorityLow),
+	switchbot.MeterPlusJP: policy(5*time.Minute, PriorityLow),
+	switchbot.MeterPlusUS: policy(5*time.Minute, PriorityLow),
+	switchbot.WoIOSensor:  policy(5*time.Minute, PriorityLow),
+	switchbot.MeterPro:    policy(5*time.Minute, PriorityLow),
+	switchbot.MeterProCO2: policy(5*time.Minute, PriorityLow),
+	switchbot.Hub2:        policy(5*time.Minute, PriorityLow),
+
+	// these devices report no status worth polling
+	switchbot.Hub:          {Never: true},
+	switchbot.HubPlus:      {Never: true},
+	switchbot.HubMini:      {Never: true},
+	switchbot.IndoorCam:    {Never: true},
+	switchbot.PanTiltCam:   {Never: true},
+	switchbot.PanTiltCam2K: {Never: true},
+}
+
+// merge returns the policy overridden by the non-zero fields of o.
+func (p PollPolicy) merge(o PollPolicy) PollPolicy {
+	if o.Interval > 0 {
+		p.Interval = o.Interval
+		p.Never = false
+	}
+	if o.Priority != nil {
+		p.Priority = o.Priority
+	}
+	if o.Never {
+		p.Never = true
+	}
+
+	return p
+}
+
+// Plan is the poll plan of the exporter.
+type Plan struct {
+	// DailyBudget is the number of API calls per day the plan fits in.
+	DailyBudget int `json:"dailyBudget"`
+	// DailyCalls is the number of API calls per day the plan makes.
+	DailyCalls float64 `json:"dailyCalls"`
+	// ListInterval is the interval between fetches of the device list.
+	ListInterval Duration `json:"listInterval"`
+	// Devices are the planned devices ordered by their IDs.
+	Devices []PlannedDevice `json:"devices"`
+}
+
+// PlannedDevice is the poll plan of a device.
+type PlannedDevice struct {
+	ID       string                       `json:"deviceId"`
+	Name     string                       `json:"deviceName"`
+	Type     switchbot.PhysicalDeviceType `json:"deviceType"`
+	Priority Priority                     `json:"priority"`
+	// BaseInterval is the interval given by the device type or the config,
+	// zero if the device is never polled.
+	BaseInterval Duration `json:"baseInterval"`
+	// Interval is the interval stretched to fit the daily budget.
+	Interval Duration `json:"interval"`
+	// NextPoll is the time the device is polled next by Poll.
+	NextPoll time.Time `json:"nextPoll,omitempty"`
+}
+
+// Duration is a time.Duration encoded as a string in JSON, e.g. "5m0s".
+type Duration time.Duration
+
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+// MarshalText encodes the duration as a string.
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+// UnmarshalText decodes the duration from a string.
+func (d *Duration) UnmarshalText(b []byte) error {
+	v, err := time.ParseDuration(string(b))
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+
+	return nil
+}
+
+// Plan returns the current poll plan.
+func (e *Exporter) Plan() Plan {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	plan := e.plan
+	plan.Devices = append([]PlannedDevice(nil), e.plan.Devices...)
+
+	return plan
+}
+
+// PlanHandler returns an http.Handler which serves the current poll plan in JSON.
+func (e *Exporter) PlanHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, e.Plan())
+	})
+}
+
+// replan makes the poll plan of the devices, keeping the next poll times of
+// the devices in the current plan.
+func (e *Exporter) replan(devices []switchbot.Device) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	budget := e.dailyBudget
+	if c := e.config; c != nil && c.Polling.DailyBudget > 0 {
+		budget = c.Polling.DailyBudget
+	}
+
+	nextPoll := make(map[string]time.Time, len(e.plan.Devices))
+	for _, d := range e.plan.Devices {
+		nextPoll[d.ID] = d.NextPoll
+	}
+
+	plan := Plan{
+		DailyBudget:  budget,
+		ListInterval: Duration(e.listInterval),
+	}
+	for _, d := range devices {
+		p := e.pollPolicy(d)
+		interval := p.Interval
+		if p.Never {
+			interval = 0
+		}
+
+		plan.Devices = append(plan.Devices, PlannedDevice{
+			ID:           d.ID,
+			Name:         e.config.DeviceName(d),
+			Type:         d.Type,
+			Priority:     *p.Priority,
+			BaseInterval: Duration(interval),
+			Interval:     Duration(interval),
+			NextPoll:     nextPoll[d.ID],
+		})
+	}
+	sort.Slice(plan.Devices, func(i, j int) bool { return plan.Devices[i].ID < plan.Devices[j].ID })
+
+	stretch(&plan)
+	e.plan = plan
+}
+
+// pollPolicy returns the poll policy of the device, overridden by the config
+// for the device type and then for the device name and ID.
+func (e *Exporter) pollPolicy(d switchbot.Device) PollPolicy {
+	p := policy(e.defaultPollInterval, PriorityNormal)
+	if o, ok := defaultPollPolicies[d.Type]; ok {
+		p = p.merge(o)
+	}
+
+	if c := e.config; c != nil {
+		if o, ok := c.Polling.Types[string(d.Type)]; ok {
+			p = p.merge(o)
+		}
+		if o, ok := c.Polling.Devices[d.Name]; ok {
+			p = p.merge(o)
+		}
+		if o, ok := c.Polling.Devices[d.ID]; ok {
+			p = p.merge(o)
+		}
+	}
+
+	return p
+}
+
+func dailyCalls(interval Duration) float64 {
+	if interval <= 0 {
+		return 0
+	}
+
+	return float64(24*time.Hour) / float64(interval)
+}
+
+// stretch stretches the intervals of the devices from the lowest priority
+// until the plan fits in the daily budget, and sets the daily calls of the plan.
+func stretch(plan *Plan) {
+	callsOf := func(priority Priority) float64 {
+		var calls float64
+		for _, d := range plan.Devices {
+			if d.Priority == priority {
+				calls += dailyCalls(d.Interval)
+			}
+		}
+		return calls
+	}
+	total := func() float64 {
+		calls := dailyCalls(plan.ListInterval)
+		for _, d := range plan.Devices {
+			calls += dailyCalls(d.Interval)
+		}
+		return calls
+	}
+
+	for _, priority := range []Priority{PriorityLow, PriorityNormal, PriorityHigh} {
+		over := total() - float64(plan.DailyBudget)
+		if over <= 0 {
+			break
+		}
+
+		calls := callsOf(priority)
+		if calls == 0 {
+			continue
+		}
+
+		// the calls of this priority are reduced to calls-over if possible
+		factor := MaxPollInterval.Seconds() // as long as possible
+		if calls > over {
+			factor = calls / (calls - over)
+		}
+		for i, d := range plan.Devices {
+			if d.Priority != priority || d.Interval <= 0 {
+				continue
+			}
+			interval := time.Duration(float64(d.BaseInterval) * factor).Round(time.Second)
+			if interval > MaxPollInterval {
+				interval = MaxPollInterval
+			}
+			plan.Devices[i].Interval = Duration(interval)
+		}
+	}
+
+	plan.DailyCalls = total()
+}
+
+// Poll polls the SwitchBot API following the poll plan until ctx is done.
+// The device list is fetched every list interval, see WithListInterval, and
+// whenever SetConfig changes the device filters, and
+// the status of each device is fetched at the interval of the device in the
+// plan. While the API errors for the device list or a device, its interval is
+// doubled on every failure up to maxBackoff, and the last values are kept.
+func (e *Exporter) Poll(ctx context.Context, maxBackoff time.Duration) {
+	var (
+		nextList     time.Time
+		listFailures int
+		failures     = map[string]int{}
+	)
+
+	for {
+		started := time.Now()
+		e.refreshMu.Lock()
+
+		var listed bool
+		if !started.Before(nextList) {
+			// the list fetched now applies the config set so far
+			select {
+			case <-e.relist:
+			default:
+			}
+
+			devices, err := e.list(ctx)
+			if err != nil {
+				if ctx.Err() != nil {
+					e.refreshMu.Unlock()
+					return
+				}
+				listFailures++
+				delay := backoff(e.listInterval, listFailures, maxBackoff)
+				nextList = started.Add(delay)
+				log.Warn().Err(err).Str("account", e.account).Msgf("⚠️ polling SwitchBot API failed, next poll in %s", delay)
+				e.finishPoll(false)
+			} else {
+				listFailures = 0
+				listed = true
+				nextList = started.Add(e.listInterval)
+				e.replan(devices)
+			}
+		}
+
+		plan := e.Plan()
+		snap := e.getSnapshot()
+		devices := make(map[string]switchbot.Device, len(snap.devices))
+		for _, d := range snap.devices {
+			devices[d.ID] = d
+		}
+
+		var due []switchbot.Device
+		for _, p := range plan.Devices {
+			if p.Interval <= 0 || started.Before(p.NextPoll) {
+				continue
+			}
+			if e.pushed(snap, p.ID, started) {
+				// the device pushes its changes by webhook events
+				failures[p.ID] = 0
+				continue
+			}
+			if d, ok := devices[p.ID]; ok {
+				due = append(due, d)
+			}
+		}
+
+		if len(due) > 0 {
+			errs := e.fetchStatus(ctx, due, started)
+			if ctx.Err() != nil {
+				e.refreshMu.Unlock()
+				return
+			}
+			for _, d := range due {
+				if _, ok := errs[d.ID]; ok {
+					failures[d.ID]++
+				} else {
+					failures[d.ID] = 0
+				}
+			}
+			e.finishPoll(len(errs) == 0)
+			e.pollDuration.Observe(time.Since(started).Seconds())
+		} else if listed && snap.lastPoll.IsZero() {
+			// no device is due at the first poll, e.g. the account has
+			// no device, which completes with the device list
+			e.finishPoll(true)
+		}
+
+		wake := nextList
+		e.updatePlan(func(p *PlannedDevice) {
+			if p.Interval <= 0 {
+				return
+			}
+			if !started.Before(p.NextPoll) {
+				p.NextPoll = started.Add(backoff(time.Duration(p.Interval), failures[p.ID], maxBackoff))
+			}
+			if p.NextPoll.Before(wake) {
+				wake = p.NextPoll
+			}
+		})
+		e.refreshMu.Unlock()
+
+		t := time.NewTimer(time.Until(wake))
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return
+		case <-e.relist:
+			// the filters of the config have changed
+			t.Stop()
+			nextList = time.Time{}
+		case <-t.C:
+		}
+	}
+}
+
+// updatePlan calls fn with every device in the plan.
+func (e *Exporter) updatePlan(fn func(*PlannedDevice)) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	devices := append([]PlannedDevice(nil), e.plan.Devices...)
+	for i := range devices {
+		fn(&devices[i])
+	}
+	e.plan.Devices = devices
+}
+
+// backoff returns interval doubled on every failure up to maxBackoff.
+func backoff(interval time.Duration, failures int, maxBackoff time.Duration) time.Duration {
+	if maxBackoff < interval {
+		maxBackoff = interval
+	}
+
+	for i := 0; i < failures && interval < maxBackoff; i++ {
+		interval *= 2
+	}
+	if interval > maxBackoff {
+		interval = maxBackoff
+	}
+
+	return interval
+}
@@ -0,0 +1,254 @@
+package prom_test
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/nasa9084/go-switchbot/v3/prom"
+	"github.com/nasa9084/go-switchbot/v3/switchbot"
+	"github.com/prometheus/client_golang/prometheus"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func newPlanClient() *fakeClient {
+	return &fakeClient{
+		devices: []switchbot.Device{
+			{ID: "hub", Name: "Hub", Type: switchbot.Hub2},
+			{ID: "lock", Name: "Front Door", Type: switchbot.Lock},
+			{ID: "meter", Name: "Living", Type: switchbot.Meter},
+			{ID: "mini", Name: "Hub Mini", Type: switchbot.HubMini},
+			{ID: "plug", Name: "Test Plug", Type: switchbot.Plug},
+		},
+		status: map[string]switchbot.DeviceStatus{},
+	}
+}
+
+func TestExporterPlan(t *testing.T) {
+	// the list, the lock, the meter, the hub and the plug make
+	// 24+2880+288+288+48=3528 calls a day
+	e := prom.NewExporter(newPlanClient(), prom.WithDefaultPollInterval(30*time.Minute), prom.WithDailyBudget(3240))
+
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(e)
+
+	if err := e.Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	plan := e.Plan()
+	want := prom.Plan{
+		DailyBudget:  3240,
+		DailyCalls:   3240,
+		ListInterval: prom.Duration(time.Hour),
+		Devices: []prom.PlannedDevice{
+			// the low priority devices are stretched to fit the budget
+			{ID: "hub", Name: "Hub", Type: switchbot.Hub2, Priority: prom.PriorityLow, BaseInterval: prom.Duration(5 * time.Minute), Interval: prom.Duration(10 * time.Minute)},
+			{ID: "lock", Name: "Front Door", Type: switchbot.Lock, Priority: prom.PriorityHigh, BaseInterval: prom.Duration(30 * time.Second), Interval: prom.Duration(30 * time.Second)},
+			{ID: "meter", Name: "Living", Type: switchbot.Meter, Priority: prom.PriorityLow, BaseInterval: prom.Duration(5 * time.Minute), Interval: prom.Duration(10 * time.Minute)},
+			{ID: "mini", Name: "Hub Mini", Type: switchbot.HubMini, Priority: prom.PriorityNormal},
+			{ID: "plug", Name: "Test Plug", Type: switchbot.Plug, Priority: prom.PriorityNormal, BaseInterval: prom.Duration(30 * time.Minute), Interval: prom.Duration(30 * time.Minute)},
+		},
+	}
+	if diff := cmp.Diff(want, plan, cmpopts.EquateApprox(0, 1e-6)); diff != "" {
+		t.Errorf("plan mismatch (-want +got):\n%s", diff)
+	}
+
+	if diff := cmp.Diff(map[string]float64{"": 3240}, gather(t, reg, "switchbot_poll_planned_daily_calls")); diff != "" {
+		t.Errorf("planned daily calls mismatch (-want +got):\n%s", diff)
+	}
+	wantIntervals := map[string]float64{
+		"id=hub,name=Hub":         600,
+		"id=lock,name=Front Door": 30,
+		"id=meter,name=Living":    600,
+		"id=mini,name=Hub Mini":   0,
+		"id=plug,name=Test Plug":  1800,
+	}
+	if diff := cmp.Diff(wantIntervals, gather(t, reg, "switchbot_device_poll_interval_seconds")); diff != "" {
+		t.Errorf("poll interval mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestExporterPlanConfig(t *testing.T) {
+	config, err := prom.ParseConfig([]byte(`
+polling:
+  dailyBudget: 100000
+  types:
+    Meter: {interval: 10m, priority: high}
+    Hub Mini: {interval: 1h}
+  devices:
+    Test Plug: {never: true}
+    lock: {interval: 15s}
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := prom.NewExporter(newPlanClient(), prom.WithConfig(config))
+	if err := e.Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	type interval struct {
+		Priority prom.Priority
+		Interval prom.Duration
+	}
+	got := map[string]interval{}
+	for _, d := range e.Plan().Devices {
+		got[d.ID] = interval{Priority: d.Priority, Interval: d.Interval}
+	}
+
+	want := map[string]interval{
+		"hub":   {Priority: prom.PriorityLow, Interval: prom.Duration(5 * time.Minute)},
+		"lock":  {Priority: prom.PriorityHigh, Interval: prom.Duration(15 * time.Second)},
+		"meter": {Priority: prom.PriorityHigh, Interval: prom.Duration(10 * time.Minute)},
+		"mini":  {Priority: prom.PriorityNormal, Interval: prom.Duration(time.Hour)},
+		"plug":  {Priority: prom.PriorityNormal},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("plan mismatch (-want +got):\n%s", diff)
+	}
+	if got := e.Plan().DailyBudget; got != 100000 {
+		t.Errorf("daily budget = %d, want 100000", got)
+	}
+}
+
+func TestExporterPoll(t *testing.T) {
+	config, err := prom.ParseConfig([]byte(`polling: {dailyBudget: 10000000, types: {Plug: {interval: 50ms}}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := newPlanClient()
+	e := prom.NewExporter(client, prom.WithConfig(config))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	e.Poll(ctx, time.Hour)
+
+	client.mu.Lock()
+	defer client.mu.Unlock()
+
+	counts := map[string]int{}
+	for _, id := range client.polled {
+		counts[id]++
+	}
+	// the plug is polled every 50ms, the others once at the start
+	if counts["plug"] < 3 {
+		t.Errorf("plug is polled %d times, want at least 3", counts["plug"])
+	}
+	for _, id := range []string{"hub", "lock", "meter"} {
+		if counts[id] != 1 {
+			t.Errorf("%s is polled %d times, want 1", id, counts[id])
+		}
+	}
+	if counts["mini"] != 0 {
+		t.Errorf("mini is polled %d times, want 0", counts["mini"])
+	}
+}
+
+func TestExporterPollConfigFilters(t *testing.T) {
+	config, err := prom.ParseConfig([]byte(`exclude: [{types: [Plug]}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := newPlanClient()
+	e := prom.NewExporter(client, prom.WithConfig(config), prom.WithListInterval(time.Hour))
+
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(e)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go e.Poll(ctx, time.Hour)
+
+	waitListed := func(n int) {
+		t.Helper()
+
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			client.mu.Lock()
+			listed := client.listed
+			client.mu.Unlock()
+			if listed >= n {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("device list is fetched %d times, want %d", listed, n)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	waitListed(1)
+
+	// the same filters do not make the exporter fetch the list again
+	same, err := prom.ParseConfig([]byte("exclude: [{types: [Plug]}]\nnames: {lock: door}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.SetConfig(same)
+	time.Sleep(50 * time.Millisecond)
+
+	client.mu.Lock()
+	listed := client.listed
+	client.mu.Unlock()
+	if listed != 1 {
+		t.Errorf("device list is fetched %d times, want 1", listed)
+	}
+
+	e.SetConfig(nil)
+	waitListed(2)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, ok := gather(t, reg, "switchbot_device_poll_interval_seconds")["id=plug,name=Test Plug"]; ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("newly included plug is not exported")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestExporterPlanHandler(t *testing.T) {
+	client := &fakeClient{devices: []switchbot.Device{{ID: "lock", Name: "Front Door", Type: switchbot.Lock}}}
+	e := prom.NewExporter(client)
+	if err := e.Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := httptest.NewRecorder()
+	e.PlanHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/debug/plan", nil))
+
+	if got := rec.Header().Get("Content-Type"); got != "application/json" {
+		t.Errorf("content type = %q, want application/json", got)
+	}
+
+	var got map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"dailyBudget":  float64(prom.DefaultDailyBudget),
+		"dailyCalls":   float64(24 + 2880),
+		"listInterval": "1h0m0s",
+		"devices": []interface{}{
+			map[string]interface{}{
+				"deviceId":     "lock",
+				"deviceName":   "Front Door",
+				"deviceType":   "Smart Lock",
+				"priority":     "high",
+				"baseInterval": "30s",
+				"interval":     "30s",
+				"nextPoll":     "0001-01-01T00:00:00Z",
+			},
+		},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("plan mismatch (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,348 @@
+package prom
+
+import (
+	"encoding/json"
+	"github.com/nasa9084/go-switchbot/v3/switchbot"
+	"html/template"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// noHub is the hub ID of the devices which are not connected to a hub.
+const noHub = "000000000000"
+
+// Inventory is the devices of the account and their last status, taken from
+// the snapshot of the exporter.
+type Inventory struct {
+	Account string `json:"account,omitempty"`
+	Up      bool   `json:"up"`
+	// LastPoll is the time of the last poll, nil before the first poll.
+	LastPoll *time.Time `json:"lastPoll,omitempty"`
+	// Quota is the usage of the daily quota, nil if unknown.
+	Quota    *QuotaUsage                `json:"quota,omitempty"`
+	Devices  []DeviceState              `json:"deviceList"`
+	Infrared []switchbot.InfraredDevice `json:"infraredRemoteList"`
+	Hubs     []HubState                 `json:"hubs"`
+}
+
+// QuotaUsage is the usage of the daily quota of the API.
+type QuotaUsage struct {
+	DailyQuota int `json:"dailyQuota"`
+	// Used is the number of requests made since 00:00 UTC.
+	Used      int `json:"used"`
+	Remaining int `json:"remaining"`
+}
+
+// DeviceState is a device and its last status.
+type DeviceState struct {
+	ID    string                       `json:"deviceId"`
+	Name  string                       `json:"deviceName"`
+	Type  switchbot.PhysicalDeviceType `json:"deviceType"`
+	Hub   string                       `json:"hubDeviceId,omitempty"`
+	Group string                       `json:"groupName,omitempty"`
+	// Status is the non-zero fields of the last status.
+	Status map[string]interface{} `json:"status,omitempty"`
+	// LastUpdate is the time the status was last updated by a poll or an
+	// event, nil if it has never been.
+	LastUpdate *time.Time `json:"lastUpdate,omitempty"`
+	Stale      bool       `json:"stale"`
+	// PollInterval is the planned interval between polls, zero if the device
+	// is not polled.
+	PollInterval Duration `json:"pollInterval"`
+	// LastError is the last error of the status fetches, and LastErrorAt is
+	// the time of it.
+	LastError   string     `json:"lastError,omitempty"`
+	LastErrorAt *time.Time `json:"lastErrorAt,omitempty"`
+	// Failing is true if the last status fetch failed.
+	Failing bool `json:"failing"`
+}
+
+// HubState is a hub and the devices connected to it.
+type HubState struct {
+	ID       string                       `json:"deviceId"`
+	Name     string                       `json:"deviceName,omitempty"`
+	Type     switchbot.PhysicalDeviceType `json:"deviceType,omitempty"`
+	Devices  []string                     `json:"deviceIds"`
+	Infrared []string                     `json:"infraredRemoteIds"`
+}
+
+// Inventory returns the devices and their last status from the snapshot.
+func (e *Exporter) Inventory() Inventory {
+	e.mu.RLock()
+	snap, config, plan := e.snapshot, e.config, e.plan
+	e.mu.RUnlock()
+	now := time.Now()
+
+	intervals := make(map[string]Duration, len(plan.Devices))
+	for _, d := range plan.Devices {
+		intervals[d.ID] = d.Interval
+	}
+
+	inv := Inventory{
+		Account:  e.account,
+		Up:       snap.up,
+		LastPoll: timeOrNil(snap.lastPoll),
+		Devices:  make([]DeviceState, 0, len(snap.devices)),
+		Infrared: append([]switchbot.InfraredDevice{}, snap.infrared...),
+	}
+
+	for _, d := range snap.devices {
+		state := DeviceState{
+			ID:           d.ID,
+			Name:         config.DeviceName(d),
+			Type:         d.Type,
+			Hub:          d.Hub,
+			Group:        d.GroupName,
+			PollInterval: intervals[d.ID],
+		}
+		lastSuccess, ok := snap.lastSuccess[d.ID]
+		if ok {
+			state.Status = statusFields(snap.status[d.ID])
+			state.LastUpdate = &lastSuccess
+		}
+		state.Stale = !ok || now.Sub(lastSuccess) > e.staleThreshold
+		if err, ok := snap.lastError[d.ID]; ok {
+			state.LastError = err.err.Error()
+			state.LastErrorAt = timeOrNil(err.at)
+			state.Failing = err.at.After(snap.lastStatus[d.ID])
+		}
+
+		inv.Devices = append(inv.Devices, state)
+	}
+
+	inv.Hubs = hubs(snap.devices, snap.infrared, config)
+
+	return inv
+}
+
+// hubs returns the hubs which the devices and the infrared remotes are
+// connected to, ordered by their IDs.
+func hubs(devices []switchbot.Device, infrared []switchbot.InfraredDevice, config *Config) []HubState {
+	byID := map[string]*HubState{}
+	hub := func(id string) *HubState {
+		if h, ok := byID[id]; ok {
+			return h
+		}
+		h := &HubState{ID: id, Devices: []string{}, Infrared: []string{}}
+		byID[id] = h
+		return h
+	}
+
+	for _, d := range devices {
+		switch d.Type {
+		case switchbot.Hub, switchbot.HubPlus, switchbot.HubMini, switchbot.Hub2:
+			h := hub(d.ID)
+			h.Name, h.Type = config.DeviceName(d), d.Type
+		}
+	}
+	for _, d := range devices {
+		if d.Hub != "" && d.Hub != noHub && d.Hub != d.ID {
+			h := hub(d.Hub)
+			h.Devices = append(h.Devices, d.ID)
+		}
+	}
+	for _, d := range infrared {
+		if d.Hub != "" && d.Hub != noHub {
+			h := hub(d.Hub)
+			h.Infrared = append(h.Infrared, d.ID)
+		}
+	}
+
+	ret := make([]HubState, 0, len(byID))
+	for _, h := range byID {
+		ret = append(ret, *h)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].ID < ret[j].ID })
+
+	return ret
+}
+
+// statusFields returns the non-zero fields of the status keyed by their JSON
+// names, except the ones of the device itself.
+func statusFields(status switchbot.DeviceStatus) map[string]interface{} {
+	fields := map[string]interface{}{}
+	if b, err := json.Marshal(status); err == nil {
+		_ = json.Unmarshal(b, &fields)
+	}
+
+	delete(fields, "deviceId")
+	delete(fields, "deviceType")
+	delete(fields, "hubDeviceId")
+	for k, v := range fields {
+		switch v {
+		case nil, "", float64(0), false:
+			delete(fields, k)
+		}
+	}
+
+	return fields
+}
+
+func timeOrNil(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+
+	return &t
+}
+
+// StatusOption configures the status handler.
+type StatusOption func(*statusHandler)
+
+// WithStatusAPIMetrics sets the API metrics the quota usage is taken from.
+func WithStatusAPIMetrics(m *APIMetrics) StatusOption {
+	return func(h *statusHandler) {
+		h.api = m
+	}
+}
+
+// WithStatusMetricsPath sets the path of the metrics linked from the status page.
+func WithStatusMetricsPath(path string) StatusOption {
+	return func(h *statusHandler) {
+		h.metricsPath = path
+	}
+}
+
+type statusHandler struct {
+	exporter    *Exporter
+	api         *APIMetrics
+	metricsPath string
+}
+
+// NewStatusHandler returns an http.Handler which serves the inventory of the
+// exporter: the status page on /, the JSON of the inventory on /api/devices
+// and the JSON of a device or an infrared remote on /api/devices/{id}.
+// Everything is served from the snapshot without calling the API.
+func NewStatusHandler(e *Exporter, opts ...StatusOption) http.Handler {
+	h := &statusHandler{
+		exporter:    e,
+		metricsPath: "/metrics",
+	}
+	for _, opt := range opts {
+		opt(h)
+	}
+
+	return h
+}
+
+func (h *statusHandler) inventory() Inventory {
+	inv := h.exporter.Inventory()
+	if h.api != nil {
+		used, quota := h.api.Usage()
+		remaining := quota - used
+		if remaining < 0 {
+			remaining = 0
+		}
+		inv.Quota = &QuotaUsage{DailyQuota: quota, Used: used, Remaining: remaining}
+	}
+
+	return inv
+}
+
+func (h *statusHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.URL.Path == "/":
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_ = statusTemplate.Execute(w, struct {
+			Inventory
+			MetricsPath string
+		}{h.inventory(), h.metricsPath})
+	case r.URL.Path == "/api/devices":
+		writeJSON(w, h.inventory())
+	case strings.HasPrefix(r.URL.Path, "/api/devices/"):
+		id := strings.TrimPrefix(r.URL.Path, "/api/devices/")
+		inv := h.exporter.Inventory()
+		for _, d := range inv.Devices {
+			if d.ID == id {
+				writeJSON(w, d)
+				return
+			}
+		}
+		for _, d := range inv.Infrared {
+			if d.ID == id {
+				writeJSON(w, d)
+				return
+			}
+		}
+		http.Error(w, "unknown device: "+id, http.StatusNotFound)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(v)
+}
+
+var statusTemplate = template.Must(template.New("status").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<title>SwitchBot Exporter</title>
+<style>
+body { font-family: sans-serif; }
+table { border-collapse: collapse; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: left; vertical-align: top; }
+.failing { color: #c00; }
+.stale { color: #888; }
+</style>
+</head>
+<body>
+<h1>SwitchBot Exporter{{with .Account}} ({{.}}){{end}}</h1>
+<p>
+<a href="{{.MetricsPath}}">Metrics</a> |
+<a href="/api/devices">JSON</a> |
+<a href="/debug/plan">Poll plan</a> |
+<a href="/healthz">Health</a> |
+<a href="/readyz">Readiness</a>
+</p>
+<p>
+API: {{if .Up}}up{{else}}<span class="failing">down</span>{{end}},
+last poll {{with .LastPoll}}{{.Format "2006-01-02 15:04:05 MST"}}{{else}}never{{end}}
+{{- with .Quota}}, {{.Used}} of {{.DailyQuota}} calls used today, {{.Remaining}} remaining{{end}}
+</p>
+
+<h2>Devices</h2>
+<table>
+<tr><th>Name</th><th>ID</th><th>Type</th><th>Hub</th><th>Last update</th><th>Poll interval</th><th>Status</th><th>Last error</th></tr>
+{{- range .Devices}}
+<tr{{if .Failing}} class="failing"{{else if .Stale}} class="stale"{{end}}>
+<td><a href="/api/devices/{{.ID}}">{{.Name}}</a></td>
+<td>{{.ID}}</td>
+<td>{{.Type}}</td>
+<td>{{.Hub}}</td>
+<td>{{with .LastUpdate}}{{.Format "2006-01-02 15:04:05 MST"}}{{else}}never{{end}}{{if .Stale}} (stale){{end}}</td>
+<td>{{if .PollInterval}}{{.PollInterval}}{{else}}not polled{{end}}</td>
+<td>{{range $k, $v := .Status}}{{$k}}={{$v}}<br>{{end}}</td>
+<td>{{with .LastErrorAt}}{{.Format "2006-01-02 15:04:05 MST"}}: {{end}}{{.LastError}}</td>
+</tr>
+{{- end}}
+</table>
+
+<h2>Infrared remotes</h2>
+<table>
+<tr><th>Name</th><th>ID</th><th>Type</th><th>Hub</th></tr>
+{{- range .Infrared}}
+<tr><td>{{.Name}}</td><td>{{.ID}}</td><td>{{.Type}}</td><td>{{.Hub}}</td></tr>
+{{- end}}
+</table>
+
+<h2>Hubs</h2>
+<table>
+<tr><th>Name</th><th>ID</th><th>Type</th><th>Devices</th><th>Infrared remotes</th></tr>
+{{- range .Hubs}}
+<tr>
+<td>{{.Name}}</td><td>{{.ID}}</td><td>{{.Type}}</td>
+<td>{{range .Devices}}{{.}}<br>{{end}}</td>
+<td>{{range .Infrared}}{{.}}<br>{{end}}</td>
+</tr>
+{{- end}}
+</table>
+</body>
+</html>
+`))
@@ -0,0 +1,119 @@
+package prom_test
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/nasa9084/go-switchbot/v3/prom"
+	"github.com/nasa9084/go-switchbot/v3/switchbot"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStatusHandler(t *testing.T) {
+	client := &fakeClient{
+		devices: []switchbot.Device{
+			{ID: "hub", Name: "Hub", Type: switchbot.Hub2, Hub: "000000000000"},
+			{ID: "meter", Name: "Living", Type: switchbot.Meter, Hub: "hub"},
+			{ID: "plug", Name: "Plug", Type: switchbot.Plug, Hub: "000000000000"},
+		},
+		infrared: []switchbot.InfraredDevice{{ID: "tv", Name: "TV", Type: switchbot.TV, Hub: "hub"}},
+		status: map[string]switchbot.DeviceStatus{
+			"hub":   {ID: "hub", Temperature: 20.5},
+			"meter": {ID: "meter", Temperature: 21.5, Humidity: 40, Battery: 90},
+		},
+		deviceErrs: map[string]error{"plug": switchbot.ErrDeviceOffline},
+	}
+	api := prom.NewAPIMetrics(prom.WithDailyQuota(100))
+	e := prom.NewExporter(client, prom.WithAccount("home"))
+	if err := e.Refresh(context.Background()); err == nil {
+		t.Fatal("error is expected for the offline device")
+	}
+
+	srv := httptest.NewServer(prom.NewStatusHandler(e, prom.WithStatusAPIMetrics(api)))
+	defer srv.Close()
+
+	get := func(path string, v interface{}) int {
+		t.Helper()
+
+		resp, err := http.Get(srv.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode == http.StatusOK && v != nil {
+			if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return resp.StatusCode
+	}
+
+	var inv prom.Inventory
+	if code := get("/api/devices", &inv); code != http.StatusOK {
+		t.Fatalf("status code = %d, want 200", code)
+	}
+
+	want := prom.Inventory{
+		Account: "home",
+		Quota:   &prom.QuotaUsage{DailyQuota: 100, Remaining: 100},
+		Devices: []prom.DeviceState{
+			{ID: "hub", Name: "Hub", Type: switchbot.Hub2, Hub: "000000000000", Status: map[string]interface{}{"temperature": 20.5}, PollInterval: prom.Duration(5 * time.Minute)},
+			{ID: "meter", Name: "Living", Type: switchbot.Meter, Hub: "hub", Status: map[string]interface{}{"temperature": 21.5, "humidity": float64(40), "battery": float64(90)}, PollInterval: prom.Duration(5 * time.Minute)},
+			{ID: "plug", Name: "Plug", Type: switchbot.Plug, Hub: "000000000000", Stale: true, PollInterval: prom.Duration(5 * time.Minute), LastError: switchbot.ErrDeviceOffline.Error(), Failing: true},
+		},
+		Infrared: []switchbot.InfraredDevice{{ID: "tv", Name: "TV", Type: switchbot.TV, Hub: "hub"}},
+		Hubs: []prom.HubState{
+			{ID: "hub", Name: "Hub", Type: switchbot.Hub2, Devices: []string{"meter"}, Infrared: []string{"tv"}},
+		},
+	}
+	ignoreTimes := cmpopts.IgnoreFields(prom.DeviceState{}, "LastUpdate", "LastErrorAt")
+	if diff := cmp.Diff(want, inv, ignoreTimes, cmpopts.IgnoreFields(prom.Inventory{}, "LastPoll")); diff != "" {
+		t.Errorf("inventory mismatch (-want +got):\n%s", diff)
+	}
+	if inv.LastPoll == nil || inv.Devices[1].LastUpdate == nil || inv.Devices[2].LastUpdate != nil || inv.Devices[2].LastErrorAt == nil {
+		t.Errorf("unexpected times: %+v", inv)
+	}
+
+	var device prom.DeviceState
+	if code := get("/api/devices/meter", &device); code != http.StatusOK {
+		t.Fatalf("status code = %d, want 200", code)
+	}
+	if diff := cmp.Diff(want.Devices[1], device, ignoreTimes); diff != "" {
+		t.Errorf("device mismatch (-want +got):\n%s", diff)
+	}
+
+	var remote switchbot.InfraredDevice
+	if code := get("/api/devices/tv", &remote); code != http.StatusOK {
+		t.Fatalf("status code = %d, want 200", code)
+	}
+	if diff := cmp.Diff(want.Infrared[0], remote); diff != "" {
+		t.Errorf("infrared remote mismatch (-want +got):\n%s", diff)
+	}
+
+	for path, want := range map[string]int{"/api/devices/unknown": http.StatusNotFound, "/unknown": http.StatusNotFound} {
+		if code := get(path, nil); code != want {
+			t.Errorf("status code of %s = %d, want %d", path, code, want)
+		}
+	}
+
+	resp, err := http.Get(srv.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var page strings.Builder
+	if _, err := io.Copy(&page, resp.Body); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"SwitchBot Exporter (home)", "Living", "humidity=40", "TV", "0 of 100 calls used today", `class="failing"`} {
+		if !strings.Contains(page.String(), want) {
+			t.Errorf("%q is not in the status page:\n%s", want, page.String())
+		}
+	}
+}
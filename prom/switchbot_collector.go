@@ -2,47 +2,284 @@ package prom
 
 import (
 	"context"
+	"errors"
 	"github.com/nasa9084/go-switchbot/v3/switchbot"
 	"github.com/rs/zerolog/log"
+	"time"
 )
 
-var (
-	switchbotClient       *switchbot.Client
-	switchbotDevices      []switchbot.Device
-	switchbotDeviceStatus map[string]switchbot.DeviceStatus
+const (
+	// DefaultPollInterval is the default interval between polls of the SwitchBot API.
+	// Each poll calls the API once for the device list and once for each device.
+	DefaultPollInterval = 5 * time.Minute
+	// DefaultMaxBackoff is the default upper bound of the poll interval while the API errors.
+	DefaultMaxBackoff = time.Hour
 )
 
-// New Initializes Switchbot Client
-func New(token string, key string) {
-	switchbotClient = switchbot.New(token, key)
-	switchbotDeviceStatus = make(map[string]switchbot.DeviceStatus)
+// DeviceClient is the part of the SwitchBot API used by the exporter.
+// *switchbot.DeviceService implements this interface.
+type DeviceClient interface {
+	List(ctx context.Context) ([]switchbot.Device, []switchbot.InfraredDevice, error)
+	Status(ctx context.Context, id string) (switchbot.DeviceStatus, error)
 }
 
-// Refresh Refreshes Switchbot Data
-func Refresh() {
-	GetDevices()
+var _ DeviceClient = (*switchbot.DeviceService)(nil)
+
+// snapshot is the data of the last poll and the webhook events received
+// since. The maps are replaced, not modified, on every update so a snapshot
+// can be read without a lock once it is taken.
+type snapshot struct {
+	devices  []switchbot.Device
+	infrared []switchbot.InfraredDevice
+	status   map[string]switchbot.DeviceStatus
+	// excluded is the set of the IDs of the listed devices excluded by the config.
+	excluded map[string]bool
+	// lastSuccess is the time the status was last fetched by a poll, or sampled
+	// by the device of the last applied event.
+	lastSuccess map[string]time.Time
+	// lastStatus is the time the status was last fetched from the API.
+	lastStatus map[string]time.Time
+	// lastEvent is the time the last webhook event of the device was received.
+	lastEvent map[string]time.Time
+	// lastError is the last error of the status fetches of the device.
+	lastError map[string]deviceError
+	// lastList is the time the device list was last fetched successfully.
+	lastList time.Time
+	lastPoll time.Time
+	// lastPollSuccess is the time of the last poll without errors.
+	lastPollSuccess time.Time
+	up              bool
+}
+
+func (e *Exporter) getSnapshot() snapshot {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	return e.snapshot
 }
 
-// GetDevices Gets all the device values
-func GetDevices() {
-	var err error
-	var deviceStat switchbot.DeviceStatus
-	switchbotDevices, _, err = switchbotClient.Device().List(context.Background())
+// updateSnapshot replaces the snapshot with the one returned by fn.
+func (e *Exporter) updateSnapshot(fn func(snapshot) snapshot) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.snapshot = fn(e.snapshot)
+}
 
+// Refresh Refreshes Switchbot Data
+// Refresh fetches the device list and the status of all the exported devices
+// at once, except the devices which push webhook events, regardless of the
+// poll plan. The status of a device which fails to be fetched is kept from
+// the previous poll.
+func (e *Exporter) Refresh(ctx context.Context) error {
+	e.refreshMu.Lock()
+	defer e.refreshMu.Unlock()
+
+	started := time.Now()
+	defer func() {
+		e.pollDuration.Observe(time.Since(started).Seconds())
+	}()
+
+	devices, err := e.list(ctx)
 	if err != nil {
-		log.Error().Err(err).Msgf("Error Getting Devices. %s", err)
-		return
+		e.finishPoll(false)
+		return err
+	}
+	e.replan(devices)
+
+	snap := e.getSnapshot()
+	due := make([]switchbot.Device, 0, len(devices))
+	for _, d := range devices {
+		if !e.pushed(snap, d.ID, started) {
+			due = append(due, d)
+		}
+	}
+
+	var errs []error
+	for _, err := range e.fetchStatus(ctx, due, started) {
+		errs = append(errs, err)
 	}
+	e.finishPoll(len(errs) == 0)
+
+	return errors.Join(errs...)
+}
+
+// list fetches the device list, and replaces the devices in the snapshot with
+// the exported ones.
+func (e *Exporter) list(ctx context.Context) ([]switchbot.Device, error) {
+	devices, infrared, err := e.client.List(ctx)
+	if err != nil {
+		log.Error().Err(err).Str("account", e.account).Msgf("Error Getting Devices. %s", err)
+		return nil, err
+	}
+
+	e.mu.RLock()
+	config := e.config
+	e.mu.RUnlock()
+
+	// excluded devices are neither polled nor exported
+	exported := devices[:0:0]
+	excluded := map[string]bool{}
+	for _, d := range devices {
+		if config.Exported(d) {
+			exported = append(exported, d)
+		} else {
+			excluded[d.ID] = true
+		}
+	}
+
+	e.updateSnapshot(func(cur snapshot) snapshot {
+		next := cur
+		next.devices = exported
+		next.excluded = excluded
+		next.infrared = infrared
+		next.lastList = time.Now()
+		next.status = make(map[string]switchbot.DeviceStatus, len(exported))
+		next.lastSuccess = make(map[string]time.Time, len(exported))
+		next.lastStatus = make(map[string]time.Time, len(exported))
+		next.lastEvent = make(map[string]time.Time, len(exported))
+		next.lastError = make(map[string]deviceError, len(exported))
+
+		for _, d := range exported {
+			if stat, ok := cur.status[d.ID]; ok {
+				next.status[d.ID] = stat
+			}
+			copyTime(next.lastSuccess, cur.lastSuccess, d.ID)
+			copyTime(next.lastStatus, cur.lastStatus, d.ID)
+			copyTime(next.lastEvent, cur.lastEvent, d.ID)
+			if err, ok := cur.lastError[d.ID]; ok {
+				next.lastError[d.ID] = err
+			}
+		}
+
+		return next
+	})
+
+	return exported, nil
+}
+
+// fetchStatus fetches the status of the devices, and returns the errors keyed
+// by the device IDs. The status of the devices which have sent webhook events
+// since started is not replaced with the fetched one.
+func (e *Exporter) fetchStatus(ctx context.Context, devices []switchbot.Device, started time.Time) map[string]error {
+	e.mu.RLock()
+	config := e.config
+	e.mu.RUnlock()
+
+	status := make(map[string]switchbot.DeviceStatus, len(devices))
+	fetched := make(map[string]time.Time, len(devices))
+	errs := map[string]error{}
+	failed := map[string]deviceError{}
 
 	// Loop through all this Switchbot Devices
-	for _, d := range switchbotDevices {
-		deviceStat, err = switchbotClient.Device().Status(context.Background(), d.ID)
+	for _, d := range devices {
+		deviceStat, err := e.client.Status(ctx, d.ID)
 
 		if err != nil {
-			log.Error().Err(err).Msgf("Error Getting Device Status for %s: %s", d.Name, err)
+			log.Error().Err(err).Str("account", e.account).Msgf("Error Getting Device Status for %s: %s", d.Name, err)
+			e.statusErrors.WithLabelValues(d.ID, config.DeviceName(d), statusErrorKind(err)).Inc()
+			errs[d.ID] = err
+			failed[d.ID] = deviceError{at: time.Now(), err: err}
 			continue
 		}
 		// Get the device stats, and add them to the map
-		switchbotDeviceStatus[d.ID] = deviceStat
+		status[d.ID] = deviceStat
+		fetched[d.ID] = time.Now()
+	}
+
+	e.updateSnapshot(func(cur snapshot) snapshot {
+		next := cur
+		next.status = make(map[string]switchbot.DeviceStatus, len(cur.status))
+		for k, v := range cur.status {
+			next.status[k] = v
+		}
+		next.lastSuccess = copyTimes(cur.lastSuccess)
+		next.lastStatus = copyTimes(cur.lastStatus)
+		if len(failed) > 0 {
+			next.lastError = make(map[string]deviceError, len(cur.lastError)+len(failed))
+			for k, v := range cur.lastError {
+				next.lastError[k] = v
+			}
+			for k, v := range failed {
+				next.lastError[k] = v
+			}
+		}
+
+		for id, t := range fetched {
+			next.lastStatus[id] = t
+			if ev, ok := cur.lastEvent[id]; ok && ev.After(started) {
+				// an event received during the poll is newer than the status
+				continue
+			}
+			next.status[id] = status[id]
+			next.lastSuccess[id] = t
+		}
+
+		return next
+	})
+
+	return errs
+}
+
+// Ready reports whether a poll has completed without errors, which means the
+// credentials are valid and the metrics of all the exported devices are
+// available. The exporter stays ready even if later polls fail, as the last
+// values are kept.
+func (e *Exporter) Ready() bool {
+	return !e.getSnapshot().lastPollSuccess.IsZero()
+}
+
+// deviceError is an error of a status fetch of a device.
+type deviceError struct {
+	at  time.Time
+	err error
+}
+
+// finishPoll records the time and the result of a poll.
+func (e *Exporter) finishPoll(ok bool) {
+	e.updateSnapshot(func(cur snapshot) snapshot {
+		cur.lastPoll = time.Now()
+		cur.up = ok
+		if ok {
+			cur.lastPollSuccess = cur.lastPoll
+		}
+		return cur
+	})
+}
+
+// pushed reports whether the status of the device need not be fetched
+// because the device has pushed a webhook event and the status has been
+// fetched within the pushed poll interval.
+func (e *Exporter) pushed(snap snapshot, id string, now time.Time) bool {
+	if e.pushedPollInterval <= 0 {
+		return false
+	}
+
+	event, ok := snap.lastEvent[id]
+	if !ok || now.Sub(event) > e.pushedPollInterval {
+		return false
+	}
+
+	polled, ok := snap.lastStatus[id]
+	return ok && now.Sub(polled) < e.pushedPollInterval
+}
+
+func copyTime(dst, src map[string]time.Time, key string) {
+	if v, ok := src[key]; ok {
+		dst[key] = v
+	}
+}
+
+// statusErrorKind returns the value of the kind label of the status fetch error.
+func statusErrorKind(err error) string {
+	switch {
+	case errors.Is(err, switchbot.ErrDeviceOffline):
+		return "offline"
+	case errors.Is(err, switchbot.ErrHubOffline):
+		return "hub_offline"
+	case errors.Is(err, switchbot.ErrDeviceInternal):
+		return "190"
+	default:
+		return "other"
 	}
 }
@@ -0,0 +1,124 @@
+package prom
+
+import (
+	"github.com/nasa9084/go-switchbot/v3/switchbot"
+	"github.com/prometheus/client_golang/prometheus"
+	"strings"
+	"time"
+)
+
+// eventMetrics are the counters fed by webhook events.
+type eventMetrics struct {
+	events           *prometheus.CounterVec
+	motionDetections *prometheus.CounterVec
+	contactOpen      *prometheus.CounterVec
+	lockJammed       *prometheus.CounterVec
+	keypadResults    *prometheus.CounterVec
+}
+
+func newEventMetrics(constLabels prometheus.Labels) eventMetrics {
+	counter := func(name, help string, labels ...string) *prometheus.CounterVec {
+		return prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   namespace,
+			Name:        name,
+			Help:        help,
+			ConstLabels: constLabels,
+		}, labels)
+	}
+
+	return eventMetrics{
+		events:           counter("webhook_events_total", "The number of received webhook events", "device_type"),
+		motionDetections: counter("motion_detections_total", "The number of motions detected by the device", "id", "name"),
+		contactOpen:      counter("contact_open_total", "The number of times the contact sensor is opened", "id", "name"),
+		lockJammed:       counter("lock_jammed_total", "The number of times the lock is jammed", "id", "name"),
+		keypadResults:    counter("keypad_command_results_total", "The number of the results of the commands sent by the keypad", "id", "name", "result"),
+	}
+}
+
+func (m eventMetrics) collectors() []prometheus.Collector {
+	return []prometheus.Collector{m.events, m.motionDetections, m.contactOpen, m.lockJammed, m.keypadResults}
+}
+
+// ApplyEvent updates the metrics of the device with the given webhook event
+// immediately, and counts the event. ApplyEvent can be registered with
+// (*switchbot.WebhookHandler).OnEvent.
+// The events of devices which are not listed by the last poll are only counted
+// by the device type, and the events of devices excluded by the config are
+// dropped. An event sampled before the last update of the device status is
+// counted but does not overwrite the status.
+func (e *Exporter) ApplyEvent(event switchbot.Event) {
+	id := event.DeviceID()
+	sampledAt := event.Time()
+	now := time.Now()
+
+	var (
+		name     string
+		known    bool
+		excluded bool
+	)
+	e.updateSnapshot(func(cur snapshot) snapshot {
+		if cur.excluded[id] {
+			excluded = true
+			return cur
+		}
+		for _, d := range cur.devices {
+			if d.ID == id {
+				name, known = e.config.DeviceName(d), true
+				break
+			}
+		}
+		if !known || sampledAt.Before(cur.lastSuccess[id]) {
+			return cur
+		}
+
+		next := cur
+		next.status = make(map[string]switchbot.DeviceStatus, len(cur.status)+1)
+		for k, v := range cur.status {
+			next.status[k] = v
+		}
+		next.lastSuccess = copyTimes(cur.lastSuccess)
+		next.lastEvent = copyTimes(cur.lastEvent)
+
+		next.status[id] = cur.status[id].ApplyEvent(event)
+		next.lastSuccess[id] = sampledAt
+		next.lastEvent[id] = now
+
+		return next
+	})
+	if excluded {
+		return
+	}
+
+	e.eventMetrics.events.WithLabelValues(event.WebhookDeviceType()).Inc()
+	if !known {
+		return
+	}
+
+	switch ev := event.(type) {
+	case *switchbot.MotionSensorEvent:
+		if ev.Context.DetectionState == "DETECTED" {
+			e.eventMetrics.motionDetections.WithLabelValues(id, name).Inc()
+		}
+	case *switchbot.ContactSensorEvent:
+		if strings.EqualFold(ev.Context.OpenState, string(switchbot.ContactOpen)) {
+			e.eventMetrics.contactOpen.WithLabelValues(id, name).Inc()
+		}
+	case *switchbot.LockEvent:
+		if strings.EqualFold(ev.Context.LockState, "jammed") {
+			e.eventMetrics.lockJammed.WithLabelValues(id, name).Inc()
+		}
+	case *switchbot.KeypadEvent:
+		if ev.Context.Result != "" {
+			e.eventMetrics.keypadResults.WithLabelValues(id, name, ev.Context.Result).Inc()
+		}
+	}
+}
+
+func copyTimes(m map[string]time.Time) map[string]time.Time {
+	ret := make(map[string]time.Time, len(m)+1)
+	for k, v := range m {
+		ret[k] = v
+	}
+
+	return ret
+}
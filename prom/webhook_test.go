@@ -0,0 +1,100 @@
+package prom_test
+
+import (
+	"context"
+	"fmt"
+	"github.com/google/go-cmp/cmp"
+	"github.com/nasa9084/go-switchbot/v3/prom"
+	"github.com/nasa9084/go-switchbot/v3/switchbot"
+	"github.com/prometheus/client_golang/prometheus"
+	"testing"
+	"time"
+)
+
+func mustParseWebhook(t *testing.T, payload string) switchbot.Event {
+	t.Helper()
+
+	event, err := switchbot.ParseWebhookPayload([]byte(payload))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return event
+}
+
+func TestExporterApplyEvent(t *testing.T) {
+	client := &fakeClient{
+		devices: []switchbot.Device{
+			{ID: "01005E901000", Name: "Door", Type: switchbot.Lock},
+			{ID: "01005E901001", Name: "Hall", Type: switchbot.MotionSensor},
+			{ID: "01005E901003", Name: "Test Motion", Type: switchbot.MotionSensor},
+		},
+		status: map[string]switchbot.DeviceStatus{
+			"01005E901000": {ID: "01005E901000", Type: switchbot.Lock, LockState: "locked", DoorState: "closed", Battery: 90},
+			"01005E901001": {ID: "01005E901001", Type: switchbot.MotionSensor, Battery: 80},
+		},
+	}
+	config, err := prom.ParseConfig([]byte(`exclude: [{name: "^Test "}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := prom.NewExporter(client, prom.WithConfig(config), prom.WithPushedPollInterval(time.Hour))
+
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(e)
+
+	if err := e.Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now().Add(time.Second).UnixMilli()
+	e.ApplyEvent(mustParseWebhook(t, fmt.Sprintf(`{"eventType":"changeReport","eventVersion":"1","context":{"deviceType":"WoLock","deviceMac":"01:00:5e:90:10:00","lockState":"JAMMED","timeOfSample":%d}}`, now)))
+	for i := 0; i < 3; i++ {
+		e.ApplyEvent(mustParseWebhook(t, fmt.Sprintf(`{"eventType":"changeReport","eventVersion":"1","context":{"deviceType":"WoPresence","deviceMac":"01:00:5e:90:10:01","detectionState":"DETECTED","timeOfSample":%d}}`, now+int64(i))))
+	}
+	// the events of unknown devices are only counted by the device type
+	e.ApplyEvent(mustParseWebhook(t, fmt.Sprintf(`{"eventType":"changeReport","eventVersion":"1","context":{"deviceType":"WoPresence","deviceMac":"01:00:5e:90:10:02","detectionState":"DETECTED","timeOfSample":%d}}`, now)))
+	// the events of excluded devices are dropped
+	e.ApplyEvent(mustParseWebhook(t, fmt.Sprintf(`{"eventType":"changeReport","eventVersion":"1","context":{"deviceType":"WoPresence","deviceMac":"01:00:5e:90:10:03","detectionState":"DETECTED","timeOfSample":%d}}`, now)))
+	// the events sampled before the last update are counted but do not
+	// overwrite the status
+	e.ApplyEvent(mustParseWebhook(t, `{"eventType":"changeReport","eventVersion":"1","context":{"deviceType":"WoLock","deviceMac":"01:00:5e:90:10:00","lockState":"UNLOCKED","timeOfSample":123456789}}`))
+
+	jammed := map[string]float64{
+		"id=01005E901000,name=Door,state=locked":   0,
+		"id=01005E901000,name=Door,state=unlocked": 0,
+		"id=01005E901000,name=Door,state=jammed":   1,
+	}
+	if diff := cmp.Diff(jammed, gather(t, reg, "switchbot_device_lock_state")); diff != "" {
+		t.Errorf("lock state mismatch (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff(map[string]float64{"id=01005E901000,name=Door": 1}, gather(t, reg, "switchbot_lock_jammed_total")); diff != "" {
+		t.Errorf("lock jammed mismatch (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff(map[string]float64{"id=01005E901001,name=Hall": 3}, gather(t, reg, "switchbot_motion_detections_total")); diff != "" {
+		t.Errorf("motion detections mismatch (-want +got):\n%s", diff)
+	}
+	motion := map[string]float64{"id=01005E901001,name=Hall,state=detected": 1, "id=01005E901001,name=Hall,state=not_detected": 0}
+	if diff := cmp.Diff(motion, gather(t, reg, "switchbot_device_motion_state")); diff != "" {
+		t.Errorf("motion state mismatch (-want +got):\n%s", diff)
+	}
+	// the battery level is kept from the status
+	if diff := cmp.Diff(map[string]float64{"id=01005E901000,name=Door": 90, "id=01005E901001,name=Hall": 80}, gather(t, reg, "switchbot_device_battery")); diff != "" {
+		t.Errorf("battery mismatch (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff(map[string]float64{"device_type=WoLock": 2, "device_type=WoPresence": 4}, gather(t, reg, "switchbot_webhook_events_total")); diff != "" {
+		t.Errorf("webhook events mismatch (-want +got):\n%s", diff)
+	}
+
+	// the devices which push events are not polled again within the pushed poll interval
+	client.polled = nil
+	if err := e.Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(client.polled) != 0 {
+		t.Errorf("pushing devices are polled: %v", client.polled)
+	}
+	if diff := cmp.Diff(jammed, gather(t, reg, "switchbot_device_lock_state")); diff != "" {
+		t.Errorf("lock state mismatch after poll (-want +got):\n%s", diff)
+	}
+}
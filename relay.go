@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/nasa9084/go-switchbot/v3/switchbot"
+	"github.com/rs/zerolog/log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+type relayCmd struct {
+	ListenAddress string   `env:"RELAY_LISTEN_ADDRESS" help:"${env} - Address to listen on for webhook requests" default:":8080"`
+	Path          string   `env:"RELAY_PATH" help:"${env} - Path under which to receive webhook requests" default:"/webhook"`
+	Targets       []string `name:"target" env:"RELAY_TARGETS" help:"${env} - Downstream URL to relay all events to, can be repeated"`
+	Config        string   `env:"RELAY_CONFIG" help:"${env} - JSON file describing the relay targets" type:"existingfile"`
+	QueueDir      string   `env:"RELAY_QUEUE_DIR" help:"${env} - Directory to persist the retry queues in, queues are held in memory if empty"`
+	QueueSize     int      `env:"RELAY_QUEUE_SIZE" help:"${env} - Number of events queued for each target" default:"1000"`
+	WebhookToken  string   `env:"SWITCHBOT_WEBHOOK_TOKEN" help:"${env} - Token required in the token query parameter of webhook requests"`
+}
+
+// relayConfig is the format of the file given with --config.
+type relayConfig struct {
+	Targets []relayTargetConfig `json:"targets"`
+}
+
+type relayTargetConfig struct {
+	Name        string            `json:"name"`
+	URL         string            `json:"url"`
+	Headers     map[string]string `json:"headers"`
+	DeviceTypes []string          `json:"deviceTypes"`
+	// Transform is the name of the payload transformation, one of "none",
+	// "context" and "cloudevents".
+	Transform string `json:"transform"`
+	Timeout   string `json:"timeout"`
+	// MaxRetries is the number of retries, the default if 0 or omitted and
+	// forever if negative.
+	MaxRetries int    `json:"maxRetries"`
+	Backoff    string `json:"backoff"`
+	MaxBackoff string `json:"maxBackoff"`
+}
+
+var relayTransforms = map[string]switchbot.RelayTransform{
+	"":            nil,
+	"none":        nil,
+	"context":     switchbot.ContextOnlyTransform,
+	"cloudevents": switchbot.CloudEventsTransform,
+}
+
+func (c relayTargetConfig) target() (switchbot.RelayTarget, error) {
+	transform, ok := relayTransforms[c.Transform]
+	if !ok {
+		return switchbot.RelayTarget{}, fmt.Errorf("unknown transform: %s", c.Transform)
+	}
+
+	target := switchbot.RelayTarget{
+		Name:        c.Name,
+		URL:         c.URL,
+		DeviceTypes: c.DeviceTypes,
+		Transform:   transform,
+		MaxRetries:  c.MaxRetries,
+	}
+
+	target.Header = http.Header{}
+	for k, v := range c.Headers {
+		target.Header.Set(k, v)
+	}
+	if c.Transform == "cloudevents" && target.Header.Get("Content-Type") == "" {
+		target.Header.Set("Content-Type", switchbot.CloudEventsContentType)
+	}
+
+	for _, d := range []struct {
+		value string
+		dst   *time.Duration
+	}{
+		{c.Timeout, &target.Timeout},
+		{c.Backoff, &target.Backoff},
+		{c.MaxBackoff, &target.MaxBackoff},
+	} {
+		if d.value == "" {
+			continue
+		}
+
+		v, err := time.ParseDuration(d.value)
+		if err != nil {
+			return switchbot.RelayTarget{}, err
+		}
+		*d.dst = v
+	}
+
+	return target, nil
+}
+
+func (cmd *relayCmd) targets() ([]switchbot.RelayTarget, error) {
+	var targets []switchbot.RelayTarget
+	for _, u := range cmd.Targets {
+		targets = append(targets, switchbot.RelayTarget{URL: u})
+	}
+
+	if cmd.Config != "" {
+		b, err := os.ReadFile(cmd.Config)
+		if err != nil {
+			return nil, err
+		}
+
+		var config relayConfig
+		if err := json.Unmarshal(b, &config); err != nil {
+			return nil, fmt.Errorf("parsing %s: %w", cmd.Config, err)
+		}
+
+		for _, c := range config.Targets {
+			target, err := c.target()
+			if err != nil {
+				return nil, fmt.Errorf("relay target %s: %w", c.URL, err)
+			}
+			targets = append(targets, target)
+		}
+	}
+
+	return targets, nil
+}
+
+func (cmd *relayCmd) Run() error {
+	targets, err := cmd.targets()
+	if err != nil {
+		return err
+	}
+
+	relay, err := switchbot.NewRelay(targets,
+		switchbot.WithRelayQueueDir(cmd.QueueDir),
+		switchbot.WithRelayQueueSize(cmd.QueueSize),
+		switchbot.WithRelayErrorHandler(func(err error) {
+			log.Warn().Err(err).Msg("⚠️ failed to relay webhook event")
+		}),
+	)
+	if err != nil {
+		return err
+	}
+	defer relay.Close()
+
+	var h http.Handler = relay
+	if cmd.WebhookToken != "" {
+		auth, err := switchbot.NewWebhookAuth(cmd.WebhookToken)
+		if err != nil {
+			return err
+		}
+		h = auth.Middleware(h)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(cmd.Path, h)
+
+	srv := &http.Server{
+		Addr:              cmd.ListenAddress,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	go func() {
+		<-ctx.Done()
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(shutdownCtx)
+	}()
+
+	log.Info().Msgf("⚡ Relaying webhook events received on %s%s to %d targets", cmd.ListenAddress, cmd.Path, len(targets))
+
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"github.com/rs/zerolog/log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// serverFlags are the flags of the HTTP server of the exporter.
+type serverFlags struct {
+	TLSCertFile       string        `env:"EXPORTER_TLS_CERT_FILE" help:"${env} - TLS certificate file, served over plain HTTP if empty" type:"existingfile"`
+	TLSKeyFile        string        `env:"EXPORTER_TLS_KEY_FILE" help:"${env} - TLS private key file" type:"existingfile"`
+	TLSClientCAFile   string        `env:"EXPORTER_TLS_CLIENT_CA_FILE" help:"${env} - CA certificates file to verify client certificates with, client certificates are not required if empty" type:"existingfile"`
+	BasicAuthUsername string        `env:"EXPORTER_BASIC_AUTH_USERNAME" help:"${env} - Username of the basic authentication required for metrics"`
+	BasicAuthPassword string        `env:"EXPORTER_BASIC_AUTH_PASSWORD" help:"${env} - Password of the basic authentication required for metrics"`
+	BearerToken       string        `env:"EXPORTER_BEARER_TOKEN" help:"${env} - Bearer token required for metrics, accepted as well as the basic authentication"`
+	ReadTimeout       time.Duration `env:"EXPORTER_READ_TIMEOUT" help:"${env} - Maximum duration for reading an entire request" default:"30s"`
+	WriteTimeout      time.Duration `env:"EXPORTER_WRITE_TIMEOUT" help:"${env} - Maximum duration before timing out writes of a response" default:"2m"`
+	IdleTimeout       time.Duration `env:"EXPORTER_IDLE_TIMEOUT" help:"${env} - Maximum duration to wait for the next request on a keep-alive connection" default:"2m"`
+	ShutdownTimeout   time.Duration `env:"EXPORTER_SHUTDOWN_TIMEOUT" help:"${env} - Maximum duration to wait for in-flight requests on shutdown" default:"30s"`
+}
+
+func (f *serverFlags) validate() error {
+	if (f.TLSCertFile == "") != (f.TLSKeyFile == "") {
+		return errors.New("both of the TLS certificate and key files are required")
+	}
+	if f.TLSClientCAFile != "" && f.TLSCertFile == "" {
+		return errors.New("the TLS certificate is required to verify client certificates")
+	}
+	if (f.BasicAuthUsername == "") != (f.BasicAuthPassword == "") {
+		return errors.New("both of the basic authentication username and password are required")
+	}
+
+	return nil
+}
+
+// tlsConfig returns the TLS config of the server, nil if TLS is disabled.
+func (f *serverFlags) tlsConfig() (*tls.Config, error) {
+	if f.TLSCertFile == "" {
+		return nil, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(f.TLSCertFile, f.TLSKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("loading TLS certificate: %w", err)
+	}
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if f.TLSClientCAFile != "" {
+		pem, err := os.ReadFile(f.TLSClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in %s", f.TLSClientCAFile)
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return config, nil
+}
+
+// protect returns the handler which requires the basic authentication or the
+// bearer token, if either is set, before calling h.
+func (f *serverFlags) protect(h http.Handler) http.Handler {
+	if f.BasicAuthUsername == "" && f.BearerToken == "" {
+		return h
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if f.authorized(r) {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		if f.BasicAuthUsername != "" {
+			w.Header().Set("WWW-Authenticate", `Basic realm="switchbot-exporter"`)
+		} else {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+		}
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+	})
+}
+
+func (f *serverFlags) authorized(r *http.Request) bool {
+	if f.BasicAuthUsername != "" {
+		if username, password, ok := r.BasicAuth(); ok {
+			// both are compared so that the time taken does not tell which is wrong
+			userOK := subtle.ConstantTimeCompare([]byte(username), []byte(f.BasicAuthUsername)) == 1
+			passwordOK := subtle.ConstantTimeCompare([]byte(password), []byte(f.BasicAuthPassword)) == 1
+			if userOK && passwordOK {
+				return true
+			}
+		}
+	}
+
+	if f.BearerToken != "" {
+		auth := r.Header.Get("Authorization")
+		if len(auth) > len("Bearer ") && strings.EqualFold(auth[:len("Bearer ")], "Bearer ") {
+			return subtle.ConstantTimeCompare([]byte(auth[len("Bearer "):]), []byte(f.BearerToken)) == 1
+		}
+	}
+
+	return false
+}
+
+// serve serves h on addr until ctx is done, and then shuts the server down
+// waiting for in-flight requests up to the shutdown timeout.
+func (f *serverFlags) serve(ctx context.Context, addr string, h http.Handler) error {
+	tlsConfig, err := f.tlsConfig()
+	if err != nil {
+		return err
+	}
+
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           h,
+		TLSConfig:         tlsConfig,
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       f.ReadTimeout,
+		WriteTimeout:      f.WriteTimeout,
+		IdleTimeout:       f.IdleTimeout,
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		<-ctx.Done()
+		log.Info().Msg("🛑 Shutting down HTTP server")
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), f.ShutdownTimeout)
+		defer cancel()
+		done <- srv.Shutdown(shutdownCtx)
+	}()
+
+	if tlsConfig != nil {
+		// the certificate is already loaded in the TLS config
+		err = srv.ListenAndServeTLS("", "")
+	} else {
+		err = srv.ListenAndServe()
+	}
+	if !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+
+	return <-done
+}
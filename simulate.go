@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/nasa9084/go-switchbot/v3/switchbot/webhooktest"
+	"github.com/rs/zerolog/log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+type simulateCmd struct {
+	URL         string   `help:"URL to POST the simulated webhook events to" default:"http://127.0.0.1:8080/webhook"`
+	Scenario    string   `help:"Name of the scenario to play instead of random events, see --list"`
+	DeviceTypes []string `name:"device-type" help:"Webhook device types to generate random events for, all types if empty"`
+	Devices     int      `help:"Number of simulated devices for each device type" default:"1"`
+	Rate        float64  `help:"Number of random events sent per second" default:"1"`
+	Count       int      `help:"Number of random events or scenario plays, unlimited if zero" default:"0"`
+	Speed       float64  `help:"Speed of scenario plays, delays are skipped if zero" default:"1"`
+	Concurrency int      `help:"Number of concurrent requests" default:"4"`
+	Seed        int64    `help:"Seed of random values, random if zero"`
+	List        bool     `help:"List the supported device types and scenarios and exit"`
+}
+
+func (cmd *simulateCmd) Run() error {
+	if cmd.List {
+		fmt.Println("Device types:")
+		for _, typ := range webhooktest.SimulatedDeviceTypes() {
+			fmt.Printf("  %s\n", typ)
+		}
+
+		scenarios := webhooktest.Scenarios()
+		names := make([]string, 0, len(scenarios))
+		for name := range scenarios {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		fmt.Println("Scenarios:")
+		for _, name := range names {
+			fmt.Printf("  %s\t%s\n", name, scenarios[name].Description)
+		}
+
+		return nil
+	}
+
+	var opts []webhooktest.SimulatorOption
+	if cmd.Seed != 0 {
+		opts = append(opts, webhooktest.WithSimulatorSeed(cmd.Seed))
+	}
+	sim := webhooktest.NewSimulator(opts...)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var sent, failed int64
+	send := func(payload []byte) {
+		if err := webhooktest.PostWebhookPayload(ctx, http.DefaultClient, cmd.URL, payload); err != nil {
+			if ctx.Err() == nil {
+				atomic.AddInt64(&failed, 1)
+				log.Warn().Err(err).Msg("⚠️ failed to send simulated webhook event")
+			}
+			return
+		}
+		atomic.AddInt64(&sent, 1)
+	}
+
+	started := time.Now()
+
+	var err error
+	if cmd.Scenario != "" {
+		err = cmd.playScenario(ctx, sim, send)
+	} else {
+		err = cmd.generate(ctx, sim, send)
+	}
+
+	log.Info().Msgf("📨 Sent %d events (%d failed) in %s", sent, failed, time.Since(started).Round(time.Millisecond))
+
+	if errors.Is(err, context.Canceled) {
+		return nil
+	}
+
+	return err
+}
+
+func (cmd *simulateCmd) playScenario(ctx context.Context, sim *webhooktest.Simulator, send func([]byte)) error {
+	scenario, ok := webhooktest.Scenarios()[cmd.Scenario]
+	if !ok {
+		return fmt.Errorf("unknown scenario: %s", cmd.Scenario)
+	}
+
+	for i := 0; cmd.Count == 0 || i < cmd.Count; i++ {
+		err := sim.Play(ctx, scenario, cmd.Speed, func(payload []byte) error {
+			// scenario steps are sent in order
+			send(payload)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (cmd *simulateCmd) generate(ctx context.Context, sim *webhooktest.Simulator, send func([]byte)) error {
+	deviceTypes := cmd.DeviceTypes
+	if len(deviceTypes) == 0 {
+		deviceTypes = webhooktest.SimulatedDeviceTypes()
+	}
+
+	type device struct {
+		deviceType string
+		mac        string
+	}
+	var devices []device
+	for _, typ := range deviceTypes {
+		for i := 0; i < cmd.Devices; i++ {
+			devices = append(devices, device{deviceType: typ, mac: webhooktest.SimulatedMAC(len(devices))})
+		}
+	}
+	if len(devices) == 0 {
+		return errors.New("no devices to simulate")
+	}
+
+	if cmd.Rate <= 0 {
+		return errors.New("rate must be positive")
+	}
+
+	payloads := make(chan []byte)
+	var wg sync.WaitGroup
+	for i := 0; i < cmd.Concurrency || i == 0; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for payload := range payloads {
+				send(payload)
+			}
+		}()
+	}
+	defer wg.Wait()
+	defer close(payloads)
+
+	ticker := time.NewTicker(time.Duration(float64(time.Second) / cmd.Rate))
+	defer ticker.Stop()
+
+	for i := 0; cmd.Count == 0 || i < cmd.Count; i++ {
+		d := devices[i%len(devices)]
+		payload, err := sim.Payload(d.deviceType, d.mac)
+		if err != nil {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case payloads <- payload:
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,297 @@
+package switchbot
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"time"
+)
+
+const (
+	// CloudEventsSpecVersion is the version of the CloudEvents specification
+	// implemented by this package.
+	CloudEventsSpecVersion = "1.0"
+	// CloudEventsContentType is the content type of CloudEvents encoded in
+	// the structured content mode.
+	CloudEventsContentType = "application/cloudevents+json"
+	// DefaultCloudEventSourcePrefix is the default prefix of the source
+	// attribute of CloudEvents, which is followed by the device ID.
+	DefaultCloudEventSourcePrefix = "/switchbot/devices/"
+)
+
+// ErrInvalidCloudEvent is returned when a CloudEvent lacks required attributes.
+var ErrInvalidCloudEvent = errors.New("invalid cloudevent")
+
+// cloudEventCategories maps the deviceType values of webhook events to the
+// device categories used in the type attribute of CloudEvents.
+var cloudEventCategories = map[string]string{
+	"WoPresence":       "motion_sensor",
+	"WoContact":        "contact_sensor",
+	"WoLock":           "lock",
+	"WoLockPro":        "lock",
+	"WoCamera":         "indoor_camera",
+	"WoPanTiltCam":     "pan_tilt_camera",
+	"WoBulb":           "color_bulb",
+	"WoStrip":          "strip_light",
+	"WoPlugUS":         "plug_mini",
+	"WoPlugJP":         "plug_mini",
+	"WoMeter":          "meter",
+	"WoMeterPlus":      "meter",
+	"WoIOSensor":       "meter",
+	"WoMeterPro":       "meter",
+	"WoMeterProCO2":    "meter",
+	"WoSweeper":        "robot_vacuum",
+	"WoSweeperPlus":    "robot_vacuum",
+	"WoSweeperMini":    "robot_vacuum",
+	"WoSweeperMiniPro": "robot_vacuum",
+	"WoCeiling":        "ceiling_light",
+	"WoCeilingPro":     "ceiling_light",
+	"WoKeypad":         "keypad",
+	"WoKeypadTouch":    "keypad",
+	"WoHand":           "bot",
+	"WoCurtain":        "curtain",
+	"WoCurtain3":       "curtain",
+	"WoBlindTilt":      "blind_tilt",
+	"WoHub2":           "hub",
+	"WoHumi":           "humidifier",
+	"WoSmartFan":       "fan",
+	"WoFan2":           "fan",
+}
+
+// CloudEventType returns the type attribute of CloudEvents for the given
+// webhook deviceType, e.g. com.switchbot.lock.state_changed for WoLock.
+// Unknown device types are mapped to com.switchbot.device.state_changed.
+func CloudEventType(webhookDeviceType string) string {
+	category, ok := cloudEventCategories[webhookDeviceType]
+	if !ok {
+		category = "device"
+	}
+
+	return "com.switchbot." + category + ".state_changed"
+}
+
+// CloudEvent is a CloudEvents 1.0 event carrying a webhook event.
+// The data is the webhook payload sent by SwitchBot.
+type CloudEvent struct {
+	SpecVersion     string          `json:"specversion"`
+	ID              string          `json:"id"`
+	Source          string          `json:"source"`
+	Type            string          `json:"type"`
+	Subject         string          `json:"subject,omitempty"`
+	Time            time.Time       `json:"time"`
+	DataContentType string          `json:"datacontenttype,omitempty"`
+	Data            json.RawMessage `json:"data,omitempty"`
+}
+
+type cloudEventConfig struct {
+	sourcePrefix string
+	useMAC       bool
+}
+
+// CloudEventOption configures the conversion into CloudEvents.
+type CloudEventOption func(*cloudEventConfig)
+
+// WithCloudEventSourcePrefix sets the prefix of the source attribute.
+func WithCloudEventSourcePrefix(prefix string) CloudEventOption {
+	return func(c *cloudEventConfig) {
+		c.sourcePrefix = prefix
+	}
+}
+
+// WithCloudEventSourceMAC makes the source attribute derived from the MAC
+// address of the device instead of the device ID.
+func WithCloudEventSourceMAC() CloudEventOption {
+	return func(c *cloudEventConfig) {
+		c.useMAC = true
+	}
+}
+
+// NewCloudEvent converts the given webhook event into a CloudEvent.
+// The id attribute is derived from the device, the time of sample and the
+// payload, so the same webhook event always has the same id.
+func NewCloudEvent(event Event, opts ...CloudEventOption) (*CloudEvent, error) {
+	config := cloudEventConfig{
+		sourcePrefix: DefaultCloudEventSourcePrefix,
+	}
+
+	for _, opt := range opts {
+		opt(&config)
+	}
+
+	data, err := MarshalWebhookEvent(event)
+	if err != nil {
+		return nil, err
+	}
+
+	source := config.sourcePrefix + event.DeviceID()
+	if config.useMAC {
+		source = config.sourcePrefix + event.DeviceMAC()
+	}
+
+	digest := eventDigest(event)
+
+	return &CloudEvent{
+		SpecVersion:     CloudEventsSpecVersion,
+		ID:              fmt.Sprintf("%s-%d-%s", event.DeviceID(), event.Time().UnixMilli(), hex.EncodeToString(digest[:8])),
+		Source:          source,
+		Type:            CloudEventType(event.WebhookDeviceType()),
+		Subject:         event.DeviceID(),
+		Time:            event.Time().UTC(),
+		DataContentType: "application/json",
+		Data:            data,
+	}, nil
+}
+
+// MarshalCloudEvent encodes the given webhook event into a CloudEvent in
+// the structured content mode.
+func MarshalCloudEvent(event Event, opts ...CloudEventOption) ([]byte, error) {
+	ce, err := NewCloudEvent(event, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(ce)
+}
+
+// UnmarshalCloudEvent decodes a CloudEvent in the structured content mode
+// and returns the webhook event carried by it.
+func UnmarshalCloudEvent(data []byte, opts ...ParseOption) (Event, error) {
+	var ce CloudEvent
+	if err := json.Unmarshal(data, &ce); err != nil {
+		return nil, err
+	}
+
+	return ce.WebhookEvent(opts...)
+}
+
+func (ce *CloudEvent) validate() error {
+	switch {
+	case ce.SpecVersion != CloudEventsSpecVersion:
+		return fmt.Errorf("%w: unsupported specversion: %q", ErrInvalidCloudEvent, ce.SpecVersion)
+	case ce.ID == "":
+		return fmt.Errorf("%w: id is required", ErrInvalidCloudEvent)
+	case ce.Source == "":
+		return fmt.Errorf("%w: source is required", ErrInvalidCloudEvent)
+	case ce.Type == "":
+		return fmt.Errorf("%w: type is required", ErrInvalidCloudEvent)
+	}
+
+	return nil
+}
+
+// WebhookEvent returns the webhook event carried by the CloudEvent.
+func (ce *CloudEvent) WebhookEvent(opts ...ParseOption) (Event, error) {
+	if err := ce.validate(); err != nil {
+		return nil, err
+	}
+
+	if len(ce.Data) == 0 {
+		return nil, fmt.Errorf("%w: data is empty", ErrInvalidCloudEvent)
+	}
+
+	return ParseWebhookPayload(ce.Data, opts...)
+}
+
+// NewCloudEventRequest returns a new POST request carrying the CloudEvent.
+// If binary is true, the request is encoded in the HTTP binary content mode,
+// where the attributes are carried in ce- headers and the body is the data.
+// Otherwise the request is encoded in the structured content mode.
+func NewCloudEventRequest(ctx context.Context, url string, ce *CloudEvent, binary bool) (*http.Request, error) {
+	if !binary {
+		b, err := json.Marshal(ce)
+		if err != nil {
+			return nil, err
+		}
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(b))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", CloudEventsContentType)
+
+		return req, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(ce.Data))
+	if err != nil {
+		return nil, err
+	}
+
+	h := req.Header
+	h.Set("ce-specversion", ce.SpecVersion)
+	h.Set("ce-id", ce.ID)
+	h.Set("ce-source", ce.Source)
+	h.Set("ce-type", ce.Type)
+	if ce.Subject != "" {
+		h.Set("ce-subject", ce.Subject)
+	}
+	if !ce.Time.IsZero() {
+		h.Set("ce-time", ce.Time.Format(time.RFC3339Nano))
+	}
+	if ce.DataContentType != "" {
+		h.Set("Content-Type", ce.DataContentType)
+	}
+
+	return req, nil
+}
+
+// ParseCloudEventRequest decodes a CloudEvent from the given request in
+// either the structured or the binary content mode.
+func ParseCloudEventRequest(r *http.Request) (*CloudEvent, error) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == CloudEventsContentType {
+		var ce CloudEvent
+		if err := json.Unmarshal(body, &ce); err != nil {
+			return nil, err
+		}
+
+		if err := ce.validate(); err != nil {
+			return nil, err
+		}
+
+		return &ce, nil
+	}
+
+	h := r.Header
+	ce := CloudEvent{
+		SpecVersion:     h.Get("ce-specversion"),
+		ID:              h.Get("ce-id"),
+		Source:          h.Get("ce-source"),
+		Type:            h.Get("ce-type"),
+		Subject:         h.Get("ce-subject"),
+		DataContentType: h.Get("Content-Type"),
+		Data:            body,
+	}
+
+	if v := h.Get("ce-time"); v != "" {
+		t, err := time.Parse(time.RFC3339Nano, v)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid time: %v", ErrInvalidCloudEvent, err)
+		}
+		ce.Time = t
+	}
+
+	if err := ce.validate(); err != nil {
+		return nil, err
+	}
+
+	return &ce, nil
+}
+
+// CloudEventsTransform is a RelayTransform which forwards webhook events as
+// CloudEvents in the structured content mode. The relay target should have
+// the Content-Type header set to CloudEventsContentType.
+func CloudEventsTransform(event Event, _ []byte) ([]byte, error) {
+	return MarshalCloudEvent(event)
+}
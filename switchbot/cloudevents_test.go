@@ -0,0 +1,137 @@
+package switchbot_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/google/go-cmp/cmp"
+	switchbot2 "github.com/nasa9084/go-switchbot/v3/switchbot"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCloudEvent(t *testing.T) {
+	event, err := switchbot2.ParseWebhookPayload([]byte(`{"eventType":"changeReport","eventVersion":"1","context":{"deviceType":"WoLock","deviceMac":"01:00:5e:90:10:00","lockState":"LOCKED","doorState":"closed","timeOfSample":123456789}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ce, err := switchbot2.NewCloudEvent(event)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ce.SpecVersion != "1.0" {
+		t.Errorf("unexpected specversion: %s", ce.SpecVersion)
+	}
+	if ce.Type != "com.switchbot.lock.state_changed" {
+		t.Errorf("unexpected type: %s", ce.Type)
+	}
+	if ce.Source != "/switchbot/devices/01005E901000" {
+		t.Errorf("unexpected source: %s", ce.Source)
+	}
+	if ce.Subject != "01005E901000" {
+		t.Errorf("unexpected subject: %s", ce.Subject)
+	}
+	if want := time.UnixMilli(123456789).UTC(); !ce.Time.Equal(want) {
+		t.Errorf("unexpected time: %s", ce.Time)
+	}
+
+	again, _ := switchbot2.NewCloudEvent(event)
+	if ce.ID == "" || ce.ID != again.ID {
+		t.Errorf("id is expected to be stable but %q and %q", ce.ID, again.ID)
+	}
+
+	mac, _ := switchbot2.NewCloudEvent(event, switchbot2.WithCloudEventSourceMAC(), switchbot2.WithCloudEventSourcePrefix("switchbot:"))
+	if mac.Source != "switchbot:01:00:5e:90:10:00" {
+		t.Errorf("unexpected source: %s", mac.Source)
+	}
+
+	t.Run("structured mode", func(t *testing.T) {
+		b, err := switchbot2.MarshalCloudEvent(event)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var attrs map[string]interface{}
+		if err := json.Unmarshal(b, &attrs); err != nil {
+			t.Fatal(err)
+		}
+		if attrs["time"] != "1970-01-02T10:17:36.789Z" {
+			t.Errorf("unexpected time attribute: %v", attrs["time"])
+		}
+
+		got, err := switchbot2.UnmarshalCloudEvent(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// extra fields survive the round trip
+		if diff := cmp.Diff(event, got); diff != "" {
+			t.Errorf("event mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("binary mode", func(t *testing.T) {
+		for _, binary := range []bool{true, false} {
+			var got *switchbot2.CloudEvent
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				var err error
+				got, err = switchbot2.ParseCloudEventRequest(r)
+				if err != nil {
+					t.Error(err)
+				}
+			}))
+
+			req, err := switchbot2.NewCloudEventRequest(context.Background(), srv.URL, ce, binary)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if binary && req.Header.Get("ce-type") != ce.Type {
+				t.Errorf("ce-type header is expected in binary mode: %v", req.Header)
+			}
+
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			srv.Close()
+
+			if diff := cmp.Diff(ce, got); diff != "" {
+				t.Errorf("cloudevent mismatch in binary=%t (-want +got):\n%s", binary, diff)
+			}
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		_, err := switchbot2.UnmarshalCloudEvent([]byte(`{"specversion":"0.3","id":"1","source":"/","type":"t","data":{}}`))
+		if !errors.Is(err, switchbot2.ErrInvalidCloudEvent) {
+			t.Errorf("ErrInvalidCloudEvent is expected but %v", err)
+		}
+	})
+}
+
+func TestCloudEventType(t *testing.T) {
+	tests := []struct {
+		deviceType string
+		want       string
+	}{
+		{"WoLock", "com.switchbot.lock.state_changed"},
+		{"WoLockPro", "com.switchbot.lock.state_changed"},
+		{"WoPresence", "com.switchbot.motion_sensor.state_changed"},
+		{"WoMeterPlus", "com.switchbot.meter.state_changed"},
+		{"WoNewDevice", "com.switchbot.device.state_changed"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.deviceType, func(t *testing.T) {
+			if got := switchbot2.CloudEventType(tt.deviceType); got != tt.want {
+				t.Errorf("CloudEventType(%s) = %s, want %s", tt.deviceType, got, tt.want)
+			}
+		})
+	}
+}
@@ -10,6 +10,47 @@ import (
 	"time"
 )
 
+var (
+	// ErrDeviceOffline is returned when the device is offline (status code 161).
+	ErrDeviceOffline = errors.New("device is offline")
+	// ErrHubOffline is returned when the hub of the device is offline (status code 171).
+	ErrHubOffline = errors.New("hub device is offline")
+	// ErrDeviceInternal is returned when the device has an internal error,
+	// usually because the device states are not synchronized with the
+	// server or the request limit is reached (status code 190).
+	ErrDeviceInternal = errors.New("device internal error")
+)
+
+// StatusError is an error response of the SwitchBot API other than the ones
+// of the sentinel errors above.
+type StatusError struct {
+	// StatusCode is the status code in the response body, e.g. 152.
+	StatusCode int
+	Message    string
+}
+
+func (e *StatusError) Error() string {
+	return e.Message
+}
+
+// StatusCode returns the status code of the SwitchBot API which caused the
+// error, or 0 if the error is not caused by an error response of the API.
+func StatusCode(err error) int {
+	var statusErr *StatusError
+	switch {
+	case errors.As(err, &statusErr):
+		return statusErr.StatusCode
+	case errors.Is(err, ErrDeviceOffline):
+		return 161
+	case errors.Is(err, ErrHubOffline):
+		return 171
+	case errors.Is(err, ErrDeviceInternal):
+		return 190
+	}
+
+	return 0
+}
+
 // DeviceService handles API calls related to devices.
 // The devices API is used to access the properties and states of
 // SwitchBot devices and to send control commands to those devices.
@@ -115,9 +156,9 @@ func (svc *DeviceService) List(ctx context.Context) ([]Device, []InfraredDevice,
 	}
 
 	if response.StatusCode == 190 {
-		return nil, nil, errors.New("device internal error due to device states not synchronized with server or too many requests limit reached")
+		return nil, nil, fmt.Errorf("%w due to device states not synchronized with server or too many requests limit reached", ErrDeviceInternal)
 	} else if response.StatusCode != 100 {
-		return nil, nil, fmt.Errorf("unknown error %d from device list API", response.StatusCode)
+		return nil, nil, &StatusError{StatusCode: response.StatusCode, Message: fmt.Sprintf("unknown error %d from device list API", response.StatusCode)}
 	}
 
 	return response.Body.DeviceList, response.Body.InfraredRemoteList, nil
@@ -167,6 +208,7 @@ type DeviceStatus struct {
 	Battery                int                  `json:"battery"`
 	Version                DeviceVersion        `json:"version"`
 	Direction              string               `json:"direction"`
+	CO2                    int                  `json:"CO2"`
 }
 
 type PowerState string
@@ -213,6 +255,18 @@ func (brightness *BrightnessState) UnmarshalJSON(b []byte) error {
 	return nil
 }
 
+func (brightness BrightnessState) MarshalJSON() ([]byte, error) {
+	if brightness.ambientBrightness != "" {
+		return json.Marshal(string(brightness.ambientBrightness))
+	}
+
+	if brightness.intBrightness < 0 {
+		return []byte("null"), nil
+	}
+
+	return json.Marshal(brightness.intBrightness)
+}
+
 func (brightness BrightnessState) Int() (int, error) {
 	if brightness.intBrightness < 0 {
 		return -1, errors.New("integer brightness value is only available for color bulb devices")
@@ -277,10 +331,16 @@ func (svc *DeviceService) Status(ctx context.Context, id string) (DeviceStatus,
 		return DeviceStatus{}, err
 	}
 
-	if response.StatusCode == 190 {
-		return DeviceStatus{}, errors.New("device internal error due to device states not synchronized with server")
-	} else if response.StatusCode != 100 {
-		return DeviceStatus{}, fmt.Errorf("unknown error %d from device list API", response.StatusCode)
+	switch response.StatusCode {
+	case 100:
+	case 161:
+		return DeviceStatus{}, ErrDeviceOffline
+	case 171:
+		return DeviceStatus{}, ErrHubOffline
+	case 190:
+		return DeviceStatus{}, fmt.Errorf("%w due to device states not synchronized with server", ErrDeviceInternal)
+	default:
+		return DeviceStatus{}, &StatusError{StatusCode: response.StatusCode, Message: fmt.Sprintf("unknown error %d from device list API", response.StatusCode)}
 	}
 
 	return response.Body, nil
@@ -318,17 +378,17 @@ func (svc *DeviceService) Command(ctx context.Context, id string, cmd Command) e
 
 	switch response.StatusCode {
 	case 151:
-		return errors.New("device type error")
+		return &StatusError{StatusCode: 151, Message: "device type error"}
 	case 152:
-		return errors.New("device not found")
+		return &StatusError{StatusCode: 152, Message: "device not found"}
 	case 160:
-		return errors.New("command is not supported")
+		return &StatusError{StatusCode: 160, Message: "command is not supported"}
 	case 161:
-		return errors.New("device is offline")
+		return ErrDeviceOffline
 	case 171:
-		return errors.New("hub device is offline")
+		return ErrHubOffline
 	case 190:
-		return errors.New("device internal error due to device states not synchronizeed with server or command format is invalid")
+		return fmt.Errorf("%w due to device states not synchronizeed with server or command format is invalid", ErrDeviceInternal)
 	}
 
 	return nil
@@ -198,6 +198,63 @@ func isSameStringErr(err1, err2 error) bool {
 	return err1.Error() == err2.Error()
 }
 
+func TestDeviceStatusError(t *testing.T) {
+	tests := []struct {
+		statusCode int
+		want       error
+	}{
+		{161, switchbot2.ErrDeviceOffline},
+		{171, switchbot2.ErrHubOffline},
+		{190, switchbot2.ErrDeviceInternal},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprint(tt.statusCode), func(t *testing.T) {
+			srv := httptest.NewServer(
+				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					w.WriteHeader(http.StatusOK)
+					fmt.Fprintf(w, `{"statusCode": %d, "body": {}, "message": ""}`, tt.statusCode)
+				}),
+			)
+			defer srv.Close()
+
+			c := switchbot2.New("", "", switchbot2.WithEndpoint(srv.URL))
+			_, err := c.Device().Status(context.Background(), "C271111EC0AB")
+			if !errors.Is(err, tt.want) {
+				t.Errorf("%v is expected but %v", tt.want, err)
+			}
+			if got := switchbot2.StatusCode(err); got != tt.statusCode {
+				t.Errorf("status code %d is expected but %d", tt.statusCode, got)
+			}
+		})
+	}
+}
+
+func TestDeviceCommandStatusCode(t *testing.T) {
+	for _, statusCode := range []int{100, 151, 152, 160, 161, 171, 190} {
+		t.Run(fmt.Sprint(statusCode), func(t *testing.T) {
+			srv := httptest.NewServer(
+				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					w.WriteHeader(http.StatusOK)
+					fmt.Fprintf(w, `{"statusCode": %d, "body": {}, "message": ""}`, statusCode)
+				}),
+			)
+			defer srv.Close()
+
+			c := switchbot2.New("", "", switchbot2.WithEndpoint(srv.URL))
+			err := c.Device().Command(context.Background(), "C271111EC0AB", switchbot2.TurnOnCommand())
+
+			want := statusCode
+			if statusCode == 100 {
+				want = 0
+			}
+			if got := switchbot2.StatusCode(err); got != want {
+				t.Errorf("status code %d is expected but %d: %v", want, got, err)
+			}
+		})
+	}
+}
+
 func TestDeviceStatusBrightness(t *testing.T) {
 	type wants struct {
 		IntValue int
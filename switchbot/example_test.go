@@ -6,7 +6,7 @@ import (
 	"github.com/nasa9084/go-switchbot/v3/switchbot"
 )
 
-func ExamplePrintPhysicalDevices() {
+func Example_printPhysicalDevices() {
 	const (
 		openToken = "blahblahblah"
 		secretKey = "blahblahblah"
@@ -0,0 +1,599 @@
+package switchbot
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultRelayQueueSize is the default number of events queued for each
+	// relay target. The oldest event which is not being delivered is
+	// discarded when the queue is full.
+	DefaultRelayQueueSize = 1000
+	// DefaultRelayTimeout is the default timeout of a request to a relay target.
+	DefaultRelayTimeout = 10 * time.Second
+	// DefaultRelayMaxRetries is the default number of retries of a delivery
+	// to a relay target.
+	DefaultRelayMaxRetries = 5
+	// DefaultRelayBackoff is the default initial interval between retries,
+	// which is doubled on every retry.
+	DefaultRelayBackoff = time.Second
+	// DefaultRelayMaxBackoff is the default upper limit of the interval
+	// between retries.
+	DefaultRelayMaxBackoff = 5 * time.Minute
+)
+
+// RelayTransform transforms the payload of a webhook event before it is
+// forwarded to a relay target. Returning a nil payload skips forwarding
+// the event.
+type RelayTransform func(event Event, body []byte) ([]byte, error)
+
+// ContextOnlyTransform is a RelayTransform which forwards only the context
+// object of the webhook event.
+func ContextOnlyTransform(event Event, body []byte) ([]byte, error) {
+	var payload struct {
+		Context json.RawMessage `json:"context"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, err
+	}
+
+	return payload.Context, nil
+}
+
+// RelayTarget is a downstream receiver of relayed webhook events.
+// Zero values are replaced with the defaults.
+type RelayTarget struct {
+	// Name identifies the target in errors and in the queue directory.
+	// The URL is used if empty.
+	Name string
+	URL  string
+	// Header is added to the requests to the target.
+	Header http.Header
+	// DeviceTypes selects the events forwarded to the target. Both webhook
+	// device types, e.g. WoLock, and physical device types, e.g. Smart Lock,
+	// are accepted. All events are forwarded if empty.
+	DeviceTypes []string
+	// Transform transforms the payload forwarded to the target.
+	// The original request body is forwarded if nil.
+	Transform RelayTransform
+	// Timeout is the timeout of each request to the target.
+	Timeout time.Duration
+	// MaxRetries is the number of retries before an event is discarded.
+	// Zero means DefaultRelayMaxRetries like the other fields, so every event
+	// is retried at least once, and negative values retry forever. Requests
+	// responded with 4xx status codes other than 408 and 429 are not retried.
+	MaxRetries int
+	// Backoff is the initial interval between retries, which is doubled on
+	// every retry up to MaxBackoff.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+}
+
+func (t RelayTarget) match(event Event) bool {
+	if len(t.DeviceTypes) == 0 {
+		return true
+	}
+
+	for _, typ := range t.DeviceTypes {
+		if typ == event.WebhookDeviceType() || PhysicalDeviceType(typ) == event.PhysicalType() {
+			return true
+		}
+	}
+
+	return false
+}
+
+// RelayError is reported to the error handler of a Relay when an event
+// could not be delivered to a target.
+type RelayError struct {
+	Target string
+	// Attempts is the number of delivery attempts made so far.
+	Attempts int
+	// Discarded reports whether the event is discarded.
+	Discarded bool
+	Err       error
+}
+
+func (e *RelayError) Error() string {
+	if e.Discarded {
+		return fmt.Sprintf("relay to %s: event is discarded after %d attempts: %v", e.Target, e.Attempts, e.Err)
+	}
+
+	return fmt.Sprintf("relay to %s: attempt %d failed: %v", e.Target, e.Attempts, e.Err)
+}
+
+func (e *RelayError) Unwrap() error {
+	return e.Err
+}
+
+// Relay receives webhook events once and forwards them to multiple
+// downstream receivers. Each target has its own bounded queue and delivers
+// the events in order, retrying failed deliveries with exponential backoff.
+// If a queue directory is configured, queued events are persisted so that
+// they survive restarts.
+type Relay struct {
+	client       *http.Client
+	queueDir     string
+	queueSize    int
+	maxBodySize  int64
+	errorHandler func(error)
+
+	targets []*relayTarget
+	wg      sync.WaitGroup
+	cancel  context.CancelFunc
+}
+
+// RelayOption configures a Relay.
+type RelayOption func(*Relay)
+
+// WithRelayHTTPClient sets the HTTP client used to forward events.
+func WithRelayHTTPClient(client *http.Client) RelayOption {
+	return func(r *Relay) {
+		r.client = client
+	}
+}
+
+// WithRelayQueueDir persists the queues in the given directory.
+// By default the queues are held in memory.
+func WithRelayQueueDir(dir string) RelayOption {
+	return func(r *Relay) {
+		r.queueDir = dir
+	}
+}
+
+// WithRelayQueueSize sets the number of events queued for each target.
+func WithRelayQueueSize(n int) RelayOption {
+	return func(r *Relay) {
+		r.queueSize = n
+	}
+}
+
+// WithRelayMaxBodySize sets the maximum size of webhook request bodies
+// accepted by ServeHTTP.
+func WithRelayMaxBodySize(n int64) RelayOption {
+	return func(r *Relay) {
+		r.maxBodySize = n
+	}
+}
+
+// WithRelayErrorHandler sets a function which is called with delivery errors,
+// which are typically *RelayError.
+func WithRelayErrorHandler(fn func(error)) RelayOption {
+	return func(r *Relay) {
+		r.errorHandler = fn
+	}
+}
+
+// NewRelay returns a new Relay forwarding events to the given targets and
+// starts delivering the events queued in the queue directory, if any.
+// Close must be called to stop the delivery.
+func NewRelay(targets []RelayTarget, opts ...RelayOption) (*Relay, error) {
+	r := &Relay{
+		client:      http.DefaultClient,
+		queueSize:   DefaultRelayQueueSize,
+		maxBodySize: DefaultWebhookMaxBodySize,
+	}
+
+	for _, opt := range opts {
+		opt(r)
+	}
+
+	if len(targets) == 0 {
+		return nil, errors.New("no relay targets are given")
+	}
+
+	names := map[string]bool{}
+	for _, t := range targets {
+		if _, err := url.ParseRequestURI(t.URL); err != nil {
+			return nil, fmt.Errorf("invalid relay target URL %q: %w", t.URL, err)
+		}
+
+		if t.Name == "" {
+			t.Name = t.URL
+		}
+		if names[t.Name] {
+			return nil, fmt.Errorf("duplicated relay target: %s", t.Name)
+		}
+		names[t.Name] = true
+
+		if t.Timeout <= 0 {
+			t.Timeout = DefaultRelayTimeout
+		}
+		if t.MaxRetries == 0 {
+			t.MaxRetries = DefaultRelayMaxRetries
+		}
+		if t.Backoff <= 0 {
+			t.Backoff = DefaultRelayBackoff
+		}
+		if t.MaxBackoff <= 0 {
+			t.MaxBackoff = DefaultRelayMaxBackoff
+		}
+
+		q, err := newRelayQueue(r.queueDir, t.Name, r.queueSize)
+		if err != nil {
+			return nil, err
+		}
+
+		r.targets = append(r.targets, &relayTarget{RelayTarget: t, queue: q})
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r.cancel = cancel
+
+	for _, t := range r.targets {
+		r.wg.Add(1)
+		go func(t *relayTarget) {
+			defer r.wg.Done()
+			r.deliverLoop(ctx, t)
+		}(t)
+	}
+
+	return r, nil
+}
+
+// ServeHTTP receives a webhook request and queues it for all the targets.
+// The request is responded as soon as it is queued.
+func (r *Relay) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, req.Body, r.maxBodySize))
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	event, err := ParseWebhookPayload(body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := r.Forward(event, body); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// Forward queues the given event with its raw request body for the
+// targets which select the event.
+func (r *Relay) Forward(event Event, body []byte) error {
+	var errs []error
+	for _, t := range r.targets {
+		if !t.match(event) {
+			continue
+		}
+
+		payload := body
+		if t.Transform != nil {
+			var err error
+			payload, err = t.Transform(event, body)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("transforming event for %s: %w", t.Name, err))
+				continue
+			}
+			if payload == nil {
+				continue
+			}
+		}
+
+		if discarded, err := t.queue.push(payload); err != nil {
+			errs = append(errs, fmt.Errorf("queueing event for %s: %w", t.Name, err))
+		} else if discarded {
+			r.reportError(&RelayError{Target: t.Name, Discarded: true, Err: errors.New("queue is full")})
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Close stops the delivery. Events still queued are left in the queue
+// directory, if any, and delivered when the relay is restarted.
+func (r *Relay) Close() error {
+	r.cancel()
+	r.wg.Wait()
+
+	return nil
+}
+
+// Pending returns the number of queued events for each target.
+func (r *Relay) Pending() map[string]int {
+	ret := make(map[string]int, len(r.targets))
+	for _, t := range r.targets {
+		ret[t.Name] = t.queue.len()
+	}
+
+	return ret
+}
+
+func (r *Relay) reportError(err error) {
+	if r.errorHandler != nil {
+		r.errorHandler(err)
+	}
+}
+
+type relayTarget struct {
+	RelayTarget
+	queue *relayQueue
+}
+
+func (r *Relay) deliverLoop(ctx context.Context, t *relayTarget) {
+	for {
+		item, ok := t.queue.peek(ctx)
+		if !ok {
+			return
+		}
+
+		err := r.deliver(ctx, t, item.payload)
+		t.queue.release(item)
+		if ctx.Err() != nil {
+			// the delivery is retried after the restart
+			return
+		}
+
+		if err == nil {
+			t.queue.remove(item)
+			continue
+		}
+
+		item.attempts++
+		var permanent *relayPermanentError
+		if errors.As(err, &permanent) || (t.MaxRetries >= 0 && item.attempts > t.MaxRetries) {
+			t.queue.remove(item)
+			r.reportError(&RelayError{Target: t.Name, Attempts: item.attempts, Discarded: true, Err: err})
+			continue
+		}
+
+		r.reportError(&RelayError{Target: t.Name, Attempts: item.attempts, Err: err})
+
+		timer := time.NewTimer(relayBackoff(t.Backoff, t.MaxBackoff, item.attempts))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+func relayBackoff(initial, max time.Duration, attempts int) time.Duration {
+	d := initial
+	for i := 1; i < attempts; i++ {
+		d *= 2
+		if d >= max {
+			return max
+		}
+	}
+
+	return d
+}
+
+type relayPermanentError struct {
+	statusCode int
+}
+
+func (e *relayPermanentError) Error() string {
+	return fmt.Sprintf("unexpected status code: %d", e.statusCode)
+}
+
+func (r *Relay) deliver(ctx context.Context, t *relayTarget, payload []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, t.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.URL, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+
+	for k, vs := range t.Header {
+		for _, v := range vs {
+			req.Header.Add(k, v)
+		}
+	}
+	if req.Header.Get("Content-Type") == "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	switch {
+	case resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode == http.StatusRequestTimeout, resp.StatusCode == http.StatusTooManyRequests, resp.StatusCode >= 500:
+		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	default:
+		// the target will not accept the event however many times it is retried
+		return &relayPermanentError{statusCode: resp.StatusCode}
+	}
+}
+
+type relayItem struct {
+	seq      uint64
+	payload  []byte
+	attempts int
+}
+
+// relayQueue is a bounded FIFO queue of a relay target. If dir is not
+// empty, each item is also stored as a file named after its sequence number.
+type relayQueue struct {
+	dir  string
+	size int
+
+	mu    sync.Mutex
+	items []*relayItem
+	// inFlight is the item being delivered, which is not discarded.
+	inFlight *relayItem
+	seq      uint64
+	notify   chan struct{}
+}
+
+func newRelayQueue(baseDir, name string, size int) (*relayQueue, error) {
+	q := &relayQueue{
+		size:   size,
+		notify: make(chan struct{}, 1),
+	}
+
+	if baseDir == "" {
+		return q, nil
+	}
+
+	q.dir = filepath.Join(baseDir, url.PathEscape(name))
+	if err := os.MkdirAll(q.dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	files, err := filepath.Glob(filepath.Join(q.dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+
+	for _, file := range files {
+		seq, err := strconv.ParseUint(strings.TrimSuffix(filepath.Base(file), ".json"), 10, 64)
+		if err != nil {
+			continue
+		}
+
+		payload, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+
+		q.items = append(q.items, &relayItem{seq: seq, payload: payload})
+		q.seq = seq
+	}
+
+	if len(q.items) > 0 {
+		q.notify <- struct{}{}
+	}
+
+	return q, nil
+}
+
+func (q *relayQueue) file(seq uint64) string {
+	return filepath.Join(q.dir, fmt.Sprintf("%020d.json", seq))
+}
+
+// push appends the payload and reports whether an item is discarded, which is
+// the oldest one except the item being delivered.
+func (q *relayQueue) push(payload []byte) (bool, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.seq++
+	item := &relayItem{seq: q.seq, payload: payload}
+
+	if q.dir != "" {
+		tmp := q.file(item.seq) + ".tmp"
+		if err := os.WriteFile(tmp, payload, 0o644); err != nil {
+			return false, err
+		}
+		if err := os.Rename(tmp, q.file(item.seq)); err != nil {
+			return false, err
+		}
+	}
+
+	q.items = append(q.items, item)
+
+	discarded := false
+	if q.size > 0 && len(q.items) > q.size {
+		oldest := q.items[0]
+		if oldest == q.inFlight {
+			oldest = q.items[1]
+		}
+		q.removeLocked(oldest)
+		discarded = true
+	}
+
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+
+	return discarded, nil
+}
+
+// peek waits until the queue has an item and returns the oldest one, which
+// is marked as being delivered until it is released.
+func (q *relayQueue) peek(ctx context.Context) (*relayItem, bool) {
+	for {
+		q.mu.Lock()
+		if len(q.items) > 0 {
+			item := q.items[0]
+			q.inFlight = item
+			q.mu.Unlock()
+			return item, true
+		}
+		q.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return nil, false
+		case <-q.notify:
+		}
+	}
+}
+
+// release unmarks the item returned by peek as being delivered.
+func (q *relayQueue) release(item *relayItem) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.inFlight == item {
+		q.inFlight = nil
+	}
+}
+
+func (q *relayQueue) remove(item *relayItem) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.removeLocked(item)
+}
+
+func (q *relayQueue) removeLocked(item *relayItem) {
+	for i, it := range q.items {
+		if it == item {
+			q.items = append(q.items[:i], q.items[i+1:]...)
+			break
+		}
+	}
+
+	if q.dir != "" {
+		_ = os.Remove(q.file(item.seq))
+	}
+}
+
+func (q *relayQueue) len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return len(q.items)
+}
@@ -0,0 +1,266 @@
+package switchbot_test
+
+import (
+	"errors"
+	"fmt"
+	switchbot2 "github.com/nasa9084/go-switchbot/v3/switchbot"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const contactWebhookBody = `{"eventType":"changeReport","eventVersion":"1","context":{"deviceType":"WoContact","deviceMac":"01:00:5e:90:10:01","detectionState":"DETECTED","doorMode":"OUT_DOOR","brightness":"dim","openState":"open","timeOfSample":123456789}}`
+
+type relayReceiver struct {
+	mu     sync.Mutex
+	bodies []string
+	fails  int
+	status int
+	got    chan struct{}
+}
+
+func newRelayReceiver() *relayReceiver {
+	return &relayReceiver{got: make(chan struct{}, 16)}
+}
+
+func (rr *relayReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+
+	if rr.fails > 0 {
+		rr.fails--
+		w.WriteHeader(rr.status)
+		return
+	}
+
+	rr.bodies = append(rr.bodies, string(body))
+	rr.got <- struct{}{}
+}
+
+func (rr *relayReceiver) wait(t *testing.T, n int) []string {
+	t.Helper()
+
+	for i := 0; i < n; i++ {
+		select {
+		case <-rr.got:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%d events are expected to be relayed", n)
+		}
+	}
+
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+
+	return append([]string(nil), rr.bodies...)
+}
+
+func TestRelay(t *testing.T) {
+	t.Run("fan out with filter and transform", func(t *testing.T) {
+		all := newRelayReceiver()
+		allSrv := httptest.NewServer(all)
+		defer allSrv.Close()
+
+		locks := newRelayReceiver()
+		locksSrv := httptest.NewServer(locks)
+		defer locksSrv.Close()
+
+		relay, err := switchbot2.NewRelay([]switchbot2.RelayTarget{
+			{Name: "all", URL: allSrv.URL},
+			{Name: "locks", URL: locksSrv.URL, DeviceTypes: []string{"Smart Lock"}, Transform: switchbot2.ContextOnlyTransform},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer relay.Close()
+
+		srv := httptest.NewServer(relay)
+		defer srv.Close()
+
+		for _, body := range []string{lockWebhookBody, contactWebhookBody} {
+			resp, err := http.Post(srv.URL, "application/json", strings.NewReader(body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				t.Fatalf("unexpected status code: %d", resp.StatusCode)
+			}
+		}
+
+		if got := all.wait(t, 2); got[0] != lockWebhookBody || got[1] != contactWebhookBody {
+			t.Errorf("unexpected relayed events: %v", got)
+		}
+
+		got := locks.wait(t, 1)
+		if want := `{"deviceType":"WoLock","deviceMac":"01:00:5e:90:10:00","lockState":"LOCKED","timeOfSample":123456789}`; got[0] != want {
+			t.Errorf("unexpected transformed event: %s", got[0])
+		}
+	})
+
+	t.Run("retry", func(t *testing.T) {
+		rr := newRelayReceiver()
+		rr.fails = 2
+		rr.status = http.StatusServiceUnavailable
+		srv := httptest.NewServer(rr)
+		defer srv.Close()
+
+		var mu sync.Mutex
+		var errs []error
+		relay, err := switchbot2.NewRelay(
+			[]switchbot2.RelayTarget{{URL: srv.URL, Backoff: time.Millisecond}},
+			switchbot2.WithRelayErrorHandler(func(err error) {
+				mu.Lock()
+				defer mu.Unlock()
+				errs = append(errs, err)
+			}),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer relay.Close()
+
+		event, _ := switchbot2.ParseWebhookPayload([]byte(lockWebhookBody))
+		if err := relay.Forward(event, []byte(lockWebhookBody)); err != nil {
+			t.Fatal(err)
+		}
+
+		rr.wait(t, 1)
+
+		mu.Lock()
+		defer mu.Unlock()
+		if len(errs) != 2 {
+			t.Fatalf("2 failed attempts are expected to be reported but %v", errs)
+		}
+		var relayErr *switchbot2.RelayError
+		if !errors.As(errs[1], &relayErr) || relayErr.Attempts != 2 || relayErr.Discarded {
+			t.Errorf("unexpected error: %v", errs[1])
+		}
+	})
+
+	t.Run("permanent failure", func(t *testing.T) {
+		rr := newRelayReceiver()
+		rr.fails = 1
+		rr.status = http.StatusBadRequest
+		srv := httptest.NewServer(rr)
+		defer srv.Close()
+
+		discarded := make(chan *switchbot2.RelayError, 1)
+		relay, err := switchbot2.NewRelay(
+			[]switchbot2.RelayTarget{{URL: srv.URL}},
+			switchbot2.WithRelayErrorHandler(func(err error) {
+				var relayErr *switchbot2.RelayError
+				if errors.As(err, &relayErr) && relayErr.Discarded {
+					discarded <- relayErr
+				}
+			}),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer relay.Close()
+
+		event, _ := switchbot2.ParseWebhookPayload([]byte(lockWebhookBody))
+		relay.Forward(event, []byte(lockWebhookBody))
+
+		select {
+		case err := <-discarded:
+			if err.Attempts != 1 {
+				t.Errorf("event is expected to be discarded without retries but %d attempts", err.Attempts)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("event is expected to be discarded")
+		}
+	})
+
+	t.Run("in-flight event is not discarded", func(t *testing.T) {
+		var (
+			started = make(chan struct{}, 1)
+			release = make(chan struct{})
+		)
+		rr := newRelayReceiver()
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case started <- struct{}{}:
+				<-release
+			default:
+			}
+			rr.ServeHTTP(w, r)
+		}))
+		defer srv.Close()
+
+		relay, err := switchbot2.NewRelay(
+			[]switchbot2.RelayTarget{{Name: "target", URL: srv.URL}},
+			switchbot2.WithRelayQueueSize(2),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer relay.Close()
+
+		bodies := make([]string, 3)
+		for i := range bodies {
+			bodies[i] = strings.Replace(lockWebhookBody, "123456789", fmt.Sprint(123456789+i), 1)
+			event, _ := switchbot2.ParseWebhookPayload([]byte(bodies[i]))
+			relay.Forward(event, []byte(bodies[i]))
+
+			if i == 0 {
+				// the first event is being delivered while the others are queued
+				<-started
+			}
+		}
+		close(release)
+
+		got := rr.wait(t, 2)
+		if want := []string{bodies[0], bodies[2]}; got[0] != want[0] || got[1] != want[1] {
+			t.Errorf("the oldest event except the one being delivered is expected to be discarded: %v", got)
+		}
+	})
+
+	t.Run("disk-backed queue", func(t *testing.T) {
+		dir := t.TempDir()
+
+		// nothing listens on the target while the first relay is running
+		down := httptest.NewServer(http.NotFoundHandler())
+		down.Close()
+
+		relay, err := switchbot2.NewRelay(
+			[]switchbot2.RelayTarget{{Name: "target", URL: down.URL, Backoff: time.Hour}},
+			switchbot2.WithRelayQueueDir(dir),
+			switchbot2.WithRelayQueueSize(2),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		event, _ := switchbot2.ParseWebhookPayload([]byte(lockWebhookBody))
+		for i := 0; i < 3; i++ {
+			relay.Forward(event, []byte(lockWebhookBody))
+		}
+
+		if got := relay.Pending()["target"]; got != 2 {
+			t.Errorf("queue is expected to be bounded to 2 but %d", got)
+		}
+		relay.Close()
+
+		rr := newRelayReceiver()
+		srv := httptest.NewServer(rr)
+		defer srv.Close()
+
+		relay, err = switchbot2.NewRelay(
+			[]switchbot2.RelayTarget{{Name: "target", URL: srv.URL}},
+			switchbot2.WithRelayQueueDir(dir),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer relay.Close()
+
+		rr.wait(t, 2)
+	})
+}
@@ -52,7 +52,7 @@ func TestScenes(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	want := []switchbot.Scene{
+	want := []switchbot2.Scene{
 		{
 			ID:   "T02-20200804130110",
 			Name: "Close Office Devices",
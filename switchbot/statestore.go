@@ -0,0 +1,557 @@
+package switchbot
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultStatePollInterval is the default interval at which StateStore
+	// checks the devices to be polled.
+	DefaultStatePollInterval = time.Minute
+	// DefaultStateStaleAfter is the default duration after which the state
+	// of a device is considered stale and is polled.
+	DefaultStateStaleAfter = 10 * time.Minute
+	// DefaultStateListInterval is the default interval at which StateStore
+	// refreshes the device list.
+	DefaultStateListInterval = time.Hour
+)
+
+// ErrNoDeviceAPI is returned by the methods of StateStore which call the
+// device APIs when the StateStore is made without a DeviceAPI.
+var ErrNoDeviceAPI = errors.New("state store has no device API")
+
+// DeviceAPI is the interface of the device APIs used by StateStore.
+// *DeviceService satisfies this interface.
+type DeviceAPI interface {
+	List(ctx context.Context) ([]Device, []InfraredDevice, error)
+	Status(ctx context.Context, id string) (DeviceStatus, error)
+}
+
+// StateSource represents where a device state comes from.
+type StateSource string
+
+const (
+	StateSourceWebhook StateSource = "webhook"
+	StateSourcePolling StateSource = "polling"
+)
+
+// DeviceState is the last-known state of a device.
+type DeviceState struct {
+	ID   string             `json:"deviceId"`
+	Name string             `json:"deviceName,omitempty"`
+	Type PhysicalDeviceType `json:"deviceType,omitempty"`
+	// MAC is the MAC address reported by the latest webhook event, if any.
+	MAC string `json:"deviceMac,omitempty"`
+	// Fields holds the state fields keyed by the JSON field names of the
+	// device status API, e.g. "lockState" or "temperature".
+	Fields map[string]interface{} `json:"fields"`
+	// SampledAt is the time the latest state was sampled, which is the
+	// timeOfSample of webhook events or the time of polling.
+	SampledAt time.Time `json:"sampledAt"`
+	// Source is where the latest state comes from.
+	Source StateSource `json:"source"`
+}
+
+func (s DeviceState) clone() DeviceState {
+	fields := make(map[string]interface{}, len(s.Fields))
+	for k, v := range s.Fields {
+		fields[k] = v
+	}
+	s.Fields = fields
+
+	return s
+}
+
+// FieldChange is a change of a state field.
+// Old is nil if the field is newly known.
+type FieldChange struct {
+	Field string      `json:"field"`
+	Old   interface{} `json:"old"`
+	New   interface{} `json:"new"`
+}
+
+// StateChange is a set of changes applied to a device state.
+type StateChange struct {
+	DeviceID string        `json:"deviceId"`
+	Source   StateSource   `json:"source"`
+	Changes  []FieldChange `json:"changes"`
+	// State is the device state after the changes are applied.
+	State DeviceState `json:"state"`
+}
+
+// StateStore holds the last-known state of each device, merging the states
+// reported by webhook events and polled from the device status API.
+// Webhook events identify devices by their MAC addresses, which are mapped
+// to device IDs. Events sampled before the current state are rejected as
+// stale, and only the devices which have not reported recently are polled.
+type StateStore struct {
+	api          DeviceAPI
+	pollInterval time.Duration
+	staleAfter   time.Duration
+	listInterval time.Duration
+	now          func() time.Time
+
+	mu          sync.RWMutex
+	devices     map[string]Device
+	states      map[string]DeviceState
+	subscribers map[int]func(StateChange)
+	nextSubID   int
+	// nextSeq is the sequence number of the next committed change.
+	nextSeq uint64
+
+	// notifyMu guards notifiedSeq, which is the sequence number of the next
+	// change to be notified, so that changes are notified in commit order.
+	notifyMu    sync.Mutex
+	notifyCond  *sync.Cond
+	notifiedSeq uint64
+}
+
+// StateStoreOption configures a StateStore.
+type StateStoreOption func(*StateStore)
+
+// WithStatePollInterval sets the interval at which the StateStore checks
+// the devices to be polled.
+func WithStatePollInterval(d time.Duration) StateStoreOption {
+	return func(s *StateStore) {
+		s.pollInterval = d
+	}
+}
+
+// WithStateStaleAfter sets the duration after which the state of a device
+// is considered stale and is polled.
+func WithStateStaleAfter(d time.Duration) StateStoreOption {
+	return func(s *StateStore) {
+		s.staleAfter = d
+	}
+}
+
+// WithStateListInterval sets the interval at which the StateStore refreshes
+// the device list.
+func WithStateListInterval(d time.Duration) StateStoreOption {
+	return func(s *StateStore) {
+		s.listInterval = d
+	}
+}
+
+// NewStateStore returns a new StateStore. api is used to list devices and
+// to poll device status, and can be nil if polling is not needed, in which
+// case RefreshDevices, Poll and Run return ErrNoDeviceAPI.
+func NewStateStore(api DeviceAPI, opts ...StateStoreOption) *StateStore {
+	s := &StateStore{
+		api:          api,
+		pollInterval: DefaultStatePollInterval,
+		staleAfter:   DefaultStateStaleAfter,
+		listInterval: DefaultStateListInterval,
+		now:          time.Now,
+
+		devices:     map[string]Device{},
+		states:      map[string]DeviceState{},
+		subscribers: map[int]func(StateChange){},
+	}
+	s.notifyCond = sync.NewCond(&s.notifyMu)
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	return s
+}
+
+// Subscribe registers a function which is called with every change of the
+// device states. The returned function unregisters it.
+// The function is called synchronously in the order the changes are applied,
+// so it should return quickly and must not apply states to the store.
+func (s *StateStore) Subscribe(fn func(StateChange)) func() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id := s.nextSubID
+	s.nextSubID++
+	s.subscribers[id] = fn
+
+	return func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		delete(s.subscribers, id)
+	}
+}
+
+// SetDevices sets the list of known devices.
+func (s *StateStore) SetDevices(devices []Device) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.devices = make(map[string]Device, len(devices))
+	for _, d := range devices {
+		s.devices[d.ID] = d
+
+		if state, ok := s.states[d.ID]; ok {
+			state.Name = d.Name
+			state.Type = d.Type
+			s.states[d.ID] = state
+		}
+	}
+}
+
+// RefreshDevices refreshes the list of known devices with the device list API.
+func (s *StateStore) RefreshDevices(ctx context.Context) error {
+	if s.api == nil {
+		return ErrNoDeviceAPI
+	}
+
+	devices, _, err := s.api.List(ctx)
+	if err != nil {
+		return err
+	}
+
+	s.SetDevices(devices)
+
+	return nil
+}
+
+// DeviceIDForMAC returns the ID of the known device with the given MAC address.
+func (s *StateStore) DeviceIDForMAC(mac string) (string, bool) {
+	id := DeviceIDFromMAC(mac)
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	_, ok := s.devices[id]
+
+	return id, ok
+}
+
+// Get returns the state of the device with the given device ID or MAC address.
+func (s *StateStore) Get(id string) (DeviceState, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	state, ok := s.states[DeviceIDFromMAC(id)]
+	if !ok {
+		return DeviceState{}, false
+	}
+
+	return state.clone(), true
+}
+
+// States returns the states of all the devices ordered by their IDs.
+func (s *StateStore) States() []DeviceState {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	states := make([]DeviceState, 0, len(s.states))
+	for _, state := range s.states {
+		states = append(states, state.clone())
+	}
+
+	sort.Slice(states, func(i, j int) bool { return states[i].ID < states[j].ID })
+
+	return states
+}
+
+// ApplyEvent merges the fields of the given webhook event into the device
+// state. Events sampled before the current state are ignored.
+// ApplyEvent can be registered with (*WebhookHandler).OnEvent.
+func (s *StateStore) ApplyEvent(event Event) {
+	fields := eventFields(event)
+
+	s.apply(event.DeviceID(), event.DeviceMAC(), event.PhysicalType(), fields, event.Time(), StateSourceWebhook, false)
+}
+
+// ApplyStatus merges the given device status polled at the given time into
+// the device state. The status API reports all the fields for any device
+// type, so zero-valued fields are ignored unless they are already known.
+func (s *StateStore) ApplyStatus(status DeviceStatus, at time.Time) {
+	fields := statusFields(status)
+
+	s.apply(status.ID, "", status.Type, fields, at, StateSourcePolling, true)
+}
+
+func (s *StateStore) apply(id, mac string, typ PhysicalDeviceType, fields map[string]interface{}, sampledAt time.Time, source StateSource, skipZero bool) {
+	s.mu.Lock()
+
+	state, ok := s.states[id]
+	if !ok {
+		state = DeviceState{ID: id, Fields: map[string]interface{}{}}
+		if d, ok := s.devices[id]; ok {
+			state.Name = d.Name
+			state.Type = d.Type
+		}
+	} else if sampledAt.Before(state.SampledAt) {
+		s.mu.Unlock()
+		return
+	} else {
+		state = state.clone()
+	}
+
+	if state.Type == "" {
+		state.Type = typ
+	}
+	if mac != "" {
+		state.MAC = mac
+	}
+	state.SampledAt = sampledAt
+	state.Source = source
+
+	var changes []FieldChange
+	for k, v := range fields {
+		old, known := state.Fields[k]
+		if skipZero && !known && isZeroValue(v) {
+			continue
+		}
+		if known && reflect.DeepEqual(old, v) {
+			continue
+		}
+
+		state.Fields[k] = v
+		changes = append(changes, FieldChange{Field: k, Old: old, New: v})
+	}
+
+	s.states[id] = state
+
+	if len(changes) == 0 {
+		s.mu.Unlock()
+		return
+	}
+
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Field < changes[j].Field })
+
+	change := StateChange{
+		DeviceID: id,
+		Source:   source,
+		Changes:  changes,
+		State:    state.clone(),
+	}
+
+	subscribers := make([]func(StateChange), 0, len(s.subscribers))
+	for _, fn := range s.subscribers {
+		subscribers = append(subscribers, fn)
+	}
+
+	seq := s.nextSeq
+	s.nextSeq++
+
+	s.mu.Unlock()
+
+	// subscribers are called without the lock of the states, but after the
+	// changes committed before this one are notified
+	s.notifyMu.Lock()
+	for s.notifiedSeq != seq {
+		s.notifyCond.Wait()
+	}
+	s.notifyMu.Unlock()
+
+	defer func() {
+		s.notifyMu.Lock()
+		s.notifiedSeq++
+		s.notifyCond.Broadcast()
+		s.notifyMu.Unlock()
+	}()
+
+	for _, fn := range subscribers {
+		fn(change)
+	}
+}
+
+// Poll polls the status of the devices which have not reported within the
+// stale duration.
+func (s *StateStore) Poll(ctx context.Context) error {
+	if s.api == nil {
+		return ErrNoDeviceAPI
+	}
+
+	now := s.now()
+
+	s.mu.RLock()
+	var ids []string
+	for id := range s.devices {
+		if state, ok := s.states[id]; ok && now.Sub(state.SampledAt) < s.staleAfter {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	s.mu.RUnlock()
+
+	sort.Strings(ids)
+
+	var firstErr error
+	for _, id := range ids {
+		status, err := s.api.Status(ctx, id)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+
+		if status.ID == "" {
+			status.ID = id
+		}
+		s.ApplyStatus(status, s.now())
+	}
+
+	return firstErr
+}
+
+// Run refreshes the device list and polls the stale devices periodically
+// until ctx is canceled. Errors are passed to onError if it is not nil.
+func (s *StateStore) Run(ctx context.Context, onError func(error)) error {
+	if s.api == nil {
+		return ErrNoDeviceAPI
+	}
+
+	report := func(err error) {
+		if err != nil && onError != nil {
+			onError(err)
+		}
+	}
+
+	report(s.RefreshDevices(ctx))
+	report(s.Poll(ctx))
+
+	pollTicker := time.NewTicker(s.pollInterval)
+	defer pollTicker.Stop()
+
+	listTicker := time.NewTicker(s.listInterval)
+	defer listTicker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-listTicker.C:
+			report(s.RefreshDevices(ctx))
+		case <-pollTicker.C:
+			report(s.Poll(ctx))
+		}
+	}
+}
+
+// webhookFieldAliases maps the field names of webhook events into the
+// corresponding field names of the device status API.
+var webhookFieldAliases = map[string]string{
+	"powerState": "power",
+}
+
+// lowerCaseFields is the list of fields whose values are reported in upper
+// case by webhook events but in lower case by the device status API.
+var lowerCaseFields = map[string]bool{
+	"power":     true,
+	"lockState": true,
+}
+
+func eventFields(event Event) map[string]interface{} {
+	fields := map[string]interface{}{}
+
+	// the events of this package have the Context field, but other
+	// implementations of Event may not
+	if v := reflect.ValueOf(event); v.Kind() == reflect.Ptr && v.Elem().Kind() == reflect.Struct {
+		if ctx := v.Elem().FieldByName("Context"); ctx.IsValid() {
+			if b, err := json.Marshal(ctx.Interface()); err == nil {
+				_ = json.Unmarshal(b, &fields)
+			}
+		}
+	}
+
+	for k, raw := range eventExtraFields(event) {
+		var value interface{}
+		if err := json.Unmarshal(raw, &value); err == nil {
+			fields[k] = value
+		}
+	}
+
+	delete(fields, "deviceType")
+	delete(fields, "deviceMac")
+	delete(fields, "timeOfSample")
+
+	normalized := make(map[string]interface{}, len(fields))
+	for k, v := range fields {
+		if alias, ok := webhookFieldAliases[k]; ok {
+			k = alias
+		}
+		if s, ok := v.(string); ok && lowerCaseFields[k] {
+			v = strings.ToLower(s)
+		}
+		normalized[k] = v
+	}
+
+	return normalized
+}
+
+func statusFields(status DeviceStatus) map[string]interface{} {
+	fields := map[string]interface{}{}
+
+	if b, err := json.Marshal(status); err == nil {
+		_ = json.Unmarshal(b, &fields)
+	}
+
+	delete(fields, "deviceId")
+	delete(fields, "deviceType")
+	delete(fields, "hubDeviceId")
+
+	for k, v := range fields {
+		if s, ok := v.(string); ok && lowerCaseFields[k] {
+			fields[k] = strings.ToLower(s)
+		}
+	}
+
+	return fields
+}
+
+// ApplyEvent returns a copy of the status with the fields reported by the
+// given webhook event merged into it. Fields which the event does not report
+// are kept as they are.
+func (status DeviceStatus) ApplyEvent(event Event) DeviceStatus {
+	fields := map[string]interface{}{}
+	if b, err := json.Marshal(status); err == nil {
+		_ = json.Unmarshal(b, &fields)
+	}
+
+	for k, v := range eventFields(event) {
+		fields[k] = v
+	}
+	// motion sensors report the detection state only by webhook events
+	if state, ok := fields["detectionState"].(string); ok {
+		fields["moveDetected"] = state == "DETECTED"
+	}
+
+	b, err := json.Marshal(fields)
+	if err != nil {
+		return status
+	}
+
+	// a field of an unexpected type is left as is, as json.Unmarshal skips
+	// it and goes on with the other fields
+	merged := status
+	if err := json.Unmarshal(b, &merged); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if !errors.As(err, &typeErr) {
+			return status
+		}
+	}
+
+	return merged
+}
+
+func isZeroValue(v interface{}) bool {
+	switch v := v.(type) {
+	case nil:
+		return true
+	case string:
+		return v == ""
+	case float64:
+		return v == 0
+	case bool:
+		return !v
+	default:
+		return false
+	}
+}
@@ -0,0 +1,252 @@
+package switchbot_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	switchbot2 "github.com/nasa9084/go-switchbot/v3/switchbot"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+type fakeDeviceAPI struct {
+	devices []switchbot2.Device
+	status  map[string]switchbot2.DeviceStatus
+	polled  []string
+}
+
+func (api *fakeDeviceAPI) List(context.Context) ([]switchbot2.Device, []switchbot2.InfraredDevice, error) {
+	return api.devices, nil, nil
+}
+
+func (api *fakeDeviceAPI) Status(_ context.Context, id string) (switchbot2.DeviceStatus, error) {
+	api.polled = append(api.polled, id)
+	return api.status[id], nil
+}
+
+func TestStateStore(t *testing.T) {
+	api := &fakeDeviceAPI{
+		devices: []switchbot2.Device{
+			{ID: "01005E901000", Name: "Front Door", Type: switchbot2.Lock},
+			{ID: "C271111EC0AB", Name: "Living Room", Type: switchbot2.Meter},
+		},
+		status: map[string]switchbot2.DeviceStatus{
+			"01005E901000": {ID: "01005E901000", Type: switchbot2.Lock, LockState: "unlocked", Battery: 80},
+			"C271111EC0AB": {ID: "C271111EC0AB", Type: switchbot2.Meter, Temperature: 26.1, Humidity: 52},
+		},
+	}
+
+	store := switchbot2.NewStateStore(api)
+	if err := store.RefreshDevices(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	var changes []switchbot2.StateChange
+	unsubscribe := store.Subscribe(func(c switchbot2.StateChange) { changes = append(changes, c) })
+	defer unsubscribe()
+
+	if err := store.Poll(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff([]string{"01005E901000", "C271111EC0AB"}, api.polled); diff != "" {
+		t.Errorf("polled devices mismatch (-want +got):\n%s", diff)
+	}
+
+	lock, ok := store.Get("01:00:5e:90:10:00")
+	if !ok {
+		t.Fatal("lock state is expected to be stored")
+	}
+	want := map[string]interface{}{"lockState": "unlocked", "battery": float64(80)}
+	if diff := cmp.Diff(want, lock.Fields); diff != "" {
+		t.Errorf("lock fields mismatch (-want +got):\n%s", diff)
+	}
+
+	changes = nil
+
+	event, err := switchbot2.ParseWebhookPayload([]byte(fmt.Sprintf(`{"eventType":"changeReport","eventVersion":"1","context":{"deviceType":"WoLock","deviceMac":"01:00:5e:90:10:00","lockState":"LOCKED","timeOfSample":%d}}`, time.Now().Add(time.Second).UnixMilli())))
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.ApplyEvent(event)
+
+	if len(changes) != 1 {
+		t.Fatalf("1 change is expected to be notified but %d", len(changes))
+	}
+	wantChanges := []switchbot2.FieldChange{{Field: "lockState", Old: "unlocked", New: "locked"}}
+	if diff := cmp.Diff(wantChanges, changes[0].Changes); diff != "" {
+		t.Errorf("changes mismatch (-want +got):\n%s", diff)
+	}
+	if changes[0].Source != switchbot2.StateSourceWebhook || changes[0].State.Name != "Front Door" {
+		t.Errorf("unexpected change: %+v", changes[0])
+	}
+
+	t.Run("stale event", func(t *testing.T) {
+		changes = nil
+
+		event, err := switchbot2.ParseWebhookPayload([]byte(`{"eventType":"changeReport","eventVersion":"1","context":{"deviceType":"WoLock","deviceMac":"01:00:5e:90:10:00","lockState":"UNLOCKED","timeOfSample":123456789}}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		store.ApplyEvent(event)
+
+		if len(changes) != 0 {
+			t.Errorf("stale event is expected to be ignored but %+v", changes)
+		}
+		if got, _ := store.Get("01005E901000"); got.Fields["lockState"] != "locked" {
+			t.Errorf("lock state is expected to be kept but %v", got.Fields["lockState"])
+		}
+	})
+
+	t.Run("zero battery", func(t *testing.T) {
+		if got, _ := store.Get("01005E901000"); got.Fields["battery"] != float64(80) {
+			t.Fatalf("battery level is expected to be kept by events without it but %v", got.Fields["battery"])
+		}
+
+		event, err := switchbot2.ParseWebhookPayload([]byte(fmt.Sprintf(`{"eventType":"changeReport","eventVersion":"1","context":{"deviceType":"WoLock","deviceMac":"01:00:5e:90:10:00","lockState":"LOCKED","battery":0,"timeOfSample":%d}}`, time.Now().Add(2*time.Second).UnixMilli())))
+		if err != nil {
+			t.Fatal(err)
+		}
+		store.ApplyEvent(event)
+
+		if got, _ := store.Get("01005E901000"); got.Fields["battery"] != float64(0) {
+			t.Errorf("battery level of 0 is expected to be stored but %v", got.Fields["battery"])
+		}
+	})
+
+	t.Run("poll only stale devices", func(t *testing.T) {
+		api.polled = nil
+
+		if err := store.Poll(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(api.polled) != 0 {
+			t.Errorf("recently reported devices are not expected to be polled but %v", api.polled)
+		}
+	})
+}
+
+func TestStateStoreNotificationOrder(t *testing.T) {
+	store := switchbot2.NewStateStore(nil)
+
+	var (
+		mu     sync.Mutex
+		latest time.Time
+		count  int
+	)
+	store.Subscribe(func(c switchbot2.StateChange) {
+		// yield so that the notifications of concurrent changes interleave
+		runtime.Gosched()
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		if c.State.SampledAt.Before(latest) {
+			t.Errorf("state sampled at %v is notified after the one at %v", c.State.SampledAt, latest)
+		}
+		latest = c.State.SampledAt
+		count++
+	})
+
+	base := time.Now()
+	var wg sync.WaitGroup
+	for i := 1; i <= 100; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			status := switchbot2.DeviceStatus{ID: "C271111EC0AB", Type: switchbot2.Meter, Temperature: float64(i)}
+			store.ApplyStatus(status, base.Add(time.Duration(i)*time.Millisecond))
+		}(i)
+	}
+	wg.Wait()
+
+	state, _ := store.Get("C271111EC0AB")
+	if !latest.Equal(state.SampledAt) {
+		t.Errorf("the latest notified state is sampled at %v but the stored one is at %v", latest, state.SampledAt)
+	}
+	if count == 0 {
+		t.Error("changes are expected to be notified")
+	}
+}
+
+func TestDeviceStatusApplyEvent(t *testing.T) {
+	status := switchbot2.DeviceStatus{
+		ID:        "01005E901000",
+		Type:      switchbot2.Lock,
+		LockState: "locked",
+		DoorState: "closed",
+		Battery:   90,
+		Version:   "V1.2",
+	}
+
+	event, err := switchbot2.ParseWebhookPayload([]byte(`{"eventType":"changeReport","eventVersion":"1","context":{"deviceType":"WoLock","deviceMac":"01:00:5e:90:10:00","lockState":"JAMMED","timeOfSample":123456789}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := status
+	want.LockState = "jammed"
+	if diff := cmp.Diff(want, status.ApplyEvent(event), cmp.AllowUnexported(switchbot2.BrightnessState{})); diff != "" {
+		t.Errorf("status mismatch (-want +got):\n%s", diff)
+	}
+
+	motion, err := switchbot2.ParseWebhookPayload([]byte(`{"eventType":"changeReport","eventVersion":"1","context":{"deviceType":"WoPresence","deviceMac":"01:00:5e:90:10:01","detectionState":"DETECTED","timeOfSample":123456789}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := (switchbot2.DeviceStatus{}).ApplyEvent(motion); !got.IsMoveDetected {
+		t.Errorf("motion is expected to be detected: %+v", got)
+	}
+
+	// the battery of an unexpected type is left as is
+	meter, err := switchbot2.ParseWebhookPayload([]byte(`{"eventType":"changeReport","eventVersion":"1","context":{"deviceType":"WoMeter","deviceMac":"01:00:5e:90:10:02","temperature":25.5,"humidity":40,"battery":"low","timeOfSample":123456789}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	meterStatus := switchbot2.DeviceStatus{ID: "01005E901002", Type: switchbot2.Meter, Temperature: 20, Humidity: 30, Battery: 80}
+	want = meterStatus
+	want.Temperature = 25.5
+	want.Humidity = 40
+	if diff := cmp.Diff(want, meterStatus.ApplyEvent(meter), cmp.AllowUnexported(switchbot2.BrightnessState{})); diff != "" {
+		t.Errorf("status mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestStateStoreCustomEvent(t *testing.T) {
+	s := switchbot2.NewStateStore(nil)
+
+	// events other than the ones of this package are applied without fields
+	s.ApplyEvent(customEvent{MAC: "01:00:5e:90:10:0a"})
+
+	state, ok := s.Get("01005E90100A")
+	if !ok {
+		t.Fatal("state is expected to be stored")
+	}
+	if len(state.Fields) != 0 {
+		t.Errorf("no fields are expected but %v", state.Fields)
+	}
+}
+
+func TestStateStoreWithoutAPI(t *testing.T) {
+	s := switchbot2.NewStateStore(nil)
+	s.SetDevices([]switchbot2.Device{{ID: "01005E901000"}})
+
+	ctx := context.Background()
+	if err := s.RefreshDevices(ctx); !errors.Is(err, switchbot2.ErrNoDeviceAPI) {
+		t.Errorf("RefreshDevices() = %v, want ErrNoDeviceAPI", err)
+	}
+	if err := s.Poll(ctx); !errors.Is(err, switchbot2.ErrNoDeviceAPI) {
+		t.Errorf("Poll() = %v, want ErrNoDeviceAPI", err)
+	}
+	if err := s.Run(ctx, nil); !errors.Is(err, switchbot2.ErrNoDeviceAPI) {
+		t.Errorf("Run() = %v, want ErrNoDeviceAPI", err)
+	}
+}
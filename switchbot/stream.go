@@ -0,0 +1,506 @@
+package switchbot
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultStreamHistorySize is the default number of the latest messages
+	// kept by a Stream for subscribers resuming with the last event ID.
+	DefaultStreamHistorySize = 1024
+	// DefaultStreamBufferSize is the default number of messages buffered for
+	// each subscriber. Subscribers which fall behind more than the buffer
+	// are dropped.
+	DefaultStreamBufferSize = 64
+	// DefaultStreamKeepAlive is the default interval of keep-alive messages
+	// sent to idle SSE and WebSocket clients.
+	DefaultStreamKeepAlive = 30 * time.Second
+)
+
+// ErrStreamClosed is returned when a closed Stream is used.
+var ErrStreamClosed = errors.New("stream is closed")
+
+// StreamKind represents the kind of a stream message.
+type StreamKind string
+
+const (
+	// StreamKindEvent is the kind of the messages carrying webhook events.
+	StreamKindEvent StreamKind = "event"
+	// StreamKindState is the kind of the messages carrying device state changes.
+	StreamKindState StreamKind = "state"
+)
+
+// StreamMessage is a message delivered to stream subscribers.
+type StreamMessage struct {
+	// ID is the sequential ID of the message, which is used to resume the
+	// stream. IDs start over when the Stream is recreated.
+	ID         uint64             `json:"id"`
+	Kind       StreamKind         `json:"kind"`
+	DeviceID   string             `json:"deviceId"`
+	DeviceType PhysicalDeviceType `json:"deviceType,omitempty"`
+	Time       time.Time          `json:"time"`
+	// Data is the webhook event payload for StreamKindEvent, or the
+	// StateChange for StreamKindState.
+	Data json.RawMessage `json:"data"`
+}
+
+// StreamFilter selects the messages delivered to a subscriber.
+// Zero values match everything.
+type StreamFilter struct {
+	// DeviceIDs selects the messages of the devices. Both device IDs and
+	// MAC addresses are accepted.
+	DeviceIDs   []string
+	DeviceTypes []PhysicalDeviceType
+	Kinds       []StreamKind
+}
+
+// StreamFilterFromQuery builds a StreamFilter from the query parameters
+// deviceId, deviceType and kind. Each parameter can be repeated or have
+// comma-separated values.
+func StreamFilterFromQuery(q url.Values) StreamFilter {
+	f := StreamFilter{
+		DeviceIDs: splitQueryValues(q["deviceId"]),
+	}
+
+	for _, typ := range splitQueryValues(q["deviceType"]) {
+		f.DeviceTypes = append(f.DeviceTypes, PhysicalDeviceType(typ))
+	}
+	for _, kind := range splitQueryValues(q["kind"]) {
+		f.Kinds = append(f.Kinds, StreamKind(kind))
+	}
+
+	return f
+}
+
+func splitQueryValues(values []string) []string {
+	var ret []string
+	for _, v := range values {
+		for _, s := range strings.Split(v, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				ret = append(ret, s)
+			}
+		}
+	}
+
+	return ret
+}
+
+func (f StreamFilter) match(msg StreamMessage) bool {
+	if len(f.DeviceIDs) > 0 {
+		matched := false
+		for _, id := range f.DeviceIDs {
+			if DeviceIDFromMAC(id) == msg.DeviceID {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+
+	if len(f.DeviceTypes) > 0 {
+		matched := false
+		for _, typ := range f.DeviceTypes {
+			if typ == msg.DeviceType {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+
+	if len(f.Kinds) > 0 {
+		matched := false
+		for _, kind := range f.Kinds {
+			if kind == msg.Kind {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Stream fans out webhook events and device state changes to any number of
+// subscribers. Stream implements http.Handler serving the messages as
+// Server-Sent Events, or over WebSocket for WebSocket upgrade requests.
+//
+// Publishing never blocks: subscribers which cannot keep up with the
+// messages are dropped.
+type Stream struct {
+	historySize int
+	bufferSize  int
+	keepAlive   time.Duration
+	// allowedOrigins are the origins allowed to open WebSocket connections
+	// in addition to the same origin, and allowAnyOrigin allows any origin.
+	allowedOrigins map[string]bool
+	allowAnyOrigin bool
+
+	mu      sync.Mutex
+	lastID  uint64
+	history []StreamMessage
+	subs    map[*StreamSubscription]struct{}
+	closed  bool
+}
+
+// StreamOption configures a Stream.
+type StreamOption func(*Stream)
+
+// WithStreamHistorySize sets the number of the latest messages kept for
+// subscribers resuming with the last event ID.
+func WithStreamHistorySize(n int) StreamOption {
+	return func(s *Stream) {
+		s.historySize = n
+	}
+}
+
+// WithStreamBufferSize sets the number of messages buffered for each subscriber.
+func WithStreamBufferSize(n int) StreamOption {
+	return func(s *Stream) {
+		s.bufferSize = n
+	}
+}
+
+// WithStreamKeepAlive sets the interval of keep-alive messages sent to idle
+// clients. Keep-alive messages are disabled if d is zero.
+func WithStreamKeepAlive(d time.Duration) StreamOption {
+	return func(s *Stream) {
+		s.keepAlive = d
+	}
+}
+
+// WithStreamAllowedOrigins sets the origins, e.g. https://example.com, which
+// are allowed to open WebSocket connections in addition to the same origin as
+// the request. "*" allows any origin. Browsers do not apply CORS to WebSocket,
+// so cross-origin connections from browsers are rejected by default.
+func WithStreamAllowedOrigins(origins ...string) StreamOption {
+	return func(s *Stream) {
+		if s.allowedOrigins == nil {
+			s.allowedOrigins = map[string]bool{}
+		}
+		for _, origin := range origins {
+			if origin == "*" {
+				s.allowAnyOrigin = true
+				continue
+			}
+			s.allowedOrigins[strings.ToLower(strings.TrimSuffix(origin, "/"))] = true
+		}
+	}
+}
+
+// NewStream returns a new Stream.
+// Webhook events are published by registering PublishEvent to a
+// WebhookHandler with OnEvent, and state changes are published by
+// registering PublishStateChange to a StateStore with Subscribe.
+func NewStream(opts ...StreamOption) *Stream {
+	s := &Stream{
+		historySize: DefaultStreamHistorySize,
+		bufferSize:  DefaultStreamBufferSize,
+		keepAlive:   DefaultStreamKeepAlive,
+		subs:        map[*StreamSubscription]struct{}{},
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	if s.bufferSize < 1 {
+		s.bufferSize = 1
+	}
+
+	return s
+}
+
+// PublishEvent publishes the given webhook event.
+func (s *Stream) PublishEvent(event Event) {
+	payload, err := MarshalWebhookEvent(event)
+	if err != nil {
+		return
+	}
+
+	_ = s.Publish(StreamKindEvent, event.DeviceID(), event.PhysicalType(), event.Time(), json.RawMessage(payload))
+}
+
+// PublishStateChange publishes the given device state change.
+func (s *Stream) PublishStateChange(change StateChange) {
+	_ = s.Publish(StreamKindState, change.DeviceID, change.State.Type, change.State.SampledAt, change)
+}
+
+// Publish publishes a message carrying v encoded in JSON.
+func (s *Stream) Publish(kind StreamKind, deviceID string, deviceType PhysicalDeviceType, at time.Time, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return ErrStreamClosed
+	}
+
+	s.lastID++
+	msg := StreamMessage{
+		ID:         s.lastID,
+		Kind:       kind,
+		DeviceID:   deviceID,
+		DeviceType: deviceType,
+		Time:       at,
+		Data:       data,
+	}
+
+	if s.historySize > 0 {
+		s.history = append(s.history, msg)
+		if len(s.history) > s.historySize {
+			s.history = append(s.history[:0:0], s.history[len(s.history)-s.historySize:]...)
+		}
+	}
+
+	for sub := range s.subs {
+		if !sub.filter.match(msg) {
+			continue
+		}
+
+		select {
+		case sub.c <- msg:
+		default:
+			sub.dropped = true
+			s.remove(sub)
+		}
+	}
+
+	return nil
+}
+
+// Subscribe subscribes the messages matching the filter published from now on.
+func (s *Stream) Subscribe(filter StreamFilter) (*StreamSubscription, error) {
+	return s.subscribe(filter, nil)
+}
+
+// SubscribeFrom subscribes the messages matching the filter published after
+// the message with the given ID. The messages still kept in the history are
+// delivered first. If the ID is unknown to the Stream, e.g. the Stream has
+// been recreated, all the messages in the history are delivered.
+func (s *Stream) SubscribeFrom(filter StreamFilter, lastEventID uint64) (*StreamSubscription, error) {
+	return s.subscribe(filter, &lastEventID)
+}
+
+func (s *Stream) subscribe(filter StreamFilter, lastEventID *uint64) (*StreamSubscription, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil, ErrStreamClosed
+	}
+
+	var replay []StreamMessage
+	if lastEventID != nil {
+		after := *lastEventID
+		if after > s.lastID {
+			after = 0
+		}
+
+		for _, msg := range s.history {
+			if msg.ID > after && filter.match(msg) {
+				replay = append(replay, msg)
+			}
+		}
+	}
+
+	sub := &StreamSubscription{
+		stream: s,
+		filter: filter,
+		c:      make(chan StreamMessage, s.bufferSize+len(replay)),
+	}
+	for _, msg := range replay {
+		sub.c <- msg
+	}
+	s.subs[sub] = struct{}{}
+
+	return sub, nil
+}
+
+// remove must be called with s.mu held.
+func (s *Stream) remove(sub *StreamSubscription) {
+	if _, ok := s.subs[sub]; !ok {
+		return
+	}
+
+	delete(s.subs, sub)
+	close(sub.c)
+}
+
+// Close closes the stream and ends all the subscriptions.
+func (s *Stream) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closed = true
+	for sub := range s.subs {
+		s.remove(sub)
+	}
+}
+
+// StreamSubscription is a subscription of a Stream.
+type StreamSubscription struct {
+	stream  *Stream
+	filter  StreamFilter
+	c       chan StreamMessage
+	dropped bool
+}
+
+// C returns the channel delivering the messages. The channel is closed
+// when the subscription ends.
+func (sub *StreamSubscription) C() <-chan StreamMessage {
+	return sub.c
+}
+
+// Dropped reports whether the subscription was ended because the subscriber
+// could not keep up with the messages.
+func (sub *StreamSubscription) Dropped() bool {
+	sub.stream.mu.Lock()
+	defer sub.stream.mu.Unlock()
+
+	return sub.dropped
+}
+
+// Close ends the subscription.
+func (sub *StreamSubscription) Close() {
+	sub.stream.mu.Lock()
+	defer sub.stream.mu.Unlock()
+
+	sub.stream.remove(sub)
+}
+
+// ServeHTTP serves the stream as Server-Sent Events, or over WebSocket for
+// WebSocket upgrade requests. The messages are filtered with the query
+// parameters described in StreamFilterFromQuery. Clients resume the stream
+// with the Last-Event-ID header or the lastEventId query parameter.
+func (s *Stream) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	websocket := isWebSocketUpgrade(r)
+	if websocket && !s.originAllowed(r) {
+		http.Error(w, "origin is not allowed", http.StatusForbidden)
+		return
+	}
+
+	filter := StreamFilterFromQuery(r.URL.Query())
+
+	lastEventID := r.Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = r.URL.Query().Get("lastEventId")
+	}
+
+	var (
+		sub *StreamSubscription
+		err error
+	)
+	if lastEventID != "" {
+		id, perr := strconv.ParseUint(lastEventID, 10, 64)
+		if perr != nil {
+			http.Error(w, fmt.Sprintf("invalid last event ID: %s", lastEventID), http.StatusBadRequest)
+			return
+		}
+		sub, err = s.SubscribeFrom(filter, id)
+	} else {
+		sub, err = s.Subscribe(filter)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	defer sub.Close()
+
+	if websocket {
+		s.serveWebSocket(w, r, sub)
+		return
+	}
+
+	s.serveSSE(w, r, sub)
+}
+
+func (s *Stream) serveSSE(w http.ResponseWriter, r *http.Request, sub *StreamSubscription) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	h := w.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	h.Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepAlive := s.keepAliveChan()
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case msg, ok := <-sub.C():
+			if !ok {
+				return
+			}
+
+			data, err := json.Marshal(msg)
+			if err != nil {
+				return
+			}
+
+			if _, err := fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", msg.ID, msg.Kind, data); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-keepAlive.C:
+			if _, err := io.WriteString(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+type keepAliveTicker struct {
+	C      <-chan time.Time
+	ticker *time.Ticker
+}
+
+func (s *Stream) keepAliveChan() keepAliveTicker {
+	if s.keepAlive <= 0 {
+		return keepAliveTicker{}
+	}
+
+	ticker := time.NewTicker(s.keepAlive)
+	return keepAliveTicker{C: ticker.C, ticker: ticker}
+}
+
+func (t keepAliveTicker) Stop() {
+	if t.ticker != nil {
+		t.ticker.Stop()
+	}
+}
@@ -0,0 +1,458 @@
+package switchbot_test
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"github.com/google/go-cmp/cmp"
+	switchbot2 "github.com/nasa9084/go-switchbot/v3/switchbot"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStream(t *testing.T) {
+	publish := func(s *switchbot2.Stream, mac string, sample int64) {
+		event, err := switchbot2.ParseWebhookPayload([]byte(fmt.Sprintf(`{"eventType":"changeReport","eventVersion":"1","context":{"deviceType":"WoLock","deviceMac":%q,"lockState":"LOCKED","timeOfSample":%d}}`, mac, sample)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.PublishEvent(event)
+	}
+
+	receive := func(sub *switchbot2.StreamSubscription) []uint64 {
+		var ids []uint64
+		for {
+			select {
+			case msg, ok := <-sub.C():
+				if !ok {
+					return ids
+				}
+				ids = append(ids, msg.ID)
+			default:
+				return ids
+			}
+		}
+	}
+
+	t.Run("filter", func(t *testing.T) {
+		s := switchbot2.NewStream()
+		defer s.Close()
+
+		sub, err := s.Subscribe(switchbot2.StreamFilter{DeviceIDs: []string{"01:00:5e:90:10:01"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		typed, err := s.Subscribe(switchbot2.StreamFilter{DeviceTypes: []switchbot2.PhysicalDeviceType{switchbot2.Lock}, Kinds: []switchbot2.StreamKind{switchbot2.StreamKindState}})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		publish(s, "01:00:5e:90:10:00", 1)
+		publish(s, "01:00:5e:90:10:01", 2)
+
+		if diff := cmp.Diff([]uint64{2}, receive(sub)); diff != "" {
+			t.Errorf("received messages mismatch (-want +got):\n%s", diff)
+		}
+		if got := receive(typed); len(got) != 0 {
+			t.Errorf("no messages are expected to be received but %v", got)
+		}
+	})
+
+	t.Run("resume", func(t *testing.T) {
+		s := switchbot2.NewStream(switchbot2.WithStreamHistorySize(2))
+		defer s.Close()
+
+		for i := int64(1); i <= 3; i++ {
+			publish(s, "01:00:5e:90:10:00", i)
+		}
+
+		sub, err := s.SubscribeFrom(switchbot2.StreamFilter{}, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff([]uint64{3}, receive(sub)); diff != "" {
+			t.Errorf("resumed messages mismatch (-want +got):\n%s", diff)
+		}
+
+		// unknown IDs resume from the oldest message in the history
+		sub, err = s.SubscribeFrom(switchbot2.StreamFilter{}, 100)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff([]uint64{2, 3}, receive(sub)); diff != "" {
+			t.Errorf("resumed messages mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("slow subscriber is dropped", func(t *testing.T) {
+		s := switchbot2.NewStream(switchbot2.WithStreamBufferSize(1))
+		defer s.Close()
+
+		slow, err := s.Subscribe(switchbot2.StreamFilter{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		publish(s, "01:00:5e:90:10:00", 1)
+		publish(s, "01:00:5e:90:10:00", 2)
+
+		if diff := cmp.Diff([]uint64{1}, receive(slow)); diff != "" {
+			t.Errorf("received messages mismatch (-want +got):\n%s", diff)
+		}
+		if !slow.Dropped() {
+			t.Error("slow subscriber is expected to be dropped")
+		}
+		if _, ok := <-slow.C(); ok {
+			t.Error("channel of the dropped subscriber is expected to be closed")
+		}
+	})
+}
+
+func TestStreamSSE(t *testing.T) {
+	s := switchbot2.NewStream()
+	defer s.Close()
+
+	s.PublishStateChange(switchbot2.StateChange{
+		DeviceID: "01005E901000",
+		Source:   switchbot2.StateSourcePolling,
+		State:    switchbot2.DeviceState{ID: "01005E901000", Type: switchbot2.Lock},
+	})
+
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+
+	req, err := http.NewRequest(http.MethodGet, srv.URL+"?kind=state", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Last-Event-ID", "0")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if got := resp.Header.Get("Content-Type"); got != "text/event-stream" {
+		t.Errorf("unexpected content type: %s", got)
+	}
+
+	r := bufio.NewReader(resp.Body)
+	var lines []string
+	for len(lines) < 3 {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, strings.TrimSuffix(line, "\n"))
+	}
+
+	if lines[0] != "id: 1" || lines[1] != "event: state" {
+		t.Fatalf("unexpected event: %q", lines)
+	}
+
+	var msg switchbot2.StreamMessage
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(lines[2], "data: ")), &msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg.DeviceID != "01005E901000" || msg.DeviceType != switchbot2.Lock {
+		t.Errorf("unexpected message: %+v", msg)
+	}
+}
+
+func TestStreamWebSocket(t *testing.T) {
+	s := switchbot2.NewStream()
+	defer s.Close()
+
+	event, err := switchbot2.ParseWebhookPayload([]byte(lockWebhookBody))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.PublishEvent(event)
+
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+
+	conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	fmt.Fprint(conn, "GET /?lastEventId=0 HTTP/1.1\r\nHost: example.com\r\nConnection: Upgrade\r\nUpgrade: websocket\r\nSec-WebSocket-Version: 13\r\nSec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n\r\n")
+
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("unexpected status code: %d", resp.StatusCode)
+	}
+	// the example in RFC 6455
+	if got := resp.Header.Get("Sec-WebSocket-Accept"); got != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Errorf("unexpected Sec-WebSocket-Accept: %s", got)
+	}
+
+	var header [2]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		t.Fatal(err)
+	}
+	if header[0] != 0x81 {
+		t.Fatalf("a final text frame is expected but %#x", header[0])
+	}
+
+	n := int(header[1] & 0x7f)
+	if n == 126 {
+		var b [2]byte
+		if _, err := io.ReadFull(r, b[:]); err != nil {
+			t.Fatal(err)
+		}
+		n = int(binary.BigEndian.Uint16(b[:]))
+	}
+
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		t.Fatal(err)
+	}
+
+	var msg switchbot2.StreamMessage
+	if err := json.Unmarshal(payload, &msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg.ID != 1 || msg.Kind != switchbot2.StreamKindEvent || msg.DeviceID != event.DeviceID() {
+		t.Errorf("unexpected message: %+v", msg)
+	}
+}
+
+func TestStreamWebSocketOrigin(t *testing.T) {
+	tests := []struct {
+		name   string
+		opts   []switchbot2.StreamOption
+		origin string
+		want   int
+	}{
+		{"no origin", nil, "", http.StatusSwitchingProtocols},
+		{"same origin", nil, "http://example.com", http.StatusSwitchingProtocols},
+		{"cross origin", nil, "https://evil.example.net", http.StatusForbidden},
+		{"invalid origin", nil, "null", http.StatusForbidden},
+		{"allowed origin", []switchbot2.StreamOption{switchbot2.WithStreamAllowedOrigins("https://dashboard.example.net/")}, "https://Dashboard.example.net", http.StatusSwitchingProtocols},
+		{"other origin", []switchbot2.StreamOption{switchbot2.WithStreamAllowedOrigins("https://dashboard.example.net")}, "https://evil.example.net", http.StatusForbidden},
+		{"any origin", []switchbot2.StreamOption{switchbot2.WithStreamAllowedOrigins("*")}, "https://evil.example.net", http.StatusSwitchingProtocols},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := switchbot2.NewStream(tt.opts...)
+			defer s.Close()
+
+			srv := httptest.NewServer(s)
+			defer srv.Close()
+
+			conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+			req := "GET / HTTP/1.1\r\nHost: example.com\r\nConnection: Upgrade\r\nUpgrade: websocket\r\nSec-WebSocket-Version: 13\r\nSec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n"
+			if tt.origin != "" {
+				req += "Origin: " + tt.origin + "\r\n"
+			}
+			fmt.Fprint(conn, req+"\r\n")
+
+			resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tt.want {
+				t.Errorf("status code is expected to be %d but %d", tt.want, resp.StatusCode)
+			}
+		})
+	}
+}
+
+func TestStreamWebSocketKey(t *testing.T) {
+	tests := []struct {
+		name string
+		key  string
+		want int
+	}{
+		{"valid key", "dGhlIHNhbXBsZSBub25jZQ==", http.StatusSwitchingProtocols},
+		{"no key", "", http.StatusBadRequest},
+		{"not base64", "the sample nonce", http.StatusBadRequest},
+		{"short key", "dGhlIHNhbXBsZQ==", http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := switchbot2.NewStream()
+			defer s.Close()
+
+			srv := httptest.NewServer(s)
+			defer srv.Close()
+
+			conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+			req := "GET / HTTP/1.1\r\nHost: example.com\r\nConnection: Upgrade\r\nUpgrade: websocket\r\nSec-WebSocket-Version: 13\r\n"
+			if tt.key != "" {
+				req += "Sec-WebSocket-Key: " + tt.key + "\r\n"
+			}
+			fmt.Fprint(conn, req+"\r\n")
+
+			resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tt.want {
+				t.Errorf("status code is expected to be %d but %d", tt.want, resp.StatusCode)
+			}
+		})
+	}
+}
+
+func TestStreamWebSocketProtocol(t *testing.T) {
+	tests := []struct {
+		name   string
+		frames [][]byte
+		want   []string
+	}{
+		{
+			name:   "fragmented message",
+			frames: [][]byte{clientFrame(0x01, []byte("hel")), clientFrame(0x89, []byte("ping")), clientFrame(0x80, []byte("lo")), clientFrame(0x88, nil)},
+			want:   []string{"pong ping", "close 1000"},
+		},
+		{
+			name:   "reserved bits",
+			frames: [][]byte{clientFrame(0xc1, []byte("hello"))},
+			want:   []string{"close 1002"},
+		},
+		{
+			name:   "unknown opcode",
+			frames: [][]byte{clientFrame(0x83, nil)},
+			want:   []string{"close 1002"},
+		},
+		{
+			name:   "fragmented control frame",
+			frames: [][]byte{clientFrame(0x09, []byte("ping"))},
+			want:   []string{"close 1002"},
+		},
+		{
+			name:   "long control frame",
+			frames: [][]byte{clientFrame(0x89, make([]byte, 126))},
+			want:   []string{"close 1002"},
+		},
+		{
+			name:   "continuation without message",
+			frames: [][]byte{clientFrame(0x80, []byte("hello"))},
+			want:   []string{"close 1002"},
+		},
+		{
+			name:   "message in fragmented message",
+			frames: [][]byte{clientFrame(0x01, []byte("hel")), clientFrame(0x81, []byte("hello"))},
+			want:   []string{"close 1002"},
+		},
+		{
+			name:   "unmasked frame",
+			frames: [][]byte{{0x81, 0x00}},
+			want:   []string{"close 1002"},
+		},
+		{
+			name:   "too big frame",
+			frames: [][]byte{{0x82, 0x80 | 127, 0, 0, 0, 0, 0, 1, 0, 1}},
+			want:   []string{"close 1009"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := switchbot2.NewStream()
+			defer s.Close()
+
+			srv := httptest.NewServer(s)
+			defer srv.Close()
+
+			conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+			fmt.Fprint(conn, "GET / HTTP/1.1\r\nHost: example.com\r\nConnection: Upgrade\r\nUpgrade: websocket\r\nSec-WebSocket-Version: 13\r\nSec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n\r\n")
+
+			r := bufio.NewReader(conn)
+			resp, err := http.ReadResponse(r, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != http.StatusSwitchingProtocols {
+				t.Fatalf("unexpected status code: %d", resp.StatusCode)
+			}
+
+			for _, frame := range tt.frames {
+				if _, err := conn.Write(frame); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			var got []string
+			for {
+				var header [2]byte
+				if _, err := io.ReadFull(r, header[:]); err != nil {
+					t.Fatal(err)
+				}
+				payload := make([]byte, header[1]&0x7f)
+				if _, err := io.ReadFull(r, payload); err != nil {
+					t.Fatal(err)
+				}
+
+				if header[0] == 0x88 {
+					got = append(got, fmt.Sprintf("close %d", binary.BigEndian.Uint16(payload)))
+					break
+				}
+				if header[0] == 0x8a {
+					got = append(got, "pong "+string(payload))
+				}
+			}
+
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("frames mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+// clientFrame returns a frame masked as sent by a client.
+func clientFrame(b0 byte, payload []byte) []byte {
+	mask := []byte{0x12, 0x34, 0x56, 0x78}
+
+	frame := []byte{b0}
+	switch n := len(payload); {
+	case n < 126:
+		frame = append(frame, 0x80|byte(n))
+	default:
+		frame = append(frame, 0x80|126, byte(n>>8), byte(n))
+	}
+	frame = append(frame, mask...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+
+	return frame
+}
@@ -0,0 +1,322 @@
+package switchbot
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// This file implements the minimal subset of WebSocket (RFC 6455) required
+// to push stream messages to clients: the server only sends text frames and
+// answers control frames sent by clients.
+
+const (
+	websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+	websocketOpContinuation = 0x0
+	websocketOpText         = 0x1
+	websocketOpBinary       = 0x2
+	websocketOpClose        = 0x8
+	websocketOpPing         = 0x9
+	websocketOpPong         = 0xa
+
+	websocketCloseNormal      = 1000
+	websocketCloseGoingAway   = 1001
+	websocketCloseProtocol    = 1002
+	websocketCloseTooBig      = 1009
+	websocketCloseTryAgain    = 1013
+	websocketMaxClientPayload = 64 << 10
+	websocketWriteTimeout     = 10 * time.Second
+)
+
+var (
+	errWebSocketProtocol = errors.New("websocket protocol error")
+	errWebSocketTooBig   = errors.New("websocket frame is too big")
+)
+
+func isWebSocketUpgrade(r *http.Request) bool {
+	return headerContainsToken(r.Header, "Connection", "upgrade") &&
+		headerContainsToken(r.Header, "Upgrade", "websocket")
+}
+
+func headerContainsToken(h http.Header, name, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, s := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(s), token) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// originAllowed reports whether the origin of the WebSocket upgrade request
+// is allowed. Requests without the Origin header are not sent by browsers and
+// are allowed.
+func (s *Stream) originAllowed(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" || s.allowAnyOrigin {
+		return true
+	}
+
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	if strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+
+	return s.allowedOrigins[strings.ToLower(u.Scheme+"://"+u.Host)]
+}
+
+func websocketAccept(key string) string {
+	sum := sha1.Sum([]byte(key + websocketGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+func (s *Stream) serveWebSocket(w http.ResponseWriter, r *http.Request, sub *StreamSubscription) {
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "unsupported websocket version", http.StatusUpgradeRequired)
+		return
+	}
+
+	// the key is a base64-encoded random 16-byte value
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if b, err := base64.StdEncoding.DecodeString(key); err != nil || len(b) != 16 {
+		http.Error(w, "missing or invalid Sec-WebSocket-Key header", http.StatusBadRequest)
+		return
+	}
+
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "websocket is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	conn, brw, err := hj.Hijack()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	ws := &websocketConn{conn: conn, w: brw.Writer}
+
+	if err := ws.writeRaw(fmt.Sprintf("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n\r\n", websocketAccept(key))); err != nil {
+		return
+	}
+
+	// the reader answers control frames and detects the disconnection
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		ws.readLoop(brw.Reader)
+	}()
+
+	keepAlive := s.keepAliveChan()
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-closed:
+			return
+		case msg, ok := <-sub.C():
+			if !ok {
+				code := websocketCloseGoingAway
+				if sub.Dropped() {
+					code = websocketCloseTryAgain
+				}
+				_ = ws.writeClose(code, "")
+				return
+			}
+
+			data, err := json.Marshal(msg)
+			if err != nil {
+				return
+			}
+
+			if err := ws.writeFrame(websocketOpText, data); err != nil {
+				return
+			}
+		case <-keepAlive.C:
+			if err := ws.writeFrame(websocketOpPing, nil); err != nil {
+				return
+			}
+		}
+	}
+}
+
+type websocketConn struct {
+	conn net.Conn
+
+	mu sync.Mutex
+	w  *bufio.Writer
+}
+
+func (ws *websocketConn) writeRaw(s string) error {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	if _, err := ws.w.WriteString(s); err != nil {
+		return err
+	}
+
+	return ws.w.Flush()
+}
+
+func (ws *websocketConn) writeFrame(opcode byte, payload []byte) error {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	_ = ws.conn.SetWriteDeadline(time.Now().Add(websocketWriteTimeout))
+
+	header := []byte{0x80 | opcode, 0}
+	switch n := len(payload); {
+	case n < 126:
+		header[1] = byte(n)
+	case n <= 0xffff:
+		header[1] = 126
+		header = append(header, 0, 0)
+		binary.BigEndian.PutUint16(header[2:], uint16(n))
+	default:
+		header[1] = 127
+		header = append(header, make([]byte, 8)...)
+		binary.BigEndian.PutUint64(header[2:], uint64(n))
+	}
+
+	if _, err := ws.w.Write(header); err != nil {
+		return err
+	}
+	if _, err := ws.w.Write(payload); err != nil {
+		return err
+	}
+
+	return ws.w.Flush()
+}
+
+func (ws *websocketConn) writeClose(code int, reason string) error {
+	payload := make([]byte, 2, 2+len(reason))
+	binary.BigEndian.PutUint16(payload, uint16(code))
+	payload = append(payload, reason...)
+
+	return ws.writeFrame(websocketOpClose, payload)
+}
+
+func (ws *websocketConn) readLoop(r *bufio.Reader) {
+	switch err := ws.read(r); {
+	case errors.Is(err, errWebSocketProtocol):
+		_ = ws.writeClose(websocketCloseProtocol, "")
+	case errors.Is(err, errWebSocketTooBig):
+		_ = ws.writeClose(websocketCloseTooBig, "")
+	}
+}
+
+// read reads the frames sent by the client until the client closes the
+// connection or violates the protocol.
+func (ws *websocketConn) read(r *bufio.Reader) error {
+	// fragmented is true while the continuation frames of a message are expected
+	var fragmented bool
+	for {
+		fin, opcode, payload, err := readWebSocketFrame(r, websocketMaxClientPayload)
+		if err != nil {
+			return err
+		}
+
+		switch opcode {
+		case websocketOpContinuation:
+			if !fragmented {
+				return errWebSocketProtocol
+			}
+			fragmented = !fin
+		case websocketOpText, websocketOpBinary:
+			// messages sent by clients are ignored, but must not start
+			// before the previous one is finished
+			if fragmented {
+				return errWebSocketProtocol
+			}
+			fragmented = !fin
+		case websocketOpPing:
+			if err := ws.writeFrame(websocketOpPong, payload); err != nil {
+				return err
+			}
+		case websocketOpClose:
+			_ = ws.writeClose(websocketCloseNormal, "")
+			return nil
+		}
+	}
+}
+
+// readWebSocketFrame reads a frame sent by a client, and reports whether it is
+// the final fragment of a message. Clients must mask their frames, and must
+// not set the reserved bits as no extension is negotiated. Control frames must
+// not be fragmented, and their payload must be 125 bytes at most.
+func readWebSocketFrame(r io.Reader, maxPayload uint64) (bool, byte, []byte, error) {
+	var header [2]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return false, 0, nil, err
+	}
+
+	fin := header[0]&0x80 != 0
+	opcode := header[0] & 0x0f
+	if header[0]&0x70 != 0 || header[1]&0x80 == 0 {
+		return false, 0, nil, errWebSocketProtocol
+	}
+
+	switch opcode {
+	case websocketOpContinuation, websocketOpText, websocketOpBinary:
+	case websocketOpClose, websocketOpPing, websocketOpPong:
+		if !fin || header[1]&0x7f > 125 {
+			return false, 0, nil, errWebSocketProtocol
+		}
+	default:
+		return false, 0, nil, errWebSocketProtocol
+	}
+
+	n := uint64(header[1] & 0x7f)
+	switch n {
+	case 126:
+		var b [2]byte
+		if _, err := io.ReadFull(r, b[:]); err != nil {
+			return false, 0, nil, err
+		}
+		n = uint64(binary.BigEndian.Uint16(b[:]))
+	case 127:
+		var b [8]byte
+		if _, err := io.ReadFull(r, b[:]); err != nil {
+			return false, 0, nil, err
+		}
+		n = binary.BigEndian.Uint64(b[:])
+	}
+
+	if n > maxPayload {
+		return false, 0, nil, errWebSocketTooBig
+	}
+
+	var mask [4]byte
+	if _, err := io.ReadFull(r, mask[:]); err != nil {
+		return false, 0, nil, err
+	}
+
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return false, 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+
+	return fin, opcode, payload, nil
+}
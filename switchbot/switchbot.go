@@ -54,6 +54,8 @@ const (
 	Bot PhysicalDeviceType = "Bot"
 	// Curtain is SwitchBot Curtain Model No. W0701600
 	Curtain PhysicalDeviceType = "Curtain"
+	// Curtain3 is SwitchBot Curtain 3 Model No. W2400000
+	Curtain3 PhysicalDeviceType = "Curtain3"
 	// Plug is SwitchBot Plug Model No. SP11
 	Plug PhysicalDeviceType = "Plug"
 	// Meter is SwitchBot Thermometer and Hygrometer Model No. SwitchBot MeterTH S1
@@ -64,10 +66,16 @@ const (
 	MeterPlusUS PhysicalDeviceType = "Meter Plus (US)"
 	// WoIOSensor is SwitchBot Indoor/Outdoor Thermo-Hygrometer Model No. W3400010
 	WoIOSensor PhysicalDeviceType = "WoIOSensor"
+	// MeterPro is SwitchBot Thermometer and Hygrometer Pro Model No. W4900000
+	MeterPro PhysicalDeviceType = "MeterPro"
+	// MeterProCO2 is SwitchBot CO2 Monitor (Thermometer and Hygrometer Pro CO2) Model No. W4900010
+	MeterProCO2 PhysicalDeviceType = "MeterPro(CO2)"
 	// Humidifier is SwitchBot Humidifier Model No. W0801801
 	Humidifier PhysicalDeviceType = "Humidifier"
 	// SmartFan is SwitchBot Smart Fan Model No. W0601100
 	SmartFan PhysicalDeviceType = "Smart Fan"
+	// BatteryCirculatorFan is SwitchBot Battery Circulator Fan Model No. W3800510
+	BatteryCirculatorFan PhysicalDeviceType = "Battery Circulator Fan"
 	// StripLight is SwitchBot LED Strip Light Model No. W1701100
 	StripLight PhysicalDeviceType = "Strip Light"
 	// PlugMiniUS is SwitchBot Plug Mini (US) Model No. W1901400
@@ -84,6 +92,8 @@ const (
 	RobotVacuumCleanerS1Plus PhysicalDeviceType = "Robot Vacuum Cleaner S1 Plus"
 	// WoSweeperMini is SwitchBot Robot Vacuum Cleaner K10+ Model No. W3011020
 	WoSweeperMini PhysicalDeviceType = "WoSweeperMini"
+	// WoSweeperMiniPro is SwitchBot Robot Vacuum Cleaner K10+ Pro Model No. W3011026
+	WoSweeperMiniPro PhysicalDeviceType = "Robot Vacuum Cleaner K10+ Pro"
 	// MotionSensor is SwitchBot Motion Sensor Model No. W1101500
 	MotionSensor PhysicalDeviceType = "Motion Sensor"
 	// ContactSensor is SwitchBot Contact Sensor Model No. W1201500
@@ -1,13 +1,15 @@
 package switchbot
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"reflect"
+	"strings"
+	"sync"
 )
 
 type WebhookService struct {
@@ -28,10 +30,27 @@ type webhookSetupRequest struct {
 	DeviceList string `json:"deviceList,omitempty"` // currently only ALL is supported
 }
 
-type webhookSetupResponse struct {
-	StatusCode int         `json:"statusCode"`
-	Body       interface{} `json:"body"`
-	Message    string      `json:"message"`
+type webhookResponse struct {
+	StatusCode int             `json:"statusCode"`
+	Message    string          `json:"message"`
+	Body       json.RawMessage `json:"body"`
+}
+
+// decodeWebhookResponse decodes the response of webhook APIs and returns
+// an error if the status code is not successful.
+func decodeWebhookResponse(resp *httpResponse, api string) (*webhookResponse, error) {
+	var response webhookResponse
+	if err := resp.DecodeJSON(&response); err != nil {
+		return nil, err
+	}
+
+	if response.StatusCode == 190 {
+		return nil, fmt.Errorf("undocumented error %d occurred for %s API: %s", response.StatusCode, api, response.Message)
+	} else if response.StatusCode != 100 {
+		return nil, fmt.Errorf("unknown error %d from %s API: %s", response.StatusCode, api, response.Message)
+	}
+
+	return &response, nil
 }
 
 // Setup configures the url that all the webhook events will be sent to.
@@ -55,6 +74,10 @@ func (svc *WebhookService) Setup(ctx context.Context, url, deviceList string) er
 	}
 	defer resp.Close()
 
+	if _, err := decodeWebhookResponse(resp, "setupWebhook"); err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -70,25 +93,10 @@ const (
 	QueryDetails WebhookQueryActionType = "queryDetails"
 )
 
-type webhookQueryResponse struct {
-}
-
-type webhookQueryUrlResponse struct {
-	StatusCode int                         `json:"statusCode"`
-	Message    string                      `json:"message"`
-	Body       webhookQueryUrlResponseBody `json:"body"`
-}
-
 type webhookQueryUrlResponseBody struct {
 	URLs []string `json:"urls"`
 }
 
-type webhookQueryDetailsResponse struct {
-	StatusCode int                   `json:"statusCode"`
-	Message    string                `json:"message"`
-	Body       []WebhookQueryDetails `json:"body"`
-}
-
 type WebhookQueryDetails struct {
 	URL        string `json:"url"`
 	CreateTime int64  `json:"createTime"`
@@ -97,9 +105,17 @@ type WebhookQueryDetails struct {
 	Enable     bool   `json:"enable"`
 }
 
+// WebhookQueryResult is the result of (*WebhookService).Query.
+type WebhookQueryResult struct {
+	// URLs is the list of configured webhook URLs, which is set for QueryURL action.
+	URLs []string
+	// Details is the list of webhook configurations, which is set for QueryDetails action.
+	Details []WebhookQueryDetails
+}
+
 // Query retrieves the current configuration info of the webhook.
 // The second argument `url` is required for QueryDetails action type.
-func (svc *WebhookService) Query(ctx context.Context, action WebhookQueryActionType, url string) error {
+func (svc *WebhookService) Query(ctx context.Context, action WebhookQueryActionType, url string) (*WebhookQueryResult, error) {
 	const path = "/v1.1/webhook/queryWebhook"
 
 	req := webhookQueryRequest{
@@ -109,7 +125,7 @@ func (svc *WebhookService) Query(ctx context.Context, action WebhookQueryActionT
 	switch action {
 	case QueryDetails:
 		if url == "" {
-			return errors.New("URL need to be specified when the action is queryDetails")
+			return nil, errors.New("URL need to be specified when the action is queryDetails")
 		}
 
 		req.URLs = []string{url}
@@ -117,76 +133,63 @@ func (svc *WebhookService) Query(ctx context.Context, action WebhookQueryActionT
 
 	resp, err := svc.c.post(ctx, path, req)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer resp.Close()
 
-	return nil
-}
+	response, err := decodeWebhookResponse(resp, "queryWebhook")
+	if err != nil {
+		return nil, err
+	}
 
-// QueryUrl retrieves the current url configuration info of the webhook.
-func (svc *WebhookService) QueryUrl(ctx context.Context) (string, error) {
-	const path = "/v1.1/webhook/queryWebhook"
+	var result WebhookQueryResult
 
-	req := webhookQueryRequest{
-		Action: QueryURL,
+	if len(response.Body) == 0 || string(response.Body) == "null" {
+		return &result, nil
 	}
 
-	resp, err := svc.c.post(ctx, path, req)
-	if err != nil {
-		return "", err
+	switch action {
+	case QueryURL:
+		var body webhookQueryUrlResponseBody
+		if err := json.Unmarshal(response.Body, &body); err != nil {
+			return nil, fmt.Errorf("decoding JSON data: %w", err)
+		}
+		result.URLs = body.URLs
+	case QueryDetails:
+		if err := json.Unmarshal(response.Body, &result.Details); err != nil {
+			return nil, fmt.Errorf("decoding JSON data: %w", err)
+		}
 	}
-	defer resp.Close()
 
-	var response webhookQueryUrlResponse
-	if err := resp.DecodeJSON(&response); err != nil {
-		return "", err
-	}
+	return &result, nil
+}
 
-	if response.StatusCode == 190 {
-		return "", fmt.Errorf("undocumented error %d occurred for queryWebhook API: %s", response.StatusCode, response.Message)
-	} else if response.StatusCode != 100 {
-		return "", fmt.Errorf("unknown error %d from queryWebhook API: %s", response.StatusCode, response.Message)
+// QueryUrl retrieves the current url configuration info of the webhook.
+func (svc *WebhookService) QueryUrl(ctx context.Context) (string, error) {
+	result, err := svc.Query(ctx, QueryURL, "")
+	if err != nil {
+		return "", err
 	}
 
-	if len(response.Body.URLs) < 1 {
+	if len(result.URLs) < 1 {
 		return "", errors.New("queryWebhook API response urls is empty")
 	}
 
-	return response.Body.URLs[0], nil
+	return result.URLs[0], nil
 }
 
 // QueryDetails retrieves the current details configuration info of the webhook.
 func (svc *WebhookService) QueryDetails(ctx context.Context, url string) (*WebhookQueryDetails, error) {
-	const path = "/v1.1/webhook/queryWebhook"
-
-	req := webhookQueryRequest{
-		Action: QueryDetails,
-	}
-	req.URLs = []string{url}
-
-	resp, err := svc.c.post(ctx, path, req)
+	result, err := svc.Query(ctx, QueryDetails, url)
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Close()
 
-	var response webhookQueryDetailsResponse
-	if err := resp.DecodeJSON(&response); err != nil {
-		return nil, err
-	}
-
-	if response.StatusCode == 190 {
-		return nil, fmt.Errorf("undocumented error %d occurred for queryWebhook API: %s", response.StatusCode, response.Message)
-	} else if response.StatusCode != 100 {
-		return nil, fmt.Errorf("unknown error %d from queryWebhook API: %s", response.StatusCode, response.Message)
-	}
-
-	if len(response.Body) < 1 {
+	if len(result.Details) < 1 {
 		return nil, errors.New("queryWebhook API response body is empty")
 	}
 
-	return &response.Body[0], nil
+	return &result.Details[0], nil
 }
 
 type webhookUpdateRequest struct {
@@ -217,6 +220,10 @@ func (svc *WebhookService) Update(ctx context.Context, url string, enable bool)
 	}
 	defer resp.Close()
 
+	if _, err := decodeWebhookResponse(resp, "updateWebhook"); err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -240,52 +247,95 @@ func (svc *WebhookService) Delete(ctx context.Context, url string) error {
 	}
 	defer resp.Close()
 
+	if _, err := decodeWebhookResponse(resp, "deleteWebhook"); err != nil {
+		return err
+	}
+
 	return nil
 }
 
-func deviceTypeFromWebhookRequest(r *http.Request) (string, error) {
-	var rawBody bytes.Buffer
-	var deviceTypeBody struct {
-		Context struct {
-			DeviceType string `json:"deviceType"`
-		} `json:"context"`
+// WebhookEnsureReport describes the changes made by (*WebhookService).Ensure.
+type WebhookEnsureReport struct {
+	// URL is the webhook URL ensured to be configured.
+	URL string
+	// Deleted is the list of stale webhook URLs which are deleted.
+	Deleted []string
+	// Created reports whether the URL is newly configured.
+	Created bool
+	// Enabled reports whether the URL has been disabled and is re-enabled.
+	Enabled bool
+}
+
+// Changed reports whether any change has been made.
+func (r *WebhookEnsureReport) Changed() bool {
+	return len(r.Deleted) > 0 || r.Created || r.Enabled
+}
+
+// Ensure makes the given url the only webhook URL configured and enabled.
+// Stale URLs are deleted, and the given url is configured if not yet, or
+// re-enabled if it has been disabled. It is safe to call Ensure repeatedly,
+// e.g. on every deployment.
+// The returned report describes what has been changed. The report is
+// returned with the changes made so far even if an error occurs.
+func (svc *WebhookService) Ensure(ctx context.Context, url string) (*WebhookEnsureReport, error) {
+	report := &WebhookEnsureReport{URL: url}
+
+	current, err := svc.Query(ctx, QueryURL, "")
+	if err != nil {
+		return report, err
 	}
 
-	if err := json.NewDecoder(io.TeeReader(r.Body, &rawBody)).Decode(&deviceTypeBody); err != nil {
-		return "", err
+	var exists bool
+	for _, u := range current.URLs {
+		if u == url {
+			exists = true
+			continue
+		}
+
+		if err := svc.Delete(ctx, u); err != nil {
+			return report, fmt.Errorf("deleting stale webhook URL %s: %w", u, err)
+		}
+		report.Deleted = append(report.Deleted, u)
 	}
 
-	r.Body = io.NopCloser(&rawBody)
+	if !exists {
+		if err := svc.Setup(ctx, url, "ALL"); err != nil {
+			return report, err
+		}
+		report.Created = true
 
-	return deviceTypeBody.Context.DeviceType, nil
-}
+		return report, nil
+	}
 
-type MotionSensorEvent struct {
-	EventType    string                   `json:"eventType"`
-	EventVersion string                   `json:"eventVersion"`
-	Context      MotionSensorEventContext `json:"context"`
+	details, err := svc.QueryDetails(ctx, url)
+	if err != nil {
+		return report, err
+	}
+
+	if !details.Enable {
+		if err := svc.Update(ctx, url, true); err != nil {
+			return report, err
+		}
+		report.Enabled = true
+	}
+
+	return report, nil
 }
 
+type MotionSensorEvent = WebhookEvent[MotionSensorEventContext]
+
 type MotionSensorEventContext struct {
-	DeviceType   string `json:"deviceType"`
-	DeviceMac    string `json:"deviceMac"`
-	TimeOfSample int64  `json:"timeOfSample"`
+	EventContext
 
 	// the motion state of the device, "DETECTED" stands for motion is detected;
 	// "NOT_DETECTED" stands for motion has not been detected for some time
 	DetectionState string `json:"detectionState"`
 }
 
-type ContactSensorEvent struct {
-	EventType    string                    `json:"eventType"`
-	EventVersion string                    `json:"eventVersion"`
-	Context      ContactSensorEventContext `json:"context"`
-}
+type ContactSensorEvent = WebhookEvent[ContactSensorEventContext]
 
 type ContactSensorEventContext struct {
-	DeviceType   string `json:"deviceType"`
-	DeviceMac    string `json:"deviceMac"`
-	TimeOfSample int64  `json:"timeOfSample"`
+	EventContext
 
 	// the motion state of the device, "DETECTED" stands for motion is detected;
 	// "NOT_DETECTED" stands for motion has not been detected for some time
@@ -298,95 +348,62 @@ type ContactSensorEventContext struct {
 	OpenState string `json:"openState"`
 }
 
-type MeterEvent struct {
-	EventType    string            `json:"eventType"`
-	EventVersion string            `json:"eventVersion"`
-	Context      MeterEventContext `json:"context"`
-}
+type MeterEvent = WebhookEvent[MeterEventContext]
 
 type MeterEventContext struct {
-	DeviceType   string `json:"deviceType"`
-	DeviceMac    string `json:"deviceMac"`
-	TimeOfSample int64  `json:"timeOfSample"`
+	EventContext
 
 	Temperature float64 `json:"temperature"`
 	Scale       string  `json:"scale"`
 	Humidity    int     `json:"humidity"`
 }
 
-type MeterPlusEvent struct {
-	EventType    string                `json:"eventType"`
-	EventVersion string                `json:"eventVersion"`
-	Context      MeterPlusEventContext `json:"context"`
-}
+type MeterPlusEvent = WebhookEvent[MeterPlusEventContext]
 
 type MeterPlusEventContext struct {
-	DeviceType   string `json:"deviceType"`
-	DeviceMac    string `json:"deviceMac"`
-	TimeOfSample int64  `json:"timeOfSample"`
+	EventContext
 
 	Temperature float64 `json:"temperature"`
 	Scale       string  `json:"scale"`
 	Humidity    int     `json:"humidity"`
 }
 
-type LockEvent struct {
-	EventType    string           `json:"eventType"`
-	EventVersion string           `json:"eventVersion"`
-	Context      LockEventContext `json:"context"`
-}
+type LockEvent = WebhookEvent[LockEventContext]
 
 type LockEventContext struct {
-	DeviceType   string `json:"deviceType"`
-	DeviceMac    string `json:"deviceMac"`
-	TimeOfSample int64  `json:"timeOfSample"`
+	EventContext
 
 	// the state of the device, "LOCKED" stands for the motor is rotated to locking position;
 	// "UNLOCKED" stands for the motor is rotated to unlocking position; "JAMMED" stands for
 	// the motor is jammed while rotating
 	LockState string `json:"lockState"`
+	// the current battery level, 0-100, only reported by some models and
+	// nil if not reported
+	Battery *int `json:"battery,omitempty"`
 }
 
-type IndoorCamEvent struct {
-	EventType    string                `json:"eventType"`
-	EventVersion string                `json:"eventVersion"`
-	Context      IndoorCamEventContext `json:"context"`
-}
+type IndoorCamEvent = WebhookEvent[IndoorCamEventContext]
 
 type IndoorCamEventContext struct {
-	DeviceType   string `json:"deviceType"`
-	DeviceMac    string `json:"deviceMac"`
-	TimeOfSample int64  `json:"timeOfSample"`
+	EventContext
 
 	// the detection state of the device, "DETECTED" stands for motion is detected
 	DetectionState string `json:"detectionState"`
 }
 
-type PanTiltCamEvent struct {
-	EventType    string                 `json:"eventType"`
-	EventVersion string                 `json:"eventVersion"`
-	Context      PanTiltCamEventContext `json:"context"`
-}
+type PanTiltCamEvent = WebhookEvent[PanTiltCamEventContext]
 
 type PanTiltCamEventContext struct {
-	DeviceType   string `json:"deviceType"`
-	DeviceMac    string `json:"deviceMac"`
-	TimeOfSample int64  `json:"timeOfSample"`
+	EventContext
 
 	// the detection state of the device, "DETECTED" stands for motion is detected
 	DetectionState string `json:"detectionState"`
 }
 
-type ColorBulbEvent struct {
-	EventType    string                `json:"eventType"`
-	EventVersion string                `json:"eventVersion"`
-	Context      ColorBulbEventContext `json:"context"`
-}
+type ColorBulbEvent = WebhookEvent[ColorBulbEventContext]
 
 type ColorBulbEventContext struct {
-	DeviceType   string `json:"deviceType"`
-	DeviceMac    string `json:"deviceMac"`
-	TimeOfSample int64  `json:"timeOfSample"`
+	EventContext
 
 	// the current power state of the device, "ON" or "OFF"
 	PowerState PowerState `json:"powerState"`
@@ -398,16 +415,10 @@ type ColorBulbEventContext struct {
 	ColorTemperature int `json:"colorTemperature"`
 }
 
-type StripLightEvent struct {
-	EventType    string                 `json:"eventType"`
-	EventVersion string                 `json:"eventVersion"`
-	Context      StripLightEventContext `json:"context"`
-}
+type StripLightEvent = WebhookEvent[StripLightEventContext]
 
 type StripLightEventContext struct {
-	DeviceType   string `json:"deviceType"`
-	DeviceMac    string `json:"deviceMac"`
-	TimeOfSample int64  `json:"timeOfSample"`
+	EventContext
 
 	// the current power state of the device, "ON" or "OFF"
 	PowerState PowerState `json:"powerState"`
@@ -417,46 +428,28 @@ type StripLightEventContext struct {
 	Color string `json:"color"`
 }
 
-type PlugMiniJPEvent struct {
-	EventType    string                 `json:"eventType"`
-	EventVersion string                 `json:"eventVersion"`
-	Context      PlugMiniJPEventContext `json:"context"`
-}
+type PlugMiniJPEvent = WebhookEvent[PlugMiniJPEventContext]
 
 type PlugMiniJPEventContext struct {
-	DeviceType   string `json:"deviceType"`
-	DeviceMac    string `json:"deviceMac"`
-	TimeOfSample int64  `json:"timeOfSample"`
+	EventContext
 
 	// the current power state of the device, "ON" or "OFF"
 	PowerState PowerState `json:"powerState"`
 }
 
-type PlugMiniUSEvent struct {
-	EventType    string                 `json:"eventType"`
-	EventVersion string                 `json:"eventVersion"`
-	Context      PlugMiniUSEventContext `json:"context"`
-}
+type PlugMiniUSEvent = WebhookEvent[PlugMiniUSEventContext]
 
 type PlugMiniUSEventContext struct {
-	DeviceType   string `json:"deviceType"`
-	DeviceMac    string `json:"deviceMac"`
-	TimeOfSample int64  `json:"timeOfSample"`
+	EventContext
 
 	// the current power state of the device, "ON" or "OFF"
 	PowerState PowerState `json:"powerState"`
 }
 
-type SweeperEvent struct {
-	EventType    string              `json:"eventType"`
-	EventVersion string              `json:"eventVersion"`
-	Context      SweeperEventContext `json:"context"`
-}
+type SweeperEvent = WebhookEvent[SweeperEventContext]
 
 type SweeperEventContext struct {
-	DeviceType   string `json:"deviceType"`
-	DeviceMac    string `json:"deviceMac"`
-	TimeOfSample int64  `json:"timeOfSample"`
+	EventContext
 
 	// the working status of the device, "StandBy", "Clearing",
 	// "Paused", "GotoChargeBase", "Charging", "ChargeDone",
@@ -468,16 +461,10 @@ type SweeperEventContext struct {
 	Battery int `json:"battery"`
 }
 
-type CeilingEvent struct {
-	EventType    string              `json:"eventType"`
-	EventVersion string              `json:"eventVersion"`
-	Context      CeilingEventContext `json:"context"`
-}
+type CeilingEvent = WebhookEvent[CeilingEventContext]
 
 type CeilingEventContext struct {
-	DeviceType   string `json:"deviceType"`
-	DeviceMac    string `json:"deviceMac"`
-	TimeOfSample int64  `json:"timeOfSample"`
+	EventContext
 
 	// ON/OFF state
 	PowerState PowerState `json:"powerState"`
@@ -487,16 +474,10 @@ type CeilingEventContext struct {
 	ColorTemperature int `json:"colorTemperature"`
 }
 
-type KeypadEvent struct {
-	EventType    string             `json:"eventType"`
-	EventVersion string             `json:"eventVersion"`
-	Context      KeypadEventContext `json:"context"`
-}
+type KeypadEvent = WebhookEvent[KeypadEventContext]
 
 type KeypadEventContext struct {
-	DeviceType   string `json:"deviceType"`
-	DeviceMac    string `json:"deviceMac"`
-	TimeOfSample int64  `json:"timeOfSample"`
+	EventContext
 
 	// the name fo the command being sent
 	EventName string `json:"eventName"`
@@ -504,114 +485,405 @@ type KeypadEventContext struct {
 	CommandID string `json:"commandId"`
 	// the result of the command, success, failed, or timeout
 	Result string `json:"result"`
+	// the current battery level, 0-100, only reported by battery reports and
+	// nil if not reported
+	Battery *int `json:"battery,omitempty"`
+}
+
+type BotEvent = WebhookEvent[BotEventContext]
+
+type BotEventContext struct {
+	EventContext
+
+	// the current power state of the device, "on" or "off"
+	Power string `json:"power"`
+	// the current battery level, 0-100
+	Battery int `json:"battery"`
+	// "pressMode", "switchMode", or "customizeMode"
+	DeviceMode string `json:"deviceMode"`
+}
+
+type CurtainEvent = WebhookEvent[CurtainEventContext]
+
+type CurtainEventContext struct {
+	EventContext
+
+	// determines if the open position and the close position of a device have been properly calibrated or not
+	IsCalibrated bool `json:"calibrate"`
+	// determines if a curtain is paired with or grouped with another curtain or not
+	IsGrouped bool `json:"group"`
+	// the percentage of the distance between the calibrated open position and closed position, 0-100
+	SlidePosition int `json:"slidePosition"`
+	// the current battery level, 0-100
+	Battery int `json:"battery"`
+}
+
+type BlindTiltEvent = WebhookEvent[BlindTiltEventContext]
+
+type BlindTiltEventContext struct {
+	EventContext
+
+	// the current firmware version
+	Version DeviceVersion `json:"version"`
+	// determines if the open position and the close position of a device have been properly calibrated or not
+	IsCalibrated bool `json:"calibrate"`
+	// determines if a blind tilt is grouped with another blind tilt or not
+	IsGrouped bool `json:"group"`
+	// the opening direction of the blind tilt, "up" or "down"
+	Direction string `json:"direction"`
+	// the current position, 0-100
+	SlidePosition int `json:"slidePosition"`
+	// the current battery level, 0-100
+	Battery int `json:"battery"`
+}
+
+type Hub2Event = WebhookEvent[Hub2EventContext]
+
+type Hub2EventContext struct {
+	EventContext
+
+	// the current temperature reading
+	Temperature float64 `json:"temperature"`
+	// the current humidity reading in percentage
+	Humidity int `json:"humidity"`
+	// the level of illuminance of the ambience light, 1-20
+	LightLevel int `json:"lightLevel"`
+	// the current temperature unit being used, "CELSIUS" or "FAHRENHEIT"
+	Scale string `json:"scale"`
+}
+
+type OutdoorMeterEvent = WebhookEvent[OutdoorMeterEventContext]
+
+type OutdoorMeterEventContext struct {
+	EventContext
+
+	Temperature float64 `json:"temperature"`
+	Scale       string  `json:"scale"`
+	Humidity    int     `json:"humidity"`
+	// the current battery level, 0-100
+	Battery int `json:"battery"`
+}
+
+type MeterProEvent = WebhookEvent[MeterProEventContext]
+
+type MeterProEventContext struct {
+	EventContext
+
+	Temperature float64 `json:"temperature"`
+	Scale       string  `json:"scale"`
+	Humidity    int     `json:"humidity"`
+	// the CO2 concentration in ppm, only reported by Meter Pro (CO2)
+	CO2 int `json:"CO2"`
+	// the current battery level, 0-100
+	Battery int `json:"battery"`
+}
+
+type HumidifierEvent = WebhookEvent[HumidifierEventContext]
+
+type HumidifierEventContext struct {
+	EventContext
+
+	// the current power state of the device, "ON" or "OFF"
+	PowerState PowerState `json:"powerState"`
+	// the current humidity reading in percentage
+	Humidity int `json:"humidity"`
+	// the current temperature reading
+	Temperature float64 `json:"temperature"`
+	// the atomization efficiency in percentage
+	NebulizationEfficiency int `json:"nebulizationEfficiency"`
+	// determines if the humidifier is in auto mode or not
+	IsAuto bool `json:"auto"`
+	// determines if the child lock is on or not
+	IsChildLock bool `json:"childLock"`
+	// determines if the sound is muted or not
+	IsSound bool `json:"sound"`
+	// determines if the water tank is empty or not
+	IsLackWater bool `json:"lackWater"`
+}
+
+type SmartFanEvent = WebhookEvent[SmartFanEventContext]
+
+type SmartFanEventContext struct {
+	EventContext
+
+	// the current power state of the device, "ON" or "OFF"
+	PowerState PowerState `json:"powerState"`
+	// the fan mode, 1 for standard and 2 for natural
+	FanMode int `json:"mode"`
+	// the fan speed, 1-4
+	FanSpeed int `json:"speed"`
+	// determines if the fan is swinging or not
+	IsShaking bool `json:"shaking"`
+	// the fan's swing direction
+	ShakeCenter int `json:"shakeCenter"`
+	// the fan's swing range, 0-120
+	ShakeRange int `json:"shakeRange"`
+}
+
+type BatteryCirculatorFanEvent = WebhookEvent[BatteryCirculatorFanEventContext]
+
+type BatteryCirculatorFanEventContext struct {
+	EventContext
+
+	// the current firmware version
+	Version DeviceVersion `json:"version"`
+	// the fan mode, "direct", "natural", "sleep", or "baby"
+	Mode string `json:"mode"`
+	// the current battery level, 0-100
+	Battery int `json:"battery"`
+	// the current power state of the device, "ON" or "OFF"
+	PowerState PowerState `json:"powerState"`
+	// the night light mode, 1 for turned off, 2 for mode 1 and 3 for mode 2
+	NightStatus int `json:"nightStatus"`
+	// determines if the horizontal oscillation is "on" or "off"
+	Oscillation string `json:"oscillation"`
+	// determines if the vertical oscillation is "on" or "off"
+	VerticalOscillation string `json:"verticalOscillation"`
+	// the battery charge status, "charging" or "uncharged"
+	ChargingStatus string `json:"chargingStatus"`
+	// the fan speed, 1-100
+	FanSpeed int `json:"fanSpeed"`
+}
+
+// ErrUnknownWebhookDeviceType is returned when a webhook request is sent from
+// a device type which is not supported by this package and strict parsing
+// is enabled.
+var ErrUnknownWebhookDeviceType = errors.New("unknown device type")
+
+// ErrMissingWebhookDeviceType is returned when a webhook request has no
+// deviceType in its context, regardless of strict parsing.
+var ErrMissingWebhookDeviceType = errors.New("missing device type")
+
+// RawEvent is a webhook event sent from a device type which is not supported
+// by this package. It keeps the whole request body so that the event can be
+// handled without waiting for this package to be updated.
+type RawEvent struct {
+	EventType    string          `json:"eventType"`
+	EventVersion string          `json:"eventVersion"`
+	Context      RawEventContext `json:"context"`
+
+	// Payload is the whole request body of the webhook request.
+	Payload json.RawMessage `json:"-"`
+	// Extra holds the fields in the event context other than
+	// deviceType, deviceMac and timeOfSample.
+	Extra map[string]json.RawMessage `json:"-"`
+}
+
+type RawEventContext struct {
+	DeviceType   string `json:"deviceType"`
+	DeviceMac    string `json:"deviceMac"`
+	TimeOfSample int64  `json:"timeOfSample"`
+}
+
+type parseConfig struct {
+	strict bool
+}
+
+// ParseOption configures how webhook requests are parsed.
+type ParseOption func(*parseConfig)
+
+// StrictParsing makes the parser return ErrUnknownWebhookDeviceType for
+// the device types which are not supported by this package, instead of
+// returning a *RawEvent.
+func StrictParsing() ParseOption {
+	return func(cfg *parseConfig) {
+		cfg.strict = true
+	}
+}
+
+// ParseWebhookRequest parses a webhook request sent by SwitchBot and returns
+// the event as one of the *XxxEvent types.
+// Use ParseWebhookEvent to get the event as an Event interface value.
+func ParseWebhookRequest(r *http.Request, opts ...ParseOption) (interface{}, error) {
+	event, err := ParseWebhookEvent(r, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	return event, nil
 }
 
-func ParseWebhookRequest(r *http.Request) (interface{}, error) {
-	deviceType, err := deviceTypeFromWebhookRequest(r)
+// ParseWebhookEvent parses a webhook request sent by SwitchBot and returns
+// the event. The dynamic type of the returned event is one of the *XxxEvent
+// types according to the deviceType of the request, or *RawEvent if the
+// deviceType is not supported by this package.
+func ParseWebhookEvent(r *http.Request, opts ...ParseOption) (Event, error) {
+	body, err := io.ReadAll(r.Body)
 	if err != nil {
 		return nil, err
 	}
 
+	return ParseWebhookPayload(body, opts...)
+}
+
+// ParseWebhookPayload parses a request body of a webhook request sent by SwitchBot.
+func ParseWebhookPayload(data []byte, opts ...ParseOption) (Event, error) {
+	var cfg parseConfig
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	var rawBody struct {
+		Context map[string]json.RawMessage `json:"context"`
+	}
+
+	if err := json.Unmarshal(data, &rawBody); err != nil {
+		return nil, err
+	}
+
+	var deviceType string
+	if v, ok := rawBody.Context["deviceType"]; ok {
+		if err := json.Unmarshal(v, &deviceType); err != nil {
+			return nil, fmt.Errorf("decoding deviceType: %w", err)
+		}
+	}
+
+	if deviceType == "" {
+		return nil, ErrMissingWebhookDeviceType
+	}
+
+	var event Event
+
 	switch deviceType {
 	case "WoPresence":
 		// Motion Sensor
-		var event MotionSensorEvent
-		if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
-			return nil, err
-		}
-		return &event, nil
+		event = &MotionSensorEvent{}
 	case "WoContact":
 		// Contact Sensor
-		var event ContactSensorEvent
-		if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
-			return nil, err
-		}
-		return &event, nil
-	case "WoLock":
-		// Lock
-		var event LockEvent
-		if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
-			return nil, err
-		}
-		return &event, nil
+		event = &ContactSensorEvent{}
+	case "WoLock", "WoLockPro":
+		// Lock, Lock Pro
+		event = &LockEvent{}
 	case "WoCamera":
 		// Indoor Cam
-		var event IndoorCamEvent
-		if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
-			return nil, err
-		}
-		return &event, nil
+		event = &IndoorCamEvent{}
 	case "WoPanTiltCam":
 		// Pan/Tilt Cam
-		var event PanTiltCamEvent
-		if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
-			return nil, err
-		}
-		return &event, nil
+		event = &PanTiltCamEvent{}
 	case "WoBulb":
 		// Color Bulb
-		var event ColorBulbEvent
-		if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
-			return nil, err
-		}
-		return &event, nil
+		event = &ColorBulbEvent{}
 	case "WoStrip":
 		// LED Strip Light
-		var event StripLightEvent
-		if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
-			return nil, err
-		}
-		return &event, nil
+		event = &StripLightEvent{}
 	case "WoPlugUS":
 		// Plug Mini (US)
-		var event PlugMiniUSEvent
-		if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
-			return nil, err
-		}
-		return &event, nil
+		event = &PlugMiniUSEvent{}
 	case "WoPlugJP":
 		// Plug Mini (JP)
-		var event PlugMiniJPEvent
-		if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
-			return nil, err
-		}
-		return &event, nil
+		event = &PlugMiniJPEvent{}
 	case "WoMeter":
 		// Meter
-		var event MeterEvent
-		if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
-			return nil, err
-		}
-		return &event, nil
+		event = &MeterEvent{}
 	case "WoMeterPlus":
 		// Meter Plus
-		var event MeterPlusEvent
-		if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
-			return nil, err
-		}
-		return &event, nil
-	case "WoSweeper", "WoSweeperPlus":
+		event = &MeterPlusEvent{}
+	case "WoSweeper", "WoSweeperPlus", "WoSweeperMini", "WoSweeperMiniPro":
 		// Cleaner
-		var event SweeperEvent
-		if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
-			return nil, err
-		}
-		return &event, nil
+		event = &SweeperEvent{}
 	case "WoCeiling", "WoCeilingPro":
 		// Ceiling lights
-		var event CeilingEvent
-		if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
-			return nil, err
-		}
-		return &event, nil
+		event = &CeilingEvent{}
 	case "WoKeypad", "WoKeypadTouch":
 		// keypad
-		var event KeypadEvent
-		if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+		event = &KeypadEvent{}
+	case "WoHand":
+		// Bot
+		event = &BotEvent{}
+	case "WoCurtain", "WoCurtain3":
+		// Curtain, Curtain 3
+		event = &CurtainEvent{}
+	case "WoBlindTilt":
+		// Blind Tilt
+		event = &BlindTiltEvent{}
+	case "WoHub2":
+		// Hub 2
+		event = &Hub2Event{}
+	case "WoIOSensor":
+		// Outdoor Meter
+		event = &OutdoorMeterEvent{}
+	case "WoMeterPro", "WoMeterProCO2":
+		// Meter Pro, Meter Pro (CO2)
+		event = &MeterProEvent{}
+	case "WoHumi":
+		// Humidifier
+		event = &HumidifierEvent{}
+	case "WoSmartFan":
+		// Smart Fan
+		event = &SmartFanEvent{}
+	case "WoFan2":
+		// Battery Circulator Fan
+		event = &BatteryCirculatorFanEvent{}
+	default:
+		if cfg.strict {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownWebhookDeviceType, deviceType)
+		}
+
+		var raw RawEvent
+		if err := json.Unmarshal(data, &raw); err != nil {
 			return nil, err
 		}
-		return &event, nil
-	default:
-		return nil, fmt.Errorf("unknown device type: %s", deviceType)
+		raw.Payload = append(json.RawMessage(nil), data...)
+		raw.Extra = extraFields(rawBody.Context, raw.Context)
+
+		return &raw, nil
+	}
+
+	if err := json.Unmarshal(data, event); err != nil {
+		return nil, err
+	}
+
+	// every event type but RawEvent is a WebhookEvent
+	context := reflect.ValueOf(event).Elem().FieldByName("Context").Interface()
+	if extra := extraFields(rawBody.Context, context); extra != nil {
+		event.(interface {
+			setExtraFields(map[string]json.RawMessage)
+		}).setExtraFields(extra)
+	}
+
+	return event, nil
+}
+
+var knownFieldsCache sync.Map // map[reflect.Type]map[string]struct{}
+
+// extraFields returns the fields in context which are not modeled by the
+// given context struct, or nil if there are no such fields.
+func extraFields(context map[string]json.RawMessage, model interface{}) map[string]json.RawMessage {
+	typ := reflect.TypeOf(model)
+
+	known, ok := knownFieldsCache.Load(typ)
+	if !ok {
+		fields := map[string]struct{}{}
+		addJSONFields(fields, typ)
+		known, _ = knownFieldsCache.LoadOrStore(typ, fields)
+	}
+
+	var extra map[string]json.RawMessage
+	for k, v := range context {
+		if _, ok := known.(map[string]struct{})[k]; ok {
+			continue
+		}
+		if extra == nil {
+			extra = map[string]json.RawMessage{}
+		}
+		extra[k] = v
+	}
+
+	return extra
+}
+
+// addJSONFields adds the JSON names of the fields of the struct type to
+// fields, including the fields of the embedded structs such as EventContext.
+func addJSONFields(fields map[string]struct{}, typ reflect.Type) {
+	for i := 0; i < typ.NumField(); i++ {
+		f := typ.Field(i)
+		name := strings.Split(f.Tag.Get("json"), ",")[0]
+		if f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct {
+			addJSONFields(fields, f.Type)
+			continue
+		}
+		if name != "" && name != "-" {
+			fields[name] = struct{}{}
+		}
 	}
 }
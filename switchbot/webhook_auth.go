@@ -0,0 +1,338 @@
+package switchbot
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultWebhookTokenParam is the default name of the query parameter which
+// carries the webhook token.
+const DefaultWebhookTokenParam = "token"
+
+var (
+	// ErrWebhookTokenMismatch is returned when a webhook request does not
+	// have a valid token.
+	ErrWebhookTokenMismatch = errors.New("webhook token mismatch")
+	// ErrWebhookSourceNotAllowed is returned when a webhook request is sent
+	// from an address which is not allowed.
+	ErrWebhookSourceNotAllowed = errors.New("webhook source address is not allowed")
+)
+
+// GenerateWebhookToken returns a new random token which is unguessable
+// enough to be embedded in a webhook URL.
+func GenerateWebhookToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// WebhookAuth authenticates webhook requests. SwitchBot does not sign
+// webhook requests so the requests are authenticated with a secret token
+// embedded in the webhook URL, either as a query parameter or as the last
+// path segment, and optionally with the source address of the request.
+type WebhookAuth struct {
+	param   string
+	inPath  bool
+	allowed []*net.IPNet
+	now     func() time.Time
+
+	mu             sync.RWMutex
+	token          string
+	previous       string
+	previousExpiry time.Time
+}
+
+// WebhookAuthOption configures a WebhookAuth.
+type WebhookAuthOption func(*WebhookAuth) error
+
+// WithTokenParam sets the name of the query parameter which carries the token.
+func WithTokenParam(name string) WebhookAuthOption {
+	return func(a *WebhookAuth) error {
+		if name == "" {
+			return errors.New("token parameter name must not be empty")
+		}
+		a.param = name
+		return nil
+	}
+}
+
+// WithTokenInPath makes the token carried as the last path segment of the
+// webhook URL instead of a query parameter.
+func WithTokenInPath() WebhookAuthOption {
+	return func(a *WebhookAuth) error {
+		a.inPath = true
+		return nil
+	}
+}
+
+// WithAllowedSources restricts the source addresses of webhook requests.
+// Each source is either an IP address or a CIDR, e.g. 192.0.2.0/24.
+// The source address is taken from (*http.Request).RemoteAddr, so when the
+// receiver is behind a reverse proxy it must set RemoteAddr properly.
+func WithAllowedSources(sources ...string) WebhookAuthOption {
+	return func(a *WebhookAuth) error {
+		for _, src := range sources {
+			if !strings.Contains(src, "/") {
+				ip := net.ParseIP(src)
+				if ip == nil {
+					return fmt.Errorf("invalid IP address: %s", src)
+				}
+				if ip.To4() != nil {
+					src += "/32"
+				} else {
+					src += "/128"
+				}
+			}
+
+			_, ipnet, err := net.ParseCIDR(src)
+			if err != nil {
+				return err
+			}
+			a.allowed = append(a.allowed, ipnet)
+		}
+		return nil
+	}
+}
+
+// NewWebhookAuth returns a new WebhookAuth which accepts the given token.
+// The token can be generated with GenerateWebhookToken.
+func NewWebhookAuth(token string, opts ...WebhookAuthOption) (*WebhookAuth, error) {
+	if token == "" {
+		return nil, errors.New("webhook token must not be empty")
+	}
+
+	a := &WebhookAuth{
+		param: DefaultWebhookTokenParam,
+		now:   time.Now,
+		token: token,
+	}
+
+	for _, opt := range opts {
+		if err := opt(a); err != nil {
+			return nil, err
+		}
+	}
+
+	return a, nil
+}
+
+// Token returns the current token.
+func (a *WebhookAuth) Token() string {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	return a.token
+}
+
+// Rotate replaces the current token with the given one. The previous token
+// is still accepted for the overlap duration so that the requests already
+// in flight are not rejected.
+func (a *WebhookAuth) Rotate(token string, overlap time.Duration) {
+	a.rotate(token, overlap)
+}
+
+// rotate rotates the token and returns the function which undoes it.
+func (a *WebhookAuth) rotate(token string, overlap time.Duration) (restore func()) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	current, previous, previousExpiry := a.token, a.previous, a.previousExpiry
+
+	a.previous = a.token
+	a.previousExpiry = a.now().Add(overlap)
+	a.token = token
+
+	return func() {
+		a.mu.Lock()
+		defer a.mu.Unlock()
+
+		a.token, a.previous, a.previousExpiry = current, previous, previousExpiry
+	}
+}
+
+// URL returns the webhook URL which carries the current token, based on the
+// given base URL.
+func (a *WebhookAuth) URL(baseURL string) (string, error) {
+	return a.urlWithToken(baseURL, a.Token())
+}
+
+func (a *WebhookAuth) urlWithToken(baseURL, token string) (string, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return "", err
+	}
+
+	if a.inPath {
+		u.Path = path.Join("/", u.Path, token)
+		u.RawPath = ""
+		return u.String(), nil
+	}
+
+	q := u.Query()
+	q.Set(a.param, token)
+	u.RawQuery = q.Encode()
+
+	return u.String(), nil
+}
+
+// Authenticate reports whether the given request is an authenticated
+// webhook request.
+func (a *WebhookAuth) Authenticate(r *http.Request) error {
+	if len(a.allowed) > 0 && !a.sourceAllowed(r.RemoteAddr) {
+		return ErrWebhookSourceNotAllowed
+	}
+
+	var given string
+	if a.inPath {
+		given = path.Base(r.URL.Path)
+	} else {
+		given = r.URL.Query().Get(a.param)
+	}
+
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	if tokenEqual(given, a.token) {
+		return nil
+	}
+
+	if a.previous != "" && a.now().Before(a.previousExpiry) && tokenEqual(given, a.previous) {
+		return nil
+	}
+
+	return ErrWebhookTokenMismatch
+}
+
+// Middleware returns an http.Handler which calls next only for authenticated
+// requests. Requests with an invalid token are responded with 404 Not Found
+// so that the webhook endpoint is not revealed, and requests from disallowed
+// source addresses are responded with 403 Forbidden.
+func (a *WebhookAuth) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch err := a.Authenticate(r); {
+		case errors.Is(err, ErrWebhookSourceNotAllowed):
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		case err != nil:
+			http.NotFound(w, r)
+		default:
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+func (a *WebhookAuth) sourceAllowed(remoteAddr string) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+
+	for _, ipnet := range a.allowed {
+		if ipnet.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func tokenEqual(given, want string) bool {
+	return subtle.ConstantTimeCompare([]byte(given), []byte(want)) == 1
+}
+
+// SetupWithAuth configures the webhook URL built from baseURL and the current
+// token of auth. The configured URL is returned.
+func (svc *WebhookService) SetupWithAuth(ctx context.Context, baseURL string, auth *WebhookAuth) (string, error) {
+	u, err := auth.URL(baseURL)
+	if err != nil {
+		return "", err
+	}
+
+	if err := svc.Setup(ctx, u, "ALL"); err != nil {
+		return "", err
+	}
+
+	return u, nil
+}
+
+// UpdateWithAuth updates the configuration of the webhook URL built from
+// baseURL and the current token of auth.
+func (svc *WebhookService) UpdateWithAuth(ctx context.Context, baseURL string, auth *WebhookAuth, enable bool) error {
+	u, err := auth.URL(baseURL)
+	if err != nil {
+		return err
+	}
+
+	return svc.Update(ctx, u, enable)
+}
+
+// RotateToken generates a new token, rotates the token of auth, and replaces
+// the webhook URL configured with the previous token by the one with the new
+// token. The token is rotated first so that both tokens are accepted while
+// the URL is replaced, and the previous token is still accepted for the
+// overlap duration. The newly configured URL is returned.
+//
+// The URL with the new token is set up before the previous one is deleted.
+// As SwitchBot allows only one webhook URL for each account, the previous
+// URL is deleted first if the setup fails, and is set up again if the setup
+// still fails. The returned error describes the state the account is left in.
+func (svc *WebhookService) RotateToken(ctx context.Context, baseURL string, auth *WebhookAuth, overlap time.Duration) (string, error) {
+	token, err := GenerateWebhookToken()
+	if err != nil {
+		return "", err
+	}
+
+	oldURL, err := auth.URL(baseURL)
+	if err != nil {
+		return "", err
+	}
+
+	newURL, err := auth.urlWithToken(baseURL, token)
+	if err != nil {
+		return "", err
+	}
+
+	restore := auth.rotate(token, overlap)
+
+	if err := svc.Setup(ctx, newURL, "ALL"); err == nil {
+		if err := svc.Delete(ctx, oldURL); err != nil {
+			return newURL, fmt.Errorf("deleting the webhook URL with the previous token: %w; both of the URLs are configured", err)
+		}
+
+		return newURL, nil
+	}
+
+	if err := svc.Delete(ctx, oldURL); err != nil {
+		restore()
+		return "", fmt.Errorf("deleting the webhook URL with the previous token: %w; the token is not rotated and the URL is still configured", err)
+	}
+
+	if err := svc.Setup(ctx, newURL, "ALL"); err != nil {
+		restore()
+		if restoreErr := svc.Setup(ctx, oldURL, "ALL"); restoreErr != nil {
+			return "", fmt.Errorf("setting up the webhook URL with the new token: %w; restoring the previous URL failed and no webhook URL is configured: %v", err, restoreErr)
+		}
+
+		return "", fmt.Errorf("setting up the webhook URL with the new token: %w; the token is not rotated and the previous URL is restored", err)
+	}
+
+	return newURL, nil
+}
@@ -0,0 +1,241 @@
+package switchbot_test
+
+import (
+	"context"
+	"encoding/json"
+	switchbot2 "github.com/nasa9084/go-switchbot/v3/switchbot"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestWebhookAuth(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	serve := func(h http.Handler, target, remoteAddr string) int {
+		req := httptest.NewRequest(http.MethodPost, target, nil)
+		if remoteAddr != "" {
+			req.RemoteAddr = remoteAddr
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	t.Run("query token", func(t *testing.T) {
+		auth, err := switchbot2.NewWebhookAuth("secret")
+		if err != nil {
+			t.Fatal(err)
+		}
+		h := auth.Middleware(ok)
+
+		if got := serve(h, "/webhook?token=secret", ""); got != http.StatusOK {
+			t.Errorf("valid token is expected to be accepted but %d", got)
+		}
+		if got := serve(h, "/webhook?token=wrong", ""); got != http.StatusNotFound {
+			t.Errorf("invalid token is expected to be rejected with 404 but %d", got)
+		}
+		if got := serve(h, "/webhook", ""); got != http.StatusNotFound {
+			t.Errorf("missing token is expected to be rejected with 404 but %d", got)
+		}
+	})
+
+	t.Run("path token", func(t *testing.T) {
+		auth, err := switchbot2.NewWebhookAuth("secret", switchbot2.WithTokenInPath())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		u, err := auth.URL("https://example.com/webhook")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := "https://example.com/webhook/secret"; u != want {
+			t.Errorf("URL() = %s, want %s", u, want)
+		}
+
+		if got := serve(auth.Middleware(ok), "/webhook/secret", ""); got != http.StatusOK {
+			t.Errorf("valid token is expected to be accepted but %d", got)
+		}
+	})
+
+	t.Run("allowed sources", func(t *testing.T) {
+		auth, err := switchbot2.NewWebhookAuth("secret", switchbot2.WithAllowedSources("192.0.2.0/24", "2001:db8::1"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		h := auth.Middleware(ok)
+
+		if got := serve(h, "/?token=secret", "192.0.2.10:1234"); got != http.StatusOK {
+			t.Errorf("allowed source is expected to be accepted but %d", got)
+		}
+		if got := serve(h, "/?token=secret", "[2001:db8::1]:1234"); got != http.StatusOK {
+			t.Errorf("allowed source is expected to be accepted but %d", got)
+		}
+		if got := serve(h, "/?token=secret", "198.51.100.1:1234"); got != http.StatusForbidden {
+			t.Errorf("disallowed source is expected to be rejected with 403 but %d", got)
+		}
+	})
+
+	t.Run("rotation", func(t *testing.T) {
+		auth, err := switchbot2.NewWebhookAuth("old")
+		if err != nil {
+			t.Fatal(err)
+		}
+		h := auth.Middleware(ok)
+
+		auth.Rotate("new", time.Hour)
+		if got := serve(h, "/?token=old", ""); got != http.StatusOK {
+			t.Errorf("previous token is expected to be accepted within the overlap but %d", got)
+		}
+		if got := serve(h, "/?token=new", ""); got != http.StatusOK {
+			t.Errorf("new token is expected to be accepted but %d", got)
+		}
+
+		auth.Rotate("newer", 0)
+		if got := serve(h, "/?token=new", ""); got != http.StatusNotFound {
+			t.Errorf("previous token is expected to be rejected after the overlap but %d", got)
+		}
+	})
+}
+
+func TestWebhookRotateToken(t *testing.T) {
+	const (
+		baseURL = "https://example.com/webhook"
+		oldURL  = baseURL + "?token=old"
+	)
+
+	tests := []struct {
+		name string
+		// maxURLs is the number of webhook URLs the account can have.
+		maxURLs int
+		// failNew makes the setup of the URL with the new token fail.
+		failNew bool
+		// failRestore makes the setup of the URL with the previous token fail.
+		failRestore bool
+		wantErr     string
+		wantActions []string
+		// wantNew is true if the URL with the new token is expected to be
+		// configured and the token is expected to be rotated.
+		wantNew bool
+		wantOld bool
+	}{
+		{
+			name:        "multiple URLs",
+			maxURLs:     2,
+			wantActions: []string{"setupWebhook", "deleteWebhook"},
+			wantNew:     true,
+		},
+		{
+			name:        "single URL",
+			maxURLs:     1,
+			wantActions: []string{"setupWebhook", "deleteWebhook", "setupWebhook"},
+			wantNew:     true,
+		},
+		{
+			name:        "setup fails after delete",
+			maxURLs:     1,
+			failNew:     true,
+			wantErr:     "the token is not rotated and the previous URL is restored",
+			wantActions: []string{"setupWebhook", "deleteWebhook", "setupWebhook", "setupWebhook"},
+			wantOld:     true,
+		},
+		{
+			name:        "restore fails",
+			maxURLs:     1,
+			failNew:     true,
+			failRestore: true,
+			wantErr:     "restoring the previous URL failed and no webhook URL is configured",
+			wantActions: []string{"setupWebhook", "deleteWebhook", "setupWebhook", "setupWebhook"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			auth, err := switchbot2.NewWebhookAuth("old")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var (
+				actions    []string
+				configured = map[string]bool{oldURL: true}
+			)
+			srv := httptest.NewServer(
+				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					var req struct {
+						Action string `json:"action"`
+						URL    string `json:"url"`
+					}
+					if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+						t.Error(err)
+					}
+					actions = append(actions, req.Action)
+
+					switch req.Action {
+					case "setupWebhook":
+						// the configured URL must be accepted as soon as it is set up
+						if err := auth.Authenticate(httptest.NewRequest(http.MethodPost, req.URL, nil)); err != nil {
+							t.Errorf("%s is not accepted while it is set up: %v", req.URL, err)
+						}
+
+						fail := req.URL == oldURL && tt.failRestore || req.URL != oldURL && tt.failNew
+						if fail || len(configured) >= tt.maxURLs {
+							w.Write([]byte(`{"statusCode":190,"body":{},"message":"failed"}`))
+							return
+						}
+						configured[req.URL] = true
+					case "deleteWebhook":
+						delete(configured, req.URL)
+					}
+					w.Write([]byte(`{"statusCode":100,"body":{},"message":""}`))
+				}),
+			)
+			defer srv.Close()
+
+			c := switchbot2.New("", "", switchbot2.WithEndpoint(srv.URL))
+
+			u, err := c.Webhook().RotateToken(context.Background(), baseURL, auth, time.Minute)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error containing %q is expected but %v", tt.wantErr, err)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+
+			if diff := cmp.Diff(tt.wantActions, actions); diff != "" {
+				t.Errorf("API calls mismatch (-want +got):\n%s", diff)
+			}
+
+			if tt.wantNew {
+				if want := baseURL + "?token=" + auth.Token(); u != want {
+					t.Errorf("rotated URL = %s, want %s", u, want)
+				}
+				if auth.Token() == "old" {
+					t.Error("token is expected to be rotated")
+				}
+				if err := auth.Authenticate(httptest.NewRequest(http.MethodPost, oldURL, nil)); err != nil {
+					t.Errorf("previous token is expected to be accepted during the overlap: %v", err)
+				}
+			} else if auth.Token() != "old" {
+				t.Errorf("token is expected not to be rotated but %s", auth.Token())
+			}
+
+			want := map[string]bool{}
+			if tt.wantNew {
+				want[u] = true
+			}
+			if tt.wantOld {
+				want[oldURL] = true
+			}
+			if diff := cmp.Diff(want, configured); diff != "" {
+				t.Errorf("configured URLs mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
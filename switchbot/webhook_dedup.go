@@ -0,0 +1,246 @@
+package switchbot
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultDedupWindow is the default duration for which a Deduplicator
+	// remembers the events it has seen.
+	DefaultDedupWindow = 10 * time.Minute
+	// DefaultDedupMaxEntries is the default number of events a Deduplicator
+	// remembers at most.
+	DefaultDedupMaxEntries = 10000
+)
+
+// LatePolicy determines how a Deduplicator treats late events, which are
+// the events sampled before the latest event seen from the same device.
+type LatePolicy int
+
+const (
+	// DropLateEvents drops late events.
+	DropLateEvents LatePolicy = iota
+	// FlagLateEvents flags late events as DedupLateFlagged.
+	FlagLateEvents
+	// PassLateEvents treats late events as same as other events.
+	PassLateEvents
+)
+
+// DedupResult is the result of (*Deduplicator).Check.
+type DedupResult int
+
+const (
+	// DedupAccepted means the event is seen for the first time.
+	DedupAccepted DedupResult = iota
+	// DedupDuplicate means the same event has already been seen.
+	DedupDuplicate
+	// DedupLate means the event is late and should be dropped.
+	DedupLate
+	// DedupLateFlagged means the event is late but should be delivered
+	// with the flag.
+	DedupLateFlagged
+)
+
+func (r DedupResult) String() string {
+	switch r {
+	case DedupAccepted:
+		return "accepted"
+	case DedupDuplicate:
+		return "duplicate"
+	case DedupLate:
+		return "late"
+	case DedupLateFlagged:
+		return "late_flagged"
+	default:
+		return "unknown"
+	}
+}
+
+// Deliver reports whether the event should be delivered to handlers.
+func (r DedupResult) Deliver() bool {
+	return r == DedupAccepted || r == DedupLateFlagged
+}
+
+type dedupKey struct {
+	deviceID     string
+	timeOfSample int64
+	digest       [sha256.Size]byte
+}
+
+type dedupEntry struct {
+	key    dedupKey
+	seenAt time.Time
+}
+
+type latestSample struct {
+	timeOfSample time.Time
+	seenAt       time.Time
+}
+
+// Deduplicator detects duplicated and out-of-order webhook events.
+// Events are identified by the device MAC address, the event payload and
+// the time of sample, and are remembered within a bounded time window.
+type Deduplicator struct {
+	window     time.Duration
+	maxEntries int
+	latePolicy LatePolicy
+	now        func() time.Time
+
+	mu      sync.Mutex
+	seen    map[dedupKey]time.Time
+	entries []dedupEntry
+	latest  map[string]latestSample
+}
+
+// DedupOption configures a Deduplicator.
+type DedupOption func(*Deduplicator)
+
+// WithDedupWindow sets the duration for which the Deduplicator remembers
+// the events it has seen.
+func WithDedupWindow(window time.Duration) DedupOption {
+	return func(d *Deduplicator) {
+		d.window = window
+	}
+}
+
+// WithDedupMaxEntries sets the number of events the Deduplicator remembers at most.
+func WithDedupMaxEntries(n int) DedupOption {
+	return func(d *Deduplicator) {
+		d.maxEntries = n
+	}
+}
+
+// WithLatePolicy sets how the Deduplicator treats late events.
+// The default policy is DropLateEvents.
+func WithLatePolicy(policy LatePolicy) DedupOption {
+	return func(d *Deduplicator) {
+		d.latePolicy = policy
+	}
+}
+
+// NewDeduplicator returns a new Deduplicator.
+func NewDeduplicator(opts ...DedupOption) *Deduplicator {
+	d := &Deduplicator{
+		window:     DefaultDedupWindow,
+		maxEntries: DefaultDedupMaxEntries,
+		latePolicy: DropLateEvents,
+		now:        time.Now,
+
+		seen:   map[dedupKey]time.Time{},
+		latest: map[string]latestSample{},
+	}
+
+	for _, opt := range opts {
+		opt(d)
+	}
+
+	return d
+}
+
+// Check records the given event and reports whether it is seen for the first
+// time, a duplicate of an event seen before, or a late event.
+func (d *Deduplicator) Check(event Event) DedupResult {
+	key := newDedupKey(event)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := d.now()
+	d.evict(now)
+
+	if _, ok := d.seen[key]; ok {
+		return DedupDuplicate
+	}
+
+	d.seen[key] = now
+	d.entries = append(d.entries, dedupEntry{key: key, seenAt: now})
+
+	sampled := event.Time()
+	latest, ok := d.latest[key.deviceID]
+	if ok && now.Sub(latest.seenAt) <= d.window && sampled.Before(latest.timeOfSample) {
+		switch d.latePolicy {
+		case FlagLateEvents:
+			return DedupLateFlagged
+		case PassLateEvents:
+			return DedupAccepted
+		default:
+			return DedupLate
+		}
+	}
+
+	d.latest[key.deviceID] = latestSample{timeOfSample: sampled, seenAt: now}
+
+	return DedupAccepted
+}
+
+// Forget forgets the given event recorded by Check, so that its redelivery is
+// not treated as a duplicate, e.g. when the event could not be processed.
+// The event is still counted as the latest sample of the device.
+func (d *Deduplicator) Forget(event Event) {
+	key := newDedupKey(event)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	// the entry is skipped by evict since the key is no longer in seen
+	delete(d.seen, key)
+}
+
+func newDedupKey(event Event) dedupKey {
+	return dedupKey{
+		deviceID:     event.DeviceID(),
+		timeOfSample: event.Time().UnixMilli(),
+		digest:       eventDigest(event),
+	}
+}
+
+// evict forgets the events which are out of the window or over the limit.
+func (d *Deduplicator) evict(now time.Time) {
+	var n int
+	for n < len(d.entries) {
+		e := d.entries[n]
+		if now.Sub(e.seenAt) <= d.window && (d.maxEntries <= 0 || len(d.entries)-n < d.maxEntries) {
+			break
+		}
+		if seenAt, ok := d.seen[e.key]; ok && seenAt.Equal(e.seenAt) {
+			delete(d.seen, e.key)
+		}
+		n++
+	}
+
+	if n > 0 {
+		d.entries = append(d.entries[:0], d.entries[n:]...)
+	}
+
+	if d.maxEntries > 0 && len(d.latest) > d.maxEntries {
+		for id, latest := range d.latest {
+			if now.Sub(latest.seenAt) > d.window {
+				delete(d.latest, id)
+			}
+		}
+	}
+}
+
+// eventDigest returns a digest of the payload of the given event.
+func eventDigest(event Event) [sha256.Size]byte {
+	if raw, ok := event.(*RawEvent); ok {
+		return sha256.Sum256(raw.Payload)
+	}
+
+	h := sha256.New()
+	enc := json.NewEncoder(h)
+	_ = enc.Encode(event)
+
+	// Extra is not marshaled with the event itself
+	if extra := eventExtraFields(event); len(extra) > 0 {
+		_ = enc.Encode(extra)
+	}
+
+	var digest [sha256.Size]byte
+	copy(digest[:], h.Sum(nil))
+
+	return digest
+}
@@ -0,0 +1,135 @@
+package switchbot_test
+
+import (
+	"fmt"
+	switchbot2 "github.com/nasa9084/go-switchbot/v3/switchbot"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func contactEvent(t *testing.T, openState string, timeOfSample int64) switchbot2.Event {
+	t.Helper()
+
+	event, err := switchbot2.ParseWebhookPayload([]byte(fmt.Sprintf(`{"eventType":"changeReport","eventVersion":"1","context":{"deviceType":"WoContact","deviceMac":"01:00:5e:90:10:00","openState":%q,"timeOfSample":%d}}`, openState, timeOfSample)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return event
+}
+
+func TestDeduplicator(t *testing.T) {
+	t.Run("duplicate", func(t *testing.T) {
+		d := switchbot2.NewDeduplicator()
+
+		if got := d.Check(contactEvent(t, "open", 1000)); got != switchbot2.DedupAccepted {
+			t.Errorf("first event is expected to be accepted but %s", got)
+		}
+		if got := d.Check(contactEvent(t, "open", 1000)); got != switchbot2.DedupDuplicate {
+			t.Errorf("same event is expected to be duplicate but %s", got)
+		}
+		if got := d.Check(contactEvent(t, "close", 1000)); got != switchbot2.DedupAccepted {
+			t.Errorf("event with different payload is expected to be accepted but %s", got)
+		}
+	})
+
+	t.Run("forget", func(t *testing.T) {
+		d := switchbot2.NewDeduplicator()
+
+		d.Check(contactEvent(t, "open", 1000))
+		d.Forget(contactEvent(t, "open", 1000))
+		if got := d.Check(contactEvent(t, "open", 1000)); got != switchbot2.DedupAccepted {
+			t.Errorf("forgotten event is expected to be accepted but %s", got)
+		}
+		if got := d.Check(contactEvent(t, "open", 1000)); got != switchbot2.DedupDuplicate {
+			t.Errorf("same event is expected to be duplicate but %s", got)
+		}
+	})
+
+	t.Run("late", func(t *testing.T) {
+		tests := []struct {
+			policy switchbot2.LatePolicy
+			want   switchbot2.DedupResult
+		}{
+			{switchbot2.DropLateEvents, switchbot2.DedupLate},
+			{switchbot2.FlagLateEvents, switchbot2.DedupLateFlagged},
+			{switchbot2.PassLateEvents, switchbot2.DedupAccepted},
+		}
+
+		for _, tt := range tests {
+			d := switchbot2.NewDeduplicator(switchbot2.WithLatePolicy(tt.policy))
+
+			d.Check(contactEvent(t, "close", 2000))
+			if got := d.Check(contactEvent(t, "open", 1000)); got != tt.want {
+				t.Errorf("late event is expected to be %s but %s", tt.want, got)
+			}
+		}
+	})
+
+	t.Run("max entries", func(t *testing.T) {
+		d := switchbot2.NewDeduplicator(switchbot2.WithDedupMaxEntries(2))
+
+		d.Check(contactEvent(t, "open", 1000))
+		d.Check(contactEvent(t, "close", 2000))
+		d.Check(contactEvent(t, "open", 3000))
+
+		if got := d.Check(contactEvent(t, "open", 3000)); got != switchbot2.DedupDuplicate {
+			t.Errorf("recent event is expected to be duplicate but %s", got)
+		}
+		if got := d.Check(contactEvent(t, "open", 1000)); got == switchbot2.DedupDuplicate {
+			t.Error("evicted event is not expected to be duplicate")
+		}
+	})
+
+	t.Run("webhook handler", func(t *testing.T) {
+		var events, late int
+
+		h := switchbot2.NewWebhookHandler(switchbot2.WithDeduplicator(switchbot2.NewDeduplicator(switchbot2.WithLatePolicy(switchbot2.FlagLateEvents))))
+		h.OnContact(func(*switchbot2.ContactSensorEvent) { events++ })
+		h.OnLateEvent(func(switchbot2.Event) { late++ })
+
+		for _, body := range []string{
+			`{"context":{"deviceType":"WoContact","deviceMac":"01:00:5e:90:10:00","openState":"open","timeOfSample":2000}}`,
+			`{"context":{"deviceType":"WoContact","deviceMac":"01:00:5e:90:10:00","openState":"open","timeOfSample":2000}}`,
+			`{"context":{"deviceType":"WoContact","deviceMac":"01:00:5e:90:10:00","openState":"close","timeOfSample":1000}}`,
+		} {
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body)))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("unexpected status code: %d", rec.Code)
+			}
+		}
+
+		if events != 1 {
+			t.Errorf("contact callback is expected to be called once but %d", events)
+		}
+		if late != 1 {
+			t.Errorf("late callback is expected to be called once but %d", late)
+		}
+	})
+
+	t.Run("webhook handler without late callbacks", func(t *testing.T) {
+		var events int
+
+		h := switchbot2.NewWebhookHandler(switchbot2.WithDeduplicator(switchbot2.NewDeduplicator(switchbot2.WithLatePolicy(switchbot2.FlagLateEvents))))
+		h.OnContact(func(*switchbot2.ContactSensorEvent) { events++ })
+
+		for _, body := range []string{
+			`{"context":{"deviceType":"WoContact","deviceMac":"01:00:5e:90:10:00","openState":"open","timeOfSample":2000}}`,
+			`{"context":{"deviceType":"WoContact","deviceMac":"01:00:5e:90:10:00","openState":"close","timeOfSample":1000}}`,
+		} {
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body)))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("unexpected status code: %d", rec.Code)
+			}
+		}
+
+		// the flagged late event is dispatched to the other callbacks
+		if events != 2 {
+			t.Errorf("contact callback is expected to be called twice but %d", events)
+		}
+	})
+}
@@ -0,0 +1,217 @@
+package switchbot
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+// Event is implemented by all the webhook events sent by SwitchBot.
+type Event interface {
+	// DeviceMAC returns the MAC address of the device which sent the event.
+	DeviceMAC() string
+	// DeviceID returns the device ID of the device which sent the event,
+	// which is the MAC address normalised to the format used by the
+	// devices API, e.g. 01:00:5e:90:10:00 becomes 01005E901000.
+	DeviceID() string
+	// WebhookDeviceType returns the deviceType value of the webhook
+	// event, e.g. WoLock.
+	WebhookDeviceType() string
+	// PhysicalType returns the physical device type of the device which
+	// sent the event, or an empty value if it is unknown.
+	PhysicalType() PhysicalDeviceType
+	// Time returns the time when the event was sampled.
+	Time() time.Time
+}
+
+var webhookPhysicalDeviceTypes = map[string]PhysicalDeviceType{
+	"WoPresence":       MotionSensor,
+	"WoContact":        ContactSensor,
+	"WoLock":           Lock,
+	"WoCamera":         IndoorCam,
+	"WoPanTiltCam":     PanTiltCam,
+	"WoBulb":           ColorBulb,
+	"WoStrip":          StripLight,
+	"WoPlugUS":         PlugMiniUS,
+	"WoPlugJP":         PlugMiniJP,
+	"WoMeter":          Meter,
+	"WoMeterPlus":      MeterPlus,
+	"WoSweeper":        RobotVacuumCleanerS1,
+	"WoSweeperPlus":    RobotVacuumCleanerS1Plus,
+	"WoCeiling":        CeilingLight,
+	"WoCeilingPro":     CeilingLightPro,
+	"WoKeypad":         KeyPad,
+	"WoKeypadTouch":    KeyPadTouch,
+	"WoHand":           Bot,
+	"WoCurtain":        Curtain,
+	"WoCurtain3":       Curtain3,
+	"WoBlindTilt":      BlindTilt,
+	"WoHub2":           Hub2,
+	"WoLockPro":        SmartLockPro,
+	"WoIOSensor":       WoIOSensor,
+	"WoMeterPro":       MeterPro,
+	"WoMeterProCO2":    MeterProCO2,
+	"WoHumi":           Humidifier,
+	"WoSmartFan":       SmartFan,
+	"WoFan2":           BatteryCirculatorFan,
+	"WoSweeperMini":    WoSweeperMini,
+	"WoSweeperMiniPro": WoSweeperMiniPro,
+}
+
+// PhysicalDeviceTypeFromWebhook returns the physical device type for the given
+// deviceType value of webhook events. An empty value is returned if the
+// deviceType is unknown.
+func PhysicalDeviceTypeFromWebhook(deviceType string) PhysicalDeviceType {
+	return webhookPhysicalDeviceTypes[deviceType]
+}
+
+// DeviceIDFromMAC converts a MAC address reported in webhook events into
+// the device ID format used by the devices API.
+func DeviceIDFromMAC(mac string) string {
+	return strings.ToUpper(strings.NewReplacer(":", "", "-", "").Replace(mac))
+}
+
+func timeOfSample(msec int64) time.Time {
+	return time.UnixMilli(msec)
+}
+
+// ExtraFielder is implemented by the events which keep the fields in the
+// event context not modeled by this package. All the events returned by
+// ParseWebhookEvent implement it.
+type ExtraFielder interface {
+	ExtraFields() map[string]json.RawMessage
+}
+
+// eventExtraFields returns the extra fields of the event, or nil if the event
+// does not keep them.
+func eventExtraFields(event Event) map[string]json.RawMessage {
+	if e, ok := event.(ExtraFielder); ok {
+		return e.ExtraFields()
+	}
+
+	return nil
+}
+
+// MarshalWebhookEvent encodes the given event into the webhook payload format
+// sent by SwitchBot, including the extra fields not modeled by this package.
+// The original payload is returned as is for RawEvent.
+func MarshalWebhookEvent(event Event) ([]byte, error) {
+	if raw, ok := event.(*RawEvent); ok {
+		return raw.Payload, nil
+	}
+
+	b, err := json.Marshal(event)
+	if err != nil {
+		return nil, err
+	}
+
+	extra := eventExtraFields(event)
+	if len(extra) == 0 {
+		return b, nil
+	}
+
+	var payload map[string]json.RawMessage
+	if err := json.Unmarshal(b, &payload); err != nil {
+		return nil, err
+	}
+
+	var context map[string]json.RawMessage
+	if err := json.Unmarshal(payload["context"], &context); err != nil {
+		return nil, err
+	}
+	for k, v := range extra {
+		context[k] = v
+	}
+
+	if payload["context"], err = json.Marshal(context); err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(payload)
+}
+
+// EventContext holds the fields common to the contexts of all the webhook
+// events, and is embedded in each XxxEventContext.
+type EventContext struct {
+	DeviceType   string `json:"deviceType"`
+	DeviceMac    string `json:"deviceMac"`
+	TimeOfSample int64  `json:"timeOfSample"`
+}
+
+func (c EventContext) eventContext() EventContext {
+	return c
+}
+
+// webhookEventContext is implemented by the context types embedding
+// EventContext.
+type webhookEventContext interface {
+	eventContext() EventContext
+}
+
+// WebhookEvent is a webhook event whose context is of type C. Each XxxEvent
+// type is a WebhookEvent of its XxxEventContext, e.g. MotionSensorEvent is
+// WebhookEvent[MotionSensorEventContext].
+type WebhookEvent[C webhookEventContext] struct {
+	EventType    string `json:"eventType"`
+	EventVersion string `json:"eventVersion"`
+	Context      C      `json:"context"`
+
+	// Extra holds the fields in the event context which are not modeled by this package.
+	Extra map[string]json.RawMessage `json:"-"`
+}
+
+func (e *WebhookEvent[C]) DeviceMAC() string {
+	return e.Context.eventContext().DeviceMac
+}
+
+func (e *WebhookEvent[C]) DeviceID() string {
+	return DeviceIDFromMAC(e.Context.eventContext().DeviceMac)
+}
+
+func (e *WebhookEvent[C]) WebhookDeviceType() string {
+	return e.Context.eventContext().DeviceType
+}
+
+func (e *WebhookEvent[C]) PhysicalType() PhysicalDeviceType {
+	return PhysicalDeviceTypeFromWebhook(e.Context.eventContext().DeviceType)
+}
+
+func (e *WebhookEvent[C]) Time() time.Time {
+	return timeOfSample(e.Context.eventContext().TimeOfSample)
+}
+
+// ExtraFields returns the fields in the event context which are not modeled
+// by this package.
+func (e *WebhookEvent[C]) ExtraFields() map[string]json.RawMessage {
+	return e.Extra
+}
+
+func (e *WebhookEvent[C]) setExtraFields(extra map[string]json.RawMessage) {
+	e.Extra = extra
+}
+
+func (e *RawEvent) DeviceMAC() string {
+	return e.Context.DeviceMac
+}
+
+func (e *RawEvent) DeviceID() string {
+	return DeviceIDFromMAC(e.Context.DeviceMac)
+}
+
+func (e *RawEvent) WebhookDeviceType() string {
+	return e.Context.DeviceType
+}
+
+func (e *RawEvent) PhysicalType() PhysicalDeviceType {
+	return PhysicalDeviceTypeFromWebhook(e.Context.DeviceType)
+}
+
+func (e *RawEvent) Time() time.Time {
+	return timeOfSample(e.Context.TimeOfSample)
+}
+
+// ExtraFields returns the fields in the event context other than
+// deviceType, deviceMac and timeOfSample.
+func (e *RawEvent) ExtraFields() map[string]json.RawMessage {
+	return e.Extra
+}
@@ -0,0 +1,90 @@
+package switchbot_test
+
+import (
+	"encoding/json"
+	switchbot2 "github.com/nasa9084/go-switchbot/v3/switchbot"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestEvent(t *testing.T) {
+	event, err := switchbot2.ParseWebhookPayload([]byte(`{"eventType":"changeReport","eventVersion":"1","context":{"deviceType":"WoPresence","deviceMac":"01:00:5e:90:10:0a","detectionState":"DETECTED","timeOfSample":1690000000123}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := event.(*switchbot2.MotionSensorEvent); !ok {
+		t.Fatalf("given webhook event must be a motion sensor event but %T", event)
+	}
+
+	if got, want := event.DeviceMAC(), "01:00:5e:90:10:0a"; got != want {
+		t.Errorf("DeviceMAC() = %s, want %s", got, want)
+	}
+	if got, want := event.DeviceID(), "01005E90100A"; got != want {
+		t.Errorf("DeviceID() = %s, want %s", got, want)
+	}
+	if got, want := event.WebhookDeviceType(), "WoPresence"; got != want {
+		t.Errorf("WebhookDeviceType() = %s, want %s", got, want)
+	}
+	if got, want := event.PhysicalType(), switchbot2.MotionSensor; got != want {
+		t.Errorf("PhysicalType() = %s, want %s", got, want)
+	}
+	if got, want := event.Time(), time.UnixMilli(1690000000123); !got.Equal(want) {
+		t.Errorf("Time() = %s, want %s", got, want)
+	}
+}
+
+// customEvent is an Event implemented outside of the package, which is not a
+// pointer and has no extra fields.
+type customEvent struct {
+	MAC string `json:"mac"`
+}
+
+func (e customEvent) DeviceMAC() string                           { return e.MAC }
+func (e customEvent) DeviceID() string                            { return switchbot2.DeviceIDFromMAC(e.MAC) }
+func (e customEvent) WebhookDeviceType() string                   { return "Custom" }
+func (e customEvent) PhysicalType() switchbot2.PhysicalDeviceType { return "" }
+func (e customEvent) Time() time.Time                             { return time.UnixMilli(0) }
+
+func TestMarshalWebhookEvent(t *testing.T) {
+	t.Run("extra fields", func(t *testing.T) {
+		payload := `{"eventType":"changeReport","eventVersion":"1","context":{"deviceType":"WoPresence","deviceMac":"01:00:5e:90:10:0a","detectionState":"DETECTED","timeOfSample":1690000000123,"newField":1}}`
+		event, err := switchbot2.ParseWebhookPayload([]byte(payload))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if diff := cmp.Diff(map[string]json.RawMessage{"newField": json.RawMessage("1")}, event.(switchbot2.ExtraFielder).ExtraFields()); diff != "" {
+			t.Errorf("extra fields mismatch (-want +got):\n%s", diff)
+		}
+
+		b, err := switchbot2.MarshalWebhookEvent(event)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var want, got interface{}
+		if err := json.Unmarshal([]byte(payload), &want); err != nil {
+			t.Fatal(err)
+		}
+		if err := json.Unmarshal(b, &got); err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("payload mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("custom event", func(t *testing.T) {
+		b, err := switchbot2.MarshalWebhookEvent(customEvent{MAC: "01:00:5e:90:10:0a"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got, want := string(b), `{"mac":"01:00:5e:90:10:0a"}`; got != want {
+			t.Errorf("payload = %s, want %s", got, want)
+		}
+	})
+}
@@ -0,0 +1,546 @@
+package switchbot
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// DefaultWebhookMaxBodySize is the default limit of the request body size
+// accepted by WebhookHandler.
+const DefaultWebhookMaxBodySize = 64 << 10
+
+// ErrWebhookQueueFull is reported when the asynchronous worker pool of a
+// WebhookHandler cannot accept any more events.
+var ErrWebhookQueueFull = errors.New("webhook event queue is full")
+
+// ErrWebhookHandlerClosed is reported when a webhook request is received
+// after the WebhookHandler has been closed.
+var ErrWebhookHandlerClosed = errors.New("webhook handler is closed")
+
+// WebhookPanicError is reported to the error handler when a callback panics.
+type WebhookPanicError struct {
+	Value interface{}
+	Stack []byte
+}
+
+func (e *WebhookPanicError) Error() string {
+	return fmt.Sprintf("webhook callback panicked: %v", e.Value)
+}
+
+// WebhookHandler is an http.Handler which receives webhook requests sent by
+// SwitchBot, parses them with ParseWebhookEvent and dispatches the events
+// to the registered callbacks.
+type WebhookHandler struct {
+	maxBodySize  int64
+	workers      int
+	queueSize    int
+	errorHandler func(error)
+	parseOptions []ParseOption
+	dedup        *Deduplicator
+	journal      *Journal
+
+	mu            sync.RWMutex
+	callbacks     []func(Event)
+	lateCallbacks []func(Event)
+
+	queue     chan queuedEvent
+	wg        sync.WaitGroup
+	closeMu   sync.RWMutex
+	closed    bool
+	closeOnce sync.Once
+}
+
+type queuedEvent struct {
+	event Event
+	late  bool
+}
+
+// WebhookHandlerOption configures a WebhookHandler.
+type WebhookHandlerOption func(*WebhookHandler)
+
+// WithMaxBodySize limits the size of accepted request bodies. Requests
+// larger than the limit are rejected with 413 Request Entity Too Large.
+func WithMaxBodySize(n int64) WebhookHandlerOption {
+	return func(h *WebhookHandler) {
+		h.maxBodySize = n
+	}
+}
+
+// WithWorkers makes the handler run callbacks asynchronously on a pool of
+// n workers fed by a queue holding up to queueSize events. When the queue
+// is full, requests are rejected with 503 Service Unavailable so SwitchBot
+// can retry them later.
+// By default callbacks run synchronously within the request.
+func WithWorkers(n, queueSize int) WebhookHandlerOption {
+	return func(h *WebhookHandler) {
+		h.workers = n
+		h.queueSize = queueSize
+	}
+}
+
+// WithErrorHandler sets a function which is called with every error occurred
+// while handling webhook requests, including panics recovered from callbacks
+// which are reported as *WebhookPanicError.
+func WithErrorHandler(fn func(error)) WebhookHandlerOption {
+	return func(h *WebhookHandler) {
+		h.errorHandler = fn
+	}
+}
+
+// WithParseOptions sets the options used to parse webhook requests.
+// Pass StrictParsing to reject the requests sent from device types which are
+// not supported by this package with 422 Unprocessable Entity.
+func WithParseOptions(opts ...ParseOption) WebhookHandlerOption {
+	return func(h *WebhookHandler) {
+		h.parseOptions = append(h.parseOptions, opts...)
+	}
+}
+
+// WithDeduplicator makes the handler drop the duplicated and late events
+// detected by the given Deduplicator. The dropped requests are responded with
+// 200 OK so that SwitchBot does not retry them. Late events flagged by
+// FlagLateEvents policy are dispatched to the callbacks registered with
+// OnLateEvent instead of the other callbacks, or to the other callbacks if
+// there is none. Events rejected because the queue is full are forgotten so
+// that their retries are not dropped.
+func WithDeduplicator(d *Deduplicator) WebhookHandlerOption {
+	return func(h *WebhookHandler) {
+		h.dedup = d
+	}
+}
+
+// WithJournal makes the handler record every accepted event into the given
+// Journal. Events dropped by the Deduplicator or rejected because the queue
+// is full are not recorded, so that a retried event is recorded only once.
+// Failures of recording are reported to the error handler and do not prevent
+// the event from being dispatched.
+func WithJournal(j *Journal) WebhookHandlerOption {
+	return func(h *WebhookHandler) {
+		h.journal = j
+	}
+}
+
+// NewWebhookHandler returns a new WebhookHandler.
+// If the handler is configured with WithWorkers, Close should be called
+// to stop the workers once the handler is no longer used.
+func NewWebhookHandler(opts ...WebhookHandlerOption) *WebhookHandler {
+	h := &WebhookHandler{
+		maxBodySize: DefaultWebhookMaxBodySize,
+	}
+
+	for _, opt := range opts {
+		opt(h)
+	}
+
+	if h.workers > 0 {
+		if h.queueSize < 0 {
+			h.queueSize = 0
+		}
+		h.queue = make(chan queuedEvent, h.queueSize)
+
+		for i := 0; i < h.workers; i++ {
+			h.wg.Add(1)
+			go h.work()
+		}
+	}
+
+	return h
+}
+
+func (h *WebhookHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	if h.maxBodySize > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, h.maxBodySize)
+	}
+
+	receivedAt := time.Now()
+
+	var event Event
+	body, err := io.ReadAll(r.Body)
+	if err == nil {
+		event, err = ParseWebhookPayload(body, h.parseOptions...)
+	}
+	if err != nil {
+		h.reportError(err)
+
+		var maxBytesErr *http.MaxBytesError
+		switch {
+		case errors.As(err, &maxBytesErr):
+			http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+		case errors.Is(err, ErrUnknownWebhookDeviceType):
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		default:
+			http.Error(w, "malformed webhook request", http.StatusBadRequest)
+		}
+		return
+	}
+
+	qe := queuedEvent{event: event}
+	if h.dedup != nil {
+		result := h.dedup.Check(event)
+		if !result.Deliver() {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		qe.late = result == DedupLateFlagged
+	}
+
+	if err := h.enqueue(qe); err != nil {
+		// SwitchBot retries the request, which must not be dropped as a duplicate
+		if h.dedup != nil {
+			h.dedup.Forget(event)
+		}
+		h.reportError(err)
+		http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+		return
+	}
+
+	if h.journal != nil {
+		if err := h.journal.Append(event, body, receivedAt); err != nil {
+			h.reportError(fmt.Errorf("appending event to journal: %w", err))
+		}
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// Dispatch dispatches the given event to the registered callbacks as if it
+// were received as a webhook request, e.g. to replay journaled events.
+// Deduplication and journaling are not applied to the event.
+func (h *WebhookHandler) Dispatch(event Event) error {
+	return h.enqueue(queuedEvent{event: event})
+}
+
+// Close stops accepting new events and waits until all the queued events
+// are processed. Requests received after Close are rejected with
+// 503 Service Unavailable.
+func (h *WebhookHandler) Close() error {
+	h.closeOnce.Do(func() {
+		h.closeMu.Lock()
+		h.closed = true
+		if h.queue != nil {
+			close(h.queue)
+		}
+		h.closeMu.Unlock()
+
+		h.wg.Wait()
+	})
+
+	return nil
+}
+
+func (h *WebhookHandler) enqueue(qe queuedEvent) error {
+	h.closeMu.RLock()
+	defer h.closeMu.RUnlock()
+
+	if h.closed {
+		return ErrWebhookHandlerClosed
+	}
+
+	if h.queue == nil {
+		h.dispatch(qe)
+		return nil
+	}
+
+	select {
+	case h.queue <- qe:
+		return nil
+	default:
+		return ErrWebhookQueueFull
+	}
+}
+
+func (h *WebhookHandler) work() {
+	defer h.wg.Done()
+
+	for qe := range h.queue {
+		h.dispatch(qe)
+	}
+}
+
+func (h *WebhookHandler) dispatch(qe queuedEvent) {
+	h.mu.RLock()
+	callbacks := h.callbacks
+	if qe.late && len(h.lateCallbacks) > 0 {
+		callbacks = h.lateCallbacks
+	}
+	h.mu.RUnlock()
+
+	for _, fn := range callbacks {
+		h.call(fn, qe.event)
+	}
+}
+
+func (h *WebhookHandler) call(fn func(Event), event Event) {
+	defer func() {
+		if v := recover(); v != nil {
+			h.reportError(&WebhookPanicError{Value: v, Stack: debug.Stack()})
+		}
+	}()
+
+	fn(event)
+}
+
+func (h *WebhookHandler) reportError(err error) {
+	if h.errorHandler != nil {
+		h.errorHandler(err)
+	}
+}
+
+func (h *WebhookHandler) addCallback(fn func(Event)) *WebhookHandler {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	callbacks := make([]func(Event), len(h.callbacks), len(h.callbacks)+1)
+	copy(callbacks, h.callbacks)
+	h.callbacks = append(callbacks, fn)
+
+	return h
+}
+
+// OnLateEvent registers a callback which is called with late events flagged
+// by the Deduplicator set with WithDeduplicator. Once a callback is
+// registered, the flagged events are no longer dispatched to the other
+// callbacks.
+func (h *WebhookHandler) OnLateEvent(fn func(Event)) *WebhookHandler {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	callbacks := make([]func(Event), len(h.lateCallbacks), len(h.lateCallbacks)+1)
+	copy(callbacks, h.lateCallbacks)
+	h.lateCallbacks = append(callbacks, fn)
+
+	return h
+}
+
+// OnEvent registers a callback which is called with every event received.
+func (h *WebhookHandler) OnEvent(fn func(Event)) *WebhookHandler {
+	return h.addCallback(fn)
+}
+
+// OnMotion registers a callback for motion sensor events.
+func (h *WebhookHandler) OnMotion(fn func(*MotionSensorEvent)) *WebhookHandler {
+	return h.addCallback(func(event Event) {
+		if e, ok := event.(*MotionSensorEvent); ok {
+			fn(e)
+		}
+	})
+}
+
+// OnContact registers a callback for contact sensor events.
+func (h *WebhookHandler) OnContact(fn func(*ContactSensorEvent)) *WebhookHandler {
+	return h.addCallback(func(event Event) {
+		if e, ok := event.(*ContactSensorEvent); ok {
+			fn(e)
+		}
+	})
+}
+
+// OnMeter registers a callback for meter events.
+func (h *WebhookHandler) OnMeter(fn func(*MeterEvent)) *WebhookHandler {
+	return h.addCallback(func(event Event) {
+		if e, ok := event.(*MeterEvent); ok {
+			fn(e)
+		}
+	})
+}
+
+// OnMeterPlus registers a callback for meter plus events.
+func (h *WebhookHandler) OnMeterPlus(fn func(*MeterPlusEvent)) *WebhookHandler {
+	return h.addCallback(func(event Event) {
+		if e, ok := event.(*MeterPlusEvent); ok {
+			fn(e)
+		}
+	})
+}
+
+// OnLock registers a callback for lock events.
+func (h *WebhookHandler) OnLock(fn func(*LockEvent)) *WebhookHandler {
+	return h.addCallback(func(event Event) {
+		if e, ok := event.(*LockEvent); ok {
+			fn(e)
+		}
+	})
+}
+
+// OnIndoorCam registers a callback for indoor cam events.
+func (h *WebhookHandler) OnIndoorCam(fn func(*IndoorCamEvent)) *WebhookHandler {
+	return h.addCallback(func(event Event) {
+		if e, ok := event.(*IndoorCamEvent); ok {
+			fn(e)
+		}
+	})
+}
+
+// OnPanTiltCam registers a callback for pan/tilt cam events.
+func (h *WebhookHandler) OnPanTiltCam(fn func(*PanTiltCamEvent)) *WebhookHandler {
+	return h.addCallback(func(event Event) {
+		if e, ok := event.(*PanTiltCamEvent); ok {
+			fn(e)
+		}
+	})
+}
+
+// OnColorBulb registers a callback for color bulb events.
+func (h *WebhookHandler) OnColorBulb(fn func(*ColorBulbEvent)) *WebhookHandler {
+	return h.addCallback(func(event Event) {
+		if e, ok := event.(*ColorBulbEvent); ok {
+			fn(e)
+		}
+	})
+}
+
+// OnStripLight registers a callback for LED strip light events.
+func (h *WebhookHandler) OnStripLight(fn func(*StripLightEvent)) *WebhookHandler {
+	return h.addCallback(func(event Event) {
+		if e, ok := event.(*StripLightEvent); ok {
+			fn(e)
+		}
+	})
+}
+
+// OnPlugMiniUS registers a callback for plug mini (US) events.
+func (h *WebhookHandler) OnPlugMiniUS(fn func(*PlugMiniUSEvent)) *WebhookHandler {
+	return h.addCallback(func(event Event) {
+		if e, ok := event.(*PlugMiniUSEvent); ok {
+			fn(e)
+		}
+	})
+}
+
+// OnPlugMiniJP registers a callback for plug mini (JP) events.
+func (h *WebhookHandler) OnPlugMiniJP(fn func(*PlugMiniJPEvent)) *WebhookHandler {
+	return h.addCallback(func(event Event) {
+		if e, ok := event.(*PlugMiniJPEvent); ok {
+			fn(e)
+		}
+	})
+}
+
+// OnSweeper registers a callback for robot vacuum cleaner events.
+func (h *WebhookHandler) OnSweeper(fn func(*SweeperEvent)) *WebhookHandler {
+	return h.addCallback(func(event Event) {
+		if e, ok := event.(*SweeperEvent); ok {
+			fn(e)
+		}
+	})
+}
+
+// OnCeiling registers a callback for ceiling light events.
+func (h *WebhookHandler) OnCeiling(fn func(*CeilingEvent)) *WebhookHandler {
+	return h.addCallback(func(event Event) {
+		if e, ok := event.(*CeilingEvent); ok {
+			fn(e)
+		}
+	})
+}
+
+// OnKeypad registers a callback for keypad events.
+func (h *WebhookHandler) OnKeypad(fn func(*KeypadEvent)) *WebhookHandler {
+	return h.addCallback(func(event Event) {
+		if e, ok := event.(*KeypadEvent); ok {
+			fn(e)
+		}
+	})
+}
+
+// OnBot registers a callback for bot events.
+func (h *WebhookHandler) OnBot(fn func(*BotEvent)) *WebhookHandler {
+	return h.addCallback(func(event Event) {
+		if e, ok := event.(*BotEvent); ok {
+			fn(e)
+		}
+	})
+}
+
+// OnCurtain registers a callback for curtain and curtain 3 events.
+func (h *WebhookHandler) OnCurtain(fn func(*CurtainEvent)) *WebhookHandler {
+	return h.addCallback(func(event Event) {
+		if e, ok := event.(*CurtainEvent); ok {
+			fn(e)
+		}
+	})
+}
+
+// OnBlindTilt registers a callback for blind tilt events.
+func (h *WebhookHandler) OnBlindTilt(fn func(*BlindTiltEvent)) *WebhookHandler {
+	return h.addCallback(func(event Event) {
+		if e, ok := event.(*BlindTiltEvent); ok {
+			fn(e)
+		}
+	})
+}
+
+// OnHub2 registers a callback for hub 2 events.
+func (h *WebhookHandler) OnHub2(fn func(*Hub2Event)) *WebhookHandler {
+	return h.addCallback(func(event Event) {
+		if e, ok := event.(*Hub2Event); ok {
+			fn(e)
+		}
+	})
+}
+
+// OnOutdoorMeter registers a callback for outdoor meter events.
+func (h *WebhookHandler) OnOutdoorMeter(fn func(*OutdoorMeterEvent)) *WebhookHandler {
+	return h.addCallback(func(event Event) {
+		if e, ok := event.(*OutdoorMeterEvent); ok {
+			fn(e)
+		}
+	})
+}
+
+// OnMeterPro registers a callback for meter pro and meter pro (CO2) events.
+func (h *WebhookHandler) OnMeterPro(fn func(*MeterProEvent)) *WebhookHandler {
+	return h.addCallback(func(event Event) {
+		if e, ok := event.(*MeterProEvent); ok {
+			fn(e)
+		}
+	})
+}
+
+// OnHumidifier registers a callback for humidifier events.
+func (h *WebhookHandler) OnHumidifier(fn func(*HumidifierEvent)) *WebhookHandler {
+	return h.addCallback(func(event Event) {
+		if e, ok := event.(*HumidifierEvent); ok {
+			fn(e)
+		}
+	})
+}
+
+// OnSmartFan registers a callback for smart fan events.
+func (h *WebhookHandler) OnSmartFan(fn func(*SmartFanEvent)) *WebhookHandler {
+	return h.addCallback(func(event Event) {
+		if e, ok := event.(*SmartFanEvent); ok {
+			fn(e)
+		}
+	})
+}
+
+// OnBatteryCirculatorFan registers a callback for battery circulator fan events.
+func (h *WebhookHandler) OnBatteryCirculatorFan(fn func(*BatteryCirculatorFanEvent)) *WebhookHandler {
+	return h.addCallback(func(event Event) {
+		if e, ok := event.(*BatteryCirculatorFanEvent); ok {
+			fn(e)
+		}
+	})
+}
+
+// OnRaw registers a callback for events sent from device types which are not
+// supported by this package.
+func (h *WebhookHandler) OnRaw(fn func(*RawEvent)) *WebhookHandler {
+	return h.addCallback(func(event Event) {
+		if e, ok := event.(*RawEvent); ok {
+			fn(e)
+		}
+	})
+}
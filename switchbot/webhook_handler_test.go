@@ -0,0 +1,210 @@
+package switchbot_test
+
+import (
+	"errors"
+	"fmt"
+	switchbot2 "github.com/nasa9084/go-switchbot/v3/switchbot"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+const lockWebhookBody = `{"eventType":"changeReport","eventVersion":"1","context":{"deviceType":"WoLock","deviceMac":"01:00:5e:90:10:00","lockState":"LOCKED","timeOfSample":123456789}}`
+
+func TestWebhookHandler(t *testing.T) {
+	t.Run("dispatch", func(t *testing.T) {
+		var (
+			got     *switchbot2.LockEvent
+			generic int
+			motion  int
+		)
+
+		h := switchbot2.NewWebhookHandler()
+		h.OnLock(func(e *switchbot2.LockEvent) { got = e }).
+			OnMotion(func(*switchbot2.MotionSensorEvent) { motion++ }).
+			OnEvent(func(switchbot2.Event) { generic++ })
+
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(lockWebhookBody)))
+
+		if rec.Code != http.StatusOK {
+			t.Fatalf("unexpected status code: %d", rec.Code)
+		}
+
+		want := &switchbot2.LockEvent{
+			EventType:    "changeReport",
+			EventVersion: "1",
+			Context: switchbot2.LockEventContext{
+				EventContext: switchbot2.EventContext{
+					DeviceType:   "WoLock",
+					DeviceMac:    "01:00:5e:90:10:00",
+					TimeOfSample: 123456789,
+				},
+				LockState: "LOCKED",
+			},
+		}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Fatalf("event mismatch (-want +got):\n%s", diff)
+		}
+
+		if generic != 1 {
+			t.Errorf("generic callback is expected to be called once but %d", generic)
+		}
+		if motion != 0 {
+			t.Errorf("motion callback is not expected to be called but %d", motion)
+		}
+	})
+
+	t.Run("status codes", func(t *testing.T) {
+		tests := []struct {
+			name   string
+			method string
+			body   string
+			want   int
+		}{
+			{"method not allowed", http.MethodGet, "", http.StatusMethodNotAllowed},
+			{"too large", http.MethodPost, lockWebhookBody + strings.Repeat(" ", 1024), http.StatusRequestEntityTooLarge},
+			{"malformed", http.MethodPost, `{"context":`, http.StatusBadRequest},
+			{"missing device type", http.MethodPost, `{}`, http.StatusBadRequest},
+			{"unknown device in strict mode", http.MethodPost, `{"context":{"deviceType":"WoUnknown"}}`, http.StatusUnprocessableEntity},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				var reported error
+				h := switchbot2.NewWebhookHandler(
+					switchbot2.WithMaxBodySize(512),
+					switchbot2.WithParseOptions(switchbot2.StrictParsing()),
+					switchbot2.WithErrorHandler(func(err error) { reported = err }),
+				)
+
+				rec := httptest.NewRecorder()
+				h.ServeHTTP(rec, httptest.NewRequest(tt.method, "/", strings.NewReader(tt.body)))
+
+				if rec.Code != tt.want {
+					t.Errorf("status code is expected to be %d but %d", tt.want, rec.Code)
+				}
+				if tt.method == http.MethodPost && reported == nil {
+					t.Error("error is expected to be reported")
+				}
+			})
+		}
+	})
+
+	t.Run("panic recovery", func(t *testing.T) {
+		var reported error
+		h := switchbot2.NewWebhookHandler(switchbot2.WithErrorHandler(func(err error) { reported = err }))
+		h.OnLock(func(*switchbot2.LockEvent) { panic("boom") })
+
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(lockWebhookBody)))
+
+		var panicErr *switchbot2.WebhookPanicError
+		if !errors.As(reported, &panicErr) {
+			t.Fatalf("panic error is expected to be reported but %v", reported)
+		}
+		if panicErr.Value != "boom" {
+			t.Errorf("unexpected panic value: %v", panicErr.Value)
+		}
+	})
+
+	t.Run("workers", func(t *testing.T) {
+		var (
+			mu    sync.Mutex
+			count int
+		)
+
+		h := switchbot2.NewWebhookHandler(switchbot2.WithWorkers(2, 10))
+		h.OnLock(func(*switchbot2.LockEvent) {
+			mu.Lock()
+			count++
+			mu.Unlock()
+		})
+
+		for i := 0; i < 5; i++ {
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(lockWebhookBody)))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("unexpected status code: %d", rec.Code)
+			}
+		}
+
+		h.Close()
+
+		if count != 5 {
+			t.Errorf("lock callback is expected to be called 5 times but %d", count)
+		}
+
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(lockWebhookBody)))
+		if rec.Code != http.StatusServiceUnavailable {
+			t.Errorf("closed handler is expected to respond 503 but %d", rec.Code)
+		}
+	})
+	t.Run("redelivery after queue full", func(t *testing.T) {
+		var (
+			mu      sync.Mutex
+			count   int
+			started = make(chan struct{}, 2)
+			release = make(chan struct{})
+		)
+
+		h := switchbot2.NewWebhookHandler(
+			switchbot2.WithWorkers(1, 1),
+			switchbot2.WithDeduplicator(switchbot2.NewDeduplicator()),
+		)
+		h.OnMotion(func(*switchbot2.MotionSensorEvent) {
+			started <- struct{}{}
+			<-release
+		})
+		h.OnLock(func(*switchbot2.LockEvent) {
+			mu.Lock()
+			count++
+			mu.Unlock()
+		})
+
+		post := func(body string) int {
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body)))
+			return rec.Code
+		}
+
+		// the only worker is blocked and the queue is filled
+		motion := `{"eventType":"changeReport","eventVersion":"1","context":{"deviceType":"WoPresence","deviceMac":"01:00:5e:90:10:01","detectionState":"DETECTED","timeOfSample":%d}}`
+		if code := post(fmt.Sprintf(motion, 1)); code != http.StatusOK {
+			t.Fatalf("unexpected status code: %d", code)
+		}
+		<-started
+		if code := post(fmt.Sprintf(motion, 2)); code != http.StatusOK {
+			t.Fatalf("unexpected status code: %d", code)
+		}
+
+		if code := post(lockWebhookBody); code != http.StatusServiceUnavailable {
+			t.Fatalf("status code is expected to be 503 but %d", code)
+		}
+		close(release)
+
+		// the redelivery is accepted once the worker is ready again
+		deadline := time.Now().Add(5 * time.Second)
+		for post(lockWebhookBody) != http.StatusOK {
+			if time.Now().After(deadline) {
+				t.Fatal("redelivery is not accepted")
+			}
+			time.Sleep(time.Millisecond)
+		}
+		if code := post(lockWebhookBody); code != http.StatusOK {
+			t.Fatalf("duplicate is expected to be responded with 200 but %d", code)
+		}
+
+		h.Close()
+
+		if count != 1 {
+			t.Errorf("lock callback is expected to be called once but %d", count)
+		}
+	})
+}
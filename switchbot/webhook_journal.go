@@ -0,0 +1,491 @@
+package switchbot
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultJournalMaxSize is the default size of a journal file at which
+	// the journal is rotated.
+	DefaultJournalMaxSize = 64 << 20
+
+	journalFilePrefix = "events-"
+	journalFileSuffix = ".jsonl"
+	journalTimeLayout = "20060102T150405.000000000Z"
+)
+
+// JournalSyncPolicy determines when the journal file is synced to the disk.
+type JournalSyncPolicy int
+
+const (
+	// SyncEveryRecord syncs the journal file on every appended record.
+	SyncEveryRecord JournalSyncPolicy = iota
+	// SyncInterval syncs the journal file periodically.
+	// The interval is configured with WithJournalSyncInterval.
+	SyncInterval
+	// SyncNever leaves syncing to the operating system.
+	SyncNever
+)
+
+// JournalRecord is a webhook event recorded in a Journal.
+type JournalRecord struct {
+	// ReceivedAt is the time when the event was received.
+	ReceivedAt time.Time `json:"receivedAt"`
+	// DeviceType is the deviceType value of the event, e.g. WoLock.
+	DeviceType string `json:"deviceType"`
+	// DeviceID is the device ID of the device which sent the event.
+	DeviceID string `json:"deviceId"`
+	// TimeOfSample is the time when the event was sampled.
+	TimeOfSample time.Time `json:"timeOfSample"`
+	// Body is the raw request body of the webhook request.
+	Body json.RawMessage `json:"body"`
+}
+
+// Event parses the recorded request body into an Event.
+func (rec JournalRecord) Event(opts ...ParseOption) (Event, error) {
+	return ParseWebhookPayload(rec.Body, opts...)
+}
+
+// Journal is an append-only journal of webhook events, which is stored
+// as JSON lines files in a directory. The journal file is rotated when it
+// exceeds the size limit or gets older than the age limit.
+type Journal struct {
+	dir          string
+	maxSize      int64
+	maxAge       time.Duration
+	syncPolicy   JournalSyncPolicy
+	syncInterval time.Duration
+	now          func() time.Time
+
+	mu       sync.Mutex
+	file     *os.File
+	w        *bufio.Writer
+	size     int64
+	openedAt time.Time
+	dirty    bool
+	closed   bool
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// JournalOption configures a Journal.
+type JournalOption func(*Journal)
+
+// WithJournalMaxSize sets the size of a journal file at which the journal is rotated.
+func WithJournalMaxSize(n int64) JournalOption {
+	return func(j *Journal) {
+		j.maxSize = n
+	}
+}
+
+// WithJournalMaxAge sets the age of a journal file at which the journal is rotated.
+// By default journal files are not rotated by their age.
+func WithJournalMaxAge(d time.Duration) JournalOption {
+	return func(j *Journal) {
+		j.maxAge = d
+	}
+}
+
+// WithJournalSyncPolicy sets when the journal file is synced to the disk.
+// The default policy is SyncEveryRecord.
+func WithJournalSyncPolicy(policy JournalSyncPolicy) JournalOption {
+	return func(j *Journal) {
+		j.syncPolicy = policy
+	}
+}
+
+// WithJournalSyncInterval sets the interval of syncing for SyncInterval policy.
+func WithJournalSyncInterval(d time.Duration) JournalOption {
+	return func(j *Journal) {
+		j.syncInterval = d
+	}
+}
+
+// OpenJournal opens a journal stored in the given directory, creating the
+// directory if not exists. New records are appended to a new journal file.
+func OpenJournal(dir string, opts ...JournalOption) (*Journal, error) {
+	j := &Journal{
+		dir:          dir,
+		maxSize:      DefaultJournalMaxSize,
+		syncPolicy:   SyncEveryRecord,
+		syncInterval: time.Second,
+		now:          time.Now,
+	}
+
+	for _, opt := range opts {
+		opt(j)
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	if j.syncPolicy == SyncInterval {
+		j.stop = make(chan struct{})
+		j.done = make(chan struct{})
+		go j.syncLoop()
+	}
+
+	return j, nil
+}
+
+// Append records the given event with its raw request body.
+// Records are expected to be appended in the order of their receipt time.
+func (j *Journal) Append(event Event, body []byte, receivedAt time.Time) error {
+	rec := JournalRecord{
+		ReceivedAt:   receivedAt.UTC(),
+		DeviceType:   event.WebhookDeviceType(),
+		DeviceID:     event.DeviceID(),
+		TimeOfSample: event.Time().UTC(),
+		Body:         json.RawMessage(body),
+	}
+
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.closed {
+		return errors.New("journal is closed")
+	}
+
+	if err := j.rotateIfNeeded(int64(len(line)), rec.ReceivedAt); err != nil {
+		return err
+	}
+
+	n, err := j.w.Write(line)
+	j.size += int64(n)
+	if err != nil {
+		return err
+	}
+
+	switch j.syncPolicy {
+	case SyncEveryRecord:
+		return j.flush(true)
+	case SyncNever:
+		return j.flush(false)
+	default:
+		j.dirty = true
+		return nil
+	}
+}
+
+// rotateIfNeeded opens a new journal file if needed. Journal files are named
+// after the receipt time of their first record so that readers can skip the
+// files out of the range.
+func (j *Journal) rotateIfNeeded(n int64, receivedAt time.Time) error {
+	if j.file != nil {
+		full := j.maxSize > 0 && j.size > 0 && j.size+n > j.maxSize
+		old := j.maxAge > 0 && j.now().Sub(j.openedAt) >= j.maxAge
+		if !full && !old {
+			return nil
+		}
+
+		if err := j.closeFile(); err != nil {
+			return err
+		}
+	}
+
+	name := filepath.Join(j.dir, journalFilePrefix+receivedAt.UTC().Format(journalTimeLayout)+journalFileSuffix)
+
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	j.file = f
+	j.w = bufio.NewWriter(f)
+	j.size = info.Size()
+	j.openedAt = j.now()
+
+	return nil
+}
+
+func (j *Journal) flush(sync bool) error {
+	if j.w == nil {
+		return nil
+	}
+
+	if err := j.w.Flush(); err != nil {
+		return err
+	}
+
+	if sync {
+		j.dirty = false
+		return j.file.Sync()
+	}
+
+	return nil
+}
+
+func (j *Journal) closeFile() error {
+	if err := j.flush(j.syncPolicy != SyncNever); err != nil {
+		return err
+	}
+
+	err := j.file.Close()
+	j.file = nil
+	j.w = nil
+
+	return err
+}
+
+func (j *Journal) syncLoop() {
+	defer close(j.done)
+
+	ticker := time.NewTicker(j.syncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-j.stop:
+			return
+		case <-ticker.C:
+			j.mu.Lock()
+			if j.dirty && !j.closed {
+				_ = j.flush(true)
+			}
+			j.mu.Unlock()
+		}
+	}
+}
+
+// Close flushes and closes the journal.
+func (j *Journal) Close() error {
+	j.mu.Lock()
+	if j.closed {
+		j.mu.Unlock()
+		return nil
+	}
+	j.closed = true
+
+	var err error
+	if j.file != nil {
+		err = j.closeFile()
+	}
+	j.mu.Unlock()
+
+	if j.stop != nil {
+		close(j.stop)
+		<-j.done
+	}
+
+	return err
+}
+
+// JournalFilter selects records read from a journal.
+// Zero values match everything.
+type JournalFilter struct {
+	// Since selects the records received at or after the time.
+	Since time.Time
+	// Until selects the records received before the time.
+	Until time.Time
+	// DeviceIDs selects the records sent from the devices. Both device IDs
+	// and MAC addresses are accepted.
+	DeviceIDs []string
+}
+
+func (f JournalFilter) match(rec JournalRecord) bool {
+	if !f.Since.IsZero() && rec.ReceivedAt.Before(f.Since) {
+		return false
+	}
+
+	if !f.Until.IsZero() && !rec.ReceivedAt.Before(f.Until) {
+		return false
+	}
+
+	if len(f.DeviceIDs) == 0 {
+		return true
+	}
+
+	for _, id := range f.DeviceIDs {
+		if DeviceIDFromMAC(id) == rec.DeviceID {
+			return true
+		}
+	}
+
+	return false
+}
+
+// JournalReader reads records from a journal in the order they were appended.
+type JournalReader struct {
+	filter JournalFilter
+	files  []string
+
+	file    *os.File
+	scanner *bufio.Scanner
+}
+
+// NewJournalReader returns a new JournalReader reading the records matching
+// the filter from the journal stored in the given directory.
+func NewJournalReader(dir string, filter JournalFilter) (*JournalReader, error) {
+	files, err := filepath.Glob(filepath.Join(dir, journalFilePrefix+"*"+journalFileSuffix))
+	if err != nil {
+		return nil, err
+	}
+
+	// the file names contain the time the files were created
+	sort.Strings(files)
+
+	// skip the files which only contain older records than filter.Since
+	if !filter.Since.IsZero() {
+		for len(files) > 1 {
+			next, err := journalFileTime(files[1])
+			if err != nil || next.After(filter.Since) {
+				break
+			}
+			files = files[1:]
+		}
+	}
+
+	return &JournalReader{
+		filter: filter,
+		files:  files,
+	}, nil
+}
+
+func journalFileTime(name string) (time.Time, error) {
+	base := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(name), journalFilePrefix), journalFileSuffix)
+	return time.Parse(journalTimeLayout, base)
+}
+
+// Next returns the next record. io.EOF is returned when there are no more records.
+func (r *JournalReader) Next() (JournalRecord, error) {
+	for {
+		if r.scanner == nil {
+			if len(r.files) == 0 {
+				return JournalRecord{}, io.EOF
+			}
+
+			// the files newer than filter.Until contain no matched records
+			if !r.filter.Until.IsZero() {
+				if t, err := journalFileTime(r.files[0]); err == nil && !t.Before(r.filter.Until) {
+					r.files = nil
+					return JournalRecord{}, io.EOF
+				}
+			}
+
+			f, err := os.Open(r.files[0])
+			if err != nil {
+				return JournalRecord{}, err
+			}
+			r.files = r.files[1:]
+			r.file = f
+			r.scanner = bufio.NewScanner(f)
+			r.scanner.Buffer(make([]byte, 64<<10), 16<<20)
+		}
+
+		if !r.scanner.Scan() {
+			err := r.scanner.Err()
+			r.file.Close()
+			r.file = nil
+			r.scanner = nil
+			if err != nil {
+				return JournalRecord{}, err
+			}
+			continue
+		}
+
+		line := r.scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+
+		var rec JournalRecord
+		if err := json.Unmarshal(line, &rec); err != nil {
+			// a partially written record may be left at the end of a
+			// file when the process crashed
+			continue
+		}
+
+		if r.filter.match(rec) {
+			return rec, nil
+		}
+	}
+}
+
+// Close closes the reader.
+func (r *JournalReader) Close() error {
+	r.files = nil
+	if r.file != nil {
+		err := r.file.Close()
+		r.file = nil
+		r.scanner = nil
+		return err
+	}
+
+	return nil
+}
+
+// Replay re-dispatches the records read from r into fn. The records are
+// dispatched keeping the original intervals between their receipt times
+// divided by speed, e.g. speed 10 replays ten times faster than the original.
+// If speed is zero or negative, the records are dispatched as fast as possible.
+// Replay stops at the first error returned by fn.
+func Replay(ctx context.Context, r *JournalReader, fn func(Event) error, speed float64) error {
+	var (
+		first   time.Time
+		started time.Time
+	)
+
+	for {
+		rec, err := r.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		if speed > 0 {
+			if first.IsZero() {
+				first = rec.ReceivedAt
+				started = time.Now()
+			}
+
+			offset := time.Duration(float64(rec.ReceivedAt.Sub(first)) / speed)
+			if wait := time.Until(started.Add(offset)); wait > 0 {
+				timer := time.NewTimer(wait)
+				select {
+				case <-ctx.Done():
+					timer.Stop()
+					return ctx.Err()
+				case <-timer.C:
+				}
+			}
+		}
+
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		event, err := rec.Event()
+		if err != nil {
+			return fmt.Errorf("parsing journaled event received at %s: %w", rec.ReceivedAt, err)
+		}
+
+		if err := fn(event); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,240 @@
+package switchbot_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	switchbot2 "github.com/nasa9084/go-switchbot/v3/switchbot"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestJournal(t *testing.T) {
+	dir := t.TempDir()
+
+	j, err := switchbot2.OpenJournal(dir, switchbot2.WithJournalMaxSize(512))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 6; i++ {
+		mac := "01:00:5e:90:10:00"
+		if i%2 == 1 {
+			mac = "01:00:5e:90:10:01"
+		}
+		body := fmt.Sprintf(`{"eventType":"changeReport","eventVersion":"1","context":{"deviceType":"WoLock","deviceMac":%q,"lockState":"LOCKED","timeOfSample":%d}}`, mac, i)
+
+		event, err := switchbot2.ParseWebhookPayload([]byte(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := j.Append(event, []byte(body), base.Add(time.Duration(i)*time.Minute)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := j.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*.jsonl"))
+	if len(files) < 2 {
+		t.Errorf("journal is expected to be rotated but %d files", len(files))
+	}
+
+	t.Run("read", func(t *testing.T) {
+		r, err := switchbot2.NewJournalReader(dir, switchbot2.JournalFilter{
+			Since:     base.Add(time.Minute),
+			Until:     base.Add(5 * time.Minute),
+			DeviceIDs: []string{"01:00:5e:90:10:01"},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Close()
+
+		var got []time.Time
+		for {
+			rec, err := r.Next()
+			if errors.Is(err, io.EOF) {
+				break
+			} else if err != nil {
+				t.Fatal(err)
+			}
+
+			if rec.DeviceID != "01005E901001" {
+				t.Errorf("unexpected device ID: %s", rec.DeviceID)
+			}
+			got = append(got, rec.ReceivedAt)
+		}
+
+		if len(got) != 2 || !got[0].Equal(base.Add(time.Minute)) || !got[1].Equal(base.Add(3*time.Minute)) {
+			t.Errorf("unexpected records: %v", got)
+		}
+	})
+
+	t.Run("replay", func(t *testing.T) {
+		r, err := switchbot2.NewJournalReader(dir, switchbot2.JournalFilter{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Close()
+
+		var samples []int64
+		h := switchbot2.NewWebhookHandler()
+		h.OnLock(func(e *switchbot2.LockEvent) {
+			samples = append(samples, e.Context.TimeOfSample)
+		})
+
+		if err := switchbot2.Replay(context.Background(), r, h.Dispatch, 0); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(samples) != 6 {
+			t.Fatalf("6 events are expected to be replayed but %d", len(samples))
+		}
+		for i, s := range samples {
+			if s != int64(i) {
+				t.Errorf("events are expected to be replayed in order but %v", samples)
+				break
+			}
+		}
+	})
+}
+
+func TestWebhookHandlerJournal(t *testing.T) {
+	dir := t.TempDir()
+
+	j, err := switchbot2.OpenJournal(dir, switchbot2.WithJournalSyncPolicy(switchbot2.SyncInterval), switchbot2.WithJournalSyncInterval(time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := switchbot2.NewWebhookHandler(switchbot2.WithJournal(j))
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(lockWebhookBody)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unexpected status code: %d", rec.Code)
+	}
+
+	if err := j.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := switchbot2.NewJournalReader(dir, switchbot2.JournalFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	got, err := r.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(got.Body) != lockWebhookBody {
+		t.Errorf("unexpected journaled body: %s", got.Body)
+	}
+	if got.DeviceType != "WoLock" {
+		t.Errorf("unexpected journaled device type: %s", got.DeviceType)
+	}
+}
+
+func TestWebhookHandlerJournalRetries(t *testing.T) {
+	dir := t.TempDir()
+
+	j, err := switchbot2.OpenJournal(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	started := make(chan struct{}, 2)
+	release := make(chan struct{})
+	h := switchbot2.NewWebhookHandler(
+		switchbot2.WithWorkers(1, 1),
+		switchbot2.WithDeduplicator(switchbot2.NewDeduplicator()),
+		switchbot2.WithJournal(j),
+	)
+	h.OnMotion(func(*switchbot2.MotionSensorEvent) {
+		started <- struct{}{}
+		<-release
+	})
+
+	post := func(body string) int {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body)))
+		return rec.Code
+	}
+
+	// the only worker is blocked and the queue is filled
+	motion := `{"eventType":"changeReport","eventVersion":"1","context":{"deviceType":"WoPresence","deviceMac":"01:00:5e:90:10:01","detectionState":"DETECTED","timeOfSample":%d}}`
+	if code := post(fmt.Sprintf(motion, 1)); code != http.StatusOK {
+		t.Fatalf("unexpected status code: %d", code)
+	}
+	<-started
+	if code := post(fmt.Sprintf(motion, 2)); code != http.StatusOK {
+		t.Fatalf("unexpected status code: %d", code)
+	}
+
+	// the rejected requests are retried by SwitchBot
+	for i := 0; i < 2; i++ {
+		if code := post(lockWebhookBody); code != http.StatusServiceUnavailable {
+			t.Fatalf("status code is expected to be 503 but %d", code)
+		}
+	}
+	close(release)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for post(lockWebhookBody) != http.StatusOK {
+		if time.Now().After(deadline) {
+			t.Fatal("redelivery is not accepted")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	// the duplicate is dropped
+	if code := post(lockWebhookBody); code != http.StatusOK {
+		t.Fatalf("unexpected status code: %d", code)
+	}
+
+	h.Close()
+	if err := j.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := switchbot2.NewJournalReader(dir, switchbot2.JournalFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	var locks, records int
+	for {
+		record, err := r.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		records++
+		if record.DeviceType == "WoLock" {
+			locks++
+		}
+	}
+
+	if records != 3 {
+		t.Errorf("3 events are expected to be journaled but %d", records)
+	}
+	if locks != 1 {
+		t.Errorf("the lock event is expected to be journaled once but %d", locks)
+	}
+}
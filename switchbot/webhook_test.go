@@ -4,9 +4,12 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	switchbot2 "github.com/nasa9084/go-switchbot/v3/switchbot"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
@@ -50,7 +53,7 @@ func TestWebhookQuery(t *testing.T) {
 	t.Run("queryUrl", func(t *testing.T) {
 		srv := httptest.NewServer(
 			http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-				w.Write([]byte(`{"statusCode":100,"body":{"urls":[url1]},"message":""}`))
+				w.Write([]byte(`{"statusCode":100,"body":{"urls":["url1"]},"message":""}`))
 
 				if r.Method != http.MethodPost {
 					t.Fatalf("POST method is expected but %s", r.Method)
@@ -75,15 +78,24 @@ func TestWebhookQuery(t *testing.T) {
 
 		c := switchbot2.New("", "", switchbot2.WithEndpoint(srv.URL))
 
-		if err := c.Webhook().Query(context.Background(), switchbot2.QueryURL, ""); err != nil {
+		got, err := c.Webhook().Query(context.Background(), switchbot2.QueryURL, "")
+		if err != nil {
 			t.Fatal(err)
 		}
+
+		want := &switchbot2.WebhookQueryResult{
+			URLs: []string{"url1"},
+		}
+
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Fatalf("result mismatch (-want +got):\n%s", diff)
+		}
 	})
 
 	t.Run("queryDetails", func(t *testing.T) {
 		srv := httptest.NewServer(
 			http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-				w.Write([]byte(`{"statusCode":100,"body":[{"url":url1,"createTime":123456,"lastUpdateTime":123456,"deviceList":"ALL","enable":true}],"message":""}`))
+				w.Write([]byte(`{"statusCode":100,"body":[{"url":"url1","createTime":123456,"lastUpdateTime":123456,"deviceList":"ALL","enable":true}],"message":""}`))
 
 				if r.Method != http.MethodPost {
 					t.Fatalf("POST method is expected but %s", r.Method)
@@ -108,12 +120,125 @@ func TestWebhookQuery(t *testing.T) {
 
 		c := switchbot2.New("", "", switchbot2.WithEndpoint(srv.URL))
 
-		if err := c.Webhook().Query(context.Background(), switchbot2.QueryDetails, "url1"); err != nil {
+		got, err := c.Webhook().Query(context.Background(), switchbot2.QueryDetails, "url1")
+		if err != nil {
 			t.Fatal(err)
 		}
+
+		want := &switchbot2.WebhookQueryResult{
+			Details: []switchbot2.WebhookQueryDetails{
+				{
+					URL:        "url1",
+					CreateTime: 123456,
+					LastUpdate: 123456,
+					DeviceList: "ALL",
+					Enable:     true,
+				},
+			},
+		}
+
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Fatalf("result mismatch (-want +got):\n%s", diff)
+		}
 	})
 }
 
+func TestWebhookEnsure(t *testing.T) {
+	tests := []struct {
+		name    string
+		urls    string
+		enabled bool
+		want    *switchbot2.WebhookEnsureReport
+		actions []string
+	}{
+		{
+			name: "create",
+			urls: `[]`,
+			want: &switchbot2.WebhookEnsureReport{
+				URL:     "url1",
+				Created: true,
+			},
+			actions: []string{"queryUrl", "setupWebhook"},
+		},
+		{
+			name: "replace stale url",
+			urls: `["url0"]`,
+			want: &switchbot2.WebhookEnsureReport{
+				URL:     "url1",
+				Deleted: []string{"url0"},
+				Created: true,
+			},
+			actions: []string{"queryUrl", "deleteWebhook", "setupWebhook"},
+		},
+		{
+			name:    "re-enable",
+			urls:    `["url1"]`,
+			enabled: false,
+			want: &switchbot2.WebhookEnsureReport{
+				URL:     "url1",
+				Enabled: true,
+			},
+			actions: []string{"queryUrl", "queryDetails", "updateWebhook"},
+		},
+		{
+			name:    "unchanged",
+			urls:    `["url1"]`,
+			enabled: true,
+			want: &switchbot2.WebhookEnsureReport{
+				URL: "url1",
+			},
+			actions: []string{"queryUrl", "queryDetails"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var actions []string
+
+			srv := httptest.NewServer(
+				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					var req map[string]interface{}
+					if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+						t.Fatal(err)
+					}
+
+					action, _ := req["action"].(string)
+					actions = append(actions, action)
+
+					switch action {
+					case "queryUrl":
+						fmt.Fprintf(w, `{"statusCode":100,"body":{"urls":%s},"message":""}`, tt.urls)
+					case "queryDetails":
+						fmt.Fprintf(w, `{"statusCode":100,"body":[{"url":"url1","deviceList":"ALL","enable":%t}],"message":""}`, tt.enabled)
+					default:
+						w.Write([]byte(`{"statusCode":100,"body":{},"message":""}`))
+					}
+				}),
+			)
+			defer srv.Close()
+
+			c := switchbot2.New("", "", switchbot2.WithEndpoint(srv.URL))
+
+			got, err := c.Webhook().Ensure(context.Background(), "url1")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("report mismatch (-want +got):\n%s", diff)
+			}
+
+			if diff := cmp.Diff(tt.actions, actions); diff != "" {
+				t.Errorf("actions mismatch (-want +got):\n%s", diff)
+			}
+
+			if want := tt.name != "unchanged"; got.Changed() != want {
+				t.Errorf("Changed() = %t, want %t", got.Changed(), want)
+			}
+		})
+	}
+}
+
 func TestWebhookUpdate(t *testing.T) {
 	srv := httptest.NewServer(
 		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -201,10 +326,12 @@ func TestParseWebhook(t *testing.T) {
 						EventType:    "changeReport",
 						EventVersion: "1",
 						Context: switchbot2.MotionSensorEventContext{
-							DeviceType:     "WoPresence",
-							DeviceMac:      "01:00:5e:90:10:00",
+							EventContext: switchbot2.EventContext{
+								DeviceType:   "WoPresence",
+								DeviceMac:    "01:00:5e:90:10:00",
+								TimeOfSample: 123456789,
+							},
 							DetectionState: "NOT_DETECTED",
-							TimeOfSample:   123456789,
 						},
 					}
 
@@ -234,13 +361,15 @@ func TestParseWebhook(t *testing.T) {
 						EventType:    "changeReport",
 						EventVersion: "1",
 						Context: switchbot2.ContactSensorEventContext{
-							DeviceType:     "WoContact",
-							DeviceMac:      "01:00:5e:90:10:00",
+							EventContext: switchbot2.EventContext{
+								DeviceType:   "WoContact",
+								DeviceMac:    "01:00:5e:90:10:00",
+								TimeOfSample: 123456789,
+							},
 							DetectionState: "NOT_DETECTED",
 							DoorMode:       "OUT_DOOR",
 							Brightness:     switchbot2.AmbientBrightnessDim,
 							OpenState:      "open",
-							TimeOfSample:   123456789,
 						},
 					}
 
@@ -270,12 +399,14 @@ func TestParseWebhook(t *testing.T) {
 						EventType:    "changeReport",
 						EventVersion: "1",
 						Context: switchbot2.MeterEventContext{
-							DeviceType:   "WoMeter",
-							DeviceMac:    "01:00:5e:90:10:00",
-							Temperature:  22.5,
-							Scale:        "CELSIUS",
-							Humidity:     31,
-							TimeOfSample: 123456789,
+							EventContext: switchbot2.EventContext{
+								DeviceType:   "WoMeter",
+								DeviceMac:    "01:00:5e:90:10:00",
+								TimeOfSample: 123456789,
+							},
+							Temperature: 22.5,
+							Scale:       "CELSIUS",
+							Humidity:    31,
 						},
 					}
 
@@ -305,12 +436,14 @@ func TestParseWebhook(t *testing.T) {
 						EventType:    "changeReport",
 						EventVersion: "1",
 						Context: switchbot2.MeterPlusEventContext{
-							DeviceType:   "WoMeterPlus",
-							DeviceMac:    "01:00:5e:90:10:00",
-							Temperature:  22.5,
-							Scale:        "CELSIUS",
-							Humidity:     31,
-							TimeOfSample: 123456789,
+							EventContext: switchbot2.EventContext{
+								DeviceType:   "WoMeterPlus",
+								DeviceMac:    "01:00:5e:90:10:00",
+								TimeOfSample: 123456789,
+							},
+							Temperature: 22.5,
+							Scale:       "CELSIUS",
+							Humidity:    31,
 						},
 					}
 
@@ -342,10 +475,12 @@ func TestParseWebhook(t *testing.T) {
 						EventType:    "changeReport",
 						EventVersion: "1",
 						Context: switchbot2.LockEventContext{
-							DeviceType:   "WoLock",
-							DeviceMac:    "01:00:5e:90:10:00",
-							LockState:    "LOCKED",
-							TimeOfSample: 123456789,
+							EventContext: switchbot2.EventContext{
+								DeviceType:   "WoLock",
+								DeviceMac:    "01:00:5e:90:10:00",
+								TimeOfSample: 123456789,
+							},
+							LockState: "LOCKED",
 						},
 					}
 
@@ -375,10 +510,12 @@ func TestParseWebhook(t *testing.T) {
 						EventType:    "changeReport",
 						EventVersion: "1",
 						Context: switchbot2.IndoorCamEventContext{
-							DeviceType:     "WoCamera",
-							DeviceMac:      "01:00:5e:90:10:00",
+							EventContext: switchbot2.EventContext{
+								DeviceType:   "WoCamera",
+								DeviceMac:    "01:00:5e:90:10:00",
+								TimeOfSample: 123456789,
+							},
 							DetectionState: "DETECTED",
-							TimeOfSample:   123456789,
 						},
 					}
 
@@ -408,10 +545,12 @@ func TestParseWebhook(t *testing.T) {
 						EventType:    "changeReport",
 						EventVersion: "1",
 						Context: switchbot2.PanTiltCamEventContext{
-							DeviceType:     "WoPanTiltCam",
-							DeviceMac:      "01:00:5e:90:10:00",
+							EventContext: switchbot2.EventContext{
+								DeviceType:   "WoPanTiltCam",
+								DeviceMac:    "01:00:5e:90:10:00",
+								TimeOfSample: 123456789,
+							},
 							DetectionState: "DETECTED",
-							TimeOfSample:   123456789,
 						},
 					}
 
@@ -441,13 +580,15 @@ func TestParseWebhook(t *testing.T) {
 						EventType:    "changeReport",
 						EventVersion: "1",
 						Context: switchbot2.ColorBulbEventContext{
-							DeviceType:       "WoBulb",
-							DeviceMac:        "01:00:5e:90:10:00",
+							EventContext: switchbot2.EventContext{
+								DeviceType:   "WoBulb",
+								DeviceMac:    "01:00:5e:90:10:00",
+								TimeOfSample: 123456789,
+							},
 							PowerState:       switchbot2.PowerOn,
 							Brightness:       10,
 							Color:            "255:245:235",
 							ColorTemperature: 3500,
-							TimeOfSample:     123456789,
 						},
 					}
 
@@ -477,12 +618,14 @@ func TestParseWebhook(t *testing.T) {
 						EventType:    "changeReport",
 						EventVersion: "1",
 						Context: switchbot2.StripLightEventContext{
-							DeviceType:   "WoStrip",
-							DeviceMac:    "01:00:5e:90:10:00",
-							PowerState:   switchbot2.PowerOn,
-							Brightness:   10,
-							Color:        "255:245:235",
-							TimeOfSample: 123456789,
+							EventContext: switchbot2.EventContext{
+								DeviceType:   "WoStrip",
+								DeviceMac:    "01:00:5e:90:10:00",
+								TimeOfSample: 123456789,
+							},
+							PowerState: switchbot2.PowerOn,
+							Brightness: 10,
+							Color:      "255:245:235",
 						},
 					}
 
@@ -512,10 +655,12 @@ func TestParseWebhook(t *testing.T) {
 						EventType:    "changeReport",
 						EventVersion: "1",
 						Context: switchbot2.PlugMiniUSEventContext{
-							DeviceType:   "WoPlugUS",
-							DeviceMac:    "01:00:5e:90:10:00",
-							PowerState:   switchbot2.PowerOn,
-							TimeOfSample: 123456789,
+							EventContext: switchbot2.EventContext{
+								DeviceType:   "WoPlugUS",
+								DeviceMac:    "01:00:5e:90:10:00",
+								TimeOfSample: 123456789,
+							},
+							PowerState: switchbot2.PowerOn,
 						},
 					}
 
@@ -545,10 +690,12 @@ func TestParseWebhook(t *testing.T) {
 						EventType:    "changeReport",
 						EventVersion: "1",
 						Context: switchbot2.PlugMiniJPEventContext{
-							DeviceType:   "WoPlugJP",
-							DeviceMac:    "01:00:5e:90:10:00",
-							PowerState:   switchbot2.PowerOn,
-							TimeOfSample: 123456789,
+							EventContext: switchbot2.EventContext{
+								DeviceType:   "WoPlugJP",
+								DeviceMac:    "01:00:5e:90:10:00",
+								TimeOfSample: 123456789,
+							},
+							PowerState: switchbot2.PowerOn,
 						},
 					}
 
@@ -578,12 +725,14 @@ func TestParseWebhook(t *testing.T) {
 						EventType:    "changeReport",
 						EventVersion: "1",
 						Context: switchbot2.SweeperEventContext{
-							DeviceType:    "WoSweeper",
-							DeviceMac:     "01:00:5e:90:10:00",
+							EventContext: switchbot2.EventContext{
+								DeviceType:   "WoSweeper",
+								DeviceMac:    "01:00:5e:90:10:00",
+								TimeOfSample: 123456789,
+							},
 							WorkingStatus: switchbot2.CleanerStandBy,
 							OnlineStatus:  switchbot2.CleanerOnline,
 							Battery:       100,
-							TimeOfSample:  123456789,
 						},
 					}
 
@@ -613,12 +762,14 @@ func TestParseWebhook(t *testing.T) {
 						EventType:    "changeReport",
 						EventVersion: "1",
 						Context: switchbot2.SweeperEventContext{
-							DeviceType:    "WoSweeperPlus",
-							DeviceMac:     "01:00:5e:90:10:00",
+							EventContext: switchbot2.EventContext{
+								DeviceType:   "WoSweeperPlus",
+								DeviceMac:    "01:00:5e:90:10:00",
+								TimeOfSample: 123456789,
+							},
 							WorkingStatus: switchbot2.CleanerStandBy,
 							OnlineStatus:  switchbot2.CleanerOnline,
 							Battery:       100,
-							TimeOfSample:  123456789,
 						},
 					}
 
@@ -648,12 +799,14 @@ func TestParseWebhook(t *testing.T) {
 						EventType:    "changeReport",
 						EventVersion: "1",
 						Context: switchbot2.CeilingEventContext{
-							DeviceType:       "WoCeiling",
-							DeviceMac:        "01:00:5e:90:10:00",
+							EventContext: switchbot2.EventContext{
+								DeviceType:   "WoCeiling",
+								DeviceMac:    "01:00:5e:90:10:00",
+								TimeOfSample: 123456789,
+							},
 							PowerState:       switchbot2.PowerOn,
 							Brightness:       10,
 							ColorTemperature: 3500,
-							TimeOfSample:     123456789,
 						},
 					}
 
@@ -683,12 +836,14 @@ func TestParseWebhook(t *testing.T) {
 						EventType:    "changeReport",
 						EventVersion: "1",
 						Context: switchbot2.CeilingEventContext{
-							DeviceType:       "WoCeilingPro",
-							DeviceMac:        "01:00:5e:90:10:00",
+							EventContext: switchbot2.EventContext{
+								DeviceType:   "WoCeilingPro",
+								DeviceMac:    "01:00:5e:90:10:00",
+								TimeOfSample: 123456789,
+							},
 							PowerState:       switchbot2.PowerOn,
 							Brightness:       10,
 							ColorTemperature: 3500,
-							TimeOfSample:     123456789,
 						},
 					}
 
@@ -719,12 +874,14 @@ func TestParseWebhook(t *testing.T) {
 							EventType:    "changeReport",
 							EventVersion: "1",
 							Context: switchbot2.KeypadEventContext{
-								DeviceType:   "WoKeypad",
-								DeviceMac:    "01:00:5e:90:10:00",
-								EventName:    "createKey",
-								CommandID:    "CMD-1663558451952-01",
-								Result:       "success",
-								TimeOfSample: 123456789,
+								EventContext: switchbot2.EventContext{
+									DeviceType:   "WoKeypad",
+									DeviceMac:    "01:00:5e:90:10:00",
+									TimeOfSample: 123456789,
+								},
+								EventName: "createKey",
+								CommandID: "CMD-1663558451952-01",
+								Result:    "success",
 							},
 						}
 
@@ -753,12 +910,14 @@ func TestParseWebhook(t *testing.T) {
 							EventType:    "changeReport",
 							EventVersion: "1",
 							Context: switchbot2.KeypadEventContext{
-								DeviceType:   "WoKeypad",
-								DeviceMac:    "01:00:5e:90:10:00",
-								EventName:    "deleteKey",
-								CommandID:    "CMD-1663558451952-01",
-								Result:       "success",
-								TimeOfSample: 123456789,
+								EventContext: switchbot2.EventContext{
+									DeviceType:   "WoKeypad",
+									DeviceMac:    "01:00:5e:90:10:00",
+									TimeOfSample: 123456789,
+								},
+								EventName: "deleteKey",
+								CommandID: "CMD-1663558451952-01",
+								Result:    "success",
 							},
 						}
 
@@ -790,12 +949,14 @@ func TestParseWebhook(t *testing.T) {
 							EventType:    "changeReport",
 							EventVersion: "1",
 							Context: switchbot2.KeypadEventContext{
-								DeviceType:   "WoKeypadTouch",
-								DeviceMac:    "01:00:5e:90:10:00",
-								EventName:    "createKey",
-								CommandID:    "CMD-1663558451952-01",
-								Result:       "success",
-								TimeOfSample: 123456789,
+								EventContext: switchbot2.EventContext{
+									DeviceType:   "WoKeypadTouch",
+									DeviceMac:    "01:00:5e:90:10:00",
+									TimeOfSample: 123456789,
+								},
+								EventName: "createKey",
+								CommandID: "CMD-1663558451952-01",
+								Result:    "success",
 							},
 						}
 
@@ -824,12 +985,14 @@ func TestParseWebhook(t *testing.T) {
 							EventType:    "changeReport",
 							EventVersion: "1",
 							Context: switchbot2.KeypadEventContext{
-								DeviceType:   "WoKeypadTouch",
-								DeviceMac:    "01:00:5e:90:10:00",
-								EventName:    "deleteKey",
-								CommandID:    "CMD-1663558451952-01",
-								Result:       "success",
-								TimeOfSample: 123456789,
+								EventContext: switchbot2.EventContext{
+									DeviceType:   "WoKeypadTouch",
+									DeviceMac:    "01:00:5e:90:10:00",
+									TimeOfSample: 123456789,
+								},
+								EventName: "deleteKey",
+								CommandID: "CMD-1663558451952-01",
+								Result:    "success",
 							},
 						}
 
@@ -846,4 +1009,720 @@ func TestParseWebhook(t *testing.T) {
 			sendWebhook(srv.URL, `{"eventType":"changeReport","eventVersion":"1","context":{"deviceType":"WoKeypadTouch","deviceMac":"01:00:5e:90:10:00","eventName":"deleteKey","commandId":"CMD-1663558451952-01","result":"success","timeOfSample":123456789}}`)
 		})
 	})
+
+	t.Run("Bot", func(t *testing.T) {
+		srv := httptest.NewServer(
+			http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				event, err := switchbot2.ParseWebhookRequest(r)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if got, ok := event.(*switchbot2.BotEvent); ok {
+					want := switchbot2.BotEvent{
+						EventType:    "changeReport",
+						EventVersion: "1",
+						Context: switchbot2.BotEventContext{
+							EventContext: switchbot2.EventContext{
+								DeviceType:   "WoHand",
+								DeviceMac:    "01:00:5e:90:10:00",
+								TimeOfSample: 123456789,
+							},
+							Power:      "on",
+							Battery:    10,
+							DeviceMode: "pressMode",
+						},
+					}
+
+					if diff := cmp.Diff(want, *got); diff != "" {
+						t.Fatalf("event mismatch (-want +got):\n%s", diff)
+					}
+				} else {
+					t.Fatalf("given webhook event must be a bot event but %T", event)
+				}
+			}),
+		)
+		defer srv.Close()
+
+		sendWebhook(srv.URL, `{"eventType":"changeReport","eventVersion":"1","context":{"deviceType":"WoHand","deviceMac":"01:00:5e:90:10:00","power":"on","battery":10,"deviceMode":"pressMode","timeOfSample":123456789}}`)
+	})
+
+	t.Run("Curtain", func(t *testing.T) {
+		srv := httptest.NewServer(
+			http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				event, err := switchbot2.ParseWebhookRequest(r)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if got, ok := event.(*switchbot2.CurtainEvent); ok {
+					want := switchbot2.CurtainEvent{
+						EventType:    "changeReport",
+						EventVersion: "1",
+						Context: switchbot2.CurtainEventContext{
+							EventContext: switchbot2.EventContext{
+								DeviceType:   "WoCurtain",
+								DeviceMac:    "01:00:5e:90:10:00",
+								TimeOfSample: 123456789,
+							},
+							IsCalibrated:  false,
+							IsGrouped:     false,
+							SlidePosition: 50,
+							Battery:       100,
+						},
+					}
+
+					if diff := cmp.Diff(want, *got); diff != "" {
+						t.Fatalf("event mismatch (-want +got):\n%s", diff)
+					}
+				} else {
+					t.Fatalf("given webhook event must be a curtain event but %T", event)
+				}
+			}),
+		)
+		defer srv.Close()
+
+		sendWebhook(srv.URL, `{"eventType":"changeReport","eventVersion":"1","context":{"deviceType":"WoCurtain","deviceMac":"01:00:5e:90:10:00","calibrate":false,"group":false,"slidePosition":50,"battery":100,"timeOfSample":123456789}}`)
+	})
+
+	t.Run("Curtain 3", func(t *testing.T) {
+		srv := httptest.NewServer(
+			http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				event, err := switchbot2.ParseWebhookRequest(r)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if got, ok := event.(*switchbot2.CurtainEvent); ok {
+					want := switchbot2.CurtainEvent{
+						EventType:    "changeReport",
+						EventVersion: "1",
+						Context: switchbot2.CurtainEventContext{
+							EventContext: switchbot2.EventContext{
+								DeviceType:   "WoCurtain3",
+								DeviceMac:    "01:00:5e:90:10:00",
+								TimeOfSample: 123456789,
+							},
+							IsCalibrated:  true,
+							IsGrouped:     false,
+							SlidePosition: 50,
+							Battery:       100,
+						},
+					}
+
+					if diff := cmp.Diff(want, *got); diff != "" {
+						t.Fatalf("event mismatch (-want +got):\n%s", diff)
+					}
+				} else {
+					t.Fatalf("given webhook event must be a curtain event but %T", event)
+				}
+			}),
+		)
+		defer srv.Close()
+
+		sendWebhook(srv.URL, `{"eventType":"changeReport","eventVersion":"1","context":{"deviceType":"WoCurtain3","deviceMac":"01:00:5e:90:10:00","calibrate":true,"group":false,"slidePosition":50,"battery":100,"timeOfSample":123456789}}`)
+	})
+
+	t.Run("Blind Tilt", func(t *testing.T) {
+		srv := httptest.NewServer(
+			http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				event, err := switchbot2.ParseWebhookRequest(r)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if got, ok := event.(*switchbot2.BlindTiltEvent); ok {
+					want := switchbot2.BlindTiltEvent{
+						EventType:    "changeReport",
+						EventVersion: "1",
+						Context: switchbot2.BlindTiltEventContext{
+							EventContext: switchbot2.EventContext{
+								DeviceType:   "WoBlindTilt",
+								DeviceMac:    "01:00:5e:90:10:00",
+								TimeOfSample: 123456789,
+							},
+							Version:       "V1.0",
+							IsCalibrated:  true,
+							IsGrouped:     false,
+							Direction:     "up",
+							SlidePosition: 50,
+							Battery:       100,
+						},
+					}
+
+					if diff := cmp.Diff(want, *got); diff != "" {
+						t.Fatalf("event mismatch (-want +got):\n%s", diff)
+					}
+				} else {
+					t.Fatalf("given webhook event must be a blind tilt event but %T", event)
+				}
+			}),
+		)
+		defer srv.Close()
+
+		sendWebhook(srv.URL, `{"eventType":"changeReport","eventVersion":"1","context":{"deviceType":"WoBlindTilt","deviceMac":"01:00:5e:90:10:00","version":"V1.0","calibrate":true,"group":false,"direction":"up","slidePosition":50,"battery":100,"timeOfSample":123456789}}`)
+	})
+
+	t.Run("Hub 2", func(t *testing.T) {
+		srv := httptest.NewServer(
+			http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				event, err := switchbot2.ParseWebhookRequest(r)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if got, ok := event.(*switchbot2.Hub2Event); ok {
+					want := switchbot2.Hub2Event{
+						EventType:    "changeReport",
+						EventVersion: "1",
+						Context: switchbot2.Hub2EventContext{
+							EventContext: switchbot2.EventContext{
+								DeviceType:   "WoHub2",
+								DeviceMac:    "01:00:5e:90:10:00",
+								TimeOfSample: 123456789,
+							},
+							Temperature: 13,
+							Humidity:    18,
+							LightLevel:  19,
+							Scale:       "CELSIUS",
+						},
+					}
+
+					if diff := cmp.Diff(want, *got); diff != "" {
+						t.Fatalf("event mismatch (-want +got):\n%s", diff)
+					}
+				} else {
+					t.Fatalf("given webhook event must be a hub 2 event but %T", event)
+				}
+			}),
+		)
+		defer srv.Close()
+
+		sendWebhook(srv.URL, `{"eventType":"changeReport","eventVersion":"1","context":{"deviceType":"WoHub2","deviceMac":"01:00:5e:90:10:00","temperature":13,"humidity":18,"lightLevel":19,"scale":"CELSIUS","timeOfSample":123456789}}`)
+	})
+
+	t.Run("Lock Pro", func(t *testing.T) {
+		srv := httptest.NewServer(
+			http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				event, err := switchbot2.ParseWebhookRequest(r)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if got, ok := event.(*switchbot2.LockEvent); ok {
+					want := switchbot2.LockEvent{
+						EventType:    "changeReport",
+						EventVersion: "1",
+						Context: switchbot2.LockEventContext{
+							EventContext: switchbot2.EventContext{
+								DeviceType:   "WoLockPro",
+								DeviceMac:    "01:00:5e:90:10:00",
+								TimeOfSample: 123456789,
+							},
+							LockState: "LOCKED",
+							Battery:   intPtr(90),
+						},
+					}
+
+					if diff := cmp.Diff(want, *got); diff != "" {
+						t.Fatalf("event mismatch (-want +got):\n%s", diff)
+					}
+				} else {
+					t.Fatalf("given webhook event must be a lock event but %T", event)
+				}
+			}),
+		)
+		defer srv.Close()
+
+		sendWebhook(srv.URL, `{"eventType":"changeReport","eventVersion":"1","context":{"deviceType":"WoLockPro","deviceMac":"01:00:5e:90:10:00","lockState":"LOCKED","battery":90,"timeOfSample":123456789}}`)
+	})
+
+	t.Run("Outdoor Meter", func(t *testing.T) {
+		srv := httptest.NewServer(
+			http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				event, err := switchbot2.ParseWebhookRequest(r)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if got, ok := event.(*switchbot2.OutdoorMeterEvent); ok {
+					want := switchbot2.OutdoorMeterEvent{
+						EventType:    "changeReport",
+						EventVersion: "1",
+						Context: switchbot2.OutdoorMeterEventContext{
+							EventContext: switchbot2.EventContext{
+								DeviceType:   "WoIOSensor",
+								DeviceMac:    "01:00:5e:90:10:00",
+								TimeOfSample: 123456789,
+							},
+							Temperature: 22.5,
+							Scale:       "CELSIUS",
+							Humidity:    31,
+							Battery:     99,
+						},
+					}
+
+					if diff := cmp.Diff(want, *got); diff != "" {
+						t.Fatalf("event mismatch (-want +got):\n%s", diff)
+					}
+				} else {
+					t.Fatalf("given webhook event must be a outdoor meter event but %T", event)
+				}
+			}),
+		)
+		defer srv.Close()
+
+		sendWebhook(srv.URL, `{"eventType":"changeReport","eventVersion":"1","context":{"deviceType":"WoIOSensor","deviceMac":"01:00:5e:90:10:00","temperature":22.5,"scale":"CELSIUS","humidity":31,"battery":99,"timeOfSample":123456789}}`)
+	})
+
+	t.Run("Meter Pro", func(t *testing.T) {
+		srv := httptest.NewServer(
+			http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				event, err := switchbot2.ParseWebhookRequest(r)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if got, ok := event.(*switchbot2.MeterProEvent); ok {
+					want := switchbot2.MeterProEvent{
+						EventType:    "changeReport",
+						EventVersion: "1",
+						Context: switchbot2.MeterProEventContext{
+							EventContext: switchbot2.EventContext{
+								DeviceType:   "WoMeterPro",
+								DeviceMac:    "01:00:5e:90:10:00",
+								TimeOfSample: 123456789,
+							},
+							Temperature: 22.5,
+							Scale:       "CELSIUS",
+							Humidity:    31,
+							Battery:     99,
+						},
+					}
+
+					if diff := cmp.Diff(want, *got); diff != "" {
+						t.Fatalf("event mismatch (-want +got):\n%s", diff)
+					}
+				} else {
+					t.Fatalf("given webhook event must be a meter pro event but %T", event)
+				}
+			}),
+		)
+		defer srv.Close()
+
+		sendWebhook(srv.URL, `{"eventType":"changeReport","eventVersion":"1","context":{"deviceType":"WoMeterPro","deviceMac":"01:00:5e:90:10:00","temperature":22.5,"scale":"CELSIUS","humidity":31,"battery":99,"timeOfSample":123456789}}`)
+	})
+
+	t.Run("Meter Pro (CO2)", func(t *testing.T) {
+		srv := httptest.NewServer(
+			http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				event, err := switchbot2.ParseWebhookRequest(r)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if got, ok := event.(*switchbot2.MeterProEvent); ok {
+					want := switchbot2.MeterProEvent{
+						EventType:    "changeReport",
+						EventVersion: "1",
+						Context: switchbot2.MeterProEventContext{
+							EventContext: switchbot2.EventContext{
+								DeviceType:   "WoMeterProCO2",
+								DeviceMac:    "01:00:5e:90:10:00",
+								TimeOfSample: 123456789,
+							},
+							Temperature: 22.5,
+							Scale:       "CELSIUS",
+							Humidity:    31,
+							CO2:         1203,
+							Battery:     99,
+						},
+					}
+
+					if diff := cmp.Diff(want, *got); diff != "" {
+						t.Fatalf("event mismatch (-want +got):\n%s", diff)
+					}
+				} else {
+					t.Fatalf("given webhook event must be a meter pro event but %T", event)
+				}
+			}),
+		)
+		defer srv.Close()
+
+		sendWebhook(srv.URL, `{"eventType":"changeReport","eventVersion":"1","context":{"deviceType":"WoMeterProCO2","deviceMac":"01:00:5e:90:10:00","temperature":22.5,"scale":"CELSIUS","humidity":31,"CO2":1203,"battery":99,"timeOfSample":123456789}}`)
+	})
+
+	t.Run("Humidifier", func(t *testing.T) {
+		srv := httptest.NewServer(
+			http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				event, err := switchbot2.ParseWebhookRequest(r)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if got, ok := event.(*switchbot2.HumidifierEvent); ok {
+					want := switchbot2.HumidifierEvent{
+						EventType:    "changeReport",
+						EventVersion: "1",
+						Context: switchbot2.HumidifierEventContext{
+							EventContext: switchbot2.EventContext{
+								DeviceType:   "WoHumi",
+								DeviceMac:    "01:00:5e:90:10:00",
+								TimeOfSample: 123456789,
+							},
+							PowerState:             switchbot2.PowerOn,
+							Humidity:               50,
+							Temperature:            22.5,
+							NebulizationEfficiency: 30,
+							IsAuto:                 false,
+							IsChildLock:            true,
+							IsSound:                false,
+							IsLackWater:            false,
+						},
+					}
+
+					if diff := cmp.Diff(want, *got); diff != "" {
+						t.Fatalf("event mismatch (-want +got):\n%s", diff)
+					}
+				} else {
+					t.Fatalf("given webhook event must be a humidifier event but %T", event)
+				}
+			}),
+		)
+		defer srv.Close()
+
+		sendWebhook(srv.URL, `{"eventType":"changeReport","eventVersion":"1","context":{"deviceType":"WoHumi","deviceMac":"01:00:5e:90:10:00","powerState":"ON","humidity":50,"temperature":22.5,"nebulizationEfficiency":30,"auto":false,"childLock":true,"sound":false,"lackWater":false,"timeOfSample":123456789}}`)
+	})
+
+	t.Run("Smart Fan", func(t *testing.T) {
+		srv := httptest.NewServer(
+			http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				event, err := switchbot2.ParseWebhookRequest(r)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if got, ok := event.(*switchbot2.SmartFanEvent); ok {
+					want := switchbot2.SmartFanEvent{
+						EventType:    "changeReport",
+						EventVersion: "1",
+						Context: switchbot2.SmartFanEventContext{
+							EventContext: switchbot2.EventContext{
+								DeviceType:   "WoSmartFan",
+								DeviceMac:    "01:00:5e:90:10:00",
+								TimeOfSample: 123456789,
+							},
+							PowerState:  switchbot2.PowerOn,
+							FanMode:     1,
+							FanSpeed:    3,
+							IsShaking:   true,
+							ShakeCenter: 60,
+							ShakeRange:  60,
+						},
+					}
+
+					if diff := cmp.Diff(want, *got); diff != "" {
+						t.Fatalf("event mismatch (-want +got):\n%s", diff)
+					}
+				} else {
+					t.Fatalf("given webhook event must be a smart fan event but %T", event)
+				}
+			}),
+		)
+		defer srv.Close()
+
+		sendWebhook(srv.URL, `{"eventType":"changeReport","eventVersion":"1","context":{"deviceType":"WoSmartFan","deviceMac":"01:00:5e:90:10:00","powerState":"ON","mode":1,"speed":3,"shaking":true,"shakeCenter":60,"shakeRange":60,"timeOfSample":123456789}}`)
+	})
+
+	t.Run("Battery Circulator Fan", func(t *testing.T) {
+		srv := httptest.NewServer(
+			http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				event, err := switchbot2.ParseWebhookRequest(r)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if got, ok := event.(*switchbot2.BatteryCirculatorFanEvent); ok {
+					want := switchbot2.BatteryCirculatorFanEvent{
+						EventType:    "changeReport",
+						EventVersion: "1",
+						Context: switchbot2.BatteryCirculatorFanEventContext{
+							EventContext: switchbot2.EventContext{
+								DeviceType:   "WoFan2",
+								DeviceMac:    "01:00:5e:90:10:00",
+								TimeOfSample: 123456789,
+							},
+							Version:             "V3.1",
+							Mode:                "direct",
+							Battery:             22,
+							PowerState:          switchbot2.PowerOn,
+							NightStatus:         1,
+							Oscillation:         "on",
+							VerticalOscillation: "off",
+							ChargingStatus:      "charging",
+							FanSpeed:            3,
+						},
+					}
+
+					if diff := cmp.Diff(want, *got); diff != "" {
+						t.Fatalf("event mismatch (-want +got):\n%s", diff)
+					}
+				} else {
+					t.Fatalf("given webhook event must be a battery circulator fan event but %T", event)
+				}
+			}),
+		)
+		defer srv.Close()
+
+		sendWebhook(srv.URL, `{"eventType":"changeReport","eventVersion":"1","context":{"deviceType":"WoFan2","deviceMac":"01:00:5e:90:10:00","version":"V3.1","mode":"direct","battery":22,"powerState":"ON","nightStatus":1,"oscillation":"on","verticalOscillation":"off","chargingStatus":"charging","fanSpeed":3,"timeOfSample":123456789}}`)
+	})
+
+	t.Run("Robot Vacuum Cleaner K10+", func(t *testing.T) {
+		srv := httptest.NewServer(
+			http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				event, err := switchbot2.ParseWebhookRequest(r)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if got, ok := event.(*switchbot2.SweeperEvent); ok {
+					want := switchbot2.SweeperEvent{
+						EventType:    "changeReport",
+						EventVersion: "1",
+						Context: switchbot2.SweeperEventContext{
+							EventContext: switchbot2.EventContext{
+								DeviceType:   "WoSweeperMini",
+								DeviceMac:    "01:00:5e:90:10:00",
+								TimeOfSample: 123456789,
+							},
+							WorkingStatus: switchbot2.CleanerStandBy,
+							OnlineStatus:  switchbot2.CleanerOnline,
+							Battery:       100,
+						},
+					}
+
+					if diff := cmp.Diff(want, *got); diff != "" {
+						t.Fatalf("event mismatch (-want +got):\n%s", diff)
+					}
+				} else {
+					t.Fatalf("given webhook event must be a sweeper event but %T", event)
+				}
+			}),
+		)
+		defer srv.Close()
+
+		sendWebhook(srv.URL, `{"eventType":"changeReport","eventVersion":"1","context":{"deviceType":"WoSweeperMini","deviceMac":"01:00:5e:90:10:00","workingStatus":"StandBy","onlineStatus":"online","battery":100,"timeOfSample":123456789}}`)
+	})
+
+	t.Run("Robot Vacuum Cleaner K10+ Pro", func(t *testing.T) {
+		srv := httptest.NewServer(
+			http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				event, err := switchbot2.ParseWebhookRequest(r)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if got, ok := event.(*switchbot2.SweeperEvent); ok {
+					want := switchbot2.SweeperEvent{
+						EventType:    "changeReport",
+						EventVersion: "1",
+						Context: switchbot2.SweeperEventContext{
+							EventContext: switchbot2.EventContext{
+								DeviceType:   "WoSweeperMiniPro",
+								DeviceMac:    "01:00:5e:90:10:00",
+								TimeOfSample: 123456789,
+							},
+							WorkingStatus: switchbot2.CleanerStandBy,
+							OnlineStatus:  switchbot2.CleanerOnline,
+							Battery:       100,
+						},
+					}
+
+					if diff := cmp.Diff(want, *got); diff != "" {
+						t.Fatalf("event mismatch (-want +got):\n%s", diff)
+					}
+				} else {
+					t.Fatalf("given webhook event must be a sweeper event but %T", event)
+				}
+			}),
+		)
+		defer srv.Close()
+
+		sendWebhook(srv.URL, `{"eventType":"changeReport","eventVersion":"1","context":{"deviceType":"WoSweeperMiniPro","deviceMac":"01:00:5e:90:10:00","workingStatus":"StandBy","onlineStatus":"online","battery":100,"timeOfSample":123456789}}`)
+	})
+
+	t.Run("Keypad battery report", func(t *testing.T) {
+		srv := httptest.NewServer(
+			http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				event, err := switchbot2.ParseWebhookRequest(r)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if got, ok := event.(*switchbot2.KeypadEvent); ok {
+					want := switchbot2.KeypadEvent{
+						EventType:    "changeReport",
+						EventVersion: "1",
+						Context: switchbot2.KeypadEventContext{
+							EventContext: switchbot2.EventContext{
+								DeviceType:   "WoKeypad",
+								DeviceMac:    "01:00:5e:90:10:00",
+								TimeOfSample: 123456789,
+							},
+							Battery: intPtr(80),
+						},
+					}
+
+					if diff := cmp.Diff(want, *got); diff != "" {
+						t.Fatalf("event mismatch (-want +got):\n%s", diff)
+					}
+				} else {
+					t.Fatalf("given webhook event must be a keypad event but %T", event)
+				}
+			}),
+		)
+		defer srv.Close()
+
+		sendWebhook(srv.URL, `{"eventType":"changeReport","eventVersion":"1","context":{"deviceType":"WoKeypad","deviceMac":"01:00:5e:90:10:00","battery":80,"timeOfSample":123456789}}`)
+	})
+}
+
+func TestParseWebhookPayload(t *testing.T) {
+	t.Run("unknown device type", func(t *testing.T) {
+		body := `{"eventType":"changeReport","eventVersion":"1","context":{"deviceType":"WoNewDevice","deviceMac":"01:00:5e:90:10:00","timeOfSample":123456789,"power":"on"}}`
+
+		event, err := switchbot2.ParseWebhookPayload([]byte(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got, ok := event.(*switchbot2.RawEvent)
+		if !ok {
+			t.Fatalf("given webhook event must be a raw event but %T", event)
+		}
+
+		want := switchbot2.RawEvent{
+			EventType:    "changeReport",
+			EventVersion: "1",
+			Context: switchbot2.RawEventContext{
+				DeviceType:   "WoNewDevice",
+				DeviceMac:    "01:00:5e:90:10:00",
+				TimeOfSample: 123456789,
+			},
+			Payload: json.RawMessage(body),
+			Extra: map[string]json.RawMessage{
+				"power": json.RawMessage(`"on"`),
+			},
+		}
+
+		if diff := cmp.Diff(want, *got); diff != "" {
+			t.Fatalf("event mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("unknown device type in strict mode", func(t *testing.T) {
+		_, err := switchbot2.ParseWebhookPayload([]byte(`{"context":{"deviceType":"WoNewDevice"}}`), switchbot2.StrictParsing())
+		if !errors.Is(err, switchbot2.ErrUnknownWebhookDeviceType) {
+			t.Fatalf("ErrUnknownWebhookDeviceType is expected but %v", err)
+		}
+	})
+
+	t.Run("missing device type", func(t *testing.T) {
+		for _, body := range []string{
+			`{}`,
+			`{"eventType":"changeReport","eventVersion":"1","context":{"deviceMac":"01:00:5e:90:10:00","timeOfSample":123456789}}`,
+			`{"context":{"deviceType":""}}`,
+		} {
+			if _, err := switchbot2.ParseWebhookPayload([]byte(body)); !errors.Is(err, switchbot2.ErrMissingWebhookDeviceType) {
+				t.Errorf("ErrMissingWebhookDeviceType is expected for %s but %v", body, err)
+			}
+		}
+	})
+
+	t.Run("absent battery", func(t *testing.T) {
+		for _, body := range []string{
+			`{"eventType":"changeReport","eventVersion":"1","context":{"deviceType":"WoLock","deviceMac":"01:00:5e:90:10:00","lockState":"LOCKED","timeOfSample":123456789}}`,
+			`{"eventType":"changeReport","eventVersion":"1","context":{"deviceType":"WoKeypad","deviceMac":"01:00:5e:90:10:00","eventName":"createKey","timeOfSample":123456789}}`,
+		} {
+			event, err := switchbot2.ParseWebhookPayload([]byte(body))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			b, err := switchbot2.MarshalWebhookEvent(event)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if strings.Contains(string(b), `"battery"`) {
+				t.Errorf("battery level is not expected to be added: %s", b)
+			}
+		}
+	})
+
+	t.Run("zero battery", func(t *testing.T) {
+		for _, body := range []string{
+			`{"eventType":"changeReport","eventVersion":"1","context":{"deviceType":"WoLock","deviceMac":"01:00:5e:90:10:00","lockState":"LOCKED","battery":0,"timeOfSample":123456789}}`,
+			`{"eventType":"changeReport","eventVersion":"1","context":{"deviceType":"WoKeypad","deviceMac":"01:00:5e:90:10:00","eventName":"battery","battery":0,"timeOfSample":123456789}}`,
+		} {
+			event, err := switchbot2.ParseWebhookPayload([]byte(body))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var battery *int
+			switch event := event.(type) {
+			case *switchbot2.LockEvent:
+				battery = event.Context.Battery
+			case *switchbot2.KeypadEvent:
+				battery = event.Context.Battery
+			}
+			if battery == nil || *battery != 0 {
+				t.Errorf("battery level of 0 is expected to be parsed: %v", battery)
+			}
+
+			b, err := switchbot2.MarshalWebhookEvent(event)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(string(b), `"battery":0`) {
+				t.Errorf("battery level of 0 is expected to be kept: %s", b)
+			}
+		}
+	})
+
+	t.Run("extra fields", func(t *testing.T) {
+		event, err := switchbot2.ParseWebhookPayload([]byte(`{"eventType":"changeReport","eventVersion":"1","context":{"deviceType":"WoLock","deviceMac":"01:00:5e:90:10:00","lockState":"LOCKED","doorState":"closed","timeOfSample":123456789}}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got, ok := event.(*switchbot2.LockEvent)
+		if !ok {
+			t.Fatalf("given webhook event must be a lock event but %T", event)
+		}
+
+		want := switchbot2.LockEvent{
+			EventType:    "changeReport",
+			EventVersion: "1",
+			Context: switchbot2.LockEventContext{
+				EventContext: switchbot2.EventContext{
+					DeviceType:   "WoLock",
+					DeviceMac:    "01:00:5e:90:10:00",
+					TimeOfSample: 123456789,
+				},
+				LockState: "LOCKED",
+			},
+			Extra: map[string]json.RawMessage{
+				"doorState": json.RawMessage(`"closed"`),
+			},
+		}
+
+		if diff := cmp.Diff(want, *got); diff != "" {
+			t.Fatalf("event mismatch (-want +got):\n%s", diff)
+		}
+	})
+}
+
+func intPtr(v int) *int {
+	return &v
 }
@@ -0,0 +1,667 @@
+// Package webhooktest generates SwitchBot webhook events for testing webhook
+// receivers.
+package webhooktest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/nasa9084/go-switchbot/v3/switchbot"
+	"io"
+	"math"
+	"math/rand"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Simulator generates valid and plausible webhook events for testing
+// webhook receivers. The state of each simulated device is kept so that
+// consecutive events of a device are consistent, e.g. temperatures drift
+// gradually and locks alternate between locked and unlocked.
+type Simulator struct {
+	now func() time.Time
+
+	mu     sync.Mutex
+	rand   *rand.Rand
+	states map[string]*simulatedState
+}
+
+type simulatedState struct {
+	temperature float64
+	humidity    int
+	co2         int
+	battery     int
+	on          bool
+	locked      bool
+	open        bool
+	detected    bool
+	position    int
+	brightness  int
+	commands    int
+}
+
+// SimulatorOption configures a Simulator.
+type SimulatorOption func(*Simulator)
+
+// WithSimulatorSeed sets the seed of the random values, which makes the
+// generated events reproducible.
+func WithSimulatorSeed(seed int64) SimulatorOption {
+	return func(s *Simulator) {
+		s.rand = rand.New(rand.NewSource(seed))
+	}
+}
+
+// WithSimulatorClock sets the function returning the time of sample of
+// generated events.
+func WithSimulatorClock(now func() time.Time) SimulatorOption {
+	return func(s *Simulator) {
+		s.now = now
+	}
+}
+
+// NewSimulator returns a new Simulator.
+func NewSimulator(opts ...SimulatorOption) *Simulator {
+	s := &Simulator{
+		now:    time.Now,
+		rand:   rand.New(rand.NewSource(time.Now().UnixNano())),
+		states: map[string]*simulatedState{},
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	return s
+}
+
+// SimulatedMAC returns the n-th MAC address for simulated devices, which
+// is a locally administered address so that it never conflicts with the
+// MAC addresses of real devices.
+func SimulatedMAC(n int) string {
+	return fmt.Sprintf("02:00:00:%02X:%02X:%02X", byte(n>>16), byte(n>>8), byte(n))
+}
+
+type simulatedGenerator func(s *Simulator, st *simulatedState, deviceType, mac string, at int64) switchbot.Event
+
+var simulatedGenerators = map[string]simulatedGenerator{
+	"WoPresence":       simulateMotionSensor,
+	"WoContact":        simulateContactSensor,
+	"WoLock":           simulateLock,
+	"WoLockPro":        simulateLock,
+	"WoCamera":         simulateIndoorCam,
+	"WoPanTiltCam":     simulatePanTiltCam,
+	"WoBulb":           simulateColorBulb,
+	"WoStrip":          simulateStripLight,
+	"WoPlugUS":         simulatePlugMiniUS,
+	"WoPlugJP":         simulatePlugMiniJP,
+	"WoMeter":          simulateMeter,
+	"WoMeterPlus":      simulateMeterPlus,
+	"WoIOSensor":       simulateOutdoorMeter,
+	"WoMeterPro":       simulateMeterPro,
+	"WoMeterProCO2":    simulateMeterPro,
+	"WoSweeper":        simulateSweeper,
+	"WoSweeperPlus":    simulateSweeper,
+	"WoSweeperMini":    simulateSweeper,
+	"WoSweeperMiniPro": simulateSweeper,
+	"WoCeiling":        simulateCeiling,
+	"WoCeilingPro":     simulateCeiling,
+	"WoKeypad":         simulateKeypad,
+	"WoKeypadTouch":    simulateKeypad,
+	"WoHand":           simulateBot,
+	"WoCurtain":        simulateCurtain,
+	"WoCurtain3":       simulateCurtain,
+	"WoBlindTilt":      simulateBlindTilt,
+	"WoHub2":           simulateHub2,
+	"WoHumi":           simulateHumidifier,
+	"WoSmartFan":       simulateSmartFan,
+	"WoFan2":           simulateBatteryCirculatorFan,
+}
+
+// SimulatedDeviceTypes returns the webhook deviceType values supported by
+// Simulator in sorted order.
+func SimulatedDeviceTypes() []string {
+	types := make([]string, 0, len(simulatedGenerators))
+	for typ := range simulatedGenerators {
+		types = append(types, typ)
+	}
+	sort.Strings(types)
+
+	return types
+}
+
+// Event generates the next event of the device with the given webhook
+// deviceType and MAC address.
+func (s *Simulator) Event(deviceType, mac string) (switchbot.Event, error) {
+	gen, ok := simulatedGenerators[deviceType]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", switchbot.ErrUnknownWebhookDeviceType, deviceType)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	st, ok := s.states[mac]
+	if !ok {
+		st = &simulatedState{
+			temperature: 18 + s.rand.Float64()*8,
+			humidity:    35 + s.rand.Intn(30),
+			co2:         450 + s.rand.Intn(400),
+			battery:     60 + s.rand.Intn(41),
+			locked:      true,
+			position:    s.rand.Intn(101),
+			brightness:  50,
+		}
+		s.states[mac] = st
+	}
+
+	// temperatures and humidities drift slowly and batteries drain slowly
+	st.temperature = math.Round((st.temperature+s.rand.Float64()*0.6-0.3)*10) / 10
+	st.humidity = clamp(st.humidity+s.rand.Intn(3)-1, 10, 95)
+	st.co2 = clamp(st.co2+s.rand.Intn(61)-30, 400, 3000)
+	if s.rand.Intn(20) == 0 {
+		st.battery = clamp(st.battery-1, 0, 100)
+	}
+
+	return gen(s, st, deviceType, mac, s.now().UnixMilli()), nil
+}
+
+// Payload generates the next event of the device and encodes it in the
+// webhook payload format.
+func (s *Simulator) Payload(deviceType, mac string) ([]byte, error) {
+	event, err := s.Event(deviceType, mac)
+	if err != nil {
+		return nil, err
+	}
+
+	return switchbot.MarshalWebhookEvent(event)
+}
+
+func clamp(v, lo, hi int) int {
+	if v < lo {
+		return lo
+	}
+	if v > hi {
+		return hi
+	}
+
+	return v
+}
+
+func eventContext(deviceType, mac string, at int64) switchbot.EventContext {
+	return switchbot.EventContext{DeviceType: deviceType, DeviceMac: mac, TimeOfSample: at}
+}
+
+func detection(detected bool) string {
+	if detected {
+		return "DETECTED"
+	}
+
+	return "NOT_DETECTED"
+}
+
+func powerState(on bool) switchbot.PowerState {
+	if on {
+		return switchbot.PowerOn
+	}
+
+	return switchbot.PowerOff
+}
+
+func simulateMotionSensor(s *Simulator, st *simulatedState, deviceType, mac string, at int64) switchbot.Event {
+	// motion is detected in bursts
+	st.detected = s.rand.Intn(3) > 0
+	return &switchbot.MotionSensorEvent{
+		EventType:    "changeReport",
+		EventVersion: "1",
+		Context:      switchbot.MotionSensorEventContext{EventContext: eventContext(deviceType, mac, at), DetectionState: detection(st.detected)},
+	}
+}
+
+func simulateContactSensor(s *Simulator, st *simulatedState, deviceType, mac string, at int64) switchbot.Event {
+	st.open = !st.open
+	openState := "close"
+	if st.open {
+		openState = "open"
+	}
+	brightness := switchbot.AmbientBrightnessBright
+	if s.rand.Intn(2) == 0 {
+		brightness = switchbot.AmbientBrightnessDim
+	}
+
+	return &switchbot.ContactSensorEvent{
+		EventType:    "changeReport",
+		EventVersion: "1",
+		Context: switchbot.ContactSensorEventContext{
+			EventContext:   eventContext(deviceType, mac, at),
+			DetectionState: detection(st.open),
+			DoorMode:       "OUT_DOOR",
+			Brightness:     brightness,
+			OpenState:      openState,
+		},
+	}
+}
+
+func simulateLock(s *Simulator, st *simulatedState, deviceType, mac string, at int64) switchbot.Event {
+	lockState := "UNLOCKED"
+	if s.rand.Intn(50) == 0 {
+		lockState = "JAMMED"
+	} else {
+		st.locked = !st.locked
+		if st.locked {
+			lockState = "LOCKED"
+		}
+	}
+
+	battery := st.battery
+	return &switchbot.LockEvent{
+		EventType:    "changeReport",
+		EventVersion: "1",
+		Context:      switchbot.LockEventContext{EventContext: eventContext(deviceType, mac, at), LockState: lockState, Battery: &battery},
+	}
+}
+
+func simulateIndoorCam(s *Simulator, st *simulatedState, deviceType, mac string, at int64) switchbot.Event {
+	st.detected = s.rand.Intn(3) > 0
+	return &switchbot.IndoorCamEvent{
+		EventType:    "changeReport",
+		EventVersion: "1",
+		Context:      switchbot.IndoorCamEventContext{EventContext: eventContext(deviceType, mac, at), DetectionState: detection(st.detected)},
+	}
+}
+
+func simulatePanTiltCam(s *Simulator, st *simulatedState, deviceType, mac string, at int64) switchbot.Event {
+	st.detected = s.rand.Intn(3) > 0
+	return &switchbot.PanTiltCamEvent{
+		EventType:    "changeReport",
+		EventVersion: "1",
+		Context:      switchbot.PanTiltCamEventContext{EventContext: eventContext(deviceType, mac, at), DetectionState: detection(st.detected)},
+	}
+}
+
+func simulateColor(s *Simulator) string {
+	return fmt.Sprintf("%d:%d:%d", s.rand.Intn(256), s.rand.Intn(256), s.rand.Intn(256))
+}
+
+func simulateColorBulb(s *Simulator, st *simulatedState, deviceType, mac string, at int64) switchbot.Event {
+	st.on = !st.on
+	st.brightness = 1 + s.rand.Intn(100)
+	return &switchbot.ColorBulbEvent{
+		EventType:    "changeReport",
+		EventVersion: "1",
+		Context: switchbot.ColorBulbEventContext{
+			EventContext:     eventContext(deviceType, mac, at),
+			PowerState:       powerState(st.on),
+			Brightness:       st.brightness,
+			Color:            simulateColor(s),
+			ColorTemperature: 2700 + s.rand.Intn(38)*100,
+		},
+	}
+}
+
+func simulateStripLight(s *Simulator, st *simulatedState, deviceType, mac string, at int64) switchbot.Event {
+	st.on = !st.on
+	st.brightness = 1 + s.rand.Intn(100)
+	return &switchbot.StripLightEvent{
+		EventType:    "changeReport",
+		EventVersion: "1",
+		Context:      switchbot.StripLightEventContext{EventContext: eventContext(deviceType, mac, at), PowerState: powerState(st.on), Brightness: st.brightness, Color: simulateColor(s)},
+	}
+}
+
+func simulatePlugMiniUS(s *Simulator, st *simulatedState, deviceType, mac string, at int64) switchbot.Event {
+	st.on = !st.on
+	return &switchbot.PlugMiniUSEvent{
+		EventType:    "changeReport",
+		EventVersion: "1",
+		Context:      switchbot.PlugMiniUSEventContext{EventContext: eventContext(deviceType, mac, at), PowerState: powerState(st.on)},
+	}
+}
+
+func simulatePlugMiniJP(s *Simulator, st *simulatedState, deviceType, mac string, at int64) switchbot.Event {
+	st.on = !st.on
+	return &switchbot.PlugMiniJPEvent{
+		EventType:    "changeReport",
+		EventVersion: "1",
+		Context:      switchbot.PlugMiniJPEventContext{EventContext: eventContext(deviceType, mac, at), PowerState: powerState(st.on)},
+	}
+}
+
+func simulateMeter(s *Simulator, st *simulatedState, deviceType, mac string, at int64) switchbot.Event {
+	return &switchbot.MeterEvent{
+		EventType:    "changeReport",
+		EventVersion: "1",
+		Context:      switchbot.MeterEventContext{EventContext: eventContext(deviceType, mac, at), Temperature: st.temperature, Scale: "CELSIUS", Humidity: st.humidity},
+	}
+}
+
+func simulateMeterPlus(s *Simulator, st *simulatedState, deviceType, mac string, at int64) switchbot.Event {
+	return &switchbot.MeterPlusEvent{
+		EventType:    "changeReport",
+		EventVersion: "1",
+		Context:      switchbot.MeterPlusEventContext{EventContext: eventContext(deviceType, mac, at), Temperature: st.temperature, Scale: "CELSIUS", Humidity: st.humidity},
+	}
+}
+
+func simulateOutdoorMeter(s *Simulator, st *simulatedState, deviceType, mac string, at int64) switchbot.Event {
+	return &switchbot.OutdoorMeterEvent{
+		EventType:    "changeReport",
+		EventVersion: "1",
+		Context:      switchbot.OutdoorMeterEventContext{EventContext: eventContext(deviceType, mac, at), Temperature: st.temperature - 10, Scale: "CELSIUS", Humidity: st.humidity, Battery: st.battery},
+	}
+}
+
+func simulateMeterPro(s *Simulator, st *simulatedState, deviceType, mac string, at int64) switchbot.Event {
+	var co2 int
+	if deviceType == "WoMeterProCO2" {
+		co2 = st.co2
+	}
+
+	return &switchbot.MeterProEvent{
+		EventType:    "changeReport",
+		EventVersion: "1",
+		Context:      switchbot.MeterProEventContext{EventContext: eventContext(deviceType, mac, at), Temperature: st.temperature, Scale: "CELSIUS", Humidity: st.humidity, CO2: co2, Battery: st.battery},
+	}
+}
+
+var simulatedCleanerStatuses = []switchbot.CleanerWorkingStatus{switchbot.CleanerStandBy, switchbot.CleanerClearing, switchbot.CleanerGotoChargeBase, switchbot.CleanerCharging, switchbot.CleanerChargeDone}
+
+func simulateSweeper(s *Simulator, st *simulatedState, deviceType, mac string, at int64) switchbot.Event {
+	// the cleaner goes through the cleaning cycle
+	status := simulatedCleanerStatuses[st.commands%len(simulatedCleanerStatuses)]
+	st.commands++
+
+	return &switchbot.SweeperEvent{
+		EventType:    "changeReport",
+		EventVersion: "1",
+		Context:      switchbot.SweeperEventContext{EventContext: eventContext(deviceType, mac, at), WorkingStatus: status, OnlineStatus: switchbot.CleanerOnline, Battery: st.battery},
+	}
+}
+
+func simulateCeiling(s *Simulator, st *simulatedState, deviceType, mac string, at int64) switchbot.Event {
+	st.on = !st.on
+	return &switchbot.CeilingEvent{
+		EventType:    "changeReport",
+		EventVersion: "1",
+		Context:      switchbot.CeilingEventContext{EventContext: eventContext(deviceType, mac, at), PowerState: powerState(st.on), Brightness: 1 + s.rand.Intn(100), ColorTemperature: 2700 + s.rand.Intn(38)*100},
+	}
+}
+
+func simulateKeypad(s *Simulator, st *simulatedState, deviceType, mac string, at int64) switchbot.Event {
+	st.commands++
+	eventName := "createKey"
+	if st.commands%2 == 0 {
+		eventName = "deleteKey"
+	}
+	result := "success"
+	if s.rand.Intn(10) == 0 {
+		result = "failed"
+	}
+
+	return &switchbot.KeypadEvent{
+		EventType:    "changeReport",
+		EventVersion: "1",
+		Context: switchbot.KeypadEventContext{
+			EventContext: eventContext(deviceType, mac, at),
+			EventName:    eventName,
+			CommandID:    fmt.Sprintf("CMD-%d-%02d", at, st.commands%100),
+			Result:       result,
+		},
+	}
+}
+
+func simulateBot(s *Simulator, st *simulatedState, deviceType, mac string, at int64) switchbot.Event {
+	st.on = !st.on
+	power := "off"
+	if st.on {
+		power = "on"
+	}
+
+	return &switchbot.BotEvent{
+		EventType:    "changeReport",
+		EventVersion: "1",
+		Context:      switchbot.BotEventContext{EventContext: eventContext(deviceType, mac, at), Power: power, Battery: st.battery, DeviceMode: "switchMode"},
+	}
+}
+
+func simulateCurtain(s *Simulator, st *simulatedState, deviceType, mac string, at int64) switchbot.Event {
+	st.position = 100 - st.position
+	return &switchbot.CurtainEvent{
+		EventType:    "changeReport",
+		EventVersion: "1",
+		Context:      switchbot.CurtainEventContext{EventContext: eventContext(deviceType, mac, at), IsCalibrated: true, SlidePosition: st.position, Battery: st.battery},
+	}
+}
+
+func simulateBlindTilt(s *Simulator, st *simulatedState, deviceType, mac string, at int64) switchbot.Event {
+	st.position = s.rand.Intn(101)
+	direction := "up"
+	if s.rand.Intn(2) == 0 {
+		direction = "down"
+	}
+
+	return &switchbot.BlindTiltEvent{
+		EventType:    "changeReport",
+		EventVersion: "1",
+		Context:      switchbot.BlindTiltEventContext{EventContext: eventContext(deviceType, mac, at), Version: "V2.0", IsCalibrated: true, Direction: direction, SlidePosition: st.position, Battery: st.battery},
+	}
+}
+
+func simulateHub2(s *Simulator, st *simulatedState, deviceType, mac string, at int64) switchbot.Event {
+	return &switchbot.Hub2Event{
+		EventType:    "changeReport",
+		EventVersion: "1",
+		Context:      switchbot.Hub2EventContext{EventContext: eventContext(deviceType, mac, at), Temperature: st.temperature, Humidity: st.humidity, LightLevel: 1 + s.rand.Intn(20), Scale: "CELSIUS"},
+	}
+}
+
+func simulateHumidifier(s *Simulator, st *simulatedState, deviceType, mac string, at int64) switchbot.Event {
+	st.on = !st.on
+	return &switchbot.HumidifierEvent{
+		EventType:    "changeReport",
+		EventVersion: "1",
+		Context: switchbot.HumidifierEventContext{
+			EventContext:           eventContext(deviceType, mac, at),
+			PowerState:             powerState(st.on),
+			Humidity:               st.humidity,
+			Temperature:            st.temperature,
+			NebulizationEfficiency: s.rand.Intn(101),
+			IsAuto:                 s.rand.Intn(2) == 0,
+			IsSound:                true,
+			IsLackWater:            s.rand.Intn(20) == 0,
+		},
+	}
+}
+
+func simulateSmartFan(s *Simulator, st *simulatedState, deviceType, mac string, at int64) switchbot.Event {
+	st.on = !st.on
+	return &switchbot.SmartFanEvent{
+		EventType:    "changeReport",
+		EventVersion: "1",
+		Context: switchbot.SmartFanEventContext{
+			EventContext: eventContext(deviceType, mac, at),
+			PowerState:   powerState(st.on),
+			FanMode:      1 + s.rand.Intn(2),
+			FanSpeed:     1 + s.rand.Intn(4),
+			IsShaking:    s.rand.Intn(2) == 0,
+			ShakeCenter:  60,
+			ShakeRange:   s.rand.Intn(121),
+		},
+	}
+}
+
+func simulateBatteryCirculatorFan(s *Simulator, st *simulatedState, deviceType, mac string, at int64) switchbot.Event {
+	st.on = !st.on
+	chargingStatus := "uncharged"
+	if s.rand.Intn(4) == 0 {
+		chargingStatus = "charging"
+	}
+
+	return &switchbot.BatteryCirculatorFanEvent{
+		EventType:    "changeReport",
+		EventVersion: "1",
+		Context: switchbot.BatteryCirculatorFanEventContext{
+			EventContext:        eventContext(deviceType, mac, at),
+			Version:             "V3.1",
+			Mode:                []string{"direct", "natural", "sleep", "baby"}[s.rand.Intn(4)],
+			Battery:             st.battery,
+			PowerState:          powerState(st.on),
+			NightStatus:         1 + s.rand.Intn(3),
+			Oscillation:         "on",
+			VerticalOscillation: "off",
+			ChargingStatus:      chargingStatus,
+			FanSpeed:            1 + s.rand.Intn(100),
+		},
+	}
+}
+
+// ScenarioStep is a step of a Scenario, which sends an event from a device.
+type ScenarioStep struct {
+	// After is the delay since the previous step.
+	After      time.Duration
+	DeviceType string
+	DeviceMac  string
+	// Context overrides the fields of the context of the generated event,
+	// e.g. {"lockState": "UNLOCKED"}.
+	Context map[string]interface{}
+}
+
+// Scenario is a scripted sequence of webhook events.
+type Scenario struct {
+	Name        string
+	Description string
+	Steps       []ScenarioStep
+}
+
+// Scenarios returns the built-in scenarios keyed by their names.
+// The devices in the scenarios have the addresses returned by SimulatedMAC.
+func Scenarios() map[string]Scenario {
+	var (
+		door   = SimulatedMAC(1)
+		motion = SimulatedMAC(2)
+		lock   = SimulatedMAC(3)
+		meter  = SimulatedMAC(4)
+	)
+
+	motionBurst := Scenario{
+		Name:        "motion-burst",
+		Description: "motion is detected repeatedly and then settles",
+	}
+	for i := 0; i < 5; i++ {
+		motionBurst.Steps = append(motionBurst.Steps, ScenarioStep{After: 2 * time.Second, DeviceType: "WoPresence", DeviceMac: motion, Context: map[string]interface{}{"detectionState": "DETECTED"}})
+	}
+	motionBurst.Steps = append(motionBurst.Steps, ScenarioStep{After: 30 * time.Second, DeviceType: "WoPresence", DeviceMac: motion, Context: map[string]interface{}{"detectionState": "NOT_DETECTED"}})
+
+	temperatureDrift := Scenario{
+		Name:        "temperature-drift",
+		Description: "a meter reports gradually changing temperatures",
+	}
+	for i := 0; i < 10; i++ {
+		temperatureDrift.Steps = append(temperatureDrift.Steps, ScenarioStep{After: time.Minute, DeviceType: "WoMeter", DeviceMac: meter})
+	}
+
+	return map[string]Scenario{
+		"arrive-home": {
+			Name:        "arrive-home",
+			Description: "door opened, motion detected, lock unlocked",
+			Steps: []ScenarioStep{
+				{DeviceType: "WoContact", DeviceMac: door, Context: map[string]interface{}{"openState": "open", "detectionState": "DETECTED"}},
+				{After: 2 * time.Second, DeviceType: "WoPresence", DeviceMac: motion, Context: map[string]interface{}{"detectionState": "DETECTED"}},
+				{After: time.Second, DeviceType: "WoLock", DeviceMac: lock, Context: map[string]interface{}{"lockState": "UNLOCKED"}},
+				{After: 5 * time.Second, DeviceType: "WoContact", DeviceMac: door, Context: map[string]interface{}{"openState": "close", "detectionState": "NOT_DETECTED"}},
+			},
+		},
+		"leave-home": {
+			Name:        "leave-home",
+			Description: "door opened and closed, lock locked, motion no longer detected",
+			Steps: []ScenarioStep{
+				{DeviceType: "WoContact", DeviceMac: door, Context: map[string]interface{}{"openState": "open", "detectionState": "DETECTED"}},
+				{After: 3 * time.Second, DeviceType: "WoContact", DeviceMac: door, Context: map[string]interface{}{"openState": "close", "detectionState": "NOT_DETECTED"}},
+				{After: 2 * time.Second, DeviceType: "WoLock", DeviceMac: lock, Context: map[string]interface{}{"lockState": "LOCKED"}},
+				{After: time.Minute, DeviceType: "WoPresence", DeviceMac: motion, Context: map[string]interface{}{"detectionState": "NOT_DETECTED"}},
+			},
+		},
+		motionBurst.Name:      motionBurst,
+		temperatureDrift.Name: temperatureDrift,
+	}
+}
+
+// StepPayload generates the payload of the given scenario step.
+func (s *Simulator) StepPayload(step ScenarioStep) ([]byte, error) {
+	payload, err := s.Payload(step.DeviceType, step.DeviceMac)
+	if err != nil || len(step.Context) == 0 {
+		return payload, err
+	}
+
+	var body struct {
+		EventType    string                 `json:"eventType"`
+		EventVersion string                 `json:"eventVersion"`
+		Context      map[string]interface{} `json:"context"`
+	}
+	if err := json.Unmarshal(payload, &body); err != nil {
+		return nil, err
+	}
+
+	for k, v := range step.Context {
+		body.Context[k] = v
+	}
+
+	return json.Marshal(body)
+}
+
+// Play generates the payloads of the scenario and calls fn with each of
+// them, keeping the delays between the steps divided by speed. If speed
+// is zero or negative, the payloads are generated without delays.
+func (s *Simulator) Play(ctx context.Context, scenario Scenario, speed float64, fn func([]byte) error) error {
+	for _, step := range scenario.Steps {
+		if speed > 0 && step.After > 0 {
+			timer := time.NewTimer(time.Duration(float64(step.After) / speed))
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return ctx.Err()
+			case <-timer.C:
+			}
+		}
+
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		payload, err := s.StepPayload(step)
+		if err != nil {
+			return err
+		}
+
+		if err := fn(payload); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// PostWebhookPayload POSTs the payload to the given URL as SwitchBot does.
+// An error is returned if the response status code is not 2xx.
+func PostWebhookPayload(ctx context.Context, client *http.Client, url string, payload []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+
+	return nil
+}
@@ -0,0 +1,91 @@
+package webhooktest_test
+
+import (
+	"context"
+	"errors"
+	"github.com/google/go-cmp/cmp"
+	switchbot2 "github.com/nasa9084/go-switchbot/v3/switchbot"
+	"github.com/nasa9084/go-switchbot/v3/switchbot/webhooktest"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSimulator(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	sim := webhooktest.NewSimulator(webhooktest.WithSimulatorSeed(1), webhooktest.WithSimulatorClock(func() time.Time { return now }))
+
+	for i, deviceType := range webhooktest.SimulatedDeviceTypes() {
+		t.Run(deviceType, func(t *testing.T) {
+			mac := webhooktest.SimulatedMAC(i)
+
+			for j := 0; j < 3; j++ {
+				payload, err := sim.Payload(deviceType, mac)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				event, err := switchbot2.ParseWebhookPayload(payload, switchbot2.StrictParsing())
+				if err != nil {
+					t.Fatalf("generated payload must be parsed: %v: %s", err, payload)
+				}
+
+				if event.WebhookDeviceType() != deviceType || event.DeviceMAC() != mac || !event.Time().Equal(now) {
+					t.Errorf("unexpected event: %s", payload)
+				}
+				if event.PhysicalType() == "" {
+					t.Errorf("physical device type is unknown for %s", deviceType)
+				}
+			}
+		})
+	}
+
+	t.Run("reproducible", func(t *testing.T) {
+		a, _ := webhooktest.NewSimulator(webhooktest.WithSimulatorSeed(42)).Event("WoMeter", webhooktest.SimulatedMAC(0))
+		b, _ := webhooktest.NewSimulator(webhooktest.WithSimulatorSeed(42)).Event("WoMeter", webhooktest.SimulatedMAC(0))
+
+		if diff := cmp.Diff(a.(*switchbot2.MeterEvent).Context.Temperature, b.(*switchbot2.MeterEvent).Context.Temperature); diff != "" {
+			t.Errorf("events generated with the same seed mismatch (-a +b):\n%s", diff)
+		}
+	})
+
+	t.Run("unknown device type", func(t *testing.T) {
+		if _, err := sim.Payload("WoNewDevice", webhooktest.SimulatedMAC(0)); !errors.Is(err, switchbot2.ErrUnknownWebhookDeviceType) {
+			t.Errorf("ErrUnknownWebhookDeviceType is expected but %v", err)
+		}
+	})
+}
+
+func TestSimulatorPlay(t *testing.T) {
+	var got []string
+
+	h := switchbot2.NewWebhookHandler()
+	h.OnContact(func(e *switchbot2.ContactSensorEvent) {
+		got = append(got, "contact:"+e.Context.OpenState)
+	})
+	h.OnMotion(func(e *switchbot2.MotionSensorEvent) {
+		got = append(got, "motion:"+e.Context.DetectionState)
+	})
+	h.OnLock(func(e *switchbot2.LockEvent) {
+		got = append(got, "lock:"+e.Context.LockState)
+	})
+
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	sim := webhooktest.NewSimulator()
+	scenario := webhooktest.Scenarios()["arrive-home"]
+
+	err := sim.Play(context.Background(), scenario, 0, func(payload []byte) error {
+		return webhooktest.PostWebhookPayload(context.Background(), http.DefaultClient, srv.URL, payload)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"contact:open", "motion:DETECTED", "lock:UNLOCKED", "contact:close"}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("dispatched events mismatch (-want +got):\n%s", diff)
+	}
+}